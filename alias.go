@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+)
+
+type alias struct {
+	key     string
+	message string
+}
+
+// RegisterAlias will allow an old key to be used in config files and
+// getters in place of a new key. The old key should be the path of the
+// value in a config file.
+//
+//	config.RegisterAlias("db.hostname", "db.host")
+func RegisterAlias(old, key string) { c.RegisterAlias(old, key) }
+
+// RegisterAlias will allow an old key to be used in config files and
+// getters in place of a new key. The old key should be the path of the
+// value in a config file.
+func (c *Config) RegisterAlias(old, key string) { c.registerAlias(old, key, "") }
+
+// RegisterDeprecatedAlias is the same as RegisterAlias but a deprecation
+// message is logged whenever the old key is found in a config file. If the
+// message is empty, a message naming the new key is used.
+func RegisterDeprecatedAlias(old, key, message string) {
+	c.RegisterDeprecatedAlias(old, key, message)
+}
+
+// RegisterDeprecatedAlias is the same as RegisterAlias but a deprecation
+// message is logged whenever the old key is found in a config file. If the
+// message is empty, a message naming the new key is used.
+func (c *Config) RegisterDeprecatedAlias(old, key, message string) {
+	if message == "" {
+		message = fmt.Sprintf("%q is deprecated, use %q instead", old, key)
+	}
+	c.registerAlias(old, key, message)
+}
+
+func (c *Config) registerAlias(old, key, message string) {
+	c.mu.Lock()
+	if c.aliases == nil {
+		c.aliases = make(map[string]alias)
+	}
+	c.aliases[old] = alias{key: key, message: message}
+	c.mu.Unlock()
+	c.clearFileCache()
+}
+
+// aliasKey returns the key that an alias points
+// to or the key if it is not an alias.
+func (c *Config) aliasKey(key string) string {
+	if a, ok := c.aliases[key]; ok {
+		return a.key
+	}
+	return key
+}
+
+// rewriteAliases will move every aliased value in a config file to its
+// new key. The file is returned unchanged if there are no aliases in it
+// or if it cannot be parsed.
+func (c *Config) rewriteAliases(filename string, raw []byte) []byte {
+	if len(c.aliases) == 0 || c.unmarshal == nil || c.marshal == nil {
+		return raw
+	}
+	var m map[string]interface{}
+	if err := c.unmarshal(raw, &m); err != nil {
+		return raw
+	}
+	m = normalizeMap(m).(map[string]interface{})
+	changed := false
+	for old, a := range c.aliases {
+		val, ok := mapGet(m, splitKey(old))
+		if !ok {
+			continue
+		}
+		if a.message != "" {
+			c.logf("config: %s: %s", filename, a.message)
+		}
+		path, err := c.filePath(a.key)
+		if err != nil {
+			continue
+		}
+		if _, ok = mapGet(m, path); !ok {
+			mapSet(m, path, val)
+		}
+		mapDelete(m, splitKey(old))
+		changed = true
+	}
+	if !changed {
+		return raw
+	}
+	b, err := c.marshal(m)
+	if err != nil {
+		return raw
+	}
+	return b
+}
+
+// filePath returns the path of a key as it is written in config files.
+func (c *Config) filePath(key string) ([]string, error) {
+	var (
+		keys = splitKey(key)
+		path = make([]string, len(keys))
+		val  = c.elem
+	)
+	for i := range keys {
+		field, fld, err := findField(val, keys[i:i+1], c.tagSet())
+		if err != nil {
+			return nil, err
+		}
+		path[i] = c.fileKey(fld)
+		val = indirect(field, false)
+	}
+	return path, nil
+}
+
+// normalizeMap will convert all the nested maps from yaml
+// to maps with string keys.
+func normalizeMap(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = normalizeMap(val)
+		}
+		return m
+	case map[string]interface{}:
+		for k, val := range v {
+			v[k] = normalizeMap(val)
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeMap(v[i])
+		}
+		return v
+	}
+	return v
+}
+
+func mapGet(m map[string]interface{}, path []string) (interface{}, bool) {
+	for i, p := range path {
+		v, ok := m[p]
+		if !ok {
+			return nil, false
+		}
+		if i == len(path)-1 {
+			return v, true
+		}
+		if m, ok = v.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func mapSet(m map[string]interface{}, path []string, val interface{}) {
+	for _, p := range path[:len(path)-1] {
+		sub, ok := m[p].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			m[p] = sub
+		}
+		m = sub
+	}
+	m[path[len(path)-1]] = val
+}
+
+func mapDelete(m map[string]interface{}, path []string) {
+	for _, p := range path[:len(path)-1] {
+		sub, ok := m[p].(map[string]interface{})
+		if !ok {
+			return
+		}
+		m = sub
+	}
+	delete(m, path[len(path)-1])
+}
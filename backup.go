@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// SetBackups will set the number of backups kept when a config file is
+// rewritten by Save, WriteFile or the edit command. A value of one keeps a
+// single copy of the old file named "<file>.bak". Larger values keep a
+// rotating set of backups named "<file>.bak.1" through "<file>.bak.<n>"
+// where "<file>.bak.1" is always the most recent. Zero, the default,
+// disables backups.
+func SetBackups(n int) { c.SetBackups(n) }
+
+// SetBackups will set the number of backups kept when a config file is
+// rewritten by Save, WriteFile or the edit command. A value of one keeps a
+// single copy of the old file named "<file>.bak". Larger values keep a
+// rotating set of backups named "<file>.bak.1" through "<file>.bak.<n>"
+// where "<file>.bak.1" is always the most recent. Zero, the default,
+// disables backups.
+func (c *Config) SetBackups(n int) {
+	if n < 0 {
+		n = 0
+	}
+	c.mu.Lock()
+	c.backups = n
+	c.mu.Unlock()
+}
+
+// backup will copy a file to its backup location before it is
+// overwritten, rotating older backups. Files that do not exist yet
+// are ignored.
+func (c *Config) backup(filename string) error {
+	n := c.backups
+	if n == 0 {
+		return nil
+	}
+	stat, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if n == 1 {
+		return ioutil.WriteFile(filename+".bak", raw, stat.Mode().Perm())
+	}
+	for i := n - 1; i > 0; i-- {
+		err = os.Rename(backupName(filename, i), backupName(filename, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return ioutil.WriteFile(backupName(filename, 1), raw, stat.Mode().Perm())
+}
+
+func backupName(filename string, n int) string {
+	return fmt.Sprintf("%s.bak.%d", filename, n)
+}
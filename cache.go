@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// cachedFile is a config file that has already been read and processed.
+type cachedFile struct {
+	modTime  time.Time
+	size     int64
+	cachedAt time.Time
+	sum      [sha256.Size]byte
+	orig     []byte // contents of the file
+	plain    []byte // contents after decryption
+	raw      []byte // contents after decryption, migrations, etc.
+	keys     map[string]int
+}
+
+// fresh returns true if the file has not changed since it was cached
+// without reading it. Files modified within a second of being cached
+// could be changed again without changing the modtime so they are
+// always checked against their hash.
+func (cf *cachedFile) fresh(info os.FileInfo) bool {
+	return info.ModTime().Equal(cf.modTime) &&
+		info.Size() == cf.size &&
+		cf.modTime.Before(cf.cachedAt.Add(-time.Second))
+}
+
+// cacheable returns true if the contents of a processed config file
+// only depend on the file itself.
+func (c *Config) cacheable(filename string) bool {
+	return !c.templates && !c.autoExpand && c.evaluator(filename) == nil
+}
+
+// readCachedFile will read a config file the same way as readFile but
+// will skip processing the file again if it has not changed since it was
+// last read.
+func (c *Config) readCachedFile(filename string) ([]byte, error) {
+	if !c.cacheable(filename) {
+		return c.readFile(filename)
+	}
+	if err := c.checkPermissions(filename); err != nil {
+		return nil, err
+	}
+	fs := c.filesystem()
+	info, err := fs.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	c.cachemu.Lock()
+	cf := c.files[filename]
+	c.cachemu.Unlock()
+	if cf != nil && cf.fresh(info) {
+		// still verify in case the signature changed
+		if err = c.verify(filename, cf.orig); err != nil {
+			return nil, err
+		}
+		return cf.raw, nil
+	}
+
+	orig, err := c.readRaw(filename)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(orig)
+	if cf != nil && cf.sum == sum {
+		if err = c.verify(filename, orig); err != nil {
+			return nil, err
+		}
+	} else {
+		if err = c.verify(filename, orig); err != nil {
+			return nil, err
+		}
+		plain, err := c.decryptFile(filename, orig)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := c.transform(filename, plain)
+		if err != nil {
+			return nil, err
+		}
+		cf = &cachedFile{sum: sum, orig: orig, plain: plain, raw: raw}
+	}
+	c.cachemu.Lock()
+	cf = &cachedFile{
+		modTime:  info.ModTime(),
+		size:     info.Size(),
+		cachedAt: time.Now(),
+		sum:      sum,
+		orig:     cf.orig,
+		plain:    cf.plain,
+		raw:      cf.raw,
+		keys:     cf.keys,
+	}
+	if c.files == nil {
+		c.files = make(map[string]*cachedFile)
+	}
+	c.files[filename] = cf
+	c.cachemu.Unlock()
+	return cf.raw, nil
+}
+
+// cachedKeys is the same as fileKeys but it will use the keys of
+// a cached file if raw is the processed contents of the file. The
+// line numbers of cached files are found in the decrypted file. The
+// map returned must not be changed.
+func (c *Config) cachedKeys(filename string, raw []byte) map[string]int {
+	c.cachemu.Lock()
+	cf := c.files[filename]
+	var keys map[string]int
+	if cf != nil {
+		keys = cf.keys
+	}
+	c.cachemu.Unlock()
+	if cf == nil || !bytes.Equal(cf.raw, raw) {
+		return c.fileKeys(raw)
+	}
+	if keys == nil {
+		keys = c.fileKeys(raw)
+		if cf.plain != nil && !bytes.Equal(cf.plain, raw) {
+			c.setLines(keys, cf.plain)
+		}
+		c.cachemu.Lock()
+		cf.keys = keys
+		c.cachemu.Unlock()
+	}
+	return keys
+}
+
+// cacheEntry returns the cached contents of a file or nil
+// if the file has not been read or cannot be cached.
+func (c *Config) cacheEntry(filename string) *cachedFile {
+	if !c.cacheable(filename) {
+		return nil
+	}
+	c.cachemu.Lock()
+	defer c.cachemu.Unlock()
+	return c.files[filename]
+}
+
+// clearFileCache will remove all the cached files. This is called
+// when the settings used to process files are changed.
+func (c *Config) clearFileCache() {
+	c.cachemu.Lock()
+	c.files = nil
+	c.cachemu.Unlock()
+}
@@ -0,0 +1,136 @@
+package config
+
+import "reflect"
+
+// Clone returns an independent copy of the config. The config struct is
+// deep copied along with the files and paths, the config type, and every
+// other setting so that the copy can be changed or read from different
+// files without changing the original. Bound flags, the history, and
+// cached files are not copied.
+func Clone() *Config { return c.Clone() }
+
+// Clone returns an independent copy of the config. The config struct is
+// deep copied along with the files and paths, the config type, and every
+// other setting so that the copy can be changed or read from different
+// files without changing the original. Bound flags, the history, and
+// cached files are not copied.
+func (c *Config) Clone() *Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cp := c.copySettings()
+
+	c.srcmu.Lock()
+	if c.sources != nil {
+		cp.sources = make(map[string]Source, len(c.sources))
+		for k, v := range c.sources {
+			cp.sources[k] = v
+		}
+	}
+	c.srcmu.Unlock()
+
+	if c.elem.IsValid() {
+		elem := copyVal(c.elem)
+		cp.elem = elem
+		cp.config = elem.Addr().Interface()
+		cp.buildIndex()
+	}
+	return cp
+}
+
+// copySettings returns a copy of every setting without the config
+// struct or its sources. The caller must hold c.mu.
+func (c *Config) copySettings() *Config {
+	cp := &Config{
+		filepaths:       copyStrings(c.filepaths),
+		filenames:       copyStrings(c.filenames),
+		paths:           copyStrings(c.paths),
+		patterns:        copyStrings(c.patterns),
+		marshal:         c.marshal,
+		marshalIndent:   c.marshalIndent,
+		unmarshal:       c.unmarshal,
+		unmarshalStrict: c.unmarshalStrict,
+		tag:             c.tag,
+		tags:            c.tags,
+		keyTags:         copyStrings(c.keyTags),
+		tagNames:        copyStrings(c.tagNames),
+		extraTags:       copyStrings(c.extraTags),
+		sopsDecrypt:     c.sopsDecrypt,
+		logger:          c.logger,
+		securePerms:     c.securePerms,
+		pubkey:          c.pubkey,
+		version:         c.version,
+		hooks:           append([]DecodeHook(nil), c.hooks...),
+		fs:              c.fs,
+		autoExpand:      c.autoExpand,
+		expandFunc:      c.expandFunc,
+		tracer:          c.tracer,
+		readTimeout:     c.readTimeout,
+		reloadInterval:  c.reloadInterval,
+		precedence:      c.precedence,
+		allowMissing:    c.allowMissing,
+		failFast:        c.failFast,
+		mergeStrategy:   c.mergeStrategy,
+		backups:         c.backups,
+		lockTimeout:     c.lockTimeout,
+		jsonc:           c.jsonc,
+		strictTags:      c.strictTags,
+		elevatedEdit:    c.elevatedEdit,
+		templates:       c.templates,
+		templateData:    c.templateData,
+		keyMapper:       c.keyMapper,
+		readOnly:        c.readOnly,
+		flagFile:        c.flagFile,
+		flagFileMode:    c.flagFileMode,
+	}
+	if c.ciphers != nil {
+		cp.ciphers = make(map[string]Cipher, len(c.ciphers))
+		for k, v := range c.ciphers {
+			cp.ciphers[k] = v
+		}
+	}
+	if c.evaluators != nil {
+		cp.evaluators = make(map[string]Evaluator, len(c.evaluators))
+		for k, v := range c.evaluators {
+			cp.evaluators[k] = v
+		}
+	}
+	if c.aliases != nil {
+		cp.aliases = make(map[string]alias, len(c.aliases))
+		for k, v := range c.aliases {
+			cp.aliases[k] = v
+		}
+	}
+	if c.migrations != nil {
+		cp.migrations = make(map[int]migration, len(c.migrations))
+		for k, v := range c.migrations {
+			cp.migrations[k] = v
+		}
+	}
+	if c.mergers != nil {
+		cp.mergers = make(map[reflect.Type]MergeFunc, len(c.mergers))
+		for k, v := range c.mergers {
+			cp.mergers[k] = v
+		}
+	}
+	cp.nameReqs = copyRequirements(c.nameReqs)
+	cp.pathReqs = copyRequirements(c.pathReqs)
+	return cp
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
+
+func copyRequirements(m map[string]FileRequirement) map[string]FileRequirement {
+	if m == nil {
+		return nil
+	}
+	cp := make(map[string]FileRequirement, len(m))
+	for k, v := range m {
+		cp[k] = v
+	}
+	return cp
+}
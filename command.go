@@ -0,0 +1,566 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+func (c *Config) newSetCommand() *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "Set a config variable",
+		Long: `Set a config variable and save it to the config file.
+
+The value is parsed according to the type of the config field.`,
+		Args: cobra.ExactArgs(2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return c.completeKeys(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !dryRun {
+				if err := c.SetFromString(args[0], args[1]); err != nil {
+					return err
+				}
+				return c.Save()
+			}
+			// Work on a copy so that a dry run never changes the
+			// config struct but accepts the same keys as Set.
+			c.mu.RLock()
+			if c.readOnly {
+				c.mu.RUnlock()
+				return ErrReadOnly
+			}
+			key := c.lookupKey(args[0])
+			cp := copyVal(c.elem)
+			c.mu.RUnlock()
+			if err := c.setString(cp, key, args[1]); err != nil {
+				return err
+			}
+			if !revealSecrets(cmd) {
+				c.redact(cp)
+			}
+			b, err := c.marshalValue(cp.Addr().Interface())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s", b)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the resulting config instead of saving it")
+	return cmd
+}
+
+func (c *Config) newUnsetCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unset <key>...",
+		Short: "Reset a config variable",
+		Long: `Reset config variables and remove them from the config file.
+
+After being reset, the default value of each variable will be used.`,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: c.completeKeys,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, key := range args {
+				if err := c.Unset(key); err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+			}
+			return c.Save()
+		},
+	}
+}
+
+// completeKeys is a cobra completion function that completes config
+// keys which have not already been given as arguments. The usage of
+// each key is used as its description.
+func (c *Config) completeKeys(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	used := make(map[string]bool, len(args))
+	for _, arg := range args {
+		used[arg] = true
+	}
+	var keys []string
+	for _, comp := range c.KeyCompletions(toComplete) {
+		if used[comp.Key] {
+			continue
+		}
+		if comp.Usage != "" {
+			keys = append(keys, comp.Key+"\t"+comp.Usage)
+		} else {
+			keys = append(keys, comp.Key)
+		}
+	}
+	return keys, cobra.ShellCompDirectiveNoFileComp
+}
+
+// revealSecrets returns true if the --reveal flag was given.
+func revealSecrets(cmd *cobra.Command) bool {
+	reveal, _ := cmd.Flags().GetBool("reveal")
+	return reveal
+}
+
+type keyListing struct {
+	Key   string      `json:"key" yaml:"key"`
+	Value interface{} `json:"value" yaml:"value"`
+	Type  string      `json:"type" yaml:"type"`
+	Usage string      `json:"usage,omitempty" yaml:"usage,omitempty"`
+}
+
+func (c *Config) listKeys(reveal bool) []keyListing {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	list := make([]keyListing, 0)
+	c.walk(c.elem, "", func(key string, fld reflect.StructField, val reflect.Value) error {
+		_, _, usage, _ := getFlagInfo(fld)
+		val = c.effectiveValue(key, fld, val)
+		v := val.Interface()
+		if !reveal {
+			v = c.redactValue(fld, val)
+		}
+		list = append(list, keyListing{
+			Key:   key,
+			Value: v,
+			Type:  fld.Type.String(),
+			Usage: usage,
+		})
+		return nil
+	})
+	return list
+}
+
+func (c *Config) newGetCommand() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "get <key...>",
+		Short: "Get a config variable",
+		Long: `Get the value of one or more config variables.
+
+When multiple keys are given with json or yaml output, the values are
+printed as a single document mapping each key to its value. The command
+fails if any key does not exist.`,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: c.completeKeys,
+		SilenceUsage:      true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				vals   = make(yaml.MapSlice, len(args))
+				reveal = revealSecrets(cmd)
+			)
+			for i, key := range args {
+				val, err := c.GetErr(key)
+				if err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				if !reveal {
+					val = c.redactKey(key, val)
+				}
+				vals[i] = yaml.MapItem{Key: key, Value: val}
+			}
+			var (
+				b   []byte
+				err error
+			)
+			switch output {
+			case "raw", "":
+				for _, v := range vals {
+					fmt.Fprintf(cmd.OutOrStdout(), "%+v\n", v.Value)
+				}
+				return nil
+			case "json":
+				if len(vals) == 1 {
+					b, err = json.MarshalIndent(vals[0].Value, "", "  ")
+					break
+				}
+				m := make(map[string]interface{}, len(vals))
+				for _, v := range vals {
+					m[v.Key.(string)] = v.Value
+				}
+				b, err = json.MarshalIndent(m, "", "  ")
+			case "yaml", "yml":
+				if len(vals) == 1 {
+					b, err = yaml.Marshal(vals[0].Value)
+				} else {
+					b, err = yaml.Marshal(vals)
+				}
+				b = bytes.TrimSuffix(b, []byte{'\n'})
+			default:
+				return fmt.Errorf("unknown output format %q", output)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s\n", b)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "raw", "output format (raw, json, yaml)")
+	return cmd
+}
+
+func (c *Config) newListCommand() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List all config variables",
+		Long:    `List every config variable along with its value, type, and description.`,
+		Aliases: []string{"ls"},
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				b    []byte
+				err  error
+				list = c.listKeys(revealSecrets(cmd))
+			)
+			switch output {
+			case "json":
+				b, err = json.MarshalIndent(list, "", "  ")
+			case "yaml", "yml":
+				b, err = yaml.Marshal(list)
+			case "table", "":
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "KEY\tVALUE\tTYPE\tDESCRIPTION")
+				for _, l := range list {
+					fmt.Fprintf(w, "%s\t%v\t%s\t%s\n", l.Key, l.Value, l.Type, l.Usage)
+				}
+				return w.Flush()
+			default:
+				return fmt.Errorf("unknown output format %q", output)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s\n", b)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "output format (table, json, yaml)")
+	return cmd
+}
+
+func (c *Config) newEnvCommand() *cobra.Command {
+	var prefix string
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Print config variables as environment variables",
+		Long: `Print every config variable as a KEY=value pair that can be used as an
+env file or given to another program.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, e := range c.exportEnv(prefix, revealSecrets(cmd)) {
+				fmt.Fprintln(cmd.OutOrStdout(), e)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&prefix, "prefix", "p", "", "prefix added to each variable name")
+	return cmd
+}
+
+func (c *Config) newExplainCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "explain [key...]",
+		Short: "Show where config variables were set",
+		Long: `Show the value of config variables and where each value came from.
+
+If no keys are given then every config variable is shown.`,
+		ValidArgsFunction: c.completeKeys,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keys := args
+			if len(keys) == 0 {
+				keys = c.AllKeys()
+			}
+			reveal := revealSecrets(cmd)
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+			for _, key := range keys {
+				val, err := c.GetErr(key)
+				if err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				src, err := c.Origin(key)
+				if err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				if !reveal {
+					val = c.redactKey(key, val)
+				}
+				fmt.Fprintf(w, "%s\t%v\t%s\n", key, val, src)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// edit will open the config file in a text editor. If there is no config
+// file then one is created with the default config values. The file is
+// checked for errors after the editor exits and the user may choose to
+// re-open the editor if the file is invalid.
+func (c *Config) edit(cmd *cobra.Command) error {
+	var file string
+	if files := c.FilesUsed(); len(files) > 0 {
+		file = files[0]
+	} else {
+		f, err := c.preferredFile()
+		if err != nil {
+			return err
+		}
+		raw, err := c.sampleConfig()
+		if err != nil {
+			return err
+		}
+		if err = c.writeFile(f, raw); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "created %s\n", f)
+		file = f
+	}
+	if c.cipher(file) != nil {
+		return c.editEncrypted(cmd, file)
+	}
+	// Files that need elevated permissions are checked before
+	// anything is written so that the error is not hidden.
+	writable, err := canWrite(file)
+	if err != nil {
+		return err
+	}
+	if !writable && !c.elevatedEdit {
+		return fmt.Errorf("cannot edit %s (see AllowElevatedEdit): %w", file, os.ErrPermission)
+	}
+	unlock, err := c.lock(file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err = c.backup(file); err != nil {
+		if writable || !os.IsPermission(err) {
+			return err
+		}
+		// The directory of a file edited with elevated
+		// permissions is usually not writable either.
+		fmt.Fprintf(cmd.ErrOrStderr(), "not backing up %s: %v\n", file, err)
+	}
+	return c.editFile(cmd, file)
+}
+
+// editEncrypted will decrypt a config file into a temporary file for
+// editing and encrypt the new contents once they are valid.
+func (c *Config) editEncrypted(cmd *cobra.Command, file string) error {
+	raw, err := c.readFile(file)
+	if err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, filepath.Base(c.plainName(file)))
+	if err = ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	if err = c.editFile(cmd, tmp); err != nil {
+		return err
+	}
+	if raw, err = ioutil.ReadFile(tmp); err != nil {
+		return err
+	}
+	return c.writeFile(file, raw)
+}
+
+// editFile will open a file in the editor until it is valid and then
+// print the keys that were changed.
+func (c *Config) editFile(cmd *cobra.Command, file string) error {
+	in := bufio.NewReader(cmd.InOrStdin())
+	before, _ := c.parseFile(file)
+	for {
+		ex, err := c.runEditor(file, cmd.ErrOrStderr())
+		if err != nil {
+			return err
+		}
+		ex.Stdout = cmd.OutOrStdout()
+		ex.Stderr = cmd.ErrOrStderr()
+		ex.Stdin = cmd.InOrStdin()
+		if err = ex.Run(); err != nil {
+			return err
+		}
+		after, err := c.parseFile(file)
+		if err == nil {
+			if before.IsValid() {
+				printChanges(cmd.ErrOrStderr(), c.diffConfig(before, after, revealSecrets(cmd)))
+			}
+			return nil
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+		if !confirm(in, cmd.ErrOrStderr(), "Re-open the editor? [Y/n] ") {
+			return err
+		}
+	}
+}
+
+func confirm(r *bufio.Reader, w io.Writer, prompt string) bool {
+	fmt.Fprint(w, prompt)
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "", "y", "yes":
+		return true
+	}
+	return false
+}
+
+func (c *Config) newInitCommand() *cobra.Command {
+	var (
+		force bool
+		dir   string
+	)
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Create a new config file",
+		Long:  `Create a new config file with the default value of every config variable.`,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := c.preferredFile()
+			if err != nil {
+				return err
+			}
+			if dir != "" {
+				file = filepath.Join(dir, filepath.Base(file))
+			}
+			if _, err = os.Stat(file); err == nil && !force {
+				return fmt.Errorf("%s already exists (use --force to overwrite it)", file)
+			}
+			raw, err := c.sampleConfig()
+			if err != nil {
+				return err
+			}
+			if err = c.writeFile(file, raw); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), file)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolVarP(&force, "force", "f", false, "overwrite an existing config file")
+	flags.StringVar(&dir, "path", "", "directory to create the config file in")
+	return cmd
+}
+
+func (c *Config) newValidateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate [file...]",
+		Short: "Check config files for errors",
+		Long: `Check config files for unknown keys, invalid values, and errors
+reported by the config's Validate method without changing the current
+config.
+
+If no files are given then the config files being used are checked.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			files := args
+			if len(files) == 0 {
+				files = c.FilesUsed()
+			}
+			if len(files) == 0 {
+				return ErrNoConfigFile
+			}
+			errs := c.checkFiles(files)
+			for _, err := range errs {
+				fmt.Fprintln(cmd.ErrOrStderr(), err)
+			}
+			if len(errs) > 0 {
+				return fmt.Errorf("found %d invalid config file(s)", len(errs))
+			}
+			for _, file := range files {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: ok\n", file)
+			}
+			return nil
+		},
+	}
+}
+
+func (c *Config) newConvertCommand() *cobra.Command {
+	var to, in, out string
+	cmd := &cobra.Command{
+		Use:   "convert --to <format>",
+		Short: "Convert a config file to another format",
+		Long: `Convert a config file to another format using the config struct as
+the schema. Supported formats are yaml, json, and toml.
+
+The input format is taken from the file extension. If no input file is
+given then the config file being used is converted. If no output file
+is given then the result is printed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src := in
+			if src == "" {
+				files := c.FilesUsed()
+				if len(files) == 0 {
+					return ErrNoConfigFile
+				}
+				src = files[0]
+			}
+			// Read and encode with copies of the config so that the
+			// input is migrated and filtered using its own format and
+			// ignored fields are left out using the same struct tags.
+			dec := c.Clone()
+			if err := dec.SetType(fileType(c.plainName(src), c.tag)); err != nil {
+				return err
+			}
+			enc := c.Clone()
+			if err := enc.SetType(to); err != nil {
+				return err
+			}
+			raw, err := dec.readFile(src)
+			if err != nil {
+				return err
+			}
+			v := reflect.New(c.elem.Type()).Interface()
+			if err = dec.unmarshal(raw, v); err != nil {
+				return fmt.Errorf("%s: %w", src, err)
+			}
+			if raw, err = enc.marshalValue(v); err != nil {
+				return err
+			}
+			if out == "" {
+				_, err = cmd.OutOrStdout().Write(raw)
+				return err
+			}
+			return c.writeFile(out, raw)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&to, "to", "t", "", "output format (yaml, json, toml)")
+	flags.StringVarP(&in, "in", "i", "", "input config file")
+	flags.StringVarP(&out, "out", "o", "", "output file")
+	cmd.MarkFlagRequired("to")
+	return cmd
+}
+
+// fileType returns the config type for a file
+// based on its extension.
+func fileType(filename, fallback string) string {
+	switch ext := strings.TrimPrefix(filepath.Ext(filename), "."); ext {
+	case "yaml", "yml", "json", "jsonc", "json5", "toml":
+		return ext
+	}
+	return fallback
+}
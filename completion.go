@@ -0,0 +1,43 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Completion describes a config key for shell completion or inline help.
+type Completion struct {
+	Key   string
+	Type  string
+	Usage string
+}
+
+// KeyCompletions returns every key in the config struct that starts with
+// a prefix along with the key's type and the usage given in its struct
+// tags. This is useful for adding completion to command line interfaces
+// that do not use cobra.
+func KeyCompletions(prefix string) []Completion { return c.KeyCompletions(prefix) }
+
+// KeyCompletions returns every key in the config struct that starts with
+// a prefix along with the key's type and the usage given in its struct
+// tags. This is useful for adding completion to command line interfaces
+// that do not use cobra.
+func (c *Config) KeyCompletions(prefix string) []Completion {
+	if c.elem.Kind() == reflect.Invalid {
+		panic(errElemNotSet)
+	}
+	completions := make([]Completion, 0)
+	c.walk(c.elem, "", func(key string, fld reflect.StructField, _ reflect.Value) error {
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		_, _, usage, _ := getFlagInfo(fld)
+		completions = append(completions, Completion{
+			Key:   key,
+			Type:  fld.Type.String(),
+			Usage: usage,
+		})
+		return nil
+	})
+	return completions
+}
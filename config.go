@@ -2,19 +2,24 @@ package config
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"os/exec"
+	"os/user"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/mitchellh/go-homedir"
+	"github.com/BurntSushi/toml"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"gopkg.in/yaml.v2"
@@ -29,8 +34,14 @@ var (
 	// ErrFieldNotFound is returned when the field of a struct
 	// was not found with reflection
 	ErrFieldNotFound = errors.New("could not find struct field")
+	// ErrIndexOutOfRange is returned when a key has an index
+	// that is out of range for a slice or array.
+	ErrIndexOutOfRange = errors.New("index out of range")
 	// ErrWrongType is returned when the wrong type is used
 	ErrWrongType = errors.New("wrong type")
+	// ErrUnsupportedFlagType is returned when a struct field
+	// cannot be bound to a command line flag.
+	ErrUnsupportedFlagType = errors.New("unsupported flag type")
 
 	c      *Config
 	nilval = reflect.ValueOf(nil)
@@ -43,13 +54,31 @@ var (
 func init() { c = &Config{} }
 
 // New creates a new config object from a configuration
-// struct.
-func New(conf interface{}) *Config {
-	cfg := &Config{}
-	cfg.SetConfig(conf)
+// struct. New will panic if any of the options return an
+// error, use NewConfig to get the error instead.
+func New(conf interface{}, opts ...Option) *Config {
+	cfg, err := NewConfig(conf, opts...)
+	if err != nil {
+		panic(fmt.Errorf("config.New: %w", err))
+	}
 	return cfg
 }
 
+// NewConfig is the same as New but it will return the
+// error from the first option that fails, see Option.
+func NewConfig(conf interface{}, opts ...Option) (*Config, error) {
+	cfg := &Config{}
+	if err := cfg.SetConfig(conf); err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
 // Config holds configuration metadata
 type Config struct {
 	// List of full filepaths for possible config files.
@@ -58,40 +87,186 @@ type Config struct {
 	filenames []string
 	// List of directories in which a config file might be.
 	paths []string
+	// Glob patterns for config files, see AddFilePattern.
+	patterns []string
 
 	marshal       func(v interface{}) ([]byte, error)
 	marshalIndent func(v interface{}, prefix, indent string) ([]byte, error)
 	unmarshal     func([]byte, interface{}) error
-	tag           string
+	// unmarshalStrict is the same as unmarshal but returns
+	// an error for any unknown keys.
+	unmarshalStrict func([]byte, interface{}) error
+	tag             string
+	// Struct tags used in keys, see tagSet, SetTagNames and AddTagNames.
+	tags                         tagSet
+	keyTags, tagNames, extraTags []string
 
 	// Actual config data
 	config interface{}
 	elem   reflect.Value
 
-	mu sync.Mutex
+	mu sync.RWMutex
+
+	// Source of each config value, see Origin.
+	sources map[string]Source
+	srcmu   sync.Mutex
+
+	// Ciphers used for encrypted files and evaluators
+	// (see SetEvaluator) mapped by file extension.
+	ciphers    map[string]Cipher
+	evaluators map[string]Evaluator
+	// Used to decrypt sops files, see SetSOPS.
+	sopsDecrypt func(data []byte, format string) ([]byte, error)
+
+	logger      Logger
+	securePerms bool
+	// Key used to verify config files, see RequireSignature.
+	pubkey ed25519.PublicKey
+	// Old keys mapped to their new keys, see RegisterAlias.
+	aliases map[string]alias
+	// Migrations mapped by the version they start
+	// from and the newest version, see RegisterMigration.
+	migrations map[int]migration
+	version    int
+	// See AddDecodeHook
+	hooks []DecodeHook
+	// Key paths mapped to struct fields, see buildIndex.
+	index fieldIndex
+	fs    FS
+	// See SetAutoExpandEnv and SetExpandFunc.
+	autoExpand bool
+	expandFunc func(string) string
+	tracer     Tracer
+	// Steps traced while c.mu is locked, see flushTraces.
+	traces  []traceStep
+	tracemu sync.Mutex
+	// See SetReadTimeout
+	readTimeout time.Duration
+	// Context of the read that a copy of the
+	// config was made for, see readFileContext.
+	readCtx context.Context
+	// See SetReloadInterval and SkippedReloads
+	reloadInterval time.Duration
+	skippedReloads uint64
+	// See SetFilePrecedence and AllowMissingFile
+	precedence   FilePrecedence
+	allowMissing bool
+	// See FailFast
+	failFast bool
+	// Files marked with AddFile and AddFilepath mapped
+	// by their name or path, see FileRequirement.
+	nameReqs, pathReqs map[string]FileRequirement
+	// See SetMergeStrategy and RegisterMerger
+	mergeStrategy MergeStrategy
+	mergers       map[reflect.Type]MergeFunc
+	// See SetBackups and SetLockTimeout
+	backups     int
+	lockTimeout time.Duration
+	// Strip json comments, see SetType.
+	jsonc bool
+	// See SetStrictTags
+	strictTags bool
+	// See AllowElevatedEdit
+	elevatedEdit bool
+	// See SetTemplates and SetTemplateData
+	templates    bool
+	templateData interface{}
+	// See SetKeyMapper
+	keyMapper func(string) string
+	// See SetReadOnly
+	readOnly bool
+	// File given to the flag from BindConfigFileFlag.
+	flagFile     string
+	flagFileMode FileFlagMode
+	// Values given to the flag from BindOverrideFlag.
+	overrides []override
+	// Every flag that has been bound, see SetConfig.
+	flags []*flagValue
+	// Files that have been read mapped by
+	// their path, see readCachedFile.
+	files   map[string]*cachedFile
+	cachemu sync.Mutex
+	// Revisions of files read from a RevisionFS.
+	revisions map[string]string
+	// Ring buffer of changes, see SetHistory.
+	history    []Mutation
+	historyPos int
+	historyOut io.Writer
+	histmu     sync.Mutex
 }
 
-// SetConfig will set the config struct
+// SetConfig will set the config struct. The config struct can be
+// replaced at any time, even while watching files, and any flags that
+// are already bound will set the fields in the new struct with the same
+// keys. Flags given on the command line are set again in the new struct
+// and an error is returned if they no longer match a field.
 func SetConfig(conf interface{}) error { return c.SetConfig(conf) }
 
-// SetConfig will set the config struct
+// SetConfig will set the config struct. The config struct can be
+// replaced at any time, even while watching files, and any flags that
+// are already bound will set the fields in the new struct with the same
+// keys. Flags given on the command line are set again in the new struct
+// and an error is returned if they no longer match a field.
 func (c *Config) SetConfig(conf interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	replace := c.elem.IsValid()
 	c.config = conf
 
 	c.elem = reflect.ValueOf(conf)
 	if c.elem.Kind() == reflect.Ptr {
 		c.elem = c.elem.Elem()
 	}
-	return nil
+	c.buildIndex()
+	c.clearFileCache()
+	if !replace {
+		return nil
+	}
+	// The old sources do not describe the new values.
+	c.srcmu.Lock()
+	c.sources = nil
+	c.srcmu.Unlock()
+	err := c.rebindFlags()
+	if oerr := c.applyOverrides(); err == nil {
+		err = oerr
+	}
+	return err
 }
 
 // InitDefaults will find all the default values and set each
-// struct field accordingly.
+// struct field accordingly. If any default values cannot be set, a
+// DefaultsError is returned listing every field that failed.
 func InitDefaults() error { return c.InitDefaults() }
 
 // InitDefaults will find all the default values and set each
-// struct field accordingly.
-func (c *Config) InitDefaults() error { return setDefaults(c.elem) }
+// struct field accordingly. If any default values cannot be set, a
+// DefaultsError is returned listing every field that failed.
+func (c *Config) InitDefaults() error {
+	if err := c.checkTags(); err != nil {
+		return err
+	}
+	return setDefaultsFrom(c.elem, c.getDefaultValue)
+}
+
+// DefaultsCheck will check that every default value in the struct tags
+// of the config struct can be parsed without changing the config struct.
+// A DefaultsError is returned listing every bad default value. This is
+// useful in unit tests.
+func DefaultsCheck() error { return c.DefaultsCheck() }
+
+// DefaultsCheck will check that every default value in the struct tags
+// of the config struct can be parsed without changing the config struct.
+// A DefaultsError is returned listing every bad default value. This is
+// useful in unit tests.
+func (c *Config) DefaultsCheck() error {
+	if c.elem.Kind() == reflect.Invalid {
+		return errElemNotSet
+	}
+	if err := c.checkTags(); err != nil {
+		return err
+	}
+	return setDefaultsFrom(reflect.New(c.elem.Type()).Elem(), c.getTagDefault)
+}
 
 // GetConfig will return the the config struct that has been
 // set by the user but as an interface type.
@@ -107,14 +282,31 @@ func (c *Config) GetConfig() interface{} {
 // configuration folders where a file could be found.
 // See AddFile to add a file to the list of possible
 // files to be read within a configuration search path.
+//
+// Environment variables in the path are expanded along with the
+// tokens "{userconfig}" (see os.UserConfigDir), "{home}" (see
+// HomeDir), "{cache}" (see os.UserCacheDir) and "{tmp}" (see
+// os.TempDir) so that paths work on every platform.
+//
+//	config.AddPath("{userconfig}/myapp")
 func AddPath(path string) { c.AddPath(path) }
 
 // AddPath will add a path the the list of possible
 // configuration folders where a file could be found.
 // See AddFile to add a file to the list of possible
 // files to be read within a configuration search path.
+//
+// Environment variables in the path are expanded along with the
+// tokens "{userconfig}" (see os.UserConfigDir), "{home}" (see
+// HomeDir), "{cache}" (see os.UserCacheDir) and "{tmp}" (see
+// os.TempDir) so that paths work on every platform. Paths with
+// tokens that cannot be found are not added.
 func (c *Config) AddPath(path string) {
-	p := os.ExpandEnv(path)
+	p, err := expandTokens(os.ExpandEnv(path))
+	if err != nil {
+		c.logf("config.AddPath: %q: %v", path, err)
+		return
+	}
 	if p != "" {
 		c.paths = append(c.paths, p)
 	}
@@ -128,8 +320,9 @@ func (c *Config) AddPath(path string) {
 // any of the paths added with AddPath but is behavior which is not
 // guaranteed to be supported in the future.
 //
-// To add a directory to the search path, use AddPath.
-func AddFile(name string) { c.AddFile(name) }
+// To add a directory to the search path, use AddPath. The file can be
+// marked as Required or Optional, see FileRequirement.
+func AddFile(name string, req ...FileRequirement) { c.AddFile(name, req...) }
 
 // AddFile will add a filename to the list of possible config
 // filenames. This should be the name of a file without any information
@@ -139,23 +332,35 @@ func AddFile(name string) { c.AddFile(name) }
 // any of the paths added with AddPath but is behavior which is not
 // guaranteed to be supported in the future.
 //
-// To add a directory to the search path, use AddPath.
-func (c *Config) AddFile(name string) {
+// To add a directory to the search path, use AddPath. The file can be
+// marked as Required or Optional, see FileRequirement.
+func (c *Config) AddFile(name string, req ...FileRequirement) {
 	c.filenames = append(c.filenames, name)
+	c.setRequirement(&c.nameReqs, name, req)
 }
 
 // AddFilepath will add a full filepath to the list of possible
 // config files. This is not the same as adding the path and filename
 // separately. This does not add a search path or filename to search
 // for and should be regarded as hard coding a configuration filepath.
-func AddFilepath(filepath string) { c.AddFilepath(filepath) }
+// The same directory tokens can be used as in AddPath. The file can be
+// marked as Required or Optional, see FileRequirement.
+func AddFilepath(filepath string, req ...FileRequirement) { c.AddFilepath(filepath, req...) }
 
 // AddFilepath will add a full filepath to the list of possible
 // config files. This is not the same as adding the path and filename
 // separately. This does not add a search path or filename to search
 // for and should be regarded as hard coding a configuration filepath.
-func (c *Config) AddFilepath(filepath string) {
-	c.filepaths = append(c.filepaths, filepath)
+// The same directory tokens can be used as in AddPath. The file can be
+// marked as Required or Optional, see FileRequirement.
+func (c *Config) AddFilepath(filepath string, req ...FileRequirement) {
+	p, err := expandTokens(filepath)
+	if err != nil {
+		c.logf("config.AddFilepath: %q: %v", filepath, err)
+		return
+	}
+	c.filepaths = append(c.filepaths, p)
+	c.setRequirement(&c.pathReqs, p, req)
 }
 
 // RemoveFile will remove a filename from the
@@ -170,6 +375,7 @@ func (c *Config) RemoveFile(name string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.filenames = remove(c.filenames, name)
+	delete(c.nameReqs, name)
 }
 
 // RemovePath will remove a path from the list of possible
@@ -251,12 +457,12 @@ func (c *Config) AddUserConfigDir(dirname string) error {
 }
 
 // AddUserHomeDir will add a config dir using the user home dir
-// (see os.UserHomeDir) and join it with the name given and a "."
+// (see HomeDir) and join it with the name given and a "."
 //	$HOME/.<name>
 func AddUserHomeDir(name string) error { return c.AddUserHomeDir(name) }
 
 // AddUserHomeDir will add a config dir using the user home dir
-// (see os.UserHomeDir) and join it with the name given and a "."
+// (see HomeDir) and join it with the name given and a "."
 //	$HOME/.<name>
 func (c *Config) AddUserHomeDir(name string) error {
 	dir, err := homeDir()
@@ -266,34 +472,65 @@ func (c *Config) AddUserHomeDir(name string) error {
 	return err
 }
 
+// homeDir returns the user's home directory. When running with sudo,
+// the home directory of the user that ran sudo is used.
 func homeDir() (string, error) {
 	if runtime.GOOS != "windows" {
-		// This should be fine for linux and darwin but I'm not sure about
-		// android, netbsd, freebsd, openbsd, plan9, or solaris. If anyone
-		// cares enough to make a pull request about wether or not these OSs
-		// use sudo feel free.
-		user := os.Getenv("SUDO_USER")
-		if user != "" {
-			return filepath.Join("/home", user), nil
+		if name := os.Getenv("SUDO_USER"); name != "" {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", fmt.Errorf("could not find home directory of $SUDO_USER: %w", err)
+			}
+			if u.HomeDir != "" {
+				return u.HomeDir, nil
+			}
 		}
 	}
-	dir, err := homedir.Dir()
+	return os.UserHomeDir()
+}
+
+// expandHome replaces a leading "~" in a path with the home directory.
+func expandHome(path string) (string, error) {
+	if path == "" || path[0] != '~' {
+		return path, nil
+	}
+	if len(path) > 1 && !os.IsPathSeparator(path[1]) {
+		return "", errors.New("cannot expand the home directory of another user")
+	}
+	dir, err := homeDir()
 	if err != nil {
-		return dir, err
+		return "", err
 	}
-	return dir, nil
+	return filepath.Join(dir, path[1:]), nil
 }
 
-// HomeDir will get the user's home directory
+// HomeDir will get the user's home directory. When running with sudo,
+// the home directory of the user that ran sudo is returned. An empty
+// string is returned if the home directory cannot be found, see
+// HomeDirErr.
 func HomeDir() string {
-	home, _ := homedir.Dir()
+	home, _ := HomeDirErr()
 	return home
 }
 
-// SetType will set the file type of config being used.
+// HomeDirErr is the same as HomeDir but it returns an error
+// if the home directory cannot be found.
+func HomeDirErr() (string, error) { return homeDir() }
+
+// SetType will set the file type of config being used. The supported
+// types are "yaml", "json", "toml" and "jsonc". Jsonc files are json
+// files that may have comments and trailing commas, which are removed
+// before the file is decoded. Saving a jsonc config writes plain json.
+// The "json5" type is the same as "jsonc" and does not support the rest
+// of json5.
 func SetType(ext string) error { return c.SetType(ext) }
 
-// SetType will set the file type of config being used.
+// SetType will set the file type of config being used. The supported
+// types are "yaml", "json", "toml" and "jsonc". Jsonc files are json
+// files that may have comments and trailing commas, which are removed
+// before the file is decoded. Saving a jsonc config writes plain json.
+// The "json5" type is the same as "jsonc" and does not support the rest
+// of json5.
 func (c *Config) SetType(t string) error {
 	switch t {
 	case "yaml", "yml":
@@ -305,15 +542,62 @@ func (c *Config) SetType(t string) error {
 			return yaml.Marshal(v)
 		}
 		c.unmarshal = yaml.Unmarshal
+		c.unmarshalStrict = yaml.UnmarshalStrict
 		c.tag = "yaml"
 	case "json":
 		c.marshal = json.Marshal
 		c.marshalIndent = json.MarshalIndent
 		c.unmarshal = json.Unmarshal
+		c.unmarshalStrict = jsonUnmarshalStrict
 		c.tag = "json"
+	case "jsonc", "json5":
+		c.marshal = json.Marshal
+		c.marshalIndent = json.MarshalIndent
+		c.unmarshal = jsoncUnmarshal
+		c.unmarshalStrict = jsoncUnmarshalStrict
+		c.tag = "json"
+	case "toml":
+		c.marshal = tomlMarshal
+		c.marshalIndent = func(
+			v interface{},
+			prefix, indent string,
+		) ([]byte, error) {
+			return tomlMarshal(v)
+		}
+		c.unmarshal = toml.Unmarshal
+		c.unmarshalStrict = tomlUnmarshalStrict
+		c.tag = "toml"
 	default:
 		return fmt.Errorf("unknown config type %s", t)
 	}
+	c.jsonc = t == "jsonc" || t == "json5"
+	c.setTags()
+	c.clearFileCache()
+	return nil
+}
+
+func jsonUnmarshalStrict(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func tomlMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func tomlUnmarshalStrict(b []byte, v interface{}) error {
+	md, err := toml.Decode(string(b), v)
+	if err != nil {
+		return err
+	}
+	if keys := md.Undecoded(); len(keys) > 0 {
+		return fmt.Errorf("unknown keys %v", keys)
+	}
 	return nil
 }
 
@@ -336,11 +620,13 @@ func (c *Config) ReadConfig() error {
 }
 
 // ReadConfigNoOverwrite will read all config files but will not overwrite
-// fields on the config struct if they are not a zero value.
+// fields on the config struct if they are not a zero value. Pointer
+// fields are not overwritten if they are not nil.
 func ReadConfigNoOverwrite() error { return c.ReadConfigNoOverwrite() }
 
 // ReadConfigNoOverwrite will read all config files but will not overwrite
-// fields on the config struct if they are not a zero value.
+// fields on the config struct if they are not a zero value. Pointer
+// fields are not overwritten if they are not nil.
 func (c *Config) ReadConfigNoOverwrite() error {
 	// use 1 so that the readConfigFiles will always merge
 	// a copy insdead of unmarshaling the config in-place.
@@ -352,7 +638,7 @@ func ReadConfigFromFile(filepath string) error { return c.ReadConfigFromFile(fil
 
 // Deprecated: Use AddFilepath
 func (c *Config) ReadConfigFromFile(filepath string) error {
-	raw, err := ioutil.ReadFile(filepath)
+	raw, err := c.readFile(filepath)
 	if err != nil {
 		return err
 	}
@@ -375,18 +661,43 @@ func (c *Config) ReadConfigFile() error { return c.readConfigFiles(0) }
 // will read will not overwrite existing values written by previous config files.
 // To prevent overwrites by default, pass a number greater than zero.
 func (c *Config) readConfigFiles(found int) error {
+	return c.readConfigFilesContext(context.Background(), found)
+}
+
+// readConfigFilesContext is the same as readConfigFiles but it
+// stops when the context is done.
+func (c *Config) readConfigFilesContext(ctx context.Context, found int) error {
 	var (
-		e     error
+		errs  []*FileError
 		start = found // save this until the end
+		seen  = make(map[string]bool)
 	)
+	defer c.flushTraces()
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	defer c.recordChanges("read", Source{Kind: SourceFile}, c.snapshot())
+	defer c.reapplyOverrides()
+	if err := c.checkTags(); err != nil {
+		return err
+	}
 	filepaths := existingFiles(c)
+	missing := c.missingRequired()
+	if len(missing) > 0 && c.failFast {
+		return missing[0]
+	}
 
 	for _, filepath := range filepaths {
-		raw, err := ioutil.ReadFile(filepath)
-		if err != nil && e == nil {
-			e = err
+		done := c.trace(TraceRead, filepath)
+		raw, err := c.readFileContext(ctx, filepath)
+		done(err)
+		if err != nil && ctx.Err() != nil {
+			return err // stop reading files once canceled
+		}
+		if err != nil {
+			errs = append(errs, newFileError(filepath, nil, err))
+			if c.failFast {
+				break
+			}
 			continue
 		}
 
@@ -398,74 +709,156 @@ func (c *Config) readConfigFiles(found int) error {
 		// into the config object. This prevents overwriting
 		// existing values.
 		if found == 1 {
+			done = c.trace(TraceUnmarshal, filepath)
 			err = c.unmarshal(raw, c.config)
+			done(err)
 			if err != nil {
-				e = err
+				errs = append(errs, newFileError(filepath, raw, err))
+				if c.failFast {
+					break
+				}
 				continue
 			}
+			c.recordFile(filepath, raw, seen)
 		} else {
 			cp := reflect.New(c.elem.Type()).Interface()
+			done = c.trace(TraceUnmarshal, filepath)
 			err = c.unmarshal(raw, cp)
-			if err != nil && e == nil {
-				e = err
+			done(err)
+			if err != nil {
+				errs = append(errs, newFileError(filepath, raw, err))
+				if c.failFast {
+					break
+				}
 				continue
 			}
-			err = merge(c.elem, reflect.ValueOf(cp))
-			if err != nil && e == nil {
-				e = err
+			done = c.trace(TraceMerge, filepath)
+			err = c.merge(c.elem, reflect.ValueOf(cp), seen)
+			done(err)
+			if err != nil {
+				errs = append(errs, newFileError(filepath, raw, err))
+				if c.failFast {
+					break
+				}
 				continue
 			}
+			c.recordFile(filepath, raw, seen)
 		}
 	}
 
-	if found == start {
+	errs = append(errs, missing...)
+	if found == start && len(errs) == 0 && !c.allowMissing && !c.allOptional() {
 		return ErrNoConfigFile
 	}
-	return e
+	return fileErrors(errs)
+}
+
+// FailFast will make ReadConfig stop at the first config file that
+// cannot be read, parsed or merged and return its FileError. By default
+// every file is read and the errors from all of them are returned.
+func FailFast(failFast bool) { c.FailFast(failFast) }
+
+// FailFast will make ReadConfig stop at the first config file that
+// cannot be read, parsed or merged and return its FileError. By default
+// every file is read and the errors from all of them are returned.
+func (c *Config) FailFast(failFast bool) {
+	c.mu.Lock()
+	c.failFast = failFast
+	c.mu.Unlock()
+}
+
+// AllowMissingFile will stop ReadConfig from returning ErrNoConfigFile
+// when no config files are found. Default values and environment
+// variables are still used by the getters.
+func AllowMissingFile(allow bool) { c.AllowMissingFile(allow) }
+
+// AllowMissingFile will stop ReadConfig from returning ErrNoConfigFile
+// when no config files are found. Default values and environment
+// variables are still used by the getters.
+func (c *Config) AllowMissingFile(allow bool) {
+	c.mu.Lock()
+	c.allowMissing = allow
+	c.mu.Unlock()
 }
 
 func existingFiles(c *Config) []string {
-	l := len(c.filepaths) + len(c.paths) + len(c.filenames)
-	res := make([]string, 0, l)
-	for _, filepath := range c.filepaths {
-		if fileExists(filepath) {
-			res = append(res, filepath)
-		}
-	}
-	for _, d := range c.paths {
-		for _, f := range c.filenames {
-			file := filepath.Join(d, f)
-			if fileExists(file) {
-				res = append(res, file)
-			}
+	files := c.allPossibleFiles()
+	res := files[:0]
+	for _, file := range files {
+		if c.fileExists(file) {
+			res = append(res, file)
 		}
 	}
 	return res
 }
 
+// allPossibleFiles returns every config file that could be read in
+// order of precedence (see SetFilePrecedence). Full file paths come
+// first followed by each path joined with each filename. Files that
+// are found more than once are only included once.
 func (c *Config) allPossibleFiles() []string {
-	res := make([]string, 0, len(c.filepaths)+len(c.filenames)+len(c.paths))
-	res = append(res, c.filepaths...)
+	res := make([]string, 0, len(c.filepaths)+len(c.filenames)*len(c.paths))
+	seen := make(map[string]bool)
+	add := func(file string) {
+		if key := filepath.Clean(file); !seen[key] {
+			seen[key] = true
+			res = append(res, file)
+		}
+	}
+	for _, f := range c.filepaths {
+		add(f)
+	}
 	for _, p := range c.paths {
 		for _, f := range c.filenames {
-			res = append(res, filepath.Join(p, f))
+			add(filepath.Join(p, f))
+		}
+		for _, f := range c.matchPatterns(p) {
+			add(f)
 		}
 	}
-	return res
+	for _, f := range c.matchPatterns("") {
+		add(f)
+	}
+	if c.precedence == Ascending {
+		for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+			res[i], res[j] = res[j], res[i]
+		}
+	}
+	return c.withFlagFile(res)
 }
 
 // FilesUsed will return a list of all the configuration files
 // that exist within the specified search space. This are the
-// same files used when calling ReadConfig.
+// same files used when calling ReadConfig and they are in order
+// of precedence starting with the highest.
 func FilesUsed() []string { return c.FilesUsed() }
 
 // FilesUsed will return a list of all the configuration files
 // that exist within the specified search space. This are the
-// same files used when calling ReadConfig.
+// same files used when calling ReadConfig and they are in order
+// of precedence starting with the highest.
 func (c *Config) FilesUsed() []string {
 	return existingFiles(c)
 }
 
+// OrderedFilesUsed returns the same files as FilesUsed but in the order
+// that they are applied, starting with the lowest precedence, so values
+// in each file override the values in the files before it. Files
+// matched by AddFilePattern are in lexical order.
+func OrderedFilesUsed() []string { return c.OrderedFilesUsed() }
+
+// OrderedFilesUsed returns the same files as FilesUsed but in the order
+// that they are applied, starting with the lowest precedence, so values
+// in each file override the values in the files before it. Files
+// matched by AddFilePattern are in lexical order.
+func (c *Config) OrderedFilesUsed() []string {
+	files := existingFiles(c)
+	for i, j := 0, len(files)-1; i < j; i, j = i+1, j-1 {
+		files[i], files[j] = files[j], files[i]
+	}
+	return files
+}
+
 // FileUsed will return the file used for
 // configuration. If no existing config directory is
 // found then this will return an empty string.
@@ -488,7 +881,7 @@ func (c *Config) findFile() (string, error) {
 	for _, path := range c.paths {
 		for _, f := range c.filenames {
 			file = filepath.Join(path, f)
-			if fileExists(file) {
+			if c.fileExists(file) {
 				return file, nil
 			}
 		}
@@ -530,7 +923,7 @@ func (c *Config) DirUsed() string {
 	var path string
 	for _, path = range c.paths {
 		// find the first path that exists
-		if exists(path) {
+		if c.exists(path) {
 			return path
 		}
 	}
@@ -546,16 +939,6 @@ func (c *Config) DirUsed() string {
 	return ""
 }
 
-func exists(p string) bool {
-	_, err := os.Stat(p)
-	return !os.IsNotExist(err)
-}
-
-func fileExists(p string) bool {
-	stat, err := os.Stat(p)
-	return !os.IsNotExist(err) && !stat.IsDir()
-}
-
 // Deprecated: Use AddFile
 func SetFilename(name string) { c.SetFilename(name) }
 
@@ -604,25 +987,45 @@ type FlagInfo interface {
 }
 
 // BindToFlagSet will bind the config struct to a standard library
-// flag set
-func BindToFlagSet(set *flag.FlagSet, resolvers ...FlagInfo) { c.BindToFlagSet(set, resolvers...) }
+// flag set.
+//
+// Fields that cannot be represented as a flag, such as maps, are skipped
+// and the first one found is returned as an error wrapping
+// ErrUnsupportedFlagType. Use DisableFlag to silence the error for a
+// specific field.
+func BindToFlagSet(set *flag.FlagSet, resolvers ...FlagInfo) error {
+	return c.BindToFlagSet(set, resolvers...)
+}
 
 // BindToFlagSet will bind the config struct to a standard library
-// flag set
-func (c *Config) BindToFlagSet(set *flag.FlagSet, resolvers ...FlagInfo) {
+// flag set.
+//
+// Fields that cannot be represented as a flag, such as maps, are skipped
+// and the first one found is returned as an error wrapping
+// ErrUnsupportedFlagType. Use DisableFlag to silence the error for a
+// specific field.
+func (c *Config) BindToFlagSet(set *flag.FlagSet, resolvers ...FlagInfo) error {
+	if c.elem.Kind() == reflect.Invalid {
+		return errElemNotSet
+	}
+	if err := c.checkTags(); err != nil {
+		return err
+	}
 	resmap := make(map[string]FlagInfo)
 	for _, r := range resolvers {
 		resmap[r.Name()] = r
 	}
-	bindFlags(c.elem, "", set, resmap)
+	return c.bindFlags(c.elem, nil, "", "", "", set, resmap, make(map[reflect.Type]bool))
 }
 
-func bindFlags(
+func (c *Config) bindFlags(
 	elem reflect.Value,
-	basename string,
+	index []int,
+	basename, basepath, basekey string,
 	set *flag.FlagSet,
 	resolvers map[string]FlagInfo,
-) {
+	stack map[reflect.Type]bool,
+) (err error) {
 	if elem.Kind() == reflect.Ptr {
 		elem = elem.Elem()
 	}
@@ -630,13 +1033,20 @@ func bindFlags(
 	if typ.Kind() == reflect.Ptr {
 		typ = typ.Elem()
 	}
+	// pointers to the types on the stack are skipped
+	// so that recursive types are not bound forever
+	stack[typ] = true
+	defer delete(stack, typ)
 
 	n := typ.NumField()
 	for i := 0; i < n; i++ {
 		fldtyp := typ.Field(i)
 		fldval := elem.Field(i)
-		name, _, usage, ok := getFlagInfo(fldtyp)
-		if !ok {
+		if fldtyp.PkgPath != "" || isIgnored(fldtyp) {
+			continue // unexported or ignored
+		}
+		name, _, usage, ok := c.flagInfo(fldtyp)
+		if !ok || isFileOnly(fldtyp) {
 			continue
 		}
 		if basename != "" {
@@ -650,59 +1060,139 @@ func bindFlags(
 			usage = r.Usage()
 			name = r.Name()
 		}
+		path := joinFieldPath(basepath, fldtyp.Name)
+		key := joinFieldPath(basekey, c.keyName(fldtyp))
+		fldIndex := append(index[:len(index):len(index)], i)
 
 		k := fldtyp.Type.Kind()
-		if k == reflect.Struct {
-			bindFlags(fldval, name, set, resolvers)
+		if (k == reflect.Struct || (k == reflect.Ptr && isNestedStruct(fldtyp.Type))) && !isValueType(fldtyp.Type) {
+			if isRecursive(fldtyp.Type, stack) {
+				continue
+			}
+			e := c.bindFlags(indirect(fldval, false), fldIndex, nestedFlagPrefix(basename, name, fldtyp), path, key, set, resolvers, stack)
+			if err == nil {
+				err = e
+			}
+			continue
+		} else if !isFlagType(fldtyp.Type) {
+			if err == nil {
+				err = unsupportedFlagErr(path, name, fldtyp.Type)
+			}
 			continue
-		} else if k == reflect.Map {
-			// TODO maybe support maps
-			panic(errors.New("maps not supported for flag binding"))
-		}
-
-		// If BoolVar is not used, flag will require a value to be
-		// passed to the flag -boolflag=true. Using BooVar allows
-		// the usage to change to -boolflag (without the explicit value).
-		if fldtyp.Type.Kind() == reflect.Bool && fldval.CanAddr() {
-			deflt := fldtyp.Tag.Get("default")
-			set.BoolVar(
-				fldval.Addr().Interface().(*bool),
-				name, deflt == "true", usage,
-			)
-		} else {
-			set.Var(&flagValue{val: &fldval, fld: &fldtyp}, name, usage)
 		}
+
+		// Bool flags are handled by flagValue.IsBoolFlag so that
+		// the flag can be used as -boolflag (without the explicit value).
+		set.Var(c.newFlagValue(fldIndex, fldtyp, key, "-"+name), name, usage)
 	}
+	return err
 }
 
 // BindToPFlagSet will bind the config object to a pflag set.
 // See https://pkg.go.dev/github.com/spf13/pflag?tab=doc
-func BindToPFlagSet(set *pflag.FlagSet, resolvers ...FlagInfo) { c.BindToPFlagSet(set, resolvers...) }
+//
+// Unsupported fields are handled the same way as BindToFlagSet.
+func BindToPFlagSet(set *pflag.FlagSet, resolvers ...FlagInfo) error {
+	return c.BindToPFlagSet(set, resolvers...)
+}
 
 // BindToPFlagSet will bind the config object to a pflag set.
 // See https://pkg.go.dev/github.com/spf13/pflag?tab=doc
-func (c *Config) BindToPFlagSet(set *pflag.FlagSet, resolvers ...FlagInfo) {
+//
+// Unsupported fields are handled the same way as BindToFlagSet.
+func (c *Config) BindToPFlagSet(set *pflag.FlagSet, resolvers ...FlagInfo) error {
+	if c.elem.Kind() == reflect.Invalid {
+		return errElemNotSet
+	}
+	if err := c.checkTags(); err != nil {
+		return err
+	}
+	resmap := make(map[string]FlagInfo)
+	for _, r := range resolvers {
+		resmap[r.Name()] = r
+	}
+	return c.bindPFlags(c.elem, nil, "", "", "", "", set, resmap, make(map[reflect.Type]bool))
+}
+
+// BindSubcommandFlags will find the subcommand of cmd with the given name
+// and bind the nested struct stored at key to the subcommand's flags. The
+// flag names do not include the key so that each subcommand only exposes
+// its own options.
+//
+//	// app serve --port=8080 sets "server.port"
+//	config.BindSubcommandFlags(rootCmd, "serve", "server")
+func BindSubcommandFlags(cmd *cobra.Command, name, key string, resolvers ...FlagInfo) error {
+	return c.BindSubcommandFlags(cmd, name, key, resolvers...)
+}
+
+// BindSubcommandFlags will find the subcommand of cmd with the given name
+// and bind the nested struct stored at key to the subcommand's flags. The
+// flag names do not include the key so that each subcommand only exposes
+// its own options.
+func (c *Config) BindSubcommandFlags(cmd *cobra.Command, name, key string, resolvers ...FlagInfo) error {
+	if c.elem.Kind() == reflect.Invalid {
+		return errElemNotSet
+	}
+	var sub *cobra.Command
+	for _, sc := range cmd.Commands() {
+		if sc.Name() == name || sc.HasAlias(name) {
+			sub = sc
+			break
+		}
+	}
+	if sub == nil {
+		return fmt.Errorf("%s has no subcommand %q", cmd.Name(), name)
+	}
+	if err := c.checkTags(); err != nil {
+		return err
+	}
+	fp, ok := c.index[key]
+	if !ok || !isNestedStruct(fp.field.Type) {
+		return fmt.Errorf("%w: %q is not a nested struct", ErrFieldNotFound, key)
+	}
+	val, fld, _ := c.index.lookup(c.elem, key)
+	_, _, basekey, err := c.resolveKey(key)
+	if err != nil {
+		return err
+	}
 	resmap := make(map[string]FlagInfo)
 	for _, r := range resolvers {
 		resmap[r.Name()] = r
 	}
-	bindPFlags(c.elem, "", set, resmap)
+	return c.bindPFlags(
+		indirect(val, false), fp.index, fld.Tag.Get("group"),
+		"", fld.Name, basekey, sub.Flags(), resmap,
+		make(map[reflect.Type]bool),
+	)
 }
 
-func bindPFlags(elem reflect.Value, basename string, set *pflag.FlagSet, resolvers map[string]FlagInfo) {
+func (c *Config) bindPFlags(
+	elem reflect.Value,
+	index []int,
+	group string,
+	basename, basepath, basekey string,
+	set *pflag.FlagSet,
+	resolvers map[string]FlagInfo,
+	stack map[reflect.Type]bool,
+) (err error) {
 	var (
 		typ = elem.Type()
 		n   = typ.NumField()
 	)
+	// pointers to the types on the stack are skipped
+	// so that recursive types are not bound forever
+	stack[typ] = true
+	defer delete(stack, typ)
 	for i := 0; i < n; i++ {
 		fldtyp := typ.Field(i)
 		fldval := elem.Field(i)
+		if fldtyp.PkgPath != "" || isIgnored(fldtyp) {
+			continue // unexported or ignored
+		}
 
-		// TODO maybe support maps
-
-		name, shorthand, usage, ok := getFlagInfo(fldtyp)
-		if !ok {
-			// this field was tagged with "notflag"
+		name, shorthand, usage, ok := c.flagInfo(fldtyp)
+		if !ok || isFileOnly(fldtyp) {
+			// this field was tagged with "notflag" or "fileonly"
 			continue
 		}
 		if basename != "" {
@@ -717,27 +1207,166 @@ func bindPFlags(elem reflect.Value, basename string, set *pflag.FlagSet, resolve
 			usage = r.Usage()
 			name = r.Name()
 		}
+		path := joinFieldPath(basepath, fldtyp.Name)
+		key := joinFieldPath(basekey, c.keyName(fldtyp))
+		fldIndex := append(index[:len(index):len(index)], i)
+		fldGroup := group
+		if g := fldtyp.Tag.Get("group"); g != "" {
+			fldGroup = g
+		}
 
 		// handle nested structs
-		if fldtyp.Type.Kind() == reflect.Struct {
-			// TODO add a struct tag to change this name
-			bindPFlags(fldval, name, set, resolvers)
+		if k := fldtyp.Type.Kind(); (k == reflect.Struct || (k == reflect.Ptr && isNestedStruct(fldtyp.Type))) && !isValueType(fldtyp.Type) {
+			if isRecursive(fldtyp.Type, stack) {
+				continue
+			}
+			e := c.bindPFlags(indirect(fldval, false), fldIndex, fldGroup, nestedFlagPrefix(basename, name, fldtyp), path, key, set, resolvers, stack)
+			if err == nil {
+				err = e
+			}
+			continue
+		} else if !isFlagType(fldtyp.Type) {
+			if err == nil {
+				err = unsupportedFlagErr(path, name, fldtyp.Type)
+			}
 			continue
-		} else if k := fldval.Kind(); k == reflect.Map {
-			panic(errors.New("maps not supported for flag binding"))
 		}
 		flg := &pflag.Flag{
 			Name:      name,
 			Shorthand: shorthand,
 			Usage:     usage,
 			DefValue:  fldtyp.Tag.Get("default"),
-			Value:     &flagValue{val: &fldval, fld: &fldtyp},
+			Value:     c.newFlagValue(fldIndex, fldtyp, key, "--"+name),
 		}
-		if flg.DefValue == "" && fldval.CanInterface() {
-			flg.DefValue = fmt.Sprintf("%v", fldval.Interface())
+		if flg.DefValue == "" {
+			if v, ok := asFlagValue(fldval, false); ok {
+				flg.DefValue = v.String()
+			} else if fldval.CanInterface() {
+				flg.DefValue = fmt.Sprintf("%v", fldval.Interface())
+			}
+		}
+		if flg.Value.(*flagValue).IsBoolFlag() {
+			flg.NoOptDefVal = "true"
+		}
+		if fldGroup != "" {
+			flg.Annotations = map[string][]string{flagGroupAnnotation: {fldGroup}}
 		}
 		set.AddFlag(flg)
 	}
+	return err
+}
+
+// isFlagType reports whether valueFromString knows how to
+// parse a flag argument into a value of type t.
+func isFlagType(t reflect.Type) bool {
+	if isValueType(t) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String,
+		reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	}
+	return false
+}
+
+var flagValueType = reflect.TypeOf((*flag.Value)(nil)).Elem()
+
+// isValueType returns true for types that implement flag.Value or
+// pflag.Value, either directly or with a pointer receiver.
+func isValueType(t reflect.Type) bool {
+	if t.Kind() == reflect.Interface {
+		return false
+	}
+	return t.Implements(flagValueType) || reflect.PtrTo(t).Implements(flagValueType)
+}
+
+// asFlagValue returns a field as a flag.Value if its type is a value
+// type, see isValueType. Nil pointers are given a new value if alloc
+// is true.
+func asFlagValue(v reflect.Value, alloc bool) (flag.Value, bool) {
+	if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(flagValueType) {
+		v = v.Addr()
+	} else if v.Kind() == reflect.Ptr && v.IsNil() {
+		if !alloc || !v.CanSet() {
+			return nil, false
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+	}
+	if !v.Type().Implements(flagValueType) || !v.CanInterface() {
+		return nil, false
+	}
+	return v.Interface().(flag.Value), true
+}
+
+// newValue returns a new flag.Value for a value type.
+func newValue(t reflect.Type) (reflect.Value, flag.Value, bool) {
+	v := reflect.New(t).Elem()
+	fv, ok := asFlagValue(v, true)
+	return v, fv, ok
+}
+
+func unsupportedFlagErr(path, name string, t reflect.Type) error {
+	return fmt.Errorf("could not bind field %s to flag %q: %w (%s)", path, name, ErrUnsupportedFlagType, t)
+}
+
+// nestedFlagPrefix returns the prefix used for the flags of a nested
+// struct. The prefix is the flag name of the struct field unless the
+// "flagprefix" option of the config tag gives a different name. An
+// empty flagprefix will flatten the nested flags.
+//
+//	DB struct {
+//		Host string `config:"host"` // --database-host
+//	} `config:"db,flagprefix=database"`
+func nestedFlagPrefix(basename, name string, field reflect.StructField) string {
+	prefix, ok := flagPrefix(field)
+	if !ok {
+		return name
+	}
+	if basename == "" {
+		return prefix
+	} else if prefix == "" {
+		return basename
+	}
+	return basename + string(nestedFlagDelim) + prefix
+}
+
+// flagPrefix returns the value of the
+// "flagprefix" option in the config tag.
+func flagPrefix(field reflect.StructField) (string, bool) {
+	parts := strings.Split(field.Tag.Get("config"), ",")
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "flagprefix=") {
+			return p[len("flagprefix="):], true
+		}
+	}
+	return "", false
+}
+
+func joinFieldPath(base, name string) string {
+	if base == "" {
+		return name
+	}
+	return base + "." + name
+}
+
+// flagInfo is the same as getFlagInfo but the flag name is taken
+// from the first tag given to SetTagNames that names the field.
+func (c *Config) flagInfo(field reflect.StructField) (name, shorthand, usage string, isflag bool) {
+	name, shorthand, usage, isflag = getFlagInfo(field)
+	for _, tag := range c.tagNames {
+		if n := tagName(field, tag); n != "" {
+			name = n
+			break
+		}
+	}
+	return
 }
 
 func getFlagInfo(field reflect.StructField) (name, shorthand, usage string, isflag bool) {
@@ -774,38 +1403,171 @@ func getFlagInfo(field reflect.StructField) (name, shorthand, usage string, isfl
 	if name == "" {
 		name = field.Name
 	}
+	usage = enumUsage(field, usage)
 	isflag = true
 	return
 }
 
+// flagValue sets a config field from a command line flag. The field is
+// found from the config struct each time so that flags always change the
+// struct given to SetConfig, even for optional sections that are nil
+// pointers when the flags are bound.
 type flagValue struct {
-	val *reflect.Value
-	fld *reflect.StructField
+	c *Config
+	// index of the field from the config struct, see reflect.FieldByIndex.
+	// The index is nil if the config struct was replaced with one that
+	// does not have the field.
+	index []int
+	fld   reflect.StructField
+
+	// key and flag are used to record the flag as the
+	// source of the config value.
+	key, flag string
+	// The last value given to Set, see rebindFlags.
+	value *string
+}
+
+func (c *Config) newFlagValue(index []int, fld reflect.StructField, key, flag string) *flagValue {
+	fv := &flagValue{c: c, index: index, fld: fld, key: key, flag: flag}
+	c.mu.Lock()
+	c.flags = append(c.flags, fv)
+	c.mu.Unlock()
+	return fv
+}
+
+// rebindFlags will point every bound flag at the same key in a new
+// config struct and set the values of the flags that have already been
+// given on the command line again.
+func (c *Config) rebindFlags() error {
+	var errs []string
+	for _, fv := range c.flags {
+		fp, ok := c.index[fv.key]
+		if !ok || fp.field.Type != fv.fld.Type {
+			fv.index = nil
+			if fv.value != nil {
+				errs = append(errs, fmt.Sprintf("flag %s: %q is not in the new config struct", fv.flag, fv.key))
+			}
+			continue
+		}
+		fv.index, fv.fld = fp.index, fp.field
+		if fv.value == nil {
+			continue
+		}
+		if err := fv.set(*fv.value); err != nil {
+			errs = append(errs, fmt.Sprintf("flag %s: %v", fv.flag, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// field returns the field that the flag is bound to. Nil pointers are
+// only allocated if alloc is true, otherwise false is returned.
+func (fv *flagValue) field(alloc bool) (reflect.Value, bool) {
+	if fv.index == nil {
+		return nilval, false
+	}
+	v := fv.c.elem
+	for _, i := range fv.index {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return nilval, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
 }
 
 func (fv *flagValue) String() string {
-	if fv.val == nil {
+	// The flag package calls String on zero values
+	// when printing defaults.
+	if fv.c == nil {
 		return ""
 	}
-	if !fv.val.CanInterface() && fv.val.IsZero() {
+	fv.c.mu.RLock()
+	defer fv.c.mu.RUnlock()
+	v, ok := fv.field(false)
+	if !ok || !v.CanInterface() {
 		return ""
 	}
-	return fmt.Sprintf("%v", fv.val.Interface())
+	if val, ok := asFlagValue(v, false); ok {
+		return val.String()
+	}
+	return fmt.Sprintf("%v", v.Interface())
 }
 
 func (fv *flagValue) Set(s string) error {
-	val, err := valueFromString(s, fv.fld, fv.val)
-	if err != nil {
+	fv.c.mu.Lock()
+	defer fv.c.mu.Unlock()
+	if err := fv.set(s); err != nil {
 		return err
 	}
-	fv.val.Set(val)
+	fv.value = &s
+	return nil
+}
+
+func (fv *flagValue) set(s string) error {
+	if fv.index == nil {
+		return fmt.Errorf("cannot set %s: %w", fv.key, ErrFieldNotFound)
+	}
+	field, _ := fv.field(true)
+	if !field.IsValid() || !field.CanSet() {
+		return fmt.Errorf("cannot set %s: config struct is not addressable", fv.key)
+	}
+	if err := checkEnum(fv.key, fv.fld, s); err != nil {
+		return err
+	}
+	if v, ok := asFlagValue(field, true); ok {
+		// Fields that are flag values are set directly so that
+		// they can use the previous value, like lists that append.
+		if err := v.Set(s); err != nil {
+			return err
+		}
+	} else {
+		val, err := fv.c.decodeString(s, &fv.fld, &field)
+		if err != nil {
+			return err
+		}
+		if !val.IsValid() {
+			return fmt.Errorf("cannot set %q from a string: %w", fv.key, ErrWrongType)
+		}
+		if val.Type() != field.Type() {
+			val = val.Convert(field.Type())
+		}
+		field.Set(val)
+	}
+	src := Source{Kind: SourceFlag, Name: fv.flag}
+	fv.c.setSource(fv.key, src)
+	fv.c.recordMutation("flag", src, []string{fv.key})
 	return nil
 }
 
 func (fv *flagValue) Type() string {
+	if _, v, ok := newValue(fv.fld.Type); ok {
+		if pv, ok := v.(pflag.Value); ok {
+			return pv.Type()
+		}
+	}
 	return fv.fld.Type.String()
 }
 
+// IsBoolFlag is used by the standard library flag
+// package to allow boolean flags without a value.
+func (fv *flagValue) IsBoolFlag() bool {
+	if _, v, ok := newValue(fv.fld.Type); ok {
+		b, ok := v.(interface{ IsBoolFlag() bool })
+		return ok && b.IsBoolFlag()
+	}
+	return fv.fld.Type.Kind() == reflect.Bool
+}
+
 // NewConfigCommand creates a new cobra command for configuration
 func NewConfigCommand() *cobra.Command { return c.NewConfigCommand() }
 
@@ -813,7 +1575,7 @@ func (c *Config) NewConfigCommand() *cobra.Command {
 	listpaths := func(prefix ...string) string {
 		buf := bytes.Buffer{}
 		for _, file := range c.allPossibleFiles() {
-			if fileExists(file) {
+			if c.fileExists(file) {
 				buf.WriteString(strings.Join(prefix, ""))
 				buf.WriteString(file)
 				buf.WriteByte('\n')
@@ -839,19 +1601,15 @@ func (c *Config) NewConfigCommand() *cobra.Command {
 				return nil
 			}
 
-			f := FileUsed()
 			if edit, err := flags.GetBool("edit"); err == nil && edit {
-				if f == "" {
-					return errors.New("no config file found")
-				}
-				ex, err := runEditor(f)
-				if err != nil {
-					return err
+				return c.edit(cmd)
+			}
+
+			if search, err := flags.GetBool("search"); err == nil && search {
+				for _, f := range c.ExplainFiles() {
+					cmd.Println(f)
 				}
-				ex.Stdout = cmd.OutOrStdout()
-				ex.Stderr = cmd.ErrOrStderr()
-				ex.Stdin = cmd.InOrStdin()
-				return ex.Run()
+				return nil
 			}
 
 			if list, err := flags.GetBool("list-all"); err == nil && list {
@@ -861,22 +1619,32 @@ func (c *Config) NewConfigCommand() *cobra.Command {
 				return nil
 			}
 
-			b, err := c.marshalIndent(c.config, "", "  ")
+			var v interface{} = c.config
+			if !revealSecrets(cmd) {
+				v = c.redactedConfig()
+			}
+			b, err := c.marshalIndent(v, "", "  ")
 			if err != nil {
 				return err
 			}
+			b = c.stripIgnored(b)
 			fmt.Fprintf(cmd.ErrOrStderr(), "%s\n", listpaths("# "))
 			fmt.Fprintf(cmd.OutOrStdout(), "%s\n", b)
 			return nil
 		},
 	}
-	cmd.AddCommand(&cobra.Command{
-		Use: "get", Short: "Get a config variable",
-		Run: func(c *cobra.Command, args []string) {
-			for _, arg := range args {
-				fmt.Fprintf(c.OutOrStdout(), "%+v\n", Get(arg))
-			}
-		}})
+	cmd.PersistentFlags().Bool("reveal", false, "show the values of secret config variables")
+	cmd.AddCommand(
+		c.newGetCommand(),
+		c.newSetCommand(),
+		c.newUnsetCommand(),
+		c.newListCommand(),
+		c.newEnvCommand(),
+		c.newExplainCommand(),
+		c.newInitCommand(),
+		c.newValidateCommand(),
+		c.newConvertCommand(),
+	)
 	return cmd
 }
 
@@ -886,6 +1654,7 @@ func SetDefaultCommandFlags(cmd *cobra.Command) {
 	flags.BoolP("file", "f", false, "print the config files being used")
 	flags.BoolP("dir", "d", false, "print the config directories being used")
 	flags.BoolP("list-all", "l", false, "list all possible config files whether they exist or not")
+	flags.Bool("search", false, "show why each possible config file is or is not used")
 }
 
 func init() {
@@ -931,14 +1700,124 @@ Additional help topics:
 Use "{{.CommandPath}} [command] --help" for more information about a command.{{end}}
 `
 
-func findEditor() (string, error) {
-	editor := GetString("editor")
+// AllowElevatedEdit will allow the edit command to edit config files
+// that are not writable by the current user. When allowed, sudoedit is
+// used with $SUDO_EDITOR (or the editor from the config if not set) so
+// that the editor itself does not run as root, falling back to sudo when
+// sudoedit is not installed. On windows the editor is started as an
+// administrator which shows the UAC prompt. By default a permission error
+// is returned.
+func AllowElevatedEdit(allow bool) { c.AllowElevatedEdit(allow) }
+
+// AllowElevatedEdit will allow the edit command to edit config files
+// that are not writable by the current user. When allowed, sudoedit is
+// used with $SUDO_EDITOR (or the editor from the config if not set) so
+// that the editor itself does not run as root, falling back to sudo when
+// sudoedit is not installed. On windows the editor is started as an
+// administrator which shows the UAC prompt. By default a permission error
+// is returned.
+func (c *Config) AllowElevatedEdit(allow bool) {
+	c.mu.Lock()
+	c.elevatedEdit = allow
+	c.mu.Unlock()
+}
+
+var errNoEditor = errors.New("no editor set (use $VISUAL, $EDITOR or set it in the config)")
+
+// findEditor returns the command used to edit config files. The "editor"
+// config value is used first followed by $VISUAL, $EDITOR, and then the
+// first default editor for the platform that is installed. The command
+// may include arguments such as "code --wait".
+func (c *Config) findEditor() ([]string, error) {
+	editor := c.GetString("editor")
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if editor != "" {
+			break
+		}
+		editor = os.Getenv(env)
+	}
 	if editor == "" {
-		envEditor := os.Getenv("EDITOR")
-		if envEditor == "" {
-			return "", errors.New("no editor set (use $EDITOR or set it in the config)")
+		for _, name := range defaultEditors {
+			if _, err := exec.LookPath(name); err == nil {
+				return []string{name}, nil
+			}
+		}
+		return nil, errNoEditor
+	}
+	args, err := shellSplit(editor)
+	if err != nil {
+		return nil, fmt.Errorf("bad editor %q: %w", editor, err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("bad editor %q", editor)
+	}
+	return args, nil
+}
+
+// canWrite will check if the current user is
+// able to write to an existing file.
+func canWrite(file string) (bool, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if os.IsPermission(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, f.Close()
+}
+
+// shellSplit will split a command line into arguments the same way as a
+// shell without any expansion. Arguments can be quoted with single or
+// double quotes. Outside of single quotes, a backslash escapes a quote,
+// a space, or another backslash and is kept as is before anything else
+// so that windows paths do not need to be escaped.
+func shellSplit(s string) ([]string, error) {
+	var (
+		args  []string
+		arg   strings.Builder
+		inArg bool
+		quote rune
+	)
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\' && i+1 < len(rs) && strings.ContainsRune("\"'\\ \t", rs[i+1]) &&
+			(quote == 0 || rs[i+1] == '"' || rs[i+1] == '\\'):
+			i++
+			arg.WriteRune(rs[i])
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
 		}
-		editor = envEditor
 	}
-	return editor, nil
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
 }
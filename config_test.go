@@ -2,21 +2,34 @@ package config
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"os/user"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/mitchellh/go-homedir"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
 
@@ -40,7 +53,6 @@ func TestPaths(t *testing.T) {
 
 	t.Run("WithHome", func(t *testing.T) {
 		defer cleanup()
-		homedir.DisableCache = true
 		SetConfig(&C{})
 		os.Setenv("HOME", os.TempDir())
 		os.Setenv("USERPROFILE", os.TempDir())
@@ -52,7 +64,6 @@ func TestPaths(t *testing.T) {
 		if c.paths[0] != exptmp {
 			t.Errorf("home dir not set as a path: got %q, want %q", c.paths[0], exptmp)
 		}
-		homedir.DisableCache = false
 		AddFile("test.txt")
 		if c.filenames[0] != "test.txt" {
 			t.Errorf("expected %q to be in filenames", "test.txt")
@@ -107,6 +118,31 @@ func TestPaths(t *testing.T) {
 	if Paths()[0] != os.TempDir() {
 		t.Error("AddPath did set the wrong path")
 	}
+
+	t.Run("Tokens", func(t *testing.T) {
+		cleanup()
+		defer cleanup()
+		SetConfig(&C{})
+		AddPath("{tmp}/myapp")
+		AddPath("{home}/.myapp")
+		AddPath("{unknown}/myapp")
+		AddFilepath("{tmp}/myapp/config.yml")
+		home, err := homeDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := []string{
+			filepath.Join(os.TempDir(), "myapp"),
+			filepath.Join(home, ".myapp"),
+			"{unknown}/myapp",
+		}
+		if !reflect.DeepEqual(Paths(), exp) {
+			t.Errorf("wrong paths: got %v, want %v", Paths(), exp)
+		}
+		if f := filepath.Join(os.TempDir(), "myapp", "config.yml"); c.filepaths[0] != f {
+			t.Errorf("wrong filepath: got %q, want %q", c.filepaths[0], f)
+		}
+	})
 }
 
 func TestFileTypes(t *testing.T) {
@@ -684,6 +720,7 @@ func TestNestedDelim(t *testing.T) {
 	}
 
 	SetNestedFlagDelim('.')
+	t.Cleanup(func() { SetNestedFlagDelim('-') })
 	s = pflag.NewFlagSet("testing", pflag.ContinueOnError)
 	BindToPFlagSet(s)
 	u = s.FlagUsages()
@@ -721,10 +758,12 @@ func TestWatch(t *testing.T) {
 	check(ioutil.WriteFile(file, []byte(`{"a":"there"}`), 0644))
 	time.Sleep(time.Millisecond * 5)
 
-	if conf.A != "there" {
+	// Use the getters so that reading is
+	// synchronized with the watcher.
+	if GetString("a") != "there" {
 		t.Error("Watch did not update the config struct")
 	}
-	if conf.B != 12 {
+	if GetInt("b") != 12 {
 		t.Error("expected 12")
 	}
 }
@@ -742,12 +781,13 @@ func TestUpdated(t *testing.T) {
 	AddFile("test.json")
 	file := filepath.Join(os.TempDir(), "test.json")
 
-	f, err := os.Create(file)
-	if err != nil {
+	if err := ioutil.WriteFile(file, []byte(`{"a":"hi","b":12}`), 0644); err != nil {
 		t.Fatal(err)
 	}
-	f.Close()
 	defer os.Remove(file)
+	if err := ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
 
 	ch, err := Updated()
 	if err != nil {
@@ -759,10 +799,86 @@ func TestUpdated(t *testing.T) {
 			t.Error(err)
 		}
 	}()
-	select {
-	case <-ch:
-	case <-time.After(time.Second):
-		t.Error("update event timeout")
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-ch:
+			if e.Name != file {
+				t.Errorf("wrong file name: got %q, want %q", e.Name, file)
+			}
+			if e.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				t.Errorf("wrong op %v", e.Op)
+			}
+			// The file may be truncated before it is written.
+			if len(e.Keys) == 0 {
+				continue
+			}
+			if !reflect.DeepEqual(e.Keys, []string{"a"}) {
+				t.Errorf("expected only \"a\" to change, got %v", e.Keys)
+			}
+		case <-timeout:
+			t.Error("update event timeout")
+		}
+		return
+	}
+}
+
+func TestUpdatedKeys(t *testing.T) {
+	type C struct {
+		A string `yaml:"a"`
+		B string `yaml:"b"`
+	}
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.yml")
+	flagFile := filepath.Join(dir, "flag.yml")
+	if err := ioutil.WriteFile(base, []byte("a: one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(flagFile, []byte("b: one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := New(&C{}, WithType("yaml"), WithFilepaths(base))
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	cfg.BindConfigFileFlag(set, "config", "c")
+	if err := set.Parse([]string{"--config", flagFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	// Watch updates the file cache as well
+	if err := cfg.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := cfg.Updated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := func(file string) []string {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case e := <-ch:
+				if e.Name == file && len(e.Keys) > 0 {
+					return e.Keys
+				}
+			case <-timeout:
+				t.Fatalf("no changed keys for %s", file)
+			}
+		}
+	}
+	if err := ioutil.WriteFile(flagFile, []byte("b: two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if keys := changed(flagFile); !reflect.DeepEqual(keys, []string{"b"}) {
+		t.Errorf("wrong keys for the flag file: %q", keys)
+	}
+	if err := ioutil.WriteFile(base, []byte("a: two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if keys := changed(base); !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Errorf("wrong keys for the file path: %q", keys)
 	}
 }
 
@@ -780,7 +896,7 @@ func TestEditor(t *testing.T) {
 	}
 	defer f.Close()
 	defer os.Remove(f.Name())
-	cmd, err := runEditor(f.Name())
+	cmd, err := c.runEditor(f.Name(), ioutil.Discard)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -791,3 +907,5509 @@ func TestEditor(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestElevatedEdit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("elevation uses sudo")
+	}
+	if os.Getuid() == 0 {
+		t.Skip("root can write to any file")
+	}
+	defer cleanup()
+	os.Setenv("EDITOR", "vi")
+	defer os.Unsetenv("EDITOR")
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(file, []byte("a: 1\n"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	c := New(&struct{}{})
+	if _, err := c.runEditor(file, ioutil.Discard); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("expected a permission error, got %v", err)
+	}
+	c.AllowElevatedEdit(true)
+	cmd, err := c.runEditor(file, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Base(cmd.Path)
+	if name != "sudoedit" && name != "sudo" {
+		t.Errorf("expected sudoedit or sudo, got %q", cmd.Path)
+	}
+	if cmd.Args[len(cmd.Args)-1] != file {
+		t.Errorf("expected the file as the last argument, got %q", cmd.Args)
+	}
+
+	// files in a directory that cannot be written
+	dir := filepath.Join(t.TempDir(), "etc")
+	if err = os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file = filepath.Join(dir, "config.yml")
+	if err = ioutil.WriteFile(file, []byte("a: 1\n"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0755)
+	c = New(&struct{}{}, WithType("yaml"), WithFilepaths(file))
+	c.SetBackups(1)
+	if err = c.edit(&cobra.Command{}); !errors.Is(err, os.ErrPermission) || !strings.Contains(err.Error(), "AllowElevatedEdit") {
+		t.Errorf("expected the elevated edit error before writing any files, got %v", err)
+	}
+}
+
+func TestFindEditor(t *testing.T) {
+	defer cleanup()
+	conf := struct {
+		Editor string `config:"editor"`
+	}{}
+	c := New(&conf)
+	os.Setenv("VISUAL", "code --wait")
+	os.Setenv("EDITOR", "vi")
+	defer os.Unsetenv("VISUAL")
+	defer os.Unsetenv("EDITOR")
+	args, err := c.findEditor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, []string{"code", "--wait"}) {
+		t.Errorf("expected $VISUAL to be used first, got %q", args)
+	}
+	conf.Editor = `"C:\Program Files\Editor\edit.exe" -n`
+	args, err = c.findEditor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, []string{`C:\Program Files\Editor\edit.exe`, "-n"}) {
+		t.Errorf("expected config editor to be used first, got %q", args)
+	}
+	conf.Editor = "emacs 'unterminated"
+	if _, err = c.findEditor(); err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+
+	for _, tt := range []struct {
+		in  string
+		exp []string
+	}{
+		{"vim", []string{"vim"}},
+		{`  subl   -w `, []string{"subl", "-w"}},
+		{`a\ b "c \"d\"" 'e\ f'`, []string{"a b", `c "d"`, `e\ f`}},
+		{`""`, []string{""}},
+		{`C:\tools\vim.exe`, []string{`C:\tools\vim.exe`}},
+		{`'C:\tools\vim.exe' -n`, []string{`C:\tools\vim.exe`, "-n"}},
+		{`"C:\tools\new\vim.exe" --tab`, []string{`C:\tools\new\vim.exe`, "--tab"}},
+		{`C:\Program\ Files\vim.exe`, []string{`C:\Program Files\vim.exe`}},
+		{`code --wait "a b" 'c "d"'`, []string{"code", "--wait", "a b", `c "d"`}},
+		{"a\\\tb", []string{"a\tb"}},
+	} {
+		args, err := shellSplit(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(args, tt.exp) {
+			t.Errorf("shellSplit(%q): got %q, want %q", tt.in, args, tt.exp)
+		}
+	}
+}
+
+func TestSetCommand(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+		DB   struct {
+			Port int `yaml:"port" default:"5432"`
+		} `yaml:"db"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(file, []byte("host: localhost\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf := &C{}
+	cfg := New(conf)
+	cfg.AddFilepath(file)
+	if err := cfg.SetType("yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	cmd := cfg.NewConfigCommand()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"set", "db.port", "not-a-number"})
+	if err := cmd.Execute(); err == nil {
+		t.Error("expected an error for an invalid int")
+	}
+	cmd.SetArgs([]string{"set", "db.port", "6543", "--dry-run"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "port: 6543") {
+		t.Errorf("dry run should print the new config, got %q", out.String())
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "6543") {
+		t.Error("dry run should not write to the config file")
+	}
+	if conf.DB.Port != 0 {
+		t.Error("dry run should not change the config struct")
+	}
+
+	// dry runs resolve keys the same way as set
+	cfg.RegisterAlias("database.port", "db.port")
+	cfg.SetKeyMapper(NormalizeKey)
+	for _, key := range []string{"database.port", "DB.Port"} {
+		out.Reset()
+		cmd = cfg.NewConfigCommand()
+		cmd.SetOut(&out)
+		cmd.SetArgs([]string{"set", key, "7654", "--dry-run"})
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "port: 7654") {
+			t.Errorf("%s: dry run should accept the key, got %q", key, out.String())
+		}
+	}
+	cfg.SetReadOnly(true)
+	cmd = cfg.NewConfigCommand()
+	cmd.SetOut(ioutil.Discard)
+	cmd.SetErr(ioutil.Discard)
+	cmd.SetArgs([]string{"set", "db.port", "7654", "--dry-run"})
+	if err := cmd.Execute(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from a dry run, got %v", err)
+	}
+	cfg.SetReadOnly(false)
+
+	cmd = cfg.NewConfigCommand()
+	cmd.SetArgs([]string{"set", "host", "example.com"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "example.com" {
+		t.Errorf("wrong host: got %q", conf.Host)
+	}
+	raw, err = ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), "host: example.com") {
+		t.Errorf("config file not updated: %s", raw)
+	}
+	if stat, err := os.Stat(file); err != nil {
+		t.Fatal(err)
+	} else if stat.Mode().Perm() != 0600 {
+		t.Errorf("file permissions should be kept, got %v", stat.Mode().Perm())
+	}
+}
+
+func TestUnsetCommand(t *testing.T) {
+	type C struct {
+		Host string `json:"host" default:"localhost"`
+		Port int    `json:"port" default:"8080"`
+	}
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(file, []byte(`{"host":"example.com","port":9000}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &C{}
+	cfg := New(conf)
+	cfg.AddFilepath(file)
+	if err := cfg.SetType("json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	cmd := cfg.NewConfigCommand()
+	cmd.SetArgs([]string{"unset", "port", "host"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Port != 0 || conf.Host != "" {
+		t.Errorf("fields should be reset: %+v", conf)
+	}
+	if cfg.GetInt("port") != 8080 {
+		t.Error("getter should fall back to the default value")
+	}
+	var next C
+	cfg2 := New(&next, WithType("json"), WithFilepaths(file))
+	if err := cfg2.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if next.Port != 0 || cfg2.GetInt("port") != 8080 {
+		t.Error("reset value should have been removed from the config file")
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "{}\n" {
+		t.Errorf("reset keys should be removed from the file, got %q", raw)
+	}
+	cmd = cfg.NewConfigCommand()
+	cmd.SetArgs([]string{"unset", "not-a-key"})
+	cmd.SetErr(ioutil.Discard)
+	cmd.SetOut(ioutil.Discard)
+	if err := cmd.Execute(); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
+
+func TestUnsetRemovesKeys(t *testing.T) {
+	type C struct {
+		Name string `yaml:"name"`
+		Port int    `yaml:"port" default:"8080"`
+		DB   struct {
+			Host string `yaml:"host" default:"localhost"`
+		} `yaml:"db"`
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	err := ioutil.WriteFile(file, []byte("# comment\nname: x\nport: 9000 # the port\ndb:\n  host: example.com\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(file))
+	if err = cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	cmd := cfg.NewConfigCommand()
+	cmd.SetArgs([]string{"unset", "port", "db.host"})
+	if err = cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "# comment\nname: x\n" {
+		t.Errorf("reset keys should be removed from the file, got %q", raw)
+	}
+
+	var next C
+	cfg = New(&next, WithType("yaml"), WithFilepaths(file))
+	if err = cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if p := cfg.GetInt("port"); p != 8080 {
+		t.Errorf("default should apply after unset, got %d", p)
+	}
+	if h := cfg.GetString("db.host"); h != "localhost" {
+		t.Errorf("default should apply after unset, got %q", h)
+	}
+	if src, _ := cfg.Origin("port"); src.Kind != SourceDefault {
+		t.Errorf("unset key should come from its default, got %v", src)
+	}
+}
+
+func TestGetCommand(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+		DB   struct {
+			Port int `yaml:"port"`
+		} `yaml:"db"`
+	}
+	cfg := New(&C{Host: "localhost"})
+	cfg.SetType("yaml")
+	if err := cfg.Set("db.port", 5432); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		args []string
+		exp  string
+	}{
+		{[]string{"get", "host"}, "localhost\n"},
+		{[]string{"get", "host", "db.port"}, "localhost\n5432\n"},
+		{[]string{"get", "-o", "json", "db.port"}, "5432\n"},
+		{[]string{"get", "-o", "json", "host", "db.port"}, "{\n  \"db.port\": 5432,\n  \"host\": \"localhost\"\n}\n"},
+		{[]string{"get", "-o", "yaml", "host", "db.port"}, "host: localhost\ndb.port: 5432\n"},
+	} {
+		var out bytes.Buffer
+		cmd := cfg.NewConfigCommand()
+		cmd.SetOut(&out)
+		cmd.SetArgs(tt.args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.exp {
+			t.Errorf("%v: got %q, want %q", tt.args, out.String(), tt.exp)
+		}
+	}
+
+	// cobra prints the usage with the output writer if one is set
+	var out bytes.Buffer
+	cmd := cfg.NewConfigCommand()
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"get", "host", "nope"})
+	if err := cmd.Execute(); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("expected ErrFieldNotFound, got %v", err)
+	}
+	if strings.Contains(out.String(), "Usage:") {
+		t.Errorf("usage should not be printed for a missing key, got %q", out.String())
+	}
+}
+
+func TestListCommand(t *testing.T) {
+	type C struct {
+		Host string `config:"host,usage=the server host" default:"localhost"`
+		DB   struct {
+			Port int `config:"port"`
+		} `config:"db"`
+		Created time.Time `config:"created"`
+	}
+	cfg := New(&C{})
+	keys := cfg.AllKeys()
+	exp := []string{"host", "db.port", "created"}
+	if !reflect.DeepEqual(keys, exp) {
+		t.Errorf("wrong keys: got %v, want %v", keys, exp)
+	}
+
+	var out bytes.Buffer
+	cmd := cfg.NewConfigCommand()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"list"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected a header and 3 rows, got %q", out.String())
+	}
+	for _, s := range []string{"host", "localhost", "string", "the server host"} {
+		if !strings.Contains(lines[1], s) {
+			t.Errorf("row %q should contain %q", lines[1], s)
+		}
+	}
+
+	out.Reset()
+	cmd.SetArgs([]string{"list", "--output=json"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	var list []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[1]["key"] != "db.port" || list[1]["type"] != "int" {
+		t.Errorf("wrong json output: %s", out.String())
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host" default:"localhost"`
+		Port int    `yaml:"port" env:"CONFIG_TEST_ORIGIN_PORT"`
+		User string `yaml:"user"`
+		DB   struct {
+			Name string `yaml:"name"`
+			Port int    `yaml:"port"`
+		} `yaml:"db"`
+		Verbose bool `yaml:"verbose"`
+	}
+	dir := t.TempDir()
+	files := [2]string{filepath.Join(dir, "one.yml"), filepath.Join(dir, "two.yml")}
+	if err := ioutil.WriteFile(files[0], []byte("user: jimmy\ndb:\n  name: test\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(files[1], []byte("user: bob\ndb:\n  port: 5432\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CONFIG_TEST_ORIGIN_PORT", "8080")
+	defer os.Unsetenv("CONFIG_TEST_ORIGIN_PORT")
+
+	cfg := New(&C{})
+	cfg.AddFilepath(files[0])
+	cfg.AddFilepath(files[1])
+	if err := cfg.SetType("yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	if err := cfg.BindToPFlagSet(set); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse([]string{"--Verbose"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, exp := range map[string]Source{
+		"host":    {Kind: SourceDefault},
+		"port":    {Kind: SourceEnv, Name: "CONFIG_TEST_ORIGIN_PORT"},
+		"user":    {Kind: SourceFile, Name: files[0], Line: 1},
+		"db.name": {Kind: SourceFile, Name: files[0], Line: 3},
+		"db.port": {Kind: SourceFile, Name: files[1], Line: 3},
+		"verbose": {Kind: SourceFlag, Name: "--Verbose"},
+	} {
+		src, err := cfg.Origin(key)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if src != exp {
+			t.Errorf("wrong source for %q: got %v, want %v", key, src, exp)
+		}
+	}
+	if err := cfg.Set("User", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if src, _ := cfg.Origin("user"); src.Kind != SourceSet {
+		t.Errorf("expected source %q, got %q", SourceSet, src.Kind)
+	}
+	if _, err := cfg.Origin("nope"); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+
+	var out bytes.Buffer
+	cmd := cfg.NewConfigCommand()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"explain", "db.name"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), fmt.Sprintf("file %s:3", files[0])) {
+		t.Errorf("wrong explain output: %q", out.String())
+	}
+}
+
+func TestOriginProcessedFile(t *testing.T) {
+	type C struct {
+		Zeta  string `yaml:"zeta" toml:"zeta"`
+		Token string `yaml:"token" toml:"token" config:"token,envonly"`
+		Alpha string `yaml:"alpha" toml:"alpha"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(file, []byte("# comment\n\nzeta: z\ntoken: t\nalpha: a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := New(&C{}, WithType("yaml"), WithFilepaths(file), WithLogger(&testLogger{}))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	for key, line := range map[string]int{"zeta": 3, "alpha": 5} {
+		if src, _ := cfg.Origin(key); src != (Source{Kind: SourceFile, Name: file, Line: line}) {
+			t.Errorf("wrong source for %q: %v", key, src)
+		}
+	}
+
+	tomlfile := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(tomlfile, []byte("zeta = \"z\"\nalpha = \"a\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg = New(&C{}, WithType("toml"), WithFilepaths(tomlfile))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if src, _ := cfg.Origin("alpha"); src.Kind != SourceFile || src.Name != tomlfile {
+		t.Errorf("wrong source for a flat toml file: %v", src)
+	}
+}
+
+type validatedConfig struct {
+	Host string `yaml:"host" default:"localhost"`
+	Port int    `yaml:"port" default:"8080"`
+}
+
+func (vc *validatedConfig) Validate() error {
+	if vc.Port < 0 {
+		return errors.New("port must be positive")
+	}
+	return nil
+}
+
+func TestEditCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("editor scripts need a unix shell")
+	}
+	dir := t.TempDir()
+	editor := filepath.Join(dir, "editor.sh")
+	script := "#!/bin/sh\ncp \"$1\" \"$1.orig\"\necho \"port: $CONFIG_TEST_PORT\" > \"$1\"\n"
+	if err := ioutil.WriteFile(editor, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("EDITOR", os.Getenv("EDITOR"))
+	os.Setenv("EDITOR", editor)
+
+	conf := &validatedConfig{}
+	cfg := New(conf)
+	cfg.AddPath(filepath.Join(dir, "app"))
+	cfg.AddFile("config.yml")
+	if err := cfg.SetType("yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := cfg.NewConfigCommand()
+	SetDefaultCommandFlags(cmd)
+	cmd.SetErr(&stderr)
+	cmd.SetOut(ioutil.Discard)
+	cmd.SetIn(strings.NewReader("n\n"))
+	cmd.SetArgs([]string{"--edit"})
+	os.Setenv("CONFIG_TEST_PORT", "-1")
+	defer os.Unsetenv("CONFIG_TEST_PORT")
+	err := cmd.Execute()
+	if err == nil {
+		t.Fatal("expected a validation error")
+	}
+	if !strings.Contains(err.Error(), "port must be positive") {
+		t.Errorf("wrong error: %v", err)
+	}
+	file := filepath.Join(dir, "app", "config.yml")
+	raw, err := ioutil.ReadFile(file + ".orig")
+	if err != nil {
+		t.Fatal("config file should have been created:", err)
+	}
+	if string(raw) != "host: localhost\nport: 8080\n" {
+		t.Errorf("file should have the default values, got %q", raw)
+	}
+	if !strings.Contains(stderr.String(), "Re-open the editor?") {
+		t.Error("should have asked to re-open the editor")
+	}
+
+	os.Setenv("CONFIG_TEST_PORT", "9000")
+	cmd.SetIn(strings.NewReader(""))
+	if err = cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Port != 0 {
+		t.Error("editing should not change the config struct")
+	}
+
+	stderr.Reset()
+	os.Setenv("CONFIG_TEST_PORT", "9001")
+	if err = cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "port: 9000 -> 9001") {
+		t.Errorf("expected the changed keys to be printed, got %q", stderr.String())
+	}
+	if strings.Contains(stderr.String(), "host") {
+		t.Error("unchanged keys should not be printed")
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	raw := []byte("{\n  \"port\": 1,\n  \"host\": \"a\"\n  \"x\": 2\n}")
+	var v map[string]interface{}
+	err := json.Unmarshal(raw, &v)
+	line, col, ok := errorPosition(raw, fmt.Errorf("wrapped: %w", err))
+	if !ok {
+		t.Fatal("expected a position for a json error")
+	}
+	if line != 4 || col != 3 {
+		t.Errorf("got %d:%d, want 4:3", line, col)
+	}
+	if _, _, ok = errorPosition(raw, errors.New("other")); ok {
+		t.Error("expected no position for other errors")
+	}
+}
+
+func TestInitCommand(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host" default:"localhost" config:"host,usage=the server host"`
+		DB   struct {
+			Port int `yaml:"port" default:"5432" config:"port,usage=database port"`
+		} `yaml:"db"`
+	}
+	dir := t.TempDir()
+	cfg := New(&C{})
+	cfg.AddPath(filepath.Join(dir, "default"))
+	cfg.AddFile("config.yml")
+	if err := cfg.SetType("yaml"); err != nil {
+		t.Fatal(err)
+	}
+	cmd := cfg.NewConfigCommand()
+	cmd.SetOut(ioutil.Discard)
+	cmd.SetErr(ioutil.Discard)
+	target := filepath.Join(dir, "custom")
+	cmd.SetArgs([]string{"init", "--path", target})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(target, "config.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "# the server host\nhost: localhost\ndb:\n  # database port\n  port: 5432\n"
+	if string(raw) != exp {
+		t.Errorf("wrong sample config:\ngot  %q\nwant %q", raw, exp)
+	}
+
+	if err = cmd.Execute(); err == nil {
+		t.Error("expected an error for an existing file")
+	}
+	cmd.SetArgs([]string{"init", "--path", target, "--force"})
+	if err = cmd.Execute(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateCommand(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.yml")
+	bad := filepath.Join(dir, "bad.yml")
+	unknown := filepath.Join(dir, "unknown.yml")
+	for file, body := range map[string]string{
+		good:    "host: example.com\nport: 80\n",
+		bad:     "port: -1\n",
+		unknown: "hots: example.com\n",
+	} {
+		if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := &validatedConfig{}
+	cfg := New(conf)
+	cfg.AddFilepath(good)
+	if err := cfg.SetType("yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := cfg.NewConfigCommand()
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs([]string{"validate"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != good+": ok\n" {
+		t.Errorf("wrong output: %q", stdout.String())
+	}
+
+	stdout.Reset()
+	cmd.SetArgs([]string{"validate", good, bad, unknown})
+	if err := cmd.Execute(); err == nil {
+		t.Fatal("expected an error for invalid files")
+	}
+	errout := stderr.String()
+	if !strings.Contains(errout, bad+": port must be positive") {
+		t.Errorf("validation error not reported: %q", errout)
+	}
+	if !strings.Contains(errout, unknown+":") {
+		t.Errorf("unknown key not reported: %q", errout)
+	}
+	if conf.Host != "" || conf.Port != 0 {
+		t.Error("validating should not change the config struct")
+	}
+}
+
+type requiredNameConfig struct {
+	Name string `yaml:"name"`
+	Port int    `yaml:"port" default:"8080"`
+}
+
+func (rc *requiredNameConfig) Validate() error {
+	if rc.Name == "" {
+		return errors.New("name is required")
+	}
+	if rc.Port == 0 {
+		return errors.New("port is required")
+	}
+	return nil
+}
+
+func TestValidateFileOnItsOwn(t *testing.T) {
+	dir := t.TempDir()
+	full := filepath.Join(dir, "config.yml")
+	other := filepath.Join(dir, "other.yml")
+	if err := ioutil.WriteFile(full, []byte("name: app\nport: 9000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(other, []byte("port: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &requiredNameConfig{}
+	cfg := New(conf)
+	cfg.AddFilepath(full)
+	if err := cfg.SetType("yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.checkFile(full); err != nil {
+		t.Error(err)
+	}
+	err := cfg.checkFile(other)
+	if err == nil || !strings.Contains(err.Error(), "name is required") {
+		t.Errorf("missing keys should not be taken from the current config, got %v", err)
+	}
+	// defaults are still used for missing keys
+	if err = ioutil.WriteFile(other, []byte("name: other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.checkFile(other); err != nil {
+		t.Errorf("defaults should be used for missing keys: %v", err)
+	}
+	if conf.Name != "app" || conf.Port != 9000 {
+		t.Errorf("validating should not change the config struct: %+v", conf)
+	}
+}
+
+func TestConvertCommand(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host" json:"host" toml:"host"`
+		DB   struct {
+			Port int `yaml:"port" json:"port" toml:"port"`
+		} `yaml:"db" json:"db" toml:"db"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(file, []byte("host: localhost\ndb:\n  port: 5432\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := New(&C{})
+	cfg.AddFilepath(file)
+	if err := cfg.SetType("yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	cmd := cfg.NewConfigCommand()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"convert", "--to", "toml"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	exp := "host = \"localhost\"\n\n[db]\n  port = 5432\n"
+	if out.String() != exp {
+		t.Errorf("wrong toml output:\ngot  %q\nwant %q", out.String(), exp)
+	}
+	tomlfile := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(tomlfile, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonfile := filepath.Join(dir, "config.json")
+	cmd.SetArgs([]string{"convert", "--to", "json", "--in", tomlfile, "--out", jsonfile})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(jsonfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp = "{\n  \"host\": \"localhost\",\n  \"db\": {\n    \"port\": 5432\n  }\n}\n"
+	if string(raw) != exp {
+		t.Errorf("wrong json output:\ngot  %q\nwant %q", raw, exp)
+	}
+
+	cmd.SetOut(ioutil.Discard)
+	cmd.SetErr(ioutil.Discard)
+	cmd.SetArgs([]string{"convert", "--to", "xml", "--in", file})
+	if err := cmd.Execute(); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestConvertCommandTwice(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host" json:"host"`
+	}
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.yml"), filepath.Join(dir, "second.yml")
+	if err := ioutil.WriteFile(first, []byte("host: first\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(second, []byte("host: second\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := New(&C{})
+	cfg.AddFilepath(first)
+	cfg.AddFilepath(second)
+	if err := cfg.SetType("yaml"); err != nil {
+		t.Fatal(err)
+	}
+	cmd := cfg.NewConfigCommand()
+	for _, exp := range []string{"first", "second"} {
+		var out bytes.Buffer
+		cmd.SetOut(&out)
+		cmd.SetArgs([]string{"convert", "--to", "json"})
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), exp) {
+			t.Errorf("expected the file in use to be converted, got %q", out.String())
+		}
+		os.Remove(first)
+	}
+}
+
+func TestConvertIgnoredFields(t *testing.T) {
+	type C struct {
+		Host     string `yaml:"host" json:"host" toml:"host"`
+		Internal string `config:"-"`
+		Cache    string `yaml:"cache" json:"cache" toml:"cache" config:"-"`
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(file, []byte("host: localhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := New(&C{Internal: "x", Cache: "y"}, WithType("yaml"), WithFilepaths(file))
+	for _, to := range []string{"json", "toml", "yaml"} {
+		var out bytes.Buffer
+		cmd := cfg.NewConfigCommand()
+		cmd.SetOut(&out)
+		cmd.SetArgs([]string{"convert", "--to", to})
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		s := strings.ToLower(out.String())
+		if !strings.Contains(s, "localhost") || strings.Contains(s, "internal") || strings.Contains(s, "cache") {
+			t.Errorf("%s: ignored fields should not be converted, got %q", to, out.String())
+		}
+	}
+}
+
+func TestConvertOtherFormat(t *testing.T) {
+	type C struct {
+		Version int    `yaml:"version" json:"version" toml:"version"`
+		Host    string `yaml:"host" json:"host" toml:"host"`
+		Token   string `yaml:"token" json:"token" toml:"token" config:"token,envonly"`
+	}
+	file := filepath.Join(t.TempDir(), "old.toml")
+	if err := ioutil.WriteFile(file, []byte("hostname = \"example.com\"\ntoken = \"x\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := New(&C{}, WithType("yaml"))
+	cfg.RegisterMigration(0, 1, func(m map[string]interface{}) error {
+		m["host"] = m["hostname"]
+		delete(m, "hostname")
+		return nil
+	})
+	var out bytes.Buffer
+	cmd := cfg.NewConfigCommand()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"convert", "--to", "json", "--in", file})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	exp := "{\n  \"version\": 1,\n  \"host\": \"example.com\",\n  \"token\": \"\"\n}\n"
+	if out.String() != exp {
+		t.Errorf("input should be migrated and filtered with its own format:\ngot  %q\nwant %q", out.String(), exp)
+	}
+}
+
+func TestCompleteKeys(t *testing.T) {
+	type C struct {
+		Host string `config:"host"`
+		DB   struct {
+			Name string `config:"name"`
+			Port int    `config:"port"`
+		} `config:"db"`
+	}
+	cfg := New(&C{})
+	for _, tt := range []struct {
+		args []string
+		exp  []string
+	}{
+		{[]string{"get", "db."}, []string{"db.name", "db.port"}},
+		{[]string{"get", "db.name", "db."}, []string{"db.port"}},
+		{[]string{"unset", "h"}, []string{"host"}},
+		{[]string{"set", ""}, []string{"host", "db.name", "db.port"}},
+		{[]string{"set", "host", ""}, nil},
+	} {
+		var out bytes.Buffer
+		cmd := cfg.NewConfigCommand()
+		cmd.SetOut(&out)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(append([]string{cobra.ShellCompRequestCmd}, tt.args...))
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		// the last line is the completion directive
+		keys := lines[:len(lines)-1]
+		if len(keys) == 0 {
+			keys = nil
+		}
+		if !reflect.DeepEqual(keys, tt.exp) {
+			t.Errorf("%v: got %v, want %v", tt.args, keys, tt.exp)
+		}
+	}
+}
+
+func TestSecretsInCollections(t *testing.T) {
+	type User struct {
+		Name     string `json:"name" yaml:"name"`
+		Password string `json:"password" yaml:"password" secret:"true"`
+	}
+	type C struct {
+		Users  map[string]User  `json:"users" yaml:"users"`
+		Admins []User           `json:"admins" yaml:"admins"`
+		Owner  *[1]User         `json:"owner" yaml:"owner"`
+		Extra  map[string]*User `json:"extra" yaml:"extra"`
+	}
+	conf := &C{
+		Users:  map[string]User{"a": {Name: "a", Password: "hunter2"}},
+		Admins: []User{{Name: "b", Password: "hunter3"}},
+		Owner:  &[1]User{{Name: "c", Password: "hunter4"}},
+		Extra:  map[string]*User{"d": {Name: "d", Password: "hunter5"}},
+	}
+	cfg := New(conf, WithType("yaml"))
+
+	settings := cfg.AllSettings()
+	b, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "hunter") {
+		t.Errorf("secrets in maps and slices should be redacted: %s", b)
+	}
+	if users := settings["users"].(map[string]User); users["a"].Password != redacted || users["a"].Name != "a" {
+		t.Errorf("wrong map value %+v", users["a"])
+	}
+	if admins := settings["admins"].([]User); admins[0].Password != redacted || admins[0].Name != "b" {
+		t.Errorf("wrong slice value %+v", admins[0])
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"list"},
+		{"env"},
+		{"get", "users"},
+		{"get", "admins", "-o", "json"},
+	} {
+		var out bytes.Buffer
+		cmd := cfg.NewConfigCommand()
+		cmd.SetOut(&out)
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(out.String(), "hunter") {
+			t.Errorf("%v: secrets should be redacted:\n%s", args, out.String())
+		}
+	}
+	if conf.Users["a"].Password != "hunter2" || conf.Admins[0].Password != "hunter3" ||
+		conf.Owner[0].Password != "hunter4" || conf.Extra["d"].Password != "hunter5" {
+		t.Errorf("redacting should not change the config struct: %+v", conf)
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+		DB   struct {
+			Password string `yaml:"password" config:"password,secret"`
+			Token    int    `yaml:"token" secret:"true"`
+		} `yaml:"db"`
+	}
+	conf := &C{Host: "localhost"}
+	conf.DB.Password = "hunter2"
+	conf.DB.Token = 42
+	cfg := New(conf)
+	cfg.SetType("yaml")
+
+	exp := map[string]interface{}{
+		"host": "localhost",
+		"db":   map[string]interface{}{"password": "*****", "token": "*****"},
+	}
+	if s := cfg.AllSettings(); !reflect.DeepEqual(s, exp) {
+		t.Errorf("wrong settings: got %v, want %v", s, exp)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		exp  string
+	}{
+		{[]string{}, "host: localhost\ndb:\n  password: '*****'\n  token: 0\n\n"},
+		{[]string{"--reveal"}, "host: localhost\ndb:\n  password: hunter2\n  token: 42\n\n"},
+		{[]string{"get", "db.password", "db.token"}, "*****\n*****\n"},
+		{[]string{"get", "--reveal", "db.password"}, "hunter2\n"},
+		{[]string{"get", "db"}, "{Password:***** Token:0}\n"},
+		{[]string{"get", "-o", "yaml", "db"}, "password: '*****'\ntoken: 0\n"},
+		{[]string{"get", "--reveal", "db"}, "{Password:hunter2 Token:42}\n"},
+	} {
+		var out bytes.Buffer
+		cmd := cfg.NewConfigCommand()
+		cmd.SetOut(&out)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(tt.args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.exp {
+			t.Errorf("%v: got %q, want %q", tt.args, out.String(), tt.exp)
+		}
+	}
+	if conf.DB.Password != "hunter2" || conf.DB.Token != 42 {
+		t.Error("redacting should not change the config struct")
+	}
+
+	var out bytes.Buffer
+	cmd := cfg.NewConfigCommand()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"list"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "hunter2") {
+		t.Errorf("list should not show secrets: %q", out.String())
+	}
+}
+
+type base64Cipher struct{}
+
+func (base64Cipher) Encrypt(b []byte) ([]byte, error) {
+	return []byte(base64.StdEncoding.EncodeToString(b)), nil
+}
+
+func (base64Cipher) Decrypt(b []byte) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(string(b))
+}
+
+func TestCipher(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+		Port int    `yaml:"port"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml.b64")
+	raw, _ := base64Cipher{}.Encrypt([]byte("host: example.com\nport: 80\n"))
+	if err := ioutil.WriteFile(file, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf := &C{}
+	cfg := New(conf)
+	cfg.AddFilepath(file)
+	cfg.SetType("yaml")
+	cfg.SetCipher("b64", base64Cipher{})
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "example.com" || conf.Port != 80 {
+		t.Errorf("encrypted file not read: %+v", conf)
+	}
+	if src, _ := cfg.Origin("port"); src.Line != 2 {
+		t.Errorf("wrong source for decrypted file: %v", src)
+	}
+
+	conf.Port = 8080
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := base64Cipher{}.Decrypt(raw)
+	if err != nil {
+		t.Fatal("saved file should be encrypted:", err)
+	}
+	if string(plain) != "host: example.com\nport: 8080\n" {
+		t.Errorf("wrong file contents: %q", plain)
+	}
+
+	var out bytes.Buffer
+	cmd := cfg.NewConfigCommand()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"convert", "--to", "json"})
+	if err = cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"Port": 8080`) {
+		t.Errorf("encrypted file should be converted: %q", out.String())
+	}
+}
+
+func TestSOPS(t *testing.T) {
+	type C struct {
+		Password string `yaml:"password"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	encrypted := "password: ENC[AES256_GCM,data:abc,type:str]\nsops:\n  mac: ENC[AES256_GCM,data:xyz,type:str]\n  version: 3.7.1\n"
+	if err := ioutil.WriteFile(file, []byte(encrypted), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf := &C{}
+	cfg := New(conf)
+	cfg.AddFilepath(file)
+	cfg.SetType("yaml")
+	decrypt := func(data []byte, format string) ([]byte, error) {
+		if string(data) != encrypted || format != "yaml" {
+			t.Errorf("wrong decrypt arguments: %q %q", data, format)
+		}
+		return []byte("password: hunter2\n"), nil
+	}
+	cfg.SetSOPS(decrypt)
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Password != "hunter2" {
+		t.Errorf("sops file was not decrypted: %q", conf.Password)
+	}
+	if err := cfg.Save(); !errors.Is(err, ErrSOPSFile) {
+		t.Errorf("expected ErrSOPSFile, got %v", err)
+	}
+	if isSOPS([]byte("sops: true\n")) {
+		t.Error("files without sops metadata should not be decrypted")
+	}
+
+	// files that are not on the os file system
+	conf = &C{}
+	cfg = New(conf,
+		WithType("yaml"),
+		WithFilepaths("/etc/app/config.yml"),
+		WithFS(mapFS{"/etc/app/config.yml": encrypted}),
+	)
+	cfg.SetSOPS(decrypt)
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Password != "hunter2" {
+		t.Errorf("sops file from a file system was not decrypted: %q", conf.Password)
+	}
+}
+
+type testLogger struct{ bytes.Buffer }
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	fmt.Fprintf(&l.Buffer, format+"\n", v...)
+}
+
+func TestRequireSecurePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not checked on windows")
+	}
+	type C struct {
+		DB struct {
+			Password string `yaml:"password" secret:"true"`
+		} `yaml:"db"`
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(file, []byte("db:\n  password: hunter2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var logger testLogger
+	conf := &C{}
+	cfg := New(conf)
+	cfg.AddFilepath(file)
+	cfg.SetType("yaml")
+	cfg.SetLogger(&logger)
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal("permissions should not be checked by default:", err)
+	}
+
+	conf.DB.Password = ""
+	cfg.RequireSecurePermissions(true)
+	if err := cfg.ReadConfig(); !errors.Is(err, ErrInsecurePermissions) {
+		t.Fatalf("expected ErrInsecurePermissions, got %v", err)
+	}
+	if conf.DB.Password != "" {
+		t.Error("insecure file should not have been read")
+	}
+	if !strings.Contains(logger.String(), file) {
+		t.Errorf("should have logged a warning, got %q", logger.String())
+	}
+	if err := os.Chmod(file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.DB.Password != "hunter2" {
+		t.Error("secure file should have been read")
+	}
+}
+
+func TestRequireSignature(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err = ioutil.WriteFile(file, []byte("host: example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &C{}
+	cfg := New(conf)
+	cfg.AddFilepath(file)
+	cfg.SetType("yaml")
+	cfg.RequireSignature(pub)
+	if err = cfg.ReadConfig(); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature for a missing signature, got %v", err)
+	}
+	if err = SignFile(file, priv); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "example.com" {
+		t.Error("signed file should have been read")
+	}
+
+	conf.Host = ""
+	if err = ioutil.WriteFile(file, []byte("host: evil.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.ReadConfig(); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature for a changed file, got %v", err)
+	}
+	if conf.Host != "" {
+		t.Error("file with a bad signature should not be read")
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	type C struct {
+		Host  string `yaml:"host" default:"localhost" config:"host,usage=the server host"`
+		Level string `yaml:"level" config:"level,required" enum:"debug, info, error"`
+		DB    struct {
+			Port uint16 `yaml:"port" default:"5432"`
+		} `yaml:"db"`
+		Tags    []string          `yaml:"tags"`
+		Labels  map[string]string `yaml:"labels"`
+		Created time.Time
+		Ignored string `yaml:"-"`
+	}
+	cfg := New(&C{})
+	cfg.SetType("yaml")
+	raw, err := cfg.JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Schema     string                            `json:"$schema"`
+		Type       string                            `json:"type"`
+		Required   []string                          `json:"required"`
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	if err = json.Unmarshal(raw, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Type != "object" || schema.Schema == "" {
+		t.Errorf("wrong top level schema: %s", raw)
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"level"}) {
+		t.Errorf("wrong required fields: %v", schema.Required)
+	}
+	props := schema.Properties
+	if len(props) != 6 {
+		t.Errorf("expected 6 properties, got %d: %s", len(props), raw)
+	}
+	host := props["host"]
+	if host["type"] != "string" || host["default"] != "localhost" || host["description"] != "the server host" {
+		t.Errorf("wrong schema for host: %v", host)
+	}
+	if !reflect.DeepEqual(props["level"]["enum"], []interface{}{"debug", "info", "error"}) {
+		t.Errorf("wrong enum: %v", props["level"]["enum"])
+	}
+	port := props["db"]["properties"].(map[string]interface{})["port"].(map[string]interface{})
+	if port["type"] != "integer" || port["default"] != 5432.0 || port["minimum"] != 0.0 {
+		t.Errorf("wrong schema for db.port: %v", port)
+	}
+	if props["tags"]["type"] != "array" || props["labels"]["type"] != "object" {
+		t.Errorf("wrong schema for collections: %v %v", props["tags"], props["labels"])
+	}
+	if props["created"]["type"] != "string" {
+		t.Errorf("text marshalers should be strings: %v", props["created"])
+	}
+}
+
+func TestJSONSchemaRecursiveType(t *testing.T) {
+	type node struct {
+		Name  string `yaml:"name"`
+		Next  *node  `yaml:"next"`
+		Other *struct {
+			Back *node `yaml:"back"`
+		} `yaml:"other"`
+	}
+	cfg := New(&node{})
+	cfg.SetType("yaml")
+	raw, err := cfg.JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties  map[string]map[string]interface{} `json:"properties"`
+		Definitions map[string]map[string]interface{} `json:"definitions"`
+	}
+	if err = json.Unmarshal(raw, &schema); err != nil {
+		t.Fatal(err)
+	}
+	ref := "#/definitions/config.node"
+	if schema.Properties["next"]["$ref"] != ref {
+		t.Errorf("expected a reference for a recursive field, got %v", schema.Properties["next"])
+	}
+	back := schema.Properties["other"]["properties"].(map[string]interface{})["back"].(map[string]interface{})
+	if back["$ref"] != ref {
+		t.Errorf("expected a reference for an indirectly recursive field, got %v", back)
+	}
+	def, ok := schema.Definitions["config.node"]
+	if !ok || def["type"] != "object" {
+		t.Fatalf("recursive type not defined: %s", raw)
+	}
+	if _, ok = def["properties"].(map[string]interface{})["name"]; !ok {
+		t.Errorf("wrong definition: %v", def)
+	}
+}
+
+func TestRegisterAlias(t *testing.T) {
+	type C struct {
+		DB struct {
+			Host string `yaml:"host"`
+			Port int    `yaml:"port"`
+		} `yaml:"db"`
+		Name string `yaml:"name"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(file, []byte("db:\n  hostname: example.com\n  port: 5432\nappname: test\nname: new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var logger testLogger
+	conf := &C{}
+	cfg := New(conf)
+	cfg.AddFilepath(file)
+	cfg.SetType("yaml")
+	cfg.SetLogger(&logger)
+	cfg.RegisterDeprecatedAlias("db.hostname", "db.host", "")
+	cfg.RegisterAlias("appname", "name")
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.DB.Host != "example.com" || conf.DB.Port != 5432 {
+		t.Errorf("aliased value not read: %+v", conf.DB)
+	}
+	if conf.Name != "new" {
+		t.Errorf("new key should be used over its alias, got %q", conf.Name)
+	}
+	if cfg.GetString("db.hostname") != "example.com" {
+		t.Error("aliases should work with getters")
+	}
+	if !cfg.HasKey("db.hostname") || !cfg.HasKey("appname") {
+		t.Error("aliases should be keys")
+	}
+	if !strings.Contains(logger.String(), `"db.hostname" is deprecated, use "db.host" instead`) {
+		t.Errorf("expected a deprecation warning, got %q", logger.String())
+	}
+	if strings.Contains(logger.String(), "appname") {
+		t.Error("aliases without a message should not log anything")
+	}
+	if err := cfg.checkFile(file); err != nil {
+		t.Errorf("aliases should be valid keys: %v", err)
+	}
+}
+
+func TestRegisterMigration(t *testing.T) {
+	type C struct {
+		Version int    `yaml:"version"`
+		Host    string `yaml:"host"`
+		Port    int    `yaml:"port"`
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(file, []byte("hostname: example.com\nport: 80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &C{}
+	cfg := New(conf)
+	cfg.AddFilepath(file)
+	cfg.SetType("yaml")
+	cfg.RegisterMigration(0, 1, func(m map[string]interface{}) error {
+		m["host"] = m["hostname"]
+		delete(m, "hostname")
+		return nil
+	})
+	cfg.RegisterMigration(1, 2, func(m map[string]interface{}) error {
+		port, _ := m["port"].(int)
+		m["port"] = port + 8000
+		return nil
+	})
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "example.com" || conf.Port != 8080 || conf.Version != 2 {
+		t.Errorf("file was not migrated: %+v", conf)
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "version: 2\nhost: example.com\nport: 8080\n" {
+		t.Errorf("saved file should have the newest version, got %q", raw)
+	}
+	// Files with the newest version should not be migrated again.
+	if err = cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Port != 8080 {
+		t.Errorf("file should not be migrated twice, got port %d", conf.Port)
+	}
+
+	cfg.RegisterMigration(3, 4, func(map[string]interface{}) error { return nil })
+	if err = cfg.ReadConfig(); err == nil || !strings.Contains(err.Error(), "no migration from version 2") {
+		t.Errorf("expected an error for a missing migration, got %v", err)
+	}
+
+	for _, versions := range [][2]int{{2, 2}, {2, 1}} {
+		err = cfg.RegisterMigration(versions[0], versions[1], func(map[string]interface{}) error { return nil })
+		if !errors.Is(err, ErrInvalidMigration) {
+			t.Errorf("%d to %d: expected ErrInvalidMigration, got %v", versions[0], versions[1], err)
+		}
+	}
+	if err = cfg.ReadConfig(); err == nil || !strings.Contains(err.Error(), "no migration from version 2") {
+		t.Errorf("invalid migrations should not be registered, got %v", err)
+	}
+}
+
+type testLevel string
+
+func (l *testLevel) UnmarshalText(b []byte) error {
+	switch s := string(b); s {
+	case "debug", "info":
+		*l = testLevel(s)
+		return nil
+	default:
+		return fmt.Errorf("invalid level %q", s)
+	}
+}
+
+func TestDecodeHooks(t *testing.T) {
+	type C struct {
+		Timeout time.Duration `json:"timeout" default:"5s"`
+		Tags    []string      `json:"tags" env:"CONFIG_TEST_TAGS"`
+		Level   testLevel     `json:"level"`
+		Retry   time.Duration `json:"retry"`
+	}
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(file, []byte(`{"retry": "1m", "level": "debug"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CONFIG_TEST_TAGS", "a,b")
+	defer os.Unsetenv("CONFIG_TEST_TAGS")
+
+	conf := &C{}
+	cfg := New(conf)
+	cfg.AddFilepath(file)
+	cfg.SetType("json")
+	cfg.AddDecodeHook(StringToDurationHook)
+	cfg.AddDecodeHook(StringToSliceHook(","))
+	cfg.AddDecodeHook(TextUnmarshalerHook)
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Retry != time.Minute || conf.Level != "debug" {
+		t.Errorf("file values were not decoded: %+v", conf)
+	}
+	if cfg.Get("timeout") != 5*time.Second {
+		t.Errorf("default was not decoded: %v", cfg.Get("timeout"))
+	}
+	if tags := cfg.Get("tags"); !reflect.DeepEqual(tags, []string{"a", "b"}) {
+		t.Errorf("env value was not decoded: %v", tags)
+	}
+
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	if err := cfg.BindToPFlagSet(set, DisableFlag("Tags")); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse([]string{"--Retry", "2s"}); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Retry != 2*time.Second {
+		t.Errorf("flag value was not decoded: %v", conf.Retry)
+	}
+	if err := cfg.SetFromString("level", "loud"); err == nil {
+		t.Error("expected an error for an invalid level")
+	}
+
+	if err := ioutil.WriteFile(file, []byte(`{"level": "loud"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ReadConfig(); err == nil || !strings.Contains(err.Error(), `level: invalid level "loud"`) {
+		t.Errorf("expected an error for an invalid level, got %v", err)
+	}
+}
+
+type defaultNode struct {
+	Name string       `config:"name" default:"node"`
+	Next *defaultNode `config:"next"`
+}
+
+func TestDefaultsRecursiveType(t *testing.T) {
+	conf := defaultNode{Next: &defaultNode{}}
+	cfg := New(&conf)
+	if err := cfg.InitDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Name != "node" || conf.Next.Name != "node" {
+		t.Errorf("defaults not set: %+v %+v", conf, conf.Next)
+	}
+	if conf.Next.Next != nil {
+		t.Error("optional sections of a recursive type should not be created")
+	}
+	if !hasDefaults(reflect.TypeOf(conf)) {
+		t.Error("default not found")
+	}
+}
+
+type walkNode struct {
+	Name  string     `yaml:"name"`
+	Next  *walkNode  `yaml:"next"`
+	Other *walkOther `yaml:"other"`
+}
+
+type walkOther struct {
+	Value string    `yaml:"value"`
+	Back  *walkNode `yaml:"back"`
+}
+
+func TestWalkRecursiveType(t *testing.T) {
+	conf := walkNode{Next: &walkNode{Name: "b"}}
+	cfg := New(&conf)
+	cfg.SetType("yaml")
+	keys := cfg.AllKeys()
+	sort.Strings(keys)
+	if exp := []string{"name", "other.value"}; !reflect.DeepEqual(keys, exp) {
+		t.Errorf("wrong keys: got %v, want %v", keys, exp)
+	}
+	if s := cfg.AllSettings(); len(s) != 2 {
+		t.Errorf("wrong settings: %v", s)
+	}
+	if flat := cfg.Flatten(); len(flat) != 2 {
+		t.Errorf("wrong flattened config: %v", flat)
+	}
+	if env := cfg.ExportEnv("APP"); len(env) != 2 {
+		t.Errorf("wrong env: %v", env)
+	}
+	if comps := cfg.KeyCompletions(""); len(comps) != 2 {
+		t.Errorf("wrong completions: %v", comps)
+	}
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := cfg.BindToFlagSet(set); err != nil {
+		t.Fatal(err)
+	}
+	if set.Lookup("Name") == nil || set.Lookup("Other-Value") == nil {
+		t.Error("flags not bound for a recursive type")
+	}
+	pset := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	if err := cfg.BindToPFlagSet(pset); err != nil {
+		t.Fatal(err)
+	}
+	if pset.Lookup("Name") == nil || pset.Lookup("Other-Value") == nil {
+		t.Error("pflags not bound for a recursive type")
+	}
+}
+
+func TestPointerStructs(t *testing.T) {
+	type DB struct {
+		Host string `yaml:"host" config:"host" default:"localhost"`
+		Port int    `yaml:"port" config:"port"`
+	}
+	type C struct {
+		DB    *DB `yaml:"db" config:"db"`
+		Cache *struct {
+			Size int `yaml:"size" config:"size"`
+		} `yaml:"cache,omitempty" config:"cache"`
+	}
+	conf := &C{}
+	cfg := New(conf)
+	cfg.SetType("yaml")
+	if err := cfg.InitDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.DB == nil || conf.DB.Host != "localhost" {
+		t.Fatal("pointer to a struct with defaults should be created")
+	}
+	if conf.Cache != nil {
+		t.Error("pointer to a struct without defaults should stay nil")
+	}
+	keys := cfg.AllKeys()
+	if !reflect.DeepEqual(keys, []string{"db.host", "db.port", "cache.size"}) {
+		t.Errorf("wrong keys: %v", keys)
+	}
+	if conf.Cache != nil {
+		t.Error("listing keys should not create nil structs")
+	}
+	if cfg.GetInt("cache.size") != 0 {
+		t.Error("expected a zero value")
+	}
+
+	conf = &C{}
+	cfg = New(conf)
+	if cfg.GetString("db.host") != "localhost" {
+		t.Error("should get defaults through nil pointers")
+	}
+	if err := cfg.Set("db.port", 5432); err != nil {
+		t.Fatal(err)
+	}
+	if conf.DB.Port != 5432 {
+		t.Error("should set values through nil pointers")
+	}
+
+	conf = &C{}
+	cfg = New(conf)
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	if err := cfg.BindToPFlagSet(set); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse([]string{"--db-port=1", "--cache-size=64"}); err != nil {
+		t.Fatal(err)
+	}
+	if conf.DB.Port != 1 || conf.Cache.Size != 64 {
+		t.Errorf("flags should set pointer fields: %+v %+v", conf.DB, conf.Cache)
+	}
+}
+
+func TestSliceKeys(t *testing.T) {
+	type Server struct {
+		Host string `config:"host"`
+		Port int    `config:"port"`
+	}
+	type C struct {
+		Servers []Server   `config:"servers"`
+		Backups [2]*Server `config:"backups"`
+		Ports   []int      `config:"ports"`
+	}
+	conf := &C{
+		Servers: []Server{{Host: "a", Port: 1}, {Host: "b"}},
+		Ports:   []int{80, 443},
+	}
+	cfg := New(conf)
+	if cfg.GetString("servers.0.host") != "a" || cfg.GetInt("ports.1") != 443 {
+		t.Error("should get values from slice indices")
+	}
+	if err := cfg.Set("servers.1.port", 8080); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Servers[1].Port != 8080 {
+		t.Error("should set values at slice indices")
+	}
+	if err := cfg.Set("backups.1.host", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Backups[1] == nil || conf.Backups[1].Host != "c" {
+		t.Error("should set values through array indices")
+	}
+	if !cfg.HasKey("servers.1.host") || cfg.HasKey("servers.2.host") || cfg.HasKey("servers.x") {
+		t.Error("HasKey should check slice indices")
+	}
+	_, err := cfg.GetErr("servers.5.host")
+	if !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("expected ErrIndexOutOfRange, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "servers.5 (length 2)") {
+		t.Errorf("error should name the key: %v", err)
+	}
+	if err = cfg.Set("ports.2", 1); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("expected ErrIndexOutOfRange, got %v", err)
+	}
+	if src, err := cfg.Origin("servers.1.port"); err != nil || src.Kind != SourceSet {
+		t.Errorf("wrong origin: %v %v", src, err)
+	}
+}
+
+func TestMapKeys(t *testing.T) {
+	type User struct {
+		Email string `config:"email"`
+		Admin bool   `config:"admin"`
+	}
+	type C struct {
+		Users  map[string]User  `config:"users"`
+		Groups map[string]*User `config:"groups"`
+		Limits map[int]int      `config:"limits"`
+	}
+	conf := &C{
+		Users:  map[string]User{"alice": {Email: "alice@example.com"}},
+		Limits: map[int]int{1: 10},
+	}
+	cfg := New(conf)
+	if cfg.GetString("users.alice.email") != "alice@example.com" || cfg.GetInt("limits.1") != 10 {
+		t.Error("should get values from map keys")
+	}
+	if !cfg.HasKey("users.alice.email") || cfg.HasKey("users.bob.email") {
+		t.Error("HasKey should check map keys")
+	}
+	if cfg.IsEmpty("users.alice.email") || !cfg.IsEmpty("users.alice.admin") {
+		t.Error("IsEmpty should look through map keys")
+	}
+	_, err := cfg.GetErr("users.bob.email")
+	if !errors.Is(err, ErrFieldNotFound) {
+		t.Fatalf("expected ErrFieldNotFound, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `no map key "bob" at users.bob`) {
+		t.Errorf("error should name the missing key: %v", err)
+	}
+
+	if err = cfg.Set("users.alice.admin", true); err != nil {
+		t.Fatal(err)
+	}
+	if !conf.Users["alice"].Admin || conf.Users["alice"].Email != "alice@example.com" {
+		t.Error("should set values inside map elements")
+	}
+	if err = cfg.Set("users.bob.email", "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Users["bob"].Email != "bob@example.com" {
+		t.Error("should create missing map keys when setting")
+	}
+	if err = cfg.Set("groups.ops.email", "ops@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Groups["ops"] == nil || conf.Groups["ops"].Email != "ops@example.com" {
+		t.Error("should allocate nil maps and pointer elements")
+	}
+	if err = cfg.SetFromString("limits.2", "20"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Limits[2] != 20 {
+		t.Error("should set map values from strings")
+	}
+	if err = cfg.Unset("users.alice.admin"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Users["alice"].Admin {
+		t.Error("should unset values inside map elements")
+	}
+	if err = cfg.Set("limits.x", 1); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("expected ErrFieldNotFound for bad map key, got %v", err)
+	}
+}
+
+func TestVisit(t *testing.T) {
+	type C struct {
+		Name string `config:"name"`
+		DB   struct {
+			Host string `config:"host"`
+			Port int    `config:"port"`
+		} `config:"db"`
+		Cache *struct {
+			Size int `config:"size"`
+		} `config:"cache"`
+		hidden int
+	}
+	cfg := New(&C{Name: "x"})
+	var paths []string
+	err := cfg.Visit(func(path string, fld reflect.StructField, val reflect.Value) error {
+		paths = append(paths, path)
+		if path == "cache" {
+			return SkipStruct
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"name", "db", "db.host", "db.port", "cache"}
+	if !reflect.DeepEqual(paths, exp) {
+		t.Errorf("wrong paths: got %v, want %v", paths, exp)
+	}
+	stop := errors.New("stop")
+	n := 0
+	err = cfg.Visit(func(string, reflect.StructField, reflect.Value) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("Visit should stop at the first error: %v %d", err, n)
+	}
+}
+
+func TestVisitRecursiveType(t *testing.T) {
+	cfg := New(&walkNode{Next: &walkNode{}})
+	cfg.SetType("yaml")
+	var paths []string
+	err := cfg.Visit(func(path string, _ reflect.StructField, _ reflect.Value) error {
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"name", "other", "other.value"}
+	if !reflect.DeepEqual(paths, exp) {
+		t.Errorf("wrong paths: got %v, want %v", paths, exp)
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	type C struct {
+		Name string `config:"name" default:"default"`
+		Port int    `config:"port"`
+		DB   struct {
+			Hosts []string `config:"hosts"`
+		} `config:"db"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(file, []byte("name: x\nport: 1\ndb:\n  hosts: [a, b]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := New(&C{})
+	cfg.SetType("yaml")
+	cfg.AddFilepath(file)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				cfg.GetString("name")
+				cfg.GetInt("port")
+				cfg.Get("db.hosts")
+				cfg.AllSettings()
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := cfg.ReadConfig(); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := cfg.Set("port", i*j); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+type mapFS map[string]string
+
+func (m mapFS) ReadFile(name string) ([]byte, error) {
+	s, ok := m[filepath.ToSlash(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return []byte(s), nil
+}
+
+func (m mapFS) Stat(name string) (os.FileInfo, error) {
+	if _, ok := m[filepath.ToSlash(name)]; !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return mapFileInfo(name), nil
+}
+
+type mapFileInfo string
+
+func (fi mapFileInfo) Name() string       { return filepath.Base(string(fi)) }
+func (fi mapFileInfo) Size() int64        { return 0 }
+func (fi mapFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi mapFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi mapFileInfo) IsDir() bool        { return false }
+func (fi mapFileInfo) Sys() interface{}   { return nil }
+
+func TestNewOptions(t *testing.T) {
+	type C struct {
+		Name string `config:"name"`
+		Port int    `config:"port" default:"80"`
+	}
+	logs := &testLogger{}
+	conf := &C{}
+	cfg := New(conf,
+		WithType("yaml"),
+		WithPaths("/etc/app", "/opt/app"),
+		WithFiles("config.yml"),
+		WithFS(mapFS{"/opt/app/config.yml": "name: fromfs\n"}),
+		WithLogger(logs),
+		WithDefaults(),
+	)
+	if conf.Port != 80 {
+		t.Error("WithDefaults should set default values")
+	}
+	if cfg.logger != logs {
+		t.Error("WithLogger should set the logger")
+	}
+	if len(cfg.Paths()) != 2 {
+		t.Errorf("wrong paths: %v", cfg.Paths())
+	}
+	if files := cfg.FilesUsed(); len(files) != 1 || filepath.ToSlash(files[0]) != "/opt/app/config.yml" {
+		t.Errorf("files should be found with the FS: %v", files)
+	}
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Name != "fromfs" {
+		t.Error("config should be read with the FS")
+	}
+
+	if cfg, err := NewConfig(&C{}, WithType("xml")); err == nil || cfg != nil {
+		t.Error("NewConfig should return the error from an option")
+	}
+	if cfg, err := NewConfig(&C{}, WithType("yaml")); err != nil || cfg == nil {
+		t.Errorf("NewConfig failed: %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("New should panic when an option fails")
+		}
+	}()
+	New(&C{}, WithType("xml"))
+}
+
+func TestMustGetters(t *testing.T) {
+	type C struct {
+		Name  string  `config:"name"`
+		Port  int     `config:"port" default:"8080"`
+		Rate  float64 `config:"rate"`
+		Empty string  `config:"empty"`
+	}
+	cfg := New(&C{Name: "app", Rate: 0.5}, WithFilepaths("/etc/app/config.yml"))
+	if cfg.MustGetString("name") != "app" || cfg.MustGetInt("port") != 8080 ||
+		cfg.MustGetFloat("rate") != 0.5 || cfg.MustGet("name") != "app" {
+		t.Error("Must getters should return values that are set")
+	}
+	mustPanic := func(f func(), target error, contains ...string) {
+		t.Helper()
+		defer func() {
+			t.Helper()
+			r := recover()
+			err, ok := r.(error)
+			if !ok {
+				t.Fatalf("expected a panic with an error, got %v", r)
+			}
+			if !errors.Is(err, target) {
+				t.Errorf("expected %v, got %v", target, err)
+			}
+			for _, s := range contains {
+				if !strings.Contains(err.Error(), s) {
+					t.Errorf("%q should contain %q", err.Error(), s)
+				}
+			}
+		}()
+		f()
+	}
+	mustPanic(func() { cfg.MustGetString("empty") }, ErrMissingValue, `"empty"`, "/etc/app/config.yml")
+	mustPanic(func() { cfg.MustGetInt("missing") }, ErrFieldNotFound, `"missing"`)
+	mustPanic(func() { cfg.MustGet("empty") }, ErrMissingValue)
+}
+
+func TestAutoExpandEnv(t *testing.T) {
+	type C struct {
+		Dir    string            `config:"dir" yaml:"dir"`
+		Files  []string          `config:"files" yaml:"files"`
+		Labels map[string]string `config:"labels" yaml:"labels"`
+	}
+	os.Setenv("CONFIG_TEST_DIR", "/srv")
+	defer os.Unsetenv("CONFIG_TEST_DIR")
+	file := filepath.Join(t.TempDir(), "config.yml")
+	raw := "dir: $CONFIG_TEST_DIR/data\nfiles: [\"${CONFIG_TEST_DIR}/a\"]\n"
+	if err := ioutil.WriteFile(file, []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &C{Labels: map[string]string{"home": "$CONFIG_TEST_DIR"}}
+	cfg := New(conf, WithType("yaml"), WithFilepaths(file))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GetString("dir") != "$CONFIG_TEST_DIR/data" {
+		t.Error("values should not be expanded by default")
+	}
+	if cfg.Getenv("dir") != "/srv/data" {
+		t.Error("Getenv should always expand values")
+	}
+
+	cfg.SetAutoExpandEnv(true)
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Dir != "/srv/data" || conf.Files[0] != "/srv/a" {
+		t.Errorf("file values should be expanded: %+v", conf)
+	}
+	if cfg.GetStringMap("labels")["home"] != "/srv" {
+		t.Error("string map values should be expanded")
+	}
+
+	cfg.SetExpandFunc(func(name string) string { return "<" + name + ">" })
+	conf.Dir = "$X/data"
+	if cfg.GetString("dir") != "<X>/data" || cfg.Getenv("dir") != "<X>/data" {
+		t.Error("the expand function should be used")
+	}
+}
+
+func TestGetStringMapInterface(t *testing.T) {
+	type C struct {
+		Meta  map[string]interface{} `config:"meta"`
+		Hosts map[string][]string    `config:"hosts"`
+		Ports map[string]int         `config:"ports"`
+		DB    struct {
+			Name string `config:"name" default:"postgres"`
+			Pass string `config:"pass,secret"`
+		} `config:"db"`
+	}
+	conf := &C{
+		Meta:  map[string]interface{}{"a": 1, "b": []interface{}{"x", 2}},
+		Hosts: map[string][]string{"web": {"a", "b"}},
+		Ports: map[string]int{"http": 80},
+	}
+	conf.DB.Pass = "hunter2"
+	cfg := New(conf)
+	if m := cfg.GetStringMapInterface("meta"); m["a"] != 1 || len(m) != 2 {
+		t.Errorf("wrong map: %v", m)
+	}
+	db := cfg.GetStringMapInterface("db")
+	if db["name"] != "postgres" || db["pass"] != "hunter2" {
+		t.Errorf("nested structs should be returned as maps with defaults: %v", db)
+	}
+	if cfg.GetStringMapInterface("ports")["http"] != 80 {
+		t.Error("should get any map type")
+	}
+	if cfg.GetStringMapInterface("db.name") != nil {
+		t.Error("non-map values should return nil")
+	}
+	exp := map[string][]string{"a": {"1"}, "b": {"x", "2"}}
+	if m := cfg.GetStringMapStringSlice("meta"); !reflect.DeepEqual(m, exp) {
+		t.Errorf("got %v, want %v", m, exp)
+	}
+	if m := cfg.GetStringMapStringSlice("hosts"); !reflect.DeepEqual(m, conf.Hosts) {
+		t.Errorf("got %v, want %v", m, conf.Hosts)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	type C struct {
+		Name string `config:"name" default:"app"`
+		DB   struct {
+			Host string        `config:"host"`
+			Port int           `config:"port"`
+			TTL  time.Duration `config:"ttl"`
+		} `config:"db"`
+		Tags  []string          `config:"tags"`
+		Users map[string]string `config:"users"`
+	}
+	conf := &C{}
+	conf.DB.Host = "localhost"
+	cfg := New(conf)
+	flat := cfg.Flatten()
+	if flat["name"] != "app" || flat["db.host"] != "localhost" || flat["db.port"] != 0 {
+		t.Errorf("wrong flat map: %v", flat)
+	}
+	if len(flat) != len(cfg.AllKeys()) {
+		t.Errorf("Flatten should use the same keys as AllKeys: %v", flat)
+	}
+
+	cfg.AddDecodeHook(StringToDurationHook)
+	err := cfg.LoadFlatMap(map[string]string{
+		"db.port":     "5432",
+		"db.ttl":      "1m",
+		"name":        "other",
+		"users.alice": "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Name != "other" || conf.DB.Port != 5432 || conf.DB.TTL != time.Minute ||
+		conf.Users["alice"] != "admin" {
+		t.Errorf("values not loaded: %+v", conf)
+	}
+	if src, _ := cfg.Origin("db.port"); src.Kind != SourceSet {
+		t.Errorf("wrong origin: %v", src)
+	}
+	err = cfg.LoadFlatMap(map[string]string{"db.port": "x"})
+	if err == nil || !strings.HasPrefix(err.Error(), "db.port: ") {
+		t.Errorf("error should name the key: %v", err)
+	}
+}
+
+func TestExportEnv(t *testing.T) {
+	type C struct {
+		Name string `config:"name" default:"app"`
+		DB   struct {
+			Host     string `config:"host"`
+			Password string `config:"password,secret"`
+			MaxConns int    `config:"max-conns"`
+		} `config:"db"`
+		Token string            `config:"token" env:"API_TOKEN"`
+		Tags  []string          `config:"tags"`
+		Meta  map[string]string `config:"meta"`
+	}
+	os.Unsetenv("API_TOKEN")
+	conf := &C{Token: "t", Tags: []string{"a", "b"}, Meta: map[string]string{"k": "v"}}
+	conf.DB.Host = "localhost"
+	conf.DB.Password = "hunter2"
+	cfg := New(conf)
+	exp := []string{
+		"APP_NAME=app",
+		"APP_DB_HOST=localhost",
+		"APP_DB_PASSWORD=hunter2",
+		"APP_DB_MAX_CONNS=0",
+		"API_TOKEN=t",
+		"APP_TAGS=a,b",
+		`APP_META={"k":"v"}`,
+	}
+	if env := cfg.ExportEnv("APP"); !reflect.DeepEqual(env, exp) {
+		t.Errorf("wrong env:\ngot  %q\nwant %q", env, exp)
+	}
+	if env := cfg.ExportEnv(""); env[0] != "NAME=app" {
+		t.Errorf("wrong env without a prefix: %q", env)
+	}
+
+	var out bytes.Buffer
+	cmd := cfg.NewConfigCommand()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"env", "--prefix", "APP_"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "APP_DB_PASSWORD=*****\n") ||
+		!strings.HasPrefix(out.String(), "APP_NAME=app\n") {
+		t.Errorf("wrong command output:\n%s", out.String())
+	}
+}
+
+func TestExportEnvBytes(t *testing.T) {
+	type C struct {
+		Key []byte `config:"key" env:"CONFIG_TEST_EXPORT_BYTES"`
+	}
+	cfg := New(&C{Key: []byte("hi")})
+	env := cfg.ExportEnv("")
+	if len(env) != 1 || env[0] != "CONFIG_TEST_EXPORT_BYTES=hi" {
+		t.Fatalf("wrong env: %q", env)
+	}
+	os.Setenv("CONFIG_TEST_EXPORT_BYTES", strings.TrimPrefix(env[0], "CONFIG_TEST_EXPORT_BYTES="))
+	defer os.Unsetenv("CONFIG_TEST_EXPORT_BYTES")
+	var conf C
+	if err := New(&conf).InitDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	if string(conf.Key) != "hi" {
+		t.Errorf("exported bytes should be read back, got %q", conf.Key)
+	}
+}
+
+type handlerConfig struct {
+	Name string `config:"name" json:"name" default:"app"`
+	Port int    `config:"port" json:"port"`
+	Key  string `config:"key,secret" json:"key"`
+}
+
+func (hc *handlerConfig) Validate() error {
+	if hc.Port < 0 {
+		return errors.New("port must be positive")
+	}
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	conf := &handlerConfig{Port: 80, Key: "hunter2"}
+	cfg := New(conf)
+	do := func(h http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	h := cfg.Handler()
+	rec := do(h, "GET", "/", "")
+	var settings map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != 200 || settings["name"] != "app" || settings["key"] != redacted {
+		t.Errorf("wrong response %d: %v", rec.Code, settings)
+	}
+	rec = do(h, "GET", "/keys", "")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"key": "port"`) {
+		t.Errorf("wrong keys response %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec = do(h, "PATCH", "/", `{"port":1}`); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PATCH should not be allowed by Handler, got %d", rec.Code)
+	}
+	if rec = do(h, "GET", "/missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+
+	admin := cfg.AdminHandler(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer secret"
+	})
+	if rec = do(admin, "PATCH", "/", `{"port":1}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rec.Code)
+	}
+	rec = do(admin, "PATCH", "/", `{"port":8080,"name":"web"}`, "Authorization", "Bearer secret")
+	if rec.Code != 200 || conf.Port != 8080 || conf.Name != "web" {
+		t.Errorf("PATCH should set values, got %d %s", rec.Code, rec.Body.String())
+	}
+	if src, _ := cfg.Origin("port"); src.Kind != SourceSet {
+		t.Errorf("wrong origin: %v", src)
+	}
+	rec = do(admin, "PATCH", "/", `{"name":"other","port":-1}`, "Authorization", "Bearer secret")
+	if rec.Code != http.StatusBadRequest || conf.Port != 8080 || conf.Name != "web" {
+		t.Errorf("invalid values should be rolled back, got %d %+v", rec.Code, conf)
+	}
+	rec = do(admin, "PATCH", "/", `{"name":"other","port":"x"}`, "Authorization", "Bearer secret")
+	if rec.Code != http.StatusBadRequest || conf.Name != "web" {
+		t.Errorf("values with the wrong type should be rejected, got %d %+v", rec.Code, conf)
+	}
+
+	cfg.RegisterAlias("listen", "port")
+	cfg.SetKeyMapper(NormalizeKey)
+	rec = do(admin, "PATCH", "/", `{"listen":9000,"NAME":"mapped"}`, "Authorization", "Bearer secret")
+	if rec.Code != 200 || conf.Port != 9000 || conf.Name != "mapped" {
+		t.Errorf("PATCH should accept aliased and mapped keys, got %d %s", rec.Code, rec.Body.String())
+	}
+	if err := cfg.Set("listen", 9001); err != nil || conf.Port != 9001 {
+		t.Errorf("Set should accept the same keys as PATCH, got %v", err)
+	}
+	rec = do(admin, "PATCH", "/", `{"listen":1,"port":-1}`, "Authorization", "Bearer secret")
+	if rec.Code != http.StatusBadRequest || conf.Port != 9001 {
+		t.Errorf("a key given twice should be rolled back to its first value, got %d %+v", rec.Code, conf)
+	}
+}
+
+func TestHandlerPatchMap(t *testing.T) {
+	type C struct {
+		Limits map[string]int `config:"limits"`
+		Port   int            `config:"port"`
+	}
+	conf := C{Limits: map[string]int{"a": 1}}
+	cfg := New(&conf)
+	admin := cfg.AdminHandler(func(*http.Request) bool { return true })
+	patch := func(body string) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest("PATCH", "/", strings.NewReader(body)))
+		return rec.Code
+	}
+	if code := patch(`{"limits.b":2}`); code != 200 || conf.Limits["b"] != 2 {
+		t.Errorf("PATCH should create missing map keys, got %d %v", code, conf.Limits)
+	}
+	if code := patch(`{"limits.c":3,"port":"x"}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", code)
+	}
+	if !reflect.DeepEqual(conf.Limits, map[string]int{"a": 1, "b": 2}) {
+		t.Errorf("created map keys should be removed on rollback, got %v", conf.Limits)
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	type C struct {
+		Name string `config:"name" default:"app"`
+		Port int    `config:"port"`
+		Key  string `config:"key,secret"`
+	}
+	cfg := New(&C{Key: "hunter2"})
+	if err := cfg.Set("port", 80); err != nil {
+		t.Fatal(err)
+	}
+	cfg.PublishExpvar("config-test")
+	v := expvar.Get("config-test")
+	if v == nil {
+		t.Fatal("config should be published")
+	}
+	var vars map[string]struct {
+		Value  interface{}
+		Source string
+	}
+	if err := json.Unmarshal([]byte(v.String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars["name"].Value != "app" || vars["name"].Source != "default" {
+		t.Errorf("wrong name: %+v", vars["name"])
+	}
+	if vars["port"].Value != 80.0 || vars["port"].Source != "set" {
+		t.Errorf("wrong port: %+v", vars["port"])
+	}
+	if vars["key"].Value != redacted {
+		t.Errorf("secrets should be hidden: %+v", vars["key"])
+	}
+}
+
+func TestTracer(t *testing.T) {
+	type C struct {
+		A string `yaml:"a"`
+		B string `yaml:"b"`
+	}
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")
+	if err := ioutil.WriteFile(first, []byte("a: one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(second, []byte("b: [bad"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	cfg := New(&C{}, WithType("yaml"), WithFilepaths(first, second))
+	cfg.SetTracer(TracerFunc(func(op TraceOp, name string) func(time.Duration, error) {
+		return func(d time.Duration, err error) {
+			if d < 0 {
+				t.Error("negative duration")
+			}
+			events = append(events, fmt.Sprintf("%s %s %v", op, filepath.Base(name), err != nil))
+		}
+	}))
+	if err := cfg.ReadConfig(); err == nil {
+		t.Fatal("expected a parse error")
+	}
+	exp := []string{
+		"read a.yml false",
+		"unmarshal a.yml false",
+		"read b.yml false",
+		"unmarshal b.yml true",
+	}
+	if !reflect.DeepEqual(events, exp) {
+		t.Errorf("wrong events:\ngot  %q\nwant %q", events, exp)
+	}
+}
+
+func TestTracerUsesConfig(t *testing.T) {
+	type C struct {
+		A string `yaml:"a"`
+	}
+	var values []string
+	cfg := New(&C{}, WithType("yaml"), WithFilepaths("/etc/app/config.yml"),
+		WithFS(mapFS{"/etc/app/config.yml": "a: one\n"}))
+	cfg.SetTracer(TracerFunc(func(op TraceOp, name string) func(time.Duration, error) {
+		values = append(values, fmt.Sprintf("%s %s", op, cfg.GetString("a")))
+		return nil
+	}))
+	done := make(chan error, 1)
+	go func() {
+		err := cfg.ReadConfig()
+		if err == nil {
+			err = cfg.Apply("remote", []byte("a: two\n"))
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("calling the config from the tracer deadlocked")
+	}
+	exp := []string{"read one", "unmarshal one", "unmarshal two", "merge two"}
+	if !reflect.DeepEqual(values, exp) {
+		t.Errorf("wrong values:\ngot  %q\nwant %q", values, exp)
+	}
+}
+
+type slowFS struct {
+	mapFS
+	delay time.Duration
+}
+
+func (fs slowFS) ReadFile(name string) ([]byte, error) {
+	time.Sleep(fs.delay)
+	return fs.mapFS.ReadFile(name)
+}
+
+func TestReadConfigContext(t *testing.T) {
+	type C struct {
+		A string `yaml:"a"`
+	}
+	fs := slowFS{mapFS: mapFS{"/etc/app/config.yml": "a: one\n"}, delay: 50 * time.Millisecond}
+	conf := &C{}
+	cfg := New(conf, WithType("yaml"), WithFilepaths("/etc/app/config.yml"), WithFS(fs))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	err := cfg.ReadConfigContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	if conf.A != "" {
+		t.Error("config should not be read after the deadline")
+	}
+
+	cfg.SetReadTimeout(time.Millisecond)
+	if err = cfg.ReadConfigContext(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the read timeout, got %v", err)
+	}
+	cfg.SetReadTimeout(time.Second)
+	if err = cfg.ReadConfigContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if conf.A != "one" {
+		t.Error("config should be read")
+	}
+}
+
+func TestReadTimeoutStopsCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	type C struct {
+		A string `yaml:"a"`
+	}
+	marker := filepath.Join(t.TempDir(), "decrypted")
+	cfg := New(&C{}, WithType("yaml"),
+		WithFilepaths("/etc/app/config.yml.enc"),
+		WithFS(mapFS{"/etc/app/config.yml.enc": "a: one\n"}))
+	cfg.SetCipher(".enc", &cmdCipher{
+		decrypt: []string{"sh", "-c", "sleep 0.5 && touch " + marker + " && cat"},
+	})
+	cfg.SetReadTimeout(50 * time.Millisecond)
+	if err := cfg.ReadConfig(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the read timeout, got %v", err)
+	}
+	// change the settings while the abandoned read could still be running
+	cfg.SetSOPS(nil)
+	cfg.SetType("json")
+	cfg.SetCipher(".enc", GPG())
+	time.Sleep(time.Second)
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("the cipher command should be killed after the timeout")
+	}
+}
+
+func TestMergeStrategy(t *testing.T) {
+	type C struct {
+		Hosts   []string `yaml:"hosts"`
+		Plugins []string `yaml:"plugins" merge:"union"`
+		Ports   []int    `yaml:"ports" merge:"replace"`
+	}
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")
+	if err := ioutil.WriteFile(first, []byte("hosts: [a]\nplugins: [x, y]\nports: [1]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(second, []byte("hosts: [b]\nplugins: [y, z]\nports: [2]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &C{}
+	cfg := New(conf, WithType("yaml"), WithFilepaths(first, second))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conf.Hosts, []string{"a"}) ||
+		!reflect.DeepEqual(conf.Plugins, []string{"x", "y", "z"}) ||
+		!reflect.DeepEqual(conf.Ports, []int{1}) {
+		t.Errorf("wrong merge with the default strategy: %+v", conf)
+	}
+
+	conf = &C{}
+	cfg.SetConfig(conf)
+	cfg.SetMergeStrategy(MergeAppend)
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conf.Hosts, []string{"a", "b"}) ||
+		!reflect.DeepEqual(conf.Plugins, []string{"x", "y", "z"}) ||
+		!reflect.DeepEqual(conf.Ports, []int{1}) {
+		t.Errorf("wrong merge with the append strategy: %+v", conf)
+	}
+
+	type Bad struct {
+		Hosts []string `yaml:"hosts" merge:"shuffle"`
+	}
+	cfg = New(&Bad{}, WithType("yaml"), WithFilepaths(first, second))
+	if err := cfg.ReadConfig(); err == nil || !strings.Contains(err.Error(), "shuffle") {
+		t.Errorf("expected an error for an unknown strategy, got %v", err)
+	}
+}
+
+type portRange struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+func TestRegisterMerger(t *testing.T) {
+	type C struct {
+		Ports  portRange            `yaml:"ports"`
+		Ranges map[string]portRange `yaml:"ranges"`
+		Name   string               `yaml:"name"`
+	}
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")
+	if err := ioutil.WriteFile(first, []byte("ports: {min: 10, max: 20}\nranges: {web: {min: 80, max: 80}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(second, []byte("ports: {min: 5, max: 15}\nranges: {web: {min: 70, max: 90}}\nname: b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &C{}
+	cfg := New(conf, WithType("yaml"), WithFilepaths(first, second))
+	// combine ranges into the widest range
+	cfg.RegisterMerger(reflect.TypeOf(portRange{}), func(dst, src reflect.Value) error {
+		d, s := dst.Addr().Interface().(*portRange), src.Interface().(portRange)
+		if s.Min < d.Min {
+			d.Min = s.Min
+		}
+		if s.Max > d.Max {
+			d.Max = s.Max
+		}
+		return nil
+	})
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Ports != (portRange{5, 20}) || conf.Ranges["web"] != (portRange{70, 90}) {
+		t.Errorf("custom merger not used: %+v", conf)
+	}
+	if conf.Name != "b" {
+		t.Error("other fields should still be merged")
+	}
+
+	cfg.RegisterMerger(reflect.TypeOf(""), func(dst, src reflect.Value) error {
+		return errors.New("merge failed")
+	})
+	var ferr *FileError
+	if err := cfg.ReadConfig(); !errors.As(err, &ferr) || ferr.Err.Error() != "merge failed" {
+		t.Errorf("merger errors should be returned, got %v", err)
+	}
+}
+
+func TestExplicitZeroValues(t *testing.T) {
+	type C struct {
+		Port    int               `yaml:"port"`
+		Debug   bool              `yaml:"debug"`
+		Name    string            `yaml:"name"`
+		Retries *int              `yaml:"retries"`
+		Labels  map[string]string `yaml:"labels"`
+		DB      struct {
+			Host string `yaml:"host"`
+		} `yaml:"db"`
+	}
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")
+	if err := ioutil.WriteFile(first, []byte("port: 0\ndebug: false\nlabels: {a: x}\ndb: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	raw := "port: 80\ndebug: true\nname: b\nretries: 3\nlabels: {b: y}\ndb: {host: h}\n"
+	if err := ioutil.WriteFile(second, []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &C{}
+	cfg := New(conf, WithType("yaml"), WithFilepaths(first, second))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Port != 0 || conf.Debug {
+		t.Errorf("explicit zero values should not be replaced: %+v", conf)
+	}
+	if conf.Name != "b" || conf.DB.Host != "h" || len(conf.Labels) != 2 || *conf.Retries != 3 {
+		t.Errorf("missing values should still be merged: %+v", conf)
+	}
+
+	zero := 0
+	conf = &C{Retries: &zero}
+	cfg.SetConfig(conf)
+	if err := cfg.ReadConfigNoOverwrite(); err != nil {
+		t.Fatal(err)
+	}
+	if *conf.Retries != 0 {
+		t.Error("pointers to zero values should be treated as set")
+	}
+}
+
+func TestFilePrecedence(t *testing.T) {
+	type C struct {
+		A string `yaml:"a"`
+		B string `yaml:"b"`
+	}
+	etc, local := t.TempDir(), t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(etc, "config.yml"), []byte("a: etc\nb: etc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(local, "config.yml"), []byte("a: local\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &C{}
+	cfg := New(conf, WithType("yaml"), WithPaths(etc, local), WithFiles("config.yml"))
+	// the same file twice should only be read once
+	cfg.AddFilepath(filepath.Join(etc, ".", "config.yml"))
+	exp := []string{filepath.Join(etc, ".", "config.yml"), filepath.Join(local, "config.yml")}
+	if files := cfg.FilesUsed(); !reflect.DeepEqual(files, exp) {
+		t.Errorf("wrong files:\ngot  %v\nwant %v", files, exp)
+	}
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.A != "etc" || conf.B != "etc" {
+		t.Errorf("first file should have the highest precedence: %+v", conf)
+	}
+
+	conf = &C{}
+	cfg.SetConfig(conf)
+	cfg.SetFilePrecedence(Ascending)
+	exp[0], exp[1] = exp[1], exp[0]
+	if files := cfg.FilesUsed(); !reflect.DeepEqual(files, exp) {
+		t.Errorf("wrong files:\ngot  %v\nwant %v", files, exp)
+	}
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.A != "local" || conf.B != "etc" {
+		t.Errorf("last file should have the highest precedence: %+v", conf)
+	}
+}
+
+func TestAllowMissingFile(t *testing.T) {
+	type C struct {
+		Port int `yaml:"port" default:"8080"`
+	}
+	cfg := New(&C{}, WithType("yaml"), WithPaths(t.TempDir()), WithFiles("config.yml"))
+	if err := cfg.ReadConfig(); err != ErrNoConfigFile {
+		t.Errorf("expected ErrNoConfigFile, got %v", err)
+	}
+	cfg.AllowMissingFile(true)
+	if err := cfg.ReadConfig(); err != nil {
+		t.Errorf("missing files should be allowed: %v", err)
+	}
+	if cfg.GetInt("port") != 8080 {
+		t.Error("defaults should still be used")
+	}
+}
+
+func TestEnsureConfigFile(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host" default:"localhost" config:",usage=server host"`
+		Port int    `yaml:"port" default:"8080"`
+	}
+	dir := filepath.Join(t.TempDir(), "app")
+	cfg := New(&C{}, WithType("yaml"), WithPaths(dir), WithFiles("config.yml"))
+	file, err := cfg.EnsureConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file != filepath.Join(dir, "config.yml") {
+		t.Errorf("wrong file %q", file)
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), "host: localhost") || !strings.Contains(string(raw), "# server host") {
+		t.Errorf("file should have default values:\n%s", raw)
+	}
+	if err = ioutil.WriteFile(file, []byte("port: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := cfg.EnsureConfigFile(); err != nil || f != file {
+		t.Fatalf("should return the existing file: %q %v", f, err)
+	}
+	if written, err := cfg.WriteDefaultIfMissing(file); err != nil || written {
+		t.Errorf("existing files should not be written: %v %v", written, err)
+	}
+	if raw, _ = ioutil.ReadFile(file); string(raw) != "port: 1\n" {
+		t.Error("existing file should not change")
+	}
+}
+
+func TestBackups(t *testing.T) {
+	type C struct {
+		Port int `yaml:"port"`
+	}
+	var (
+		dir  = t.TempDir()
+		file = filepath.Join(dir, "config.yml")
+		conf = C{}
+		cfg  = New(&conf, WithType("yaml"))
+	)
+	read := func(name string) string {
+		t.Helper()
+		raw, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(raw)
+	}
+	cfg.SetBackups(1)
+	if err := cfg.WriteFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file + ".bak"); !os.IsNotExist(err) {
+		t.Error("new files should not be backed up")
+	}
+	conf.Port = 1
+	if err := cfg.WriteFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if s := read(file + ".bak"); s != "port: 0\n" {
+		t.Errorf("wrong backup %q", s)
+	}
+
+	cfg.SetBackups(2)
+	for i := 2; i <= 4; i++ {
+		conf.Port = i
+		if err := cfg.WriteFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := read(file); s != "port: 4\n" {
+		t.Errorf("wrong file %q", s)
+	}
+	if s := read(file + ".bak.1"); s != "port: 3\n" {
+		t.Errorf("wrong first backup %q", s)
+	}
+	if s := read(file + ".bak.2"); s != "port: 2\n" {
+		t.Errorf("wrong second backup %q", s)
+	}
+	if _, err := os.Stat(file + ".bak.3"); !os.IsNotExist(err) {
+		t.Error("should only keep two backups")
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	type C struct {
+		Port int `yaml:"port"`
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	cfg := New(&C{Port: 3}, WithType("yaml"))
+	cfg.SetLockTimeout(100 * time.Millisecond)
+	unlock, err := cfg.lock(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.WriteFile(file); !errors.Is(err, ErrFileLocked) {
+		t.Fatalf("expected ErrFileLocked, got %v", err)
+	}
+	unlock()
+	if err = cfg.WriteFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := ioutil.ReadFile(file); string(raw) != "port: 3\n" {
+		t.Errorf("wrong file contents %q", raw)
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("lock files should not be left next to the config file, found %d files", len(files))
+	}
+}
+
+func TestSaveKeepsYAMLComments(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+		Port int    `yaml:"port"`
+		DB   struct {
+			Name string   `yaml:"name"`
+			Tags []string `yaml:"tags"`
+		} `yaml:"db"`
+		Debug bool `yaml:"debug,omitempty"`
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	err := ioutil.WriteFile(file, []byte(`# top comment
+port: 80 # the port
+host: localhost
+# database settings
+db:
+  name: test
+  tags:
+    - a # first
+    - b
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(file))
+	if err = cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Set("port", 8080); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Set("db.tags", []string{"a", "b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Set("debug", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `# top comment
+port: 8080 # the port
+host: localhost
+# database settings
+db:
+  name: test
+  tags:
+    - a # first
+    - b
+    - c
+debug: true
+`
+	if string(raw) != exp {
+		t.Errorf("wrong file contents:\n%s\nexpected:\n%s", raw, exp)
+	}
+}
+
+func TestJSONC(t *testing.T) {
+	for _, tt := range []struct{ in, exp string }{
+		{"{\"a\": 1, // comment\n}", "{\"a\": 1" + strings.Repeat(" ", 12) + "\n}"},
+		{`[1, 2, /* x */ ]`, `[1, 2          ]`},
+		{`{"a": "//,]", "b": "\"/*"}`, `{"a": "//,]", "b": "\"/*"}`},
+		{"/* a\nb */{}", "    \n    {}"},
+	} {
+		if s := string(stripJSONC([]byte(tt.in))); s != tt.exp {
+			t.Errorf("got %q, want %q", s, tt.exp)
+		}
+	}
+
+	type C struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	file := filepath.Join(t.TempDir(), "config.jsonc")
+	err := ioutil.WriteFile(file, []byte(`{
+	// the host name
+	"host": "localhost",
+	"port": 8080, /* trailing comma */
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("jsonc"), WithFilepaths(file))
+	if err = cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "localhost" || conf.Port != 8080 {
+		t.Errorf("wrong config %+v", conf)
+	}
+	if src, err := cfg.Origin("port"); err != nil || src.Line != 4 {
+		t.Errorf("port should be on line 4, got %d %v", src.Line, err)
+	}
+}
+
+func TestSetEvaluator(t *testing.T) {
+	type C struct {
+		Host string `toml:"host"`
+		Port int    `toml:"port"`
+	}
+	file := filepath.Join(t.TempDir(), "config.cue")
+	if err := ioutil.WriteFile(file, []byte("port: 8000 + 80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("toml"), WithFilepaths(file))
+	cfg.SetEvaluator("cue", EvaluatorFunc(func(filename string, raw []byte) ([]byte, error) {
+		if filename != file || string(raw) != "port: 8000 + 80\n" {
+			t.Errorf("wrong file %s %q", filename, raw)
+		}
+		return []byte(`{"host": "localhost", "port": 8080}`), nil
+	}))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "localhost" || conf.Port != 8080 {
+		t.Errorf("wrong config %+v", conf)
+	}
+	if err := cfg.Save(); !errors.Is(err, ErrEvaluatedFile) {
+		t.Errorf("expected ErrEvaluatedFile, got %v", err)
+	}
+}
+
+func TestEnvFallback(t *testing.T) {
+	type C struct {
+		Token string `config:"token" env:"APP_TOKEN_TEST, OLD_TOKEN_TEST"`
+	}
+	cfg := New(&C{})
+	os.Setenv("OLD_TOKEN_TEST", "old")
+	defer os.Unsetenv("OLD_TOKEN_TEST")
+	if s := cfg.GetString("token"); s != "old" {
+		t.Errorf("expected the old variable, got %q", s)
+	}
+	if src, err := cfg.Origin("token"); err != nil || src.Name != "OLD_TOKEN_TEST" {
+		t.Errorf("wrong source %v %v", src, err)
+	}
+	os.Setenv("APP_TOKEN_TEST", "new")
+	defer os.Unsetenv("APP_TOKEN_TEST")
+	if s := cfg.GetString("token"); s != "new" {
+		t.Errorf("the first variable should be used first, got %q", s)
+	}
+	if src, err := cfg.Origin("token"); err != nil || src.Name != "APP_TOKEN_TEST" {
+		t.Errorf("wrong source %v %v", src, err)
+	}
+	if env := cfg.ExportEnv(""); len(env) != 1 || env[0] != "APP_TOKEN_TEST=new" {
+		t.Errorf("wrong exported env %v", env)
+	}
+}
+
+func TestEnvOnlyFileOnly(t *testing.T) {
+	type C struct {
+		Token string `config:"token,envonly" env:"ENVONLY_TOKEN_TEST"`
+		DB    struct {
+			Password string `config:"password,envonly"`
+			Host     string `config:"host"`
+		} `config:"db"`
+		CacheDir string `config:"cache_dir,fileonly" env:"FILEONLY_CACHE_TEST"`
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	err := ioutil.WriteFile(file, []byte("token: from-file\ncache_dir: /tmp/cache\ndb:\n  password: pw\n  host: db\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("ENVONLY_TOKEN_TEST", "from-env")
+	os.Setenv("FILEONLY_CACHE_TEST", "/env/cache")
+	defer os.Unsetenv("ENVONLY_TOKEN_TEST")
+	defer os.Unsetenv("FILEONLY_CACHE_TEST")
+
+	var (
+		conf   C
+		logger testLogger
+		cfg    = New(&conf, WithType("yaml"), WithFilepaths(file), WithLogger(&logger))
+	)
+	if err = cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Token != "" || conf.DB.Password != "" {
+		t.Errorf("envonly fields should not be read from files: %+v", conf)
+	}
+	if conf.DB.Host != "db" {
+		t.Errorf("other fields should still be read: %+v", conf)
+	}
+	if s := cfg.GetString("token"); s != "from-env" {
+		t.Errorf("expected token from the environment, got %q", s)
+	}
+	if !strings.Contains(logger.String(), `"db.password"`) {
+		t.Errorf("ignored keys should be logged: %q", logger.String())
+	}
+
+	conf.CacheDir = ""
+	if s := cfg.GetString("cache_dir"); s != "" {
+		t.Errorf("fileonly fields should not use the environment, got %q", s)
+	}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	if err = cfg.BindToPFlagSet(flags); err != nil {
+		t.Fatal(err)
+	}
+	if flags.Lookup("cache_dir") != nil {
+		t.Error("fileonly fields should not be bound to flags")
+	}
+	if flags.Lookup("token") == nil {
+		t.Error("envonly fields should still be bound to flags")
+	}
+}
+
+func TestDefaultsCheck(t *testing.T) {
+	type C struct {
+		A  int    `default:"one"`
+		B  string `default:"ok"`
+		DB struct {
+			Port uint16 `default:"99999"`
+			Host string `default:"localhost"`
+		}
+		C bool `default:"maybe"`
+	}
+	conf := C{}
+	cfg := New(&conf)
+	err := cfg.DefaultsCheck()
+	var derr DefaultsError
+	if !errors.As(err, &derr) {
+		t.Fatalf("expected a DefaultsError, got %v", err)
+	}
+	if len(derr) != 3 {
+		t.Fatalf("expected 3 errors, got %d: %v", len(derr), err)
+	}
+	for i, path := range []string{"field A:", "field DB.Port:", "field C:"} {
+		if !strings.HasPrefix(derr[i].Error(), path) {
+			t.Errorf("error %q should start with %q", derr[i], path)
+		}
+	}
+	if conf.B != "" || conf.DB.Host != "" {
+		t.Error("DefaultsCheck should not change the config struct")
+	}
+
+	err = cfg.InitDefaults()
+	if !errors.As(err, &derr) || len(derr) != 3 {
+		t.Fatalf("expected 3 errors, got %v", err)
+	}
+	if conf.B != "ok" || conf.DB.Host != "localhost" {
+		t.Errorf("valid defaults should still be set: %+v", conf)
+	}
+
+	type Good struct {
+		A int `default:"1"`
+	}
+	if err = New(&Good{}).DefaultsCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListAndComplexDefaults(t *testing.T) {
+	type C struct {
+		C64   complex64     `default:"1+2i"`
+		C128  complex128    `default:"(3-4i)"`
+		Ints  []int         `default:"1, 2, 3"`
+		Names []string      `default:"a,b"`
+		Arr   [3]float64    `default:"1.5,2.5"`
+		Raw   []byte        `default:"raw"`
+		Times time.Duration `default:"0"`
+	}
+	var conf C
+	cfg := New(&conf)
+	if err := cfg.InitDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.C64 != 1+2i || conf.C128 != 3-4i {
+		t.Errorf("wrong complex defaults %v %v", conf.C64, conf.C128)
+	}
+	if !reflect.DeepEqual(conf.Ints, []int{1, 2, 3}) || !reflect.DeepEqual(conf.Names, []string{"a", "b"}) {
+		t.Errorf("wrong slice defaults %v %v", conf.Ints, conf.Names)
+	}
+	if conf.Arr != [3]float64{1.5, 2.5, 0} {
+		t.Errorf("wrong array default %v", conf.Arr)
+	}
+	if string(conf.Raw) != "raw" {
+		t.Errorf("wrong byte slice default %q", conf.Raw)
+	}
+
+	type Bad struct {
+		Arr  [2]int     `default:"1,2,3"`
+		Ints []int      `default:"1,x"`
+		C    complex128 `default:"1+"`
+	}
+	err := New(&Bad{}).DefaultsCheck()
+	var derr DefaultsError
+	if !errors.As(err, &derr) || len(derr) != 3 {
+		t.Errorf("expected 3 errors, got %v", err)
+	}
+}
+
+func TestUnexportedFields(t *testing.T) {
+	type C struct {
+		Name   string `config:"name" default:"x"`
+		hidden string `config:"hidden" default:"y"`
+		Inner  struct {
+			port int `default:"80"`
+			Host string
+		} `config:"inner"`
+	}
+	conf := C{}
+	cfg := New(&conf, WithType("yaml"))
+	if err := cfg.InitDefaults(); err != nil {
+		t.Fatalf("unexported fields should be skipped: %v", err)
+	}
+	if conf.Name != "x" || conf.hidden != "" || conf.Inner.port != 0 {
+		t.Errorf("wrong defaults %+v", conf)
+	}
+	if _, err := cfg.GetErr("hidden"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("unexported fields should not be found, got %v", err)
+	}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	if err := cfg.BindToPFlagSet(flags); err != nil {
+		t.Fatal(err)
+	}
+	if flags.Lookup("hidden") != nil {
+		t.Error("unexported fields should not be bound to flags")
+	}
+	src := C{hidden: "a"}
+	src.Inner.port = 1
+	if err := merge(reflect.ValueOf(&conf), reflect.ValueOf(&src)); err != nil {
+		t.Fatal(err)
+	}
+	if conf.hidden != "" || conf.Inner.port != 0 {
+		t.Error("unexported fields should not be merged")
+	}
+
+	cfg.SetStrictTags(true)
+	err := cfg.DefaultsCheck()
+	if !errors.Is(err, ErrUnexportedField) {
+		t.Fatalf("expected ErrUnexportedField, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "hidden, Inner.port") {
+		t.Errorf("error should list the fields: %v", err)
+	}
+	if err = cfg.BindToPFlagSet(pflag.NewFlagSet("test", pflag.ContinueOnError)); !errors.Is(err, ErrUnexportedField) {
+		t.Errorf("expected ErrUnexportedField, got %v", err)
+	}
+}
+
+func TestGetSlice(t *testing.T) {
+	type MyInts []int
+	type Port uint16
+	type C struct {
+		Small  []int32       `config:"small"`
+		Mine   MyInts        `config:"mine"`
+		Ports  []Port        `config:"ports"`
+		Floats []float64     `config:"floats"`
+		Arr    [2]int8       `config:"arr"`
+		Any    []interface{} `config:"any"`
+		Names  []string      `config:"names"`
+	}
+	conf := C{
+		Small:  []int32{1, 2},
+		Mine:   MyInts{3, 4},
+		Ports:  []Port{80, 443},
+		Floats: []float64{1, 2.5},
+		Arr:    [2]int8{-1, 1},
+		Any:    []interface{}{1, int64(2)},
+		Names:  []string{"a"},
+	}
+	cfg := New(&conf)
+	if s := cfg.GetIntSlice("small"); !reflect.DeepEqual(s, []int{1, 2}) {
+		t.Errorf("wrong int slice %v", s)
+	}
+	if s := cfg.GetInt64Slice("mine"); !reflect.DeepEqual(s, []int64{3, 4}) {
+		t.Errorf("wrong int64 slice %v", s)
+	}
+	if s := cfg.GetIntSlice("any"); !reflect.DeepEqual(s, []int{1, 2}) {
+		t.Errorf("wrong int slice from interfaces %v", s)
+	}
+	if s := cfg.GetIntSlice("names"); s != nil {
+		t.Errorf("strings should not be converted to ints: %v", s)
+	}
+
+	var ports []uint16
+	if err := cfg.GetSlice("ports", &ports); err != nil || !reflect.DeepEqual(ports, []uint16{80, 443}) {
+		t.Errorf("wrong ports %v %v", ports, err)
+	}
+	var mine MyInts
+	if err := cfg.GetSlice("arr", &mine); err != nil || !reflect.DeepEqual(mine, MyInts{-1, 1}) {
+		t.Errorf("wrong array conversion %v %v", mine, err)
+	}
+	var u []uint
+	if err := cfg.GetSlice("arr", &u); !errors.Is(err, ErrWrongType) {
+		t.Errorf("negative numbers should not fit in a uint: %v", err)
+	}
+	var ints []int
+	if err := cfg.GetSlice("floats", &ints); !errors.Is(err, ErrWrongType) {
+		t.Errorf("fractions should not be converted to ints: %v", err)
+	}
+	if err := cfg.GetSlice("small", ints); !errors.Is(err, ErrWrongType) {
+		t.Errorf("expected an error for a non-pointer: %v", err)
+	}
+	s := cfg.GetIntSlice("small")
+	s[0] = 100
+	if conf.Small[0] != 1 {
+		t.Error("returned slices should be copies")
+	}
+}
+
+func TestSetCoercion(t *testing.T) {
+	type Port uint16
+	type C struct {
+		Port  Port          `config:"port"`
+		Rate  float32       `config:"rate"`
+		Count int           `config:"count"`
+		Wait  time.Duration `config:"wait"`
+		Tags  []string      `config:"tags"`
+	}
+	var conf C
+	cfg := New(&conf)
+	if err := cfg.Set("port", int64(8080)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Set("rate", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Set("count", 3.0); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Port != 8080 || conf.Rate != 2 || conf.Count != 3 {
+		t.Errorf("wrong values %+v", conf)
+	}
+	for key, val := range map[string]interface{}{
+		"port":  -1,
+		"count": 1.5,
+		"tags":  "a",
+	} {
+		if err := cfg.Set(key, val); !errors.Is(err, ErrWrongType) {
+			t.Errorf("%s: expected ErrWrongType, got %v", key, err)
+		}
+	}
+
+	if err := cfg.SetFromString("port", "443"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.SetFromString("tags", "a,b"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Port != 443 || !reflect.DeepEqual(conf.Tags, []string{"a", "b"}) {
+		t.Errorf("wrong values %+v", conf)
+	}
+	if err := cfg.SetFromString("port", "99999"); err == nil {
+		t.Error("expected an error for a port that does not fit")
+	}
+	if src, err := cfg.Origin("port"); err != nil || src.Kind != SourceSet {
+		t.Errorf("wrong source %v %v", src, err)
+	}
+}
+
+func TestFlagWriteBack(t *testing.T) {
+	type Base struct {
+		Level int `config:"level"`
+	}
+	type C struct {
+		Base
+		A struct {
+			B struct {
+				C struct {
+					Name string `config:"name"`
+				} `config:"c"`
+			} `config:"b"`
+		} `config:"a"`
+		Opt *struct {
+			Port int `config:"port"`
+		} `config:"opt"`
+	}
+	var conf C
+	cfg := New(&conf)
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	if err := cfg.BindToPFlagSet(flags); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Opt != nil {
+		t.Fatal("binding flags should not create optional sections")
+	}
+	err := flags.Parse([]string{"--Base-level=3", "--a-b-c-name=deep", "--opt-port=8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Level != 3 || conf.A.B.C.Name != "deep" {
+		t.Errorf("nested flags were not set: %+v", conf)
+	}
+	if conf.Opt == nil || conf.Opt.Port != 8080 {
+		t.Fatalf("optional section was not set: %+v", conf.Opt)
+	}
+	if s := flags.Lookup("a-b-c-name").Value.String(); s != "deep" {
+		t.Errorf("wrong flag value %q", s)
+	}
+	if src, err := cfg.Origin("opt.port"); err != nil || src.Name != "--opt-port" {
+		t.Errorf("wrong source %v %v", src, err)
+	}
+
+	// Flags should follow the struct given to SetConfig.
+	var next C
+	if err = cfg.SetConfig(&next); err != nil {
+		t.Fatal(err)
+	}
+	if err = flags.Set("a-b-c-name", "next"); err != nil {
+		t.Fatal(err)
+	}
+	if next.A.B.C.Name != "next" || conf.A.B.C.Name != "deep" {
+		t.Error("flags should set the current config struct")
+	}
+
+	// Structs that are not pointers cannot be set.
+	cfg = New(C{})
+	std := flag.NewFlagSet("test", flag.ContinueOnError)
+	std.SetOutput(ioutil.Discard)
+	if err = cfg.BindToFlagSet(std); err != nil {
+		t.Fatal(err)
+	}
+	if err = std.Set("a-b-c-name", "x"); err == nil {
+		t.Error("expected an error for a struct that is not addressable")
+	}
+}
+
+func TestFlagGroups(t *testing.T) {
+	type C struct {
+		Verbose bool `config:"verbose"`
+		DB      struct {
+			Host string `config:"host"`
+			Port int    `config:"port"`
+			TLS  struct {
+				Cert string `config:"cert" group:"Security"`
+			} `config:"tls"`
+		} `config:"db" group:"Database"`
+	}
+	cfg := New(&C{})
+	cmd := &cobra.Command{Use: "app", Run: func(*cobra.Command, []string) {}}
+	if err := cfg.BindToPFlagSet(cmd.Flags()); err != nil {
+		t.Fatal(err)
+	}
+	usage := FlagUsages(cmd.Flags())
+	groups := strings.Split(usage, "\n\n")
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 groups, got:\n%s", usage)
+	}
+	if !strings.Contains(groups[0], "--verbose") || strings.Contains(groups[0], "--db-host") {
+		t.Errorf("wrong ungrouped flags:\n%s", groups[0])
+	}
+	if !strings.HasPrefix(groups[1], "Database:\n") || !strings.Contains(groups[1], "--db-host") ||
+		!strings.Contains(groups[1], "--db-port") || strings.Contains(groups[1], "cert") {
+		t.Errorf("wrong database flags:\n%s", groups[1])
+	}
+	if !strings.HasPrefix(groups[2], "Security:\n") || !strings.Contains(groups[2], "--db-tls-cert") {
+		t.Errorf("wrong security flags:\n%s", groups[2])
+	}
+
+	UseFlagGroups(cmd)
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	if err := cmd.Usage(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Database:\n      --db-host") {
+		t.Errorf("usage should list flags by group:\n%s", out.String())
+	}
+}
+
+func TestBindSubcommandFlags(t *testing.T) {
+	type C struct {
+		Server *struct {
+			Port int    `config:"port" default:"80"`
+			Host string `config:"host"`
+		} `config:"server"`
+		Client struct {
+			Timeout int `config:"timeout"`
+		} `config:"client"`
+		Name string `config:"name"`
+	}
+	var conf C
+	cfg := New(&conf)
+	root := &cobra.Command{Use: "app"}
+	serve := &cobra.Command{Use: "serve", Aliases: []string{"s"}, Run: func(*cobra.Command, []string) {}}
+	root.AddCommand(serve, &cobra.Command{Use: "get"})
+	if err := cfg.BindSubcommandFlags(root, "s", "server"); err != nil {
+		t.Fatal(err)
+	}
+	if serve.Flags().Lookup("port") == nil || serve.Flags().Lookup("timeout") != nil || serve.Flags().Lookup("name") != nil {
+		t.Error("only the server flags should be bound")
+	}
+	root.SetArgs([]string{"serve", "--port", "8080", "--host", "localhost"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Server == nil || conf.Server.Port != 8080 || cfg.GetString("server.host") != "localhost" {
+		t.Errorf("server flags were not set: %+v", conf.Server)
+	}
+	if src, err := cfg.Origin("server.port"); err != nil || src.Name != "--port" {
+		t.Errorf("wrong source %v %v", src, err)
+	}
+	if err := cfg.BindSubcommandFlags(root, "nope", "server"); err == nil {
+		t.Error("expected an error for a missing subcommand")
+	}
+	if err := cfg.BindSubcommandFlags(root, "get", "name"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("expected ErrFieldNotFound, got %v", err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	type C struct {
+		Host  string `yaml:"host"`
+		Port  int    `yaml:"port"`
+		Token string `yaml:"token"`
+		Name  string `yaml:"name"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	tmpl := `host: {{ env "CONFIG_TEST_HOST" | default "localhost" }}
+port: {{ .Port }}
+token: {{ file "token.txt" }}
+name: {{ hostname }}
+`
+	if err := ioutil.WriteFile(file, []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "token.txt"), []byte("abc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(file))
+	cfg.SetTemplates(true)
+	cfg.SetTemplateData(map[string]int{"Port": 8080})
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	host, _ := os.Hostname()
+	exp := C{Host: "localhost", Port: 8080, Token: "abc", Name: host}
+	if conf != exp {
+		t.Errorf("got %+v, want %+v", conf, exp)
+	}
+	if err := cfg.Save(); !errors.Is(err, ErrTemplateFile) {
+		t.Errorf("expected ErrTemplateFile, got %v", err)
+	}
+
+	cfg.SetTemplateData(nil)
+	if err := cfg.ReadConfig(); err == nil {
+		t.Error("expected an error for missing template data")
+	}
+	cfg.SetTemplates(false)
+	if err := cfg.ReadConfig(); err == nil {
+		t.Error("templates should not be rendered when turned off")
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	type C struct {
+		Port int64 `yaml:"port"` // not an int so the hook is run
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(file, []byte("port: 8080\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		conf  C
+		calls int
+	)
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(file))
+	cfg.AddDecodeHook(func(from, to reflect.Type, data interface{}) (interface{}, error) {
+		calls++
+		return data, nil
+	})
+	for i := 0; i < 3; i++ {
+		if err := cfg.ReadConfig(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("unchanged file should only be processed once, got %d", calls)
+	}
+	if conf.Port != 8080 {
+		t.Errorf("wrong port %d", conf.Port)
+	}
+
+	// old modtimes skip reading the file
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(file, old, old); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := cfg.ReadConfig(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("file should not be processed after only changing modtime, got %d", calls)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("port: 9000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || conf.Port != 9000 {
+		t.Errorf("changed file should be processed again, got %d calls and port %d", calls, conf.Port)
+	}
+	cfg.AddDecodeHook(StringToDurationHook)
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("adding a hook should clear the cache, got %d calls", calls)
+	}
+}
+
+type benchConfig struct {
+	Host    string `yaml:"host" default:"localhost"`
+	Port    int    `yaml:"port" default:"8080"`
+	Timeout time.Duration
+	Tags    []string `yaml:"tags"`
+	DB      struct {
+		Name string `yaml:"name"`
+		Pool struct {
+			Size    int    `yaml:"size"`
+			Backend string `yaml:"backend" default:"pgx"`
+		} `yaml:"pool"`
+	} `yaml:"db"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+func newBenchConfig() (*Config, *benchConfig) {
+	conf := &benchConfig{Host: "example.com", Port: 443, Tags: []string{"a", "b"}}
+	conf.DB.Name = "app"
+	conf.DB.Pool.Size = 10
+	conf.Labels = map[string]string{"env": "prod"}
+	return New(conf, WithType("yaml")), conf
+}
+
+func TestGetterAllocs(t *testing.T) {
+	cfg, _ := newBenchConfig()
+	for _, tt := range []struct {
+		name   string
+		budget float64
+		fn     func()
+	}{
+		{"Get", 2, func() { cfg.Get("port") }},
+		{"GetString", 1, func() { cfg.GetString("db.name") }},
+		{"GetInt", 1, func() { cfg.GetInt("db.pool.size") }},
+		{"GetString map key", 6, func() { cfg.GetString("labels.env") }},
+	} {
+		if n := testing.AllocsPerRun(100, tt.fn); n > tt.budget {
+			t.Errorf("%s: %v allocations, want at most %v", tt.name, n, tt.budget)
+		}
+	}
+}
+
+func TestSharedMetadata(t *testing.T) {
+	a, _ := newBenchConfig()
+	b, _ := newBenchConfig()
+	if reflect.ValueOf(a.index).Pointer() != reflect.ValueOf(b.index).Pointer() {
+		t.Error("configs for the same type should share a field index")
+	}
+	fields := fieldsOf(reflect.TypeOf(benchConfig{}), defaultTags)
+	if &fields[0] != &fieldsOf(reflect.TypeOf(benchConfig{}), defaultTags)[0] {
+		t.Error("struct fields should only be parsed once")
+	}
+	if len(fields) != 6 || !fields[4].nested || fields[4].Name != "DB" {
+		t.Errorf("wrong fields %+v", fields)
+	}
+	if !fields[0].label("host") || !fields[0].label("Host") || fields[0].label("") {
+		t.Errorf("wrong labels %v", fields[0].labels)
+	}
+}
+
+func BenchmarkNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cfg, _ := newBenchConfig()
+		cfg.GetString("db.pool.backend")
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	cfg, _ := newBenchConfig()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cfg.Get("port")
+	}
+}
+
+func BenchmarkGetStringDeep(b *testing.B) {
+	cfg, _ := newBenchConfig()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cfg.GetString("db.name")
+	}
+}
+
+func BenchmarkGetDefault(b *testing.B) {
+	cfg, _ := newBenchConfig()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cfg.GetString("db.pool.backend") // zero value so the default is used
+	}
+}
+
+func BenchmarkGetMapKey(b *testing.B) {
+	cfg, _ := newBenchConfig()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cfg.GetString("labels.env")
+	}
+}
+
+func BenchmarkReadConfig(b *testing.B) {
+	for _, n := range []int{1, 4, 16} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			dir := b.TempDir()
+			files := make([]string, n)
+			for i := range files {
+				files[i] = filepath.Join(dir, fmt.Sprintf("config%d.yml", i))
+				raw := fmt.Sprintf("host: host%d\nport: %d\ndb:\n  name: db%d\n  pool:\n    size: %d\n", i, i, i, i)
+				if err := ioutil.WriteFile(files[i], []byte(raw), 0644); err != nil {
+					b.Fatal(err)
+				}
+			}
+			var conf benchConfig
+			cfg := New(&conf, WithType("yaml"), WithFilepaths(files...))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := cfg.ReadConfig(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	type large struct {
+		Fields [64]benchConfig
+		List   []benchConfig
+		Map    map[string]benchConfig
+	}
+	src := large{List: make([]benchConfig, 64), Map: make(map[string]benchConfig)}
+	for i := range src.Fields {
+		src.Fields[i].Host = "host"
+		src.Fields[i].DB.Pool.Size = i
+		src.List[i].Port = i
+		src.Map[strconv.Itoa(i)] = benchConfig{Port: i}
+	}
+	var dst large
+	cfg := New(&dst)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dst = large{}
+		if err := cfg.merge(reflect.ValueOf(&dst), reflect.ValueOf(&src), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestKeyMapper(t *testing.T) {
+	type C struct {
+		DB struct {
+			MaxConns int               `config:"max_conns"`
+			Labels   map[string]string `config:"extra_labels"`
+		} `config:"database"`
+		LogLevel string
+	}
+	var conf C
+	conf.DB.MaxConns = 5
+	conf.DB.Labels = map[string]string{"some-key": "a"}
+	cfg := New(&conf)
+	if cfg.HasKey("database.max-conns") {
+		t.Error("keys should not be mapped without a key mapper")
+	}
+	cfg.SetKeyMapper(NormalizeKey)
+	if n := cfg.GetInt("database.max-conns"); n != 5 {
+		t.Errorf("expected 5, got %d", n)
+	}
+	if n := cfg.GetInt("Database.maxConns"); n != 5 {
+		t.Errorf("expected 5, got %d", n)
+	}
+	if s := cfg.GetString("database.extra-labels.some-key"); s != "a" {
+		t.Errorf("map keys should not be mapped, got %q", s)
+	}
+	if cfg.HasKey("database.extra-labels.somekey") {
+		t.Error("map keys should not be mapped")
+	}
+	if err := cfg.Set("log-level", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.SetFromString("database.max-conns", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.LogLevel != "debug" || conf.DB.MaxConns != 10 {
+		t.Errorf("wrong config %+v", conf)
+	}
+	if src, err := cfg.Origin("log_level"); err != nil || src.Kind != SourceSet {
+		t.Errorf("wrong source %v %v", src, err)
+	}
+	if NormalizeKey("Max_Conns-x") != "maxconnsx" {
+		t.Error("wrong normalized key")
+	}
+}
+
+func TestEscapedKeys(t *testing.T) {
+	type C struct {
+		Hosts   map[string]string `config:"hosts"`
+		Version string            `config:"app.version"`
+		Nested  struct {
+			Name string `config:"first.name"`
+		} `config:"nested"`
+	}
+	var conf C
+	conf.Hosts = map[string]string{"example.com": "1.2.3.4"}
+	conf.Version = "v1"
+	cfg := New(&conf)
+	if s := cfg.GetString(`hosts.example\.com`); s != "1.2.3.4" {
+		t.Errorf("wrong map value %q", s)
+	}
+	if s := cfg.GetString(`app\.version`); s != "v1" {
+		t.Errorf("wrong value %q", s)
+	}
+	if !cfg.HasKey(`nested.first\.name`) || cfg.HasKey("nested.first.name") {
+		t.Error("escaped dots should be part of the name")
+	}
+	if err := cfg.Set(`nested.first\.name`, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Set(`hosts.a\.b`, "5.6.7.8"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Nested.Name != "x" || conf.Hosts["a.b"] != "5.6.7.8" {
+		t.Errorf("wrong config %+v", conf)
+	}
+	keys := cfg.AllKeys()
+	for _, k := range []string{`app\.version`, `nested.first\.name`} {
+		found := false
+		for _, key := range keys {
+			found = found || key == k
+		}
+		if !found {
+			t.Errorf("expected %q in %q", k, keys)
+		}
+		if !cfg.HasKey(k) {
+			t.Errorf("key %q from AllKeys should be found", k)
+		}
+	}
+
+	for _, tt := range []struct {
+		key string
+		exp []string
+	}{
+		{"a.b", []string{"a", "b"}},
+		{`a\.b.c`, []string{"a.b", "c"}},
+		{`a\\.b`, []string{`a\`, "b"}},
+		{`a\b`, []string{`a\b`}},
+	} {
+		parts := splitKey(tt.key)
+		if !reflect.DeepEqual(parts, tt.exp) {
+			t.Errorf("splitKey(%q) = %q, want %q", tt.key, parts, tt.exp)
+		}
+		if k := joinKey(parts); k != tt.key && tt.key != `a\b` {
+			t.Errorf("joinKey(%q) = %q, want %q", parts, k, tt.key)
+		}
+	}
+}
+
+func TestKeyCompletions(t *testing.T) {
+	type C struct {
+		Host string `config:"host,usage=the server host"`
+		DB   struct {
+			Port int    `config:"port,usage=database port"`
+			Name string `config:"name"`
+		} `config:"db"`
+	}
+	cfg := New(&C{})
+	exp := []Completion{
+		{Key: "db.port", Type: "int", Usage: "database port"},
+		{Key: "db.name", Type: "string"},
+	}
+	if comps := cfg.KeyCompletions("db."); !reflect.DeepEqual(comps, exp) {
+		t.Errorf("got %+v, want %+v", comps, exp)
+	}
+	if comps := cfg.KeyCompletions(""); len(comps) != 3 {
+		t.Errorf("expected every key, got %+v", comps)
+	}
+	if comps := cfg.KeyCompletions("x"); len(comps) != 0 {
+		t.Errorf("expected no keys, got %+v", comps)
+	}
+
+	keys, _ := cfg.completeKeys(nil, []string{"db.name"}, "")
+	if !reflect.DeepEqual(keys, []string{"host\tthe server host", "db.port\tdatabase port"}) {
+		t.Errorf("wrong cobra completions %q", keys)
+	}
+}
+
+func TestParse(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host" json:"host" default:"localhost"`
+		Port int    `yaml:"port" json:"port" default:"8080"`
+		Name string `yaml:"name" json:"name"`
+		DB   struct {
+			User string `yaml:"user" json:"user" default:"admin"`
+		} `yaml:"db" json:"db"`
+	}
+	conf := C{Name: "kept", Port: 1}
+	if err := Parse("yaml", []byte("port: 0\ndb:\n  user: me\n"), &conf); err != nil {
+		t.Fatal(err)
+	}
+	exp := C{Host: "localhost", Port: 8080, Name: "kept"}
+	exp.DB.User = "me"
+	if conf != exp {
+		t.Errorf("got %+v, want %+v", conf, exp)
+	}
+	if err := Parse("jsonc", []byte("{\"host\": \"example.com\", // comment\n}"), &conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "example.com" || conf.Name != "kept" {
+		t.Errorf("wrong config %+v", conf)
+	}
+
+	before := conf
+	if err := Parse("json", []byte(`{"port": "not a number"}`), &conf); err == nil {
+		t.Error("expected an error for bad data")
+	}
+	if conf != before {
+		t.Error("config should not change when there is an error")
+	}
+	if err := Parse("ini", nil, &conf); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if err := Parse("json", nil, conf); err == nil {
+		t.Error("expected an error for a non-pointer")
+	}
+}
+
+func TestSetConfigSwap(t *testing.T) {
+	type V1 struct {
+		Host string `config:"host"`
+		Port int    `config:"port"`
+		Old  bool   `config:"old"`
+	}
+	type V2 struct {
+		Name string `config:"name"`
+		Port int    `config:"port"`
+		Host string `config:"host"`
+	}
+	v1 := &V1{Host: "a"}
+	cfg := New(v1)
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	if err := cfg.BindToPFlagSet(set); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse([]string{"--port=9"}); err != nil {
+		t.Fatal(err)
+	}
+	v2 := &V2{Host: "b"}
+	if err := cfg.SetConfig(v2); err != nil {
+		t.Fatal(err)
+	}
+	if v2.Port != 9 {
+		t.Errorf("flag value should be set in the new struct, got %d", v2.Port)
+	}
+	if s := cfg.GetString("host"); s != "b" {
+		t.Errorf("expected the new struct's value, got %q", s)
+	}
+	if err := set.Set("host", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if v2.Host != "c" || v1.Host != "a" {
+		t.Errorf("flag should set the new struct, got %q and %q", v2.Host, v1.Host)
+	}
+	if src, err := cfg.Origin("port"); err != nil || src.Kind != SourceFlag {
+		t.Errorf("wrong source %v %v", src, err)
+	}
+	if err := set.Set("old", "true"); err == nil {
+		t.Error("expected an error for a flag that is not in the new struct")
+	}
+
+	// flags that were given but no longer exist are reported
+	cfg.SetConfig(&V1{})
+	if err := set.Set("old", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.SetConfig(&V2{}); err == nil || !strings.Contains(err.Error(), "--old") {
+		t.Errorf("expected an error naming the missing flag, got %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+		Port int    `yaml:"port"`
+	}
+	dir := t.TempDir()
+	high, low := filepath.Join(dir, "high.yml"), filepath.Join(dir, "low.yml")
+	write := func(file, s string) {
+		t.Helper()
+		if err := ioutil.WriteFile(file, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(high, "port: 1\n")
+	write(low, "host: a\nport: 2\n")
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(high, low))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf != (C{Host: "a", Port: 1}) {
+		t.Fatalf("wrong config %+v", conf)
+	}
+
+	write(high, "port: 3\n")
+	write(low, "host: b\nport: 4\n")
+	if err := cfg.Reload(low); err != nil {
+		t.Fatal(err)
+	}
+	if conf != (C{Host: "b", Port: 1}) {
+		t.Errorf("only the reloaded file should change and keep its precedence, got %+v", conf)
+	}
+	if err := cfg.Reload(high); err != nil {
+		t.Fatal(err)
+	}
+	if conf != (C{Host: "b", Port: 3}) {
+		t.Errorf("wrong config after reloading %s: %+v", high, conf)
+	}
+	if err := cfg.Reload(filepath.Join(dir, "other.yml")); !errors.Is(err, ErrNoConfigFile) {
+		t.Errorf("expected ErrNoConfigFile, got %v", err)
+	}
+}
+
+func TestReloadMergeAppend(t *testing.T) {
+	type C struct {
+		List []string `yaml:"list"`
+	}
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")
+	if err := ioutil.WriteFile(a, []byte("list: [a]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(b, []byte("list: [b]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(a, b))
+	cfg.SetMergeStrategy(MergeAppend)
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conf.List, []string{"a", "b"}) {
+		t.Fatalf("wrong list after ReadConfig: %v", conf.List)
+	}
+	if err := ioutil.WriteFile(a, []byte("list: [c]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Reload(a); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conf.List, []string{"c", "b"}) {
+		t.Errorf("reloaded file should be merged with the merge strategy, got %v", conf.List)
+	}
+	if err := cfg.Reload(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conf.List, []string{"c", "b"}) {
+		t.Errorf("wrong list after reloading %s: %v", b, conf.List)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+		Port int    `yaml:"port"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(file, []byte("host: a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(file))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.SetReadOnly(true)
+	if !cfg.IsReadOnly() {
+		t.Fatal("config should be read-only")
+	}
+	if err := cfg.Set("port", 80); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from Set, got %v", err)
+	}
+	if err := cfg.SetFromString("port", "80"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from SetFromString, got %v", err)
+	}
+	if err := cfg.Unset("host"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from Unset, got %v", err)
+	}
+	if err := cfg.LoadFlatMap(map[string]string{"port": "80"}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from LoadFlatMap, got %v", err)
+	}
+	if err := cfg.Save(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from Save, got %v", err)
+	}
+	if conf != (C{Host: "a"}) {
+		t.Errorf("read-only config was changed: %+v", conf)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("host: b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "b" {
+		t.Errorf("ReadConfig should still reload a read-only config, got %q", conf.Host)
+	}
+	cfg.SetReadOnly(false)
+	if err := cfg.Set("port", 80); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+		Port int    `yaml:"port"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(file, []byte("host: a\nport: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		conf C
+		buf  bytes.Buffer
+	)
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(file))
+	cfg.SetHistory(3, &buf)
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	// reading the same values again is not a change
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	set := flag.NewFlagSet("", flag.ContinueOnError)
+	if err := cfg.BindToFlagSet(set); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse([]string{"-Port", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Set("host", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte("host: c\nport: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Reload(file); err != nil {
+		t.Fatal(err)
+	}
+
+	hist := cfg.History()
+	if len(hist) != 3 {
+		t.Fatalf("expected 3 changes, got %d: %v", len(hist), hist)
+	}
+	expected := []struct {
+		op   string
+		src  Source
+		keys []string
+	}{
+		{"flag", Source{Kind: SourceFlag, Name: "-Port"}, []string{"port"}},
+		{"set", Source{Kind: SourceSet}, []string{"host"}},
+		{"reload", Source{Kind: SourceFile, Name: file}, []string{"host", "port"}},
+	}
+	for i, exp := range expected {
+		m := hist[i]
+		if m.Op != exp.op || m.Source != exp.src || !reflect.DeepEqual(m.Keys, exp.keys) {
+			t.Errorf("change %d: expected %s %v %v, got %s %v %v", i, exp.op, exp.src, exp.keys, m.Op, m.Source, m.Keys)
+		}
+		if m.Time.IsZero() {
+			t.Errorf("change %d has no time", i)
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected every change to be written, got %q", buf.String())
+	}
+	if !strings.HasSuffix(lines[0], "read: host, port") {
+		t.Errorf("wrong first line %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[3], "reload: host, port (file "+file+")") {
+		t.Errorf("wrong last line %q", lines[3])
+	}
+
+	cfg.SetHistory(0, nil)
+	if err := cfg.Set("host", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if hist = cfg.History(); len(hist) != 0 {
+		t.Errorf("history should be cleared, got %v", hist)
+	}
+}
+
+func TestReloadInterval(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(file, []byte("host: a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu          sync.Mutex
+		reads, skip int
+	)
+	tracer := TracerFunc(func(op TraceOp, name string) func(time.Duration, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch op {
+		case TraceRead:
+			reads++
+		case TraceSkipReload:
+			skip++
+		}
+		return nil
+	})
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(file))
+	cfg.SetTracer(tracer)
+	cfg.SetReloadInterval(50 * time.Millisecond)
+	sigs := make(chan string)
+	defer close(sigs)
+	go cfg.reloadOn(sigs)
+
+	for i := 0; i < 4; i++ {
+		sigs <- os.Interrupt.String()
+	}
+	count := func() (int, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return reads, skip
+	}
+	deadline := time.Now().Add(time.Second)
+	for r, _ := count(); r < 2 && time.Now().Before(deadline); r, _ = count() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if r, s := count(); r != 2 || s != 2 {
+		t.Errorf("expected 2 reloads and 2 skipped signals, got %d and %d", r, s)
+	}
+	if n := cfg.SkippedReloads(); n != 2 {
+		t.Errorf("expected 2 skipped reloads, got %d", n)
+	}
+}
+
+func TestAddPaths(t *testing.T) {
+	var conf struct{}
+	cfg := New(&conf)
+	dir := t.TempDir()
+	added, err := cfg.AddPaths(dir, "", "relative", dir+string(filepath.Separator))
+	if err == nil {
+		t.Error("expected an error for the empty and duplicate paths")
+	} else if !strings.Contains(err.Error(), "empty path") || !strings.Contains(err.Error(), "duplicate path") {
+		t.Errorf("error should list every rejected path: %v", err)
+	}
+	rel, err := filepath.Abs("relative")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{dir, rel}
+	if !reflect.DeepEqual(added, exp) {
+		t.Errorf("wrong paths added: got %v, want %v", added, exp)
+	}
+	if !reflect.DeepEqual(cfg.Paths(), exp) {
+		t.Errorf("wrong paths: got %v, want %v", cfg.Paths(), exp)
+	}
+
+	added, err = cfg.AddFiles("config.yml", " ", "config.json", "config.yml")
+	if err == nil {
+		t.Error("expected an error for the empty and duplicate filenames")
+	}
+	if exp = []string{"config.yml", "config.json"}; !reflect.DeepEqual(added, exp) {
+		t.Errorf("wrong files added: got %v, want %v", added, exp)
+	}
+	if _, err = cfg.AddFiles("other.yml"); err != nil {
+		t.Error(err)
+	}
+	if len(cfg.filenames) != 3 {
+		t.Errorf("wrong filenames: %v", cfg.filenames)
+	}
+}
+
+func TestAddFilePattern(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+		Port int    `yaml:"port"`
+		Name string `yaml:"name"`
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yml":         "host: main\n",
+		"conf.d/20-b.yaml":   "port: 2\nhost: b\n",
+		"conf.d/10-a.yaml":   "port: 1\nname: a\n",
+		"conf.d/skipped.txt": "port: 3\n",
+	}
+	for name, body := range files {
+		f := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(f, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithPaths(dir), WithFiles("config.yml"))
+	if err := cfg.AddFilePattern("conf.d/*.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		filepath.Join(dir, "config.yml"),
+		filepath.Join(dir, "conf.d", "20-b.yaml"),
+		filepath.Join(dir, "conf.d", "10-a.yaml"),
+	}
+	if used := cfg.FilesUsed(); !reflect.DeepEqual(used, exp) {
+		t.Errorf("wrong files: got %v, want %v", used, exp)
+	}
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf != (C{Host: "main", Port: 2, Name: "a"}) {
+		t.Errorf("wrong config %+v", conf)
+	}
+	if err := cfg.AddFilePattern("conf.d/[*.yaml"); !errors.Is(err, filepath.ErrBadPattern) {
+		t.Errorf("expected filepath.ErrBadPattern, got %v", err)
+	}
+	for i, j := 0, len(exp)-1; i < j; i, j = i+1, j-1 {
+		exp[i], exp[j] = exp[j], exp[i]
+	}
+	if used := cfg.OrderedFilesUsed(); !reflect.DeepEqual(used, exp) {
+		t.Errorf("wrong file order: got %v, want %v", used, exp)
+	}
+
+	// every fragment with an error is reported
+	for _, name := range []string{"10-a.yaml", "20-b.yaml"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "conf.d", name), []byte("port: [\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := cfg.ReadConfig()
+	var errs FileErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected FileErrors, got %v", err)
+	}
+	if len(errs) != 2 || errs[0].File != exp[1] || errs[1].File != exp[0] {
+		t.Errorf("wrong file errors: %v", errs)
+	}
+}
+
+func TestHomeDirSudo(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sudo is not used on windows")
+	}
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer os.Setenv("SUDO_USER", os.Getenv("SUDO_USER"))
+	os.Setenv("SUDO_USER", u.Username)
+	home, err := HomeDirErr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if home != u.HomeDir {
+		t.Errorf("expected the home dir of $SUDO_USER: got %q, want %q", home, u.HomeDir)
+	}
+	os.Setenv("SUDO_USER", "config-test-no-such-user")
+	if _, err = HomeDirErr(); err == nil {
+		t.Error("expected an error for an unknown $SUDO_USER")
+	}
+	if HomeDir() != "" {
+		t.Error("HomeDir should be empty when the home dir cannot be found")
+	}
+}
+
+func TestExplainFiles(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+	}
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		t.Helper()
+		f := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(f, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	good := write("good.yml", "host: a\n")
+	bad := write("bad.yml", "host: [\n")
+	missing := filepath.Join(dir, "missing.yml")
+	subdir := filepath.Join(dir, "dir.yml")
+	if err := os.Mkdir(subdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(good, bad, missing, subdir))
+	res := cfg.ExplainFiles()
+	exp := []struct {
+		path   string
+		status FileStatus
+		err    bool
+	}{
+		{good, FileLoaded, false},
+		{bad, FileInvalid, true},
+		{missing, FileMissing, false},
+		{subdir, FileSkipped, true},
+	}
+	if len(res) != len(exp) {
+		t.Fatalf("expected %d files, got %v", len(exp), res)
+	}
+	for i, e := range exp {
+		if res[i].Path != e.path || res[i].Status != e.status || (res[i].Err != nil) != e.err {
+			t.Errorf("wrong result for %s: %v", e.path, res[i])
+		}
+	}
+	if conf.Host != "" {
+		t.Error("ExplainFiles should not change the config struct")
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	type C struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	dir := t.TempDir()
+	var files []string
+	for _, body := range []string{"{\n  \"host\": \"a\",\n  \"port\": \"x\"\n}", "{\"host\": }", `{"port": 1}`} {
+		f := filepath.Join(dir, fmt.Sprintf("%d.json", len(files)))
+		if err := ioutil.WriteFile(f, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	var conf C
+	cfg := New(&conf, WithType("json"), WithFilepaths(files...))
+	var errs FileErrors
+	if err := cfg.ReadConfig(); !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expected errors for both broken files, got %v", err)
+	}
+	if conf.Port != 1 {
+		t.Error("files after a broken file should still be read")
+	}
+
+	conf = C{}
+	cfg.FailFast(true)
+	err := cfg.ReadConfig()
+	var ferr *FileError
+	if !errors.As(err, &ferr) {
+		t.Fatalf("expected a FileError, got %v", err)
+	}
+	if ferr.File != files[0] || ferr.Line != 3 || ferr.Column != 13 {
+		t.Errorf("wrong file position %s:%d:%d", ferr.File, ferr.Line, ferr.Column)
+	}
+	if !strings.HasPrefix(err.Error(), files[0]+":3:13: ") {
+		t.Errorf("error should start with the position: %v", err)
+	}
+	if conf.Port != 0 {
+		t.Error("files after the broken file should not be read")
+	}
+}
+
+func TestFileRequirement(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	missing := filepath.Join(dir, "missing.yml")
+
+	var conf C
+	cfg := New(&conf, WithType("yaml"))
+	cfg.AddFilepath(missing, Optional)
+	cfg.AddPath(dir)
+	cfg.AddFile("local.yml", Optional)
+	if err := cfg.ReadConfig(); err != nil {
+		t.Errorf("optional files should be skipped, got %v", err)
+	}
+
+	cfg.AddFilepath(file, Required)
+	err := cfg.ReadConfig()
+	var ferr *FileError
+	if !errors.As(err, &ferr) || !errors.Is(err, ErrRequiredFile) || ferr.File != file {
+		t.Fatalf("expected an error naming the required file, got %v", err)
+	}
+	if err = ioutil.WriteFile(file, []byte("host: a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "a" {
+		t.Errorf("required file was not read: %+v", conf)
+	}
+
+	cfg.AddFile("other.yml", Required)
+	if err = cfg.ReadConfig(); !errors.Is(err, ErrRequiredFile) || !strings.Contains(err.Error(), "other.yml") {
+		t.Errorf("expected an error naming the required filename, got %v", err)
+	}
+
+	// files that are not marked keep the old behavior
+	cfg = New(&conf, WithType("yaml"), WithFilepaths(missing))
+	cfg.AddFilepath(filepath.Join(dir, "other.yml"), Optional)
+	if err = cfg.ReadConfig(); !errors.Is(err, ErrNoConfigFile) {
+		t.Errorf("expected ErrNoConfigFile, got %v", err)
+	}
+}
+
+// revFS is a RevisionFS where each file's
+// revision is the number of times it was written.
+type revFS struct {
+	mu    sync.Mutex
+	files map[string]string
+	revs  map[string]int
+}
+
+func (fs *revFS) ReadFile(name string) ([]byte, error) {
+	b, _, err := fs.ReadFileRevision(name)
+	return b, err
+}
+
+func (fs *revFS) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.files[name]; !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return mapFileInfo(name), nil
+}
+
+func (fs *revFS) ReadFileRevision(name string) ([]byte, string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	s, ok := fs.files[name]
+	if !ok {
+		return nil, "", &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return []byte(s), strconv.Itoa(fs.revs[name]), nil
+}
+
+func (fs *revFS) WriteFileRevision(name string, data []byte, rev string) (string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	cur := ""
+	if _, ok := fs.files[name]; ok {
+		cur = strconv.Itoa(fs.revs[name])
+	}
+	if rev != cur {
+		return "", ErrConflict
+	}
+	fs.files[name] = string(data)
+	fs.revs[name]++
+	return strconv.Itoa(fs.revs[name]), nil
+}
+
+func TestRevisionFS(t *testing.T) {
+	type C struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	fs := &revFS{
+		files: map[string]string{"/etc/app.json": `{"host":"a","port":1}`},
+		revs:  map[string]int{"/etc/app.json": 1},
+	}
+	var conf C
+	cfg := New(&conf, WithType("json"), WithFS(fs), WithFilepaths("/etc/app.json"))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Set("port", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	var saved C
+	if err := json.Unmarshal([]byte(fs.files["/etc/app.json"]), &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved != (C{Host: "a", Port: 2}) || fs.revs["/etc/app.json"] != 2 {
+		t.Errorf("config was not written back: %+v, revision %d", saved, fs.revs["/etc/app.json"])
+	}
+	if _, err := os.Stat("/etc/app.json"); err == nil {
+		t.Error("should not write to the os file system")
+	}
+
+	// saving again uses the revision from the last write
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	// someone else changes the file
+	if _, err := fs.WriteFileRevision("/etc/app.json", []byte(`{"host":"b"}`), "3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Save(); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	if fs.files["/etc/app.json"] != `{"host":"b"}` {
+		t.Error("conflicting write should not change the file")
+	}
+}
+
+func TestStreamUpdates(t *testing.T) {
+	var (
+		conf validatedConfig
+		log  testLogger
+	)
+	cfg := New(&conf, WithType("yaml"), WithLogger(&log))
+	if err := cfg.Apply("push", []byte("host: a\nport: 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Apply("push", []byte("port: -1\n")); err == nil {
+		t.Error("expected a validation error")
+	}
+	if err := cfg.Apply("push", []byte("port: 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	if conf != (validatedConfig{Host: "a", Port: 2}) {
+		t.Errorf("wrong config after Apply: %+v", conf)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": comment\n\n")
+		fmt.Fprint(w, "event: other\ndata: host: ignored\n\n")
+		fmt.Fprint(w, "data: host: b\ndata: port: -5\n\n")
+		fmt.Fprint(w, "data: host: c\ndata: port: 3\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- cfg.StreamUpdates(ctx, srv.Client(), srv.URL) }()
+
+	deadline := time.Now().Add(time.Second)
+	for cfg.GetString("host") != "c" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if cfg.GetString("host") != "c" || cfg.GetInt("port") != 3 {
+		t.Errorf("pushed config was not applied: %+v", conf)
+	}
+	if src, err := cfg.Origin("port"); err != nil || src != (Source{Kind: SourceRemote, Name: srv.URL, Line: 2}) {
+		t.Errorf("wrong source %v, %v", src, err)
+	}
+	if !strings.Contains(log.String(), "port must be positive") {
+		t.Errorf("invalid updates should be logged, got %q", log.String())
+	}
+}
+
+func TestApplyRestrictions(t *testing.T) {
+	type C struct {
+		Token string `yaml:"token" config:"token,envonly"`
+		Mode  string `yaml:"mode" enum:"a,b"`
+		Host  string `yaml:"host"`
+	}
+	var (
+		conf C
+		log  testLogger
+	)
+	cfg := New(&conf, WithType("yaml"), WithLogger(&log))
+	cfg.RegisterAlias("hostname", "host")
+	if err := cfg.Apply("remote", []byte("token: injected\nhostname: a\n")); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Token != "" {
+		t.Errorf("envonly fields should not be set by Apply, got %q", conf.Token)
+	}
+	if conf.Host != "a" {
+		t.Errorf("aliases should be used by Apply, got %q", conf.Host)
+	}
+	if !strings.Contains(log.String(), "token") {
+		t.Errorf("dropped envonly field should be logged, got %q", log.String())
+	}
+	var enumErr *EnumError
+	if err := cfg.Apply("remote", []byte("mode: zzz\n")); !errors.As(err, &enumErr) {
+		t.Errorf("expected an EnumError, got %v", err)
+	}
+	if conf.Mode != "" {
+		t.Errorf("invalid enum value should not be set, got %q", conf.Mode)
+	}
+}
+
+func TestReloadOnMessage(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(file, []byte("host: a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(file))
+	msgs := make(chan string)
+	sub := SubscriberFunc(func(ctx context.Context) (string, error) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case m := <-msgs:
+			return m, nil
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg.ReloadOnMessage(ctx, sub)
+	msgs <- "config-changed"
+	deadline := time.Now().Add(time.Second)
+	for cfg.GetString("host") != "a" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if cfg.GetString("host") != "a" {
+		t.Fatal("config was not read after a message")
+	}
+	if err := ioutil.WriteFile(file, []byte("host: b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	msgs <- "config-changed"
+	for cfg.GetString("host") != "b" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if cfg.GetString("host") != "b" {
+		t.Error("config was not reloaded after a message")
+	}
+}
+
+func TestTagSelection(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host" json:"address" toml:"hostname" mapstructure:"server"`
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"))
+	conf.Host = "localhost"
+	if s := cfg.GetString("host"); s != "localhost" {
+		t.Errorf("expected the yaml tag to be used, got %q", s)
+	}
+	if cfg.HasKey("address") || cfg.HasKey("hostname") {
+		t.Error("tags for other config types should not be used with yaml")
+	}
+	if err := cfg.SetType("toml"); err != nil {
+		t.Fatal(err)
+	}
+	if s := cfg.GetString("hostname"); s != "localhost" {
+		t.Errorf("expected the toml tag to be used, got %q", s)
+	}
+	if cfg.HasKey("host") {
+		t.Error("yaml tag should not be used with toml")
+	}
+	if keys := cfg.AllKeys(); len(keys) != 1 || keys[0] != "hostname" {
+		t.Errorf("wrong keys %v", keys)
+	}
+	if cfg.HasKey("server") {
+		t.Error("mapstructure tag has not been registered")
+	}
+	cfg.AddTagNames("mapstructure")
+	if s := cfg.GetString("server"); s != "localhost" {
+		t.Errorf("expected the mapstructure tag to be used, got %q", s)
+	}
+	if err := cfg.Set("server", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "example.com" {
+		t.Errorf("wrong host %q", conf.Host)
+	}
+
+	var untyped C
+	cfg = New(&untyped)
+	if !cfg.HasKey("host") || !cfg.HasKey("address") {
+		t.Error("yaml and json tags should be used without a config type")
+	}
+}
+
+func TestSetTagNames(t *testing.T) {
+	type C struct {
+		Host string `mapstructure:"host_name" yaml:"host"`
+		DB   struct {
+			Port int `mapstructure:"db_port" config:",usage=database port"`
+		} `mapstructure:"database"`
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"))
+	cfg.SetTagNames([]string{"config", "mapstructure"})
+	if err := cfg.Set("database.db_port", 5432); err != nil {
+		t.Fatal(err)
+	}
+	if conf.DB.Port != 5432 {
+		t.Errorf("wrong port %d", conf.DB.Port)
+	}
+	if keys := cfg.AllKeys(); !reflect.DeepEqual(keys, []string{"host_name", "database.db_port"}) {
+		t.Errorf("wrong keys %v", keys)
+	}
+	if !cfg.HasKey("host") {
+		t.Error("the yaml tag should still be used")
+	}
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	if err := cfg.BindToPFlagSet(set); err != nil {
+		t.Fatal(err)
+	}
+	flg := set.Lookup("database-db_port")
+	if flg == nil {
+		t.Fatal("flag should be named using the mapstructure tag")
+	}
+	if flg.Usage != "database port" {
+		t.Errorf("wrong usage %q", flg.Usage)
+	}
+	if set.Lookup("host_name") == nil {
+		t.Error("flag should be named using the mapstructure tag")
+	}
+}
+
+func TestSetTagNamesCachedFile(t *testing.T) {
+	type C struct {
+		Host string `mapstructure:"host_name" yaml:"host"`
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(file, []byte("host: a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := New(&C{}, WithType("yaml"), WithFilepaths(file))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if src, _ := cfg.Origin("host"); src.Kind != SourceFile {
+		t.Fatalf("wrong source: %v", src)
+	}
+	cfg.SetTagNames([]string{"mapstructure"})
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if src, _ := cfg.Origin("host_name"); src.Kind != SourceFile {
+		t.Errorf("cached file should be processed with the new tags, got %v", src)
+	}
+}
+
+type logLevel string
+
+func (l *logLevel) String() string { return string(*l) }
+func (l *logLevel) Type() string   { return "level" }
+
+func (l *logLevel) Set(s string) error {
+	switch s {
+	case "debug", "info", "error":
+		*l = logLevel(s)
+		return nil
+	}
+	return fmt.Errorf("unknown level %q", s)
+}
+
+type testList []string
+
+func (l *testList) String() string { return strings.Join(*l, ",") }
+
+func (l *testList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func TestFlagValueFields(t *testing.T) {
+	type C struct {
+		Level logLevel `config:"level" default:"info"`
+		Tags  testList `config:"tags"`
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"))
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	if err := cfg.BindToPFlagSet(set); err != nil {
+		t.Fatal(err)
+	}
+	flg := set.Lookup("level")
+	if flg == nil {
+		t.Fatal("flag value fields should be bound")
+	}
+	if flg.Value.Type() != "level" || flg.DefValue != "info" {
+		t.Errorf("wrong flag type %q or default %q", flg.Value.Type(), flg.DefValue)
+	}
+	if err := set.Parse([]string{"--level=warn"}); err == nil {
+		t.Error("expected the Set method of the field to reject the value")
+	}
+	if err := set.Parse([]string{"--level=debug", "--tags=a", "--tags=b"}); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Level != "debug" {
+		t.Errorf("wrong level %q", conf.Level)
+	}
+	if !reflect.DeepEqual(conf.Tags, testList{"a", "b"}) {
+		t.Errorf("Set should be called on the field itself, got %v", conf.Tags)
+	}
+	if src, err := cfg.Origin("level"); err != nil || src.Kind != SourceFlag {
+		t.Errorf("wrong source %v, %v", src, err)
+	}
+	if err := cfg.SetFromString("level", "verbose"); err == nil {
+		t.Error("strings should be parsed with the Set method")
+	}
+	if err := cfg.SetFromString("level", "error"); err != nil || conf.Level != "error" {
+		t.Errorf("wrong level %q, %v", conf.Level, err)
+	}
+}
+
+func TestEnumTag(t *testing.T) {
+	type C struct {
+		Format string `config:"format,usage=output format" enum:"json,yaml,toml" env:"CONFIG_TEST_FORMAT"`
+		Log    struct {
+			Level string `config:"level" enum:"debug,info"`
+		} `config:"log"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(file, []byte("format: xml\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(file))
+	err := cfg.ReadConfig()
+	var enumErr *EnumError
+	if !errors.As(err, &enumErr) {
+		t.Fatalf("expected an EnumError, got %v", err)
+	}
+	if enumErr.Key != "format" || enumErr.Value != "xml" || !reflect.DeepEqual(enumErr.Allowed, []string{"json", "yaml", "toml"}) {
+		t.Errorf("wrong error %+v", enumErr)
+	}
+	if conf.Format != "" {
+		t.Errorf("invalid value should not be used, got %q", conf.Format)
+	}
+	if err := ioutil.WriteFile(file, []byte("format: toml\nlog:\n  level: info\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.clearFileCache()
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Format != "toml" || conf.Log.Level != "info" {
+		t.Errorf("wrong values %+v", conf)
+	}
+
+	if err := cfg.SetFromString("log.level", "trace"); !errors.As(err, &enumErr) || enumErr.Key != "log.level" {
+		t.Errorf("expected an EnumError for log.level, got %v", err)
+	}
+	if err := cfg.Set("format", "ini"); !errors.As(err, &enumErr) {
+		t.Errorf("expected an EnumError, got %v", err)
+	}
+
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	if err := cfg.BindToPFlagSet(set); err != nil {
+		t.Fatal(err)
+	}
+	if usage := set.Lookup("format").Usage; usage != "output format (one of json, yaml, toml)" {
+		t.Errorf("wrong usage %q", usage)
+	}
+	if err := set.Parse([]string{"--format=csv"}); err == nil || !strings.Contains(err.Error(), "must be one of json, yaml, toml") {
+		t.Errorf("expected flag to be rejected, got %v", err)
+	}
+
+	os.Setenv("CONFIG_TEST_FORMAT", "csv")
+	defer os.Unsetenv("CONFIG_TEST_FORMAT")
+	fld := reflect.TypeOf(conf).Field(0)
+	if _, err := cfg.getDefaultValue(&fld, nil); !errors.As(err, &enumErr) {
+		t.Errorf("expected environment variable to be rejected, got %v", err)
+	}
+}
+
+func TestFlagPrefix(t *testing.T) {
+	type C struct {
+		DB struct {
+			Host string `config:"host"`
+			TLS  struct {
+				Cert string `config:"cert"`
+			} `config:"tls,flagprefix="`
+		} `config:"db,flagprefix=database"`
+		Server struct {
+			Port int `config:"port"`
+		} `config:"server,flagprefix="`
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"))
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	if err := cfg.BindToPFlagSet(set); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"database-host", "database-cert", "port"} {
+		if set.Lookup(name) == nil {
+			t.Errorf("expected flag %q", name)
+		}
+	}
+	if err := set.Parse([]string{"--database-host=db", "--port=80"}); err != nil {
+		t.Fatal(err)
+	}
+	if conf.DB.Host != "db" || conf.Server.Port != 80 {
+		t.Errorf("wrong values %+v", conf)
+	}
+	if cfg.GetString("db.host") != "db" {
+		t.Error("keys should not use the flag prefix")
+	}
+
+	stdset := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := cfg.BindToFlagSet(stdset); err != nil {
+		t.Fatal(err)
+	}
+	if stdset.Lookup("database-host") == nil || stdset.Lookup("port") == nil {
+		t.Error("flag prefix should be used with the flag package")
+	}
+}
+
+func TestIgnoredField(t *testing.T) {
+	type C struct {
+		Host  string `config:"host" yaml:"host"`
+		Token string `config:"-" yaml:"token" default:"abc" env:"CONFIG_TEST_TOKEN"`
+		DB    struct {
+			Internal int `config:"-" yaml:"internal"`
+			Port     int `config:"port" yaml:"port"`
+		} `config:"db" yaml:"db"`
+	}
+	os.Setenv("CONFIG_TEST_TOKEN", "from-env")
+	defer os.Unsetenv("CONFIG_TEST_TOKEN")
+	file := filepath.Join(t.TempDir(), "config.yml")
+	err := ioutil.WriteFile(file, []byte("host: a\ntoken: secret\ndb:\n  internal: 7\n  port: 5432\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := C{}
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(file))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.InitDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Token != "" || conf.DB.Internal != 0 {
+		t.Errorf("ignored fields should not be set, got %+v", conf)
+	}
+	if conf.Host != "a" || conf.DB.Port != 5432 {
+		t.Errorf("wrong values %+v", conf)
+	}
+	if keys := cfg.AllKeys(); !reflect.DeepEqual(keys, []string{"host", "db.port"}) {
+		t.Errorf("wrong keys %v", keys)
+	}
+	for _, key := range []string{"token", "-", "Token", "db.internal"} {
+		if _, err := cfg.GetErr(key); !errors.Is(err, ErrFieldNotFound) {
+			t.Errorf("%q: expected ErrFieldNotFound, got %v", key, err)
+		}
+	}
+
+	conf.Token = "runtime"
+	conf.DB.Internal = 1
+	var out, help bytes.Buffer
+	cmd := cfg.NewConfigCommand()
+	cmd.SetOut(&out)
+	cmd.SetErr(ioutil.Discard)
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	cmd.SetArgs([]string{"list"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	if err := cfg.BindToPFlagSet(set); err != nil {
+		t.Fatal(err)
+	}
+	set.SetOutput(&help)
+	set.PrintDefaults()
+	schema, err := cfg.JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := strings.Join(cfg.ExportEnv("APP"), "\n")
+	for name, s := range map[string]string{
+		"dump":   out.String(),
+		"help":   help.String(),
+		"schema": string(schema),
+		"env":    env,
+	} {
+		if strings.Contains(strings.ToLower(s), "token") || strings.Contains(s, "internal") {
+			t.Errorf("ignored field leaked into %s: %s", name, s)
+		}
+	}
+
+	save := filepath.Join(t.TempDir(), "saved.yml")
+	if err := cfg.WriteFile(save); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "token") || strings.Contains(string(raw), "internal") {
+		t.Errorf("ignored field should not be written: %s", raw)
+	}
+}
+
+func TestDefaultFor(t *testing.T) {
+	type C struct {
+		Host string `config:"host" default:"localhost"`
+		Port int    `config:"port" default:"8080" env:"CONFIG_TEST_DEFAULT_PORT"`
+		DB   struct {
+			Timeout time.Duration `config:"timeout" default:"5s"`
+		} `config:"db"`
+		Name string `config:"name"`
+	}
+	os.Setenv("CONFIG_TEST_DEFAULT_PORT", "9000")
+	defer os.Unsetenv("CONFIG_TEST_DEFAULT_PORT")
+	conf := C{Host: "example.com"}
+	cfg := New(&conf, WithType("yaml"))
+	cfg.AddDecodeHook(StringToDurationHook)
+	if v, ok := cfg.DefaultFor("host"); !ok || v != "localhost" {
+		t.Errorf("wrong default %v, %v", v, ok)
+	}
+	if v, ok := cfg.DefaultFor("port"); !ok || v != 8080 {
+		t.Errorf("environment variables should not be used, got %v, %v", v, ok)
+	}
+	if v, ok := cfg.DefaultFor("db.timeout"); !ok || v != 5*time.Second {
+		t.Errorf("wrong default %v, %v", v, ok)
+	}
+	if _, ok := cfg.DefaultFor("name"); ok {
+		t.Error("field without a default")
+	}
+	if _, ok := cfg.DefaultFor("missing"); ok {
+		t.Error("missing key should not have a default")
+	}
+	if conf.Host != "example.com" || conf.Port != 0 || conf.DB.Timeout != 0 {
+		t.Errorf("config struct should not be changed: %+v", conf)
+	}
+}
+
+func TestExplicitZero(t *testing.T) {
+	type C struct {
+		Port    int    `yaml:"port" default:"8080"`
+		Host    string `yaml:"host" default:"localhost"`
+		Retries int    `yaml:"retries" default:"3"`
+	}
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(file, []byte("port: 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(file))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if p := cfg.GetInt("port"); p != 0 {
+		t.Errorf("explicit zero should not be replaced by the default, got %d", p)
+	}
+	if !cfg.IsEmpty("port") {
+		t.Error("explicit zero should be empty")
+	}
+	if h := cfg.GetString("host"); h != "localhost" {
+		t.Errorf("unset values should use their default, got %q", h)
+	}
+	if src, err := cfg.Origin("port"); err != nil || src.Kind != SourceFile {
+		t.Errorf("wrong source %v, %v", src, err)
+	}
+	if flat := cfg.Flatten(); flat["port"] != 0 || flat["retries"] != 3 {
+		t.Errorf("wrong values %v", flat)
+	}
+	if err := cfg.Set("retries", 0); err != nil {
+		t.Fatal(err)
+	}
+	if r := cfg.GetInt("retries"); r != 0 {
+		t.Errorf("value set to zero should be kept, got %d", r)
+	}
+	if err := cfg.Unset("port"); err != nil {
+		t.Fatal(err)
+	}
+	if p := cfg.GetInt("port"); p != 8080 {
+		t.Errorf("unset value should use the default, got %d", p)
+	}
+}
+
+func TestSaveKeepsDefaults(t *testing.T) {
+	type C struct {
+		Name string `yaml:"name" json:"name"`
+		Port int    `yaml:"port" json:"port" default:"8080"`
+		DB   struct {
+			Host string `yaml:"host" json:"host" default:"localhost"`
+		} `yaml:"db" json:"db"`
+	}
+	for _, typ := range []string{"yaml", "json"} {
+		file := filepath.Join(t.TempDir(), "config."+typ)
+		if err := ioutil.WriteFile(file, []byte(`{"name": "x"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var conf C
+		cfg := New(&conf, WithType(typ), WithFilepaths(file))
+		if err := cfg.ReadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		cmd := cfg.NewConfigCommand()
+		cmd.SetArgs([]string{"set", "name", "y"})
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(raw, []byte("port")) || bytes.Contains(raw, []byte("host")) {
+			t.Errorf("%s: unset values should not be saved, got %q", typ, raw)
+		}
+
+		// restart
+		var next C
+		cfg = New(&next, WithType(typ), WithFilepaths(file))
+		if err = cfg.ReadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		if next.Name != "y" {
+			t.Errorf("%s: saved value not read back, got %q", typ, next.Name)
+		}
+		if p := cfg.GetInt("port"); p != 8080 {
+			t.Errorf("%s: default should apply after a restart, got %d", typ, p)
+		}
+		if h := cfg.GetString("db.host"); h != "localhost" {
+			t.Errorf("%s: nested default should apply after a restart, got %q", typ, h)
+		}
+	}
+}
+
+func TestSaveRoundTrip(t *testing.T) {
+	type C struct {
+		Port    int    `json:"port" toml:"port"`
+		Name    string `json:"name" toml:"name"`
+		Extra   string `json:"extra" toml:"extra"`
+		Timeout int    `json:"timeout" toml:"timeout" default:"30"`
+	}
+	for typ, raw := range map[string]string{
+		"toml": "port = 8080\nname = \"x\"\n",
+		"json": `{"port": 8080, "name": "x"}`,
+	} {
+		file := filepath.Join(t.TempDir(), "config."+typ)
+		if err := ioutil.WriteFile(file, []byte(raw), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var conf C
+		cfg := New(&conf, WithType(typ), WithFilepaths(file))
+		if err := cfg.ReadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		conf.Extra = "direct" // no source is recorded
+		if err := cfg.Save(); err != nil {
+			t.Fatal(err)
+		}
+		saved, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(saved, []byte("timeout")) {
+			t.Errorf("%s: unset values should not be saved, got %q", typ, saved)
+		}
+		var next C
+		cfg = New(&next, WithType(typ), WithFilepaths(file))
+		if err = cfg.ReadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		if next != (C{Port: 8080, Name: "x", Extra: "direct"}) {
+			t.Errorf("%s: values were not saved, got %+v from %q", typ, next, saved)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	type C struct {
+		Host string            `yaml:"host" default:"localhost"`
+		Tags map[string]string `yaml:"tags"`
+		DB   *struct {
+			Port int `yaml:"port"`
+		} `yaml:"db"`
+	}
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.yml")
+	tenant := filepath.Join(dir, "tenant.yml")
+	if err := ioutil.WriteFile(base, []byte("host: base\ntags: {a: b}\ndb: {port: 1}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tenant, []byte("host: tenant\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(base))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	cp := cfg.Clone()
+	if cp.GetString("host") != "base" || cp.GetInt("db.port") != 1 {
+		t.Errorf("clone should have the same values, got %v", cp.Flatten())
+	}
+	if err := cp.Set("db.port", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.Set("tags.a", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if src, err := cp.Origin("db.port"); err != nil || src.Kind != SourceSet {
+		t.Errorf("wrong source %v, %v", src, err)
+	}
+	cp.AddFilepath(tenant)
+	cp.SetFilePrecedence(Ascending)
+	if err := cp.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if cp.GetString("host") != "tenant" {
+		t.Errorf("clone should read its own files, got %q", cp.GetString("host"))
+	}
+	if conf.Host != "base" || conf.DB.Port != 1 || conf.Tags["a"] != "b" {
+		t.Errorf("original config was changed: %+v", conf)
+	}
+	if files := cfg.FilesUsed(); len(files) != 1 || files[0] != base {
+		t.Errorf("original files were changed: %v", files)
+	}
+	if src, err := cfg.Origin("db.port"); err != nil || src.Kind != SourceFile {
+		t.Errorf("original source was changed: %v, %v", src, err)
+	}
+}
+
+type panicValue string
+
+func (p *panicValue) UnmarshalJSON(b []byte) error {
+	if string(b) == `"boom"` {
+		panic("bad value")
+	}
+	return json.Unmarshal(b, (*string)(p))
+}
+
+func TestWatchPanic(t *testing.T) {
+	type C struct {
+		A string     `json:"a"`
+		P panicValue `json:"p"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(file, []byte(`{"a":"one","p":"x"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		conf   C
+		log    testLogger
+		traced = make(chan error, 1)
+	)
+	cfg := New(&conf, WithType("json"))
+	cfg.AddPath(dir)
+	cfg.AddFile("config.json")
+	cfg.SetLogger(&log)
+	cfg.SetTracer(TracerFunc(func(op TraceOp, name string) func(time.Duration, error) {
+		if op != TraceWatchPanic {
+			return nil
+		}
+		return func(_ time.Duration, err error) { traced <- err }
+	}))
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(`{"a":"two","p":"boom"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-traced:
+		if !errors.Is(err, ErrWatchPanic) {
+			t.Errorf("expected ErrWatchPanic, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic was not traced")
+	}
+	if a := cfg.GetString("a"); a != "one" {
+		t.Errorf("old values should be kept after a panic, got %q", a)
+	}
+	if !strings.Contains(log.String(), "bad value") {
+		t.Errorf("panic should be logged, got %q", log.String())
+	}
+	if err := ioutil.WriteFile(file, []byte(`{"a":"three","p":"y"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for cfg.GetString("a") != "three" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if cfg.GetString("a") != "three" {
+		t.Error("files should still be watched after a panic")
+	}
+}
+
+func TestBindConfigFileFlag(t *testing.T) {
+	type C struct {
+		A string `yaml:"a"`
+		B string `yaml:"b"`
+	}
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.yml")
+	over := filepath.Join(dir, "override.yml")
+	if err := ioutil.WriteFile(base, []byte("a: base\nb: base\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(over, []byte("a: flag\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		mode []FileFlagMode
+		args []string
+		a, b string
+	}{
+		{args: nil, a: "base", b: "base"},
+		{args: []string{"--config", over}, a: "flag", b: "base"},
+		{args: []string{"-c", over}, mode: []FileFlagMode{FileFlagOnly}, a: "flag", b: ""},
+	} {
+		var conf C
+		cfg := New(&conf, WithType("yaml"))
+		cfg.AddFilepath(base)
+		set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		cfg.BindConfigFileFlag(set, "config", "c", tt.mode...)
+		if err := set.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := cfg.ReadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		if conf.A != tt.a || conf.B != tt.b {
+			t.Errorf("%v: got a=%q b=%q, want a=%q b=%q", tt.args, conf.A, conf.B, tt.a, tt.b)
+		}
+	}
+
+	var conf C
+	cfg := New(&conf, WithType("yaml"))
+	cfg.AddFilepath(base)
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	cfg.BindConfigFileFlag(set, "config", "c")
+	if err := set.Parse([]string{"--config", filepath.Join(dir, "missing.yml")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ReadConfig(); !errors.Is(err, ErrRequiredFile) {
+		t.Errorf("expected ErrRequiredFile for a missing flag file, got %v", err)
+	}
+}
+
+func TestBindOverrideFlag(t *testing.T) {
+	type C struct {
+		Host string `yaml:"host"`
+		DB   struct {
+			Port int  `yaml:"port"`
+			TLS  bool `yaml:"tls"`
+		} `yaml:"db"`
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(file, []byte("host: file\ndb:\n  port: 5432\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var conf C
+	cfg := New(&conf, WithType("yaml"))
+	cfg.AddFilepath(file)
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	cfg.BindOverrideFlag(set, "set")
+	err := set.Parse([]string{"--set", "db.port=5433", "--set", "db.tls=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "file" || conf.DB.Port != 5433 || !conf.DB.TLS {
+		t.Errorf("overrides should be set after reading files, got %+v", conf)
+	}
+	src, err := cfg.Origin("db.port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Kind != SourceFlag || src.Name != "set" {
+		t.Errorf("wrong source for an override: %v", src)
+	}
+	if err = cfg.Reload(file); err != nil {
+		t.Fatal(err)
+	}
+	if conf.DB.Port != 5433 {
+		t.Errorf("overrides should be set after a reload, got %d", conf.DB.Port)
+	}
+
+	for _, arg := range []string{"db.port", "=1", "db.port=abc", "nope=1"} {
+		set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		set.SetOutput(ioutil.Discard)
+		cfg.BindOverrideFlag(set, "set")
+		if err := set.Parse([]string{"--set", arg}); err == nil {
+			t.Errorf("expected an error for --set %s", arg)
+		}
+	}
+}
+
+func TestNetGetters(t *testing.T) {
+	type C struct {
+		Bind    string   `config:"bind"`
+		IP      net.IP   `config:"ip"`
+		API     string   `config:"api"`
+		Proxy   *url.URL `config:"proxy"`
+		DB      string   `config:"db"`
+		BadPort string   `config:"bad-port"`
+		Port    int      `config:"port"`
+	}
+	proxy, _ := url.Parse("http://proxy:3128")
+	cfg := New(&C{
+		Bind:    "::1",
+		IP:      net.IPv4(10, 0, 0, 1),
+		API:     "https://example.com/v1",
+		Proxy:   proxy,
+		DB:      "[::1]:5432",
+		BadPort: "localhost:99999",
+		Port:    80,
+	})
+
+	if ip := cfg.GetIP("bind"); !ip.Equal(net.IPv6loopback) {
+		t.Errorf("wrong ip: %v", ip)
+	}
+	if ip := cfg.GetIP("ip"); !ip.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Errorf("wrong ip: %v", ip)
+	}
+	if _, err := cfg.GetIPErr("api"); err == nil || !strings.Contains(err.Error(), "invalid IP address") {
+		t.Errorf("expected an invalid IP address error, got %v", err)
+	}
+	if _, err := cfg.GetIPErr("port"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("expected ErrWrongType, got %v", err)
+	}
+
+	if u := cfg.GetURL("api"); u == nil || u.Host != "example.com" || u.Path != "/v1" {
+		t.Errorf("wrong url: %v", u)
+	}
+	if u := cfg.GetURL("proxy"); u == nil || u.String() != "http://proxy:3128" || u == proxy {
+		t.Errorf("url fields should be copied, got %v", u)
+	}
+	if _, err := cfg.GetURLErr("db"); err == nil {
+		t.Error("expected an error for a url without a scheme")
+	}
+
+	host, port := cfg.GetHostPort("db")
+	if host != "::1" || port != 5432 {
+		t.Errorf("wrong host and port: %q %d", host, port)
+	}
+	if _, _, err := cfg.GetHostPortErr("bad-port"); err == nil || !strings.Contains(err.Error(), "invalid port") {
+		t.Errorf("expected an invalid port error, got %v", err)
+	}
+	if _, _, err := cfg.GetHostPortErr("bind"); err == nil {
+		t.Error("expected an error for an address without a port")
+	}
+}
+
+type recursiveNode struct {
+	Name  string          `yaml:"name"`
+	Next  *recursiveNode  `yaml:"next"`
+	Other *recursiveOther `yaml:"other"`
+}
+
+type recursiveOther struct {
+	Value string         `yaml:"value" config:"value,envonly" env:"CONFIG_TEST_RECURSIVE"`
+	Back  *recursiveNode `yaml:"back"`
+	Token string         `yaml:"token" secret:"true"`
+}
+
+func TestRecursiveTypeChecks(t *testing.T) {
+	for _, typ := range []reflect.Type{
+		reflect.TypeOf(recursiveNode{}),
+		reflect.TypeOf(recursiveOther{}),
+	} {
+		if !hasEnvOnly(typ) {
+			t.Errorf("%v: envonly field not found", typ)
+		}
+		if hasIgnored(typ) {
+			t.Errorf("%v: should not have ignored fields", typ)
+		}
+		if hasEnums(typ) {
+			t.Errorf("%v: should not have enum fields", typ)
+		}
+		if !hasSecrets(typ) {
+			t.Errorf("%v: secret field not found", typ)
+		}
+		if paths := taggedUnexported(typ, "", nil); len(paths) != 0 {
+			t.Errorf("%v: should not have tagged unexported fields: %v", typ, paths)
+		}
+	}
+}
+
+func TestReadRecursiveType(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yml")
+	raw := "name: a\nnext:\n  name: b\nother:\n  value: x\n  back:\n    name: c\n"
+	if err := ioutil.WriteFile(file, []byte(raw), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var conf recursiveNode
+	cfg := New(&conf, WithType("yaml"), WithFilepaths(file))
+	cfg.SetStrictTags(true)
+	cfg.RequireSecurePermissions(true)
+	if err := cfg.ReadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Name != "a" || conf.Next == nil || conf.Next.Name != "b" {
+		t.Errorf("recursive struct not read: %+v", conf)
+	}
+	if conf.Other == nil || conf.Other.Back == nil || conf.Other.Back.Name != "c" {
+		t.Errorf("indirectly recursive struct not read: %+v", conf.Other)
+	}
+	if conf.Other.Value != "" {
+		t.Errorf("envonly field should not be read from a file, got %q", conf.Other.Value)
+	}
+}
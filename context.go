@@ -0,0 +1,99 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// ReadConfigContext is the same as ReadConfig but it will stop reading
+// config files when the context is canceled or its deadline is reached.
+// This is useful when reading a file is slow, for example when it is on
+// a network file system or has to be decrypted by another program.
+func ReadConfigContext(ctx context.Context) error { return c.ReadConfigContext(ctx) }
+
+// ReadConfigContext is the same as ReadConfig but it will stop reading
+// config files when the context is canceled or its deadline is reached.
+// This is useful when reading a file is slow, for example when it is on
+// a network file system or has to be decrypted by another program.
+func (c *Config) ReadConfigContext(ctx context.Context) error {
+	return c.readConfigFilesContext(ctx, 0)
+}
+
+// SetReadTimeout will set how long each config file can take to be read
+// and decrypted. There is no timeout by default.
+func SetReadTimeout(d time.Duration) { c.SetReadTimeout(d) }
+
+// SetReadTimeout will set how long each config file can take to be read
+// and decrypted. There is no timeout by default.
+func (c *Config) SetReadTimeout(d time.Duration) {
+	c.mu.Lock()
+	c.readTimeout = d
+	c.mu.Unlock()
+}
+
+// readFileContext is the same as readFile but it will return early if
+// the context is done or the read timeout is reached.
+func (c *Config) readFileContext(ctx context.Context, filename string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.readTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.readTimeout)
+		defer cancel()
+	}
+	if ctx.Done() == nil {
+		return c.readCachedFile(filename)
+	}
+	type result struct {
+		raw []byte
+		err error
+	}
+	// The file is read by a copy of the config because the goroutine
+	// can keep running after the context is done and c.mu is unlocked.
+	rc := c.readCopy(ctx, filename)
+	ch := make(chan result, 1)
+	go func() {
+		raw, err := rc.readCachedFile(filename)
+		ch <- result{raw, err}
+	}()
+	select {
+	case res := <-ch:
+		c.cachemu.Lock()
+		if cf := rc.files[filename]; cf != nil {
+			if c.files == nil {
+				c.files = make(map[string]*cachedFile)
+			}
+			c.files[filename] = cf
+		}
+		if rev, ok := rc.revisions[filename]; ok {
+			if c.revisions == nil {
+				c.revisions = make(map[string]string)
+			}
+			c.revisions[filename] = rev
+		}
+		c.cachemu.Unlock()
+		return res.raw, res.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s: %w", filename, ctx.Err())
+	}
+}
+
+// readCopy returns a copy of the settings used to read a file. The copy
+// has an empty config struct of the same type and only the cached file
+// being read. Commands run by the copy are killed when ctx is done.
+func (c *Config) readCopy(ctx context.Context, filename string) *Config {
+	rc := c.copySettings()
+	rc.readCtx = ctx
+	if c.elem.IsValid() {
+		rc.elem = reflect.New(c.elem.Type()).Elem()
+		rc.config = rc.elem.Addr().Interface()
+		rc.buildIndex()
+	}
+	if cf := c.cacheEntry(filename); cf != nil {
+		rc.files = map[string]*cachedFile{filename: cf}
+	}
+	return rc
+}
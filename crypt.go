@@ -0,0 +1,243 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Cipher is used to read and write encrypted config files.
+type Cipher interface {
+	Decrypt([]byte) ([]byte, error)
+	Encrypt([]byte) ([]byte, error)
+}
+
+// SetCipher will use a cipher to decrypt config files with the given
+// extension before they are read and to encrypt them when they are saved.
+//
+//	config.AddFile("config.yml.age")
+//	config.SetCipher(".age", config.Age("~/.config/age/key.txt"))
+func SetCipher(ext string, cipher Cipher) { c.SetCipher(ext, cipher) }
+
+// SetCipher will use a cipher to decrypt config files with the given
+// extension before they are read and to encrypt them when they are saved.
+func (c *Config) SetCipher(ext string, cipher Cipher) {
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	c.mu.Lock()
+	if c.ciphers == nil {
+		c.ciphers = make(map[string]Cipher)
+	}
+	c.ciphers[ext] = cipher
+	c.mu.Unlock()
+	c.clearFileCache()
+}
+
+// GPG returns a Cipher that runs the gpg command. Files are encrypted
+// for each recipient or for the default key if there are no recipients.
+func GPG(recipients ...string) Cipher {
+	enc := []string{"gpg", "--batch", "--quiet", "--encrypt"}
+	if len(recipients) == 0 {
+		enc = append(enc, "--default-recipient-self")
+	}
+	for _, r := range recipients {
+		enc = append(enc, "--recipient", r)
+	}
+	return &cmdCipher{
+		decrypt: []string{"gpg", "--batch", "--quiet", "--decrypt"},
+		encrypt: enc,
+	}
+}
+
+// Age returns a Cipher that runs the age command. Files are decrypted
+// with the identity file and encrypted for each recipient or for the
+// identity if there are no recipients.
+func Age(identity string, recipients ...string) Cipher {
+	if p, err := expandHome(identity); err == nil {
+		identity = p
+	}
+	enc := []string{"age", "--encrypt"}
+	if len(recipients) == 0 {
+		enc = append(enc, "--identity", identity)
+	}
+	for _, r := range recipients {
+		enc = append(enc, "--recipient", r)
+	}
+	return &cmdCipher{
+		decrypt: []string{"age", "--decrypt", "--identity", identity},
+		encrypt: enc,
+	}
+}
+
+type cmdCipher struct {
+	decrypt, encrypt []string
+}
+
+func (cc *cmdCipher) Decrypt(b []byte) ([]byte, error) {
+	return cc.decryptContext(context.Background(), b)
+}
+
+func (cc *cmdCipher) Encrypt(b []byte) ([]byte, error) {
+	return runFilter(context.Background(), cc.encrypt, b)
+}
+
+func (cc *cmdCipher) decryptContext(ctx context.Context, b []byte) ([]byte, error) {
+	return runFilter(ctx, cc.decrypt, b)
+}
+
+// decrypt will decrypt the contents of a file with a cipher. Commands
+// are stopped when the context of the read is done.
+func (c *Config) decrypt(ci Cipher, raw []byte) ([]byte, error) {
+	if cc, ok := ci.(*cmdCipher); ok {
+		return cc.decryptContext(c.context(), raw)
+	}
+	return ci.Decrypt(raw)
+}
+
+// context returns the context of the read that the config was
+// copied for or the background context.
+func (c *Config) context() context.Context {
+	if c.readCtx == nil {
+		return context.Background()
+	}
+	return c.readCtx
+}
+
+// runFilter runs a command with some input and returns the output. The
+// command is killed when the context is done.
+func runFilter(ctx context.Context, args []string, in []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", args[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// cipher returns the cipher used for a file or nil
+// if the file is not encrypted.
+func (c *Config) cipher(filename string) Cipher {
+	return c.ciphers[filepath.Ext(filename)]
+}
+
+// readFile will read a config file and decrypt it if needed after
+// checking its permissions and signature. Older files are migrated to
+// the newest version, aliased keys are moved to their new keys,
+// environment variables are expanded, and the decode hooks are run.
+func (c *Config) readFile(filename string) ([]byte, error) {
+	if err := c.checkPermissions(filename); err != nil {
+		return nil, err
+	}
+	raw, err := c.readRaw(filename)
+	if err != nil {
+		return nil, err
+	}
+	return c.processFile(filename, raw)
+}
+
+// processFile will verify, decrypt and transform the contents
+// of a config file before it is decoded.
+func (c *Config) processFile(filename string, raw []byte) ([]byte, error) {
+	if err := c.verify(filename, raw); err != nil {
+		return nil, err
+	}
+	raw, err := c.decryptFile(filename, raw)
+	if err != nil {
+		return nil, err
+	}
+	return c.transform(filename, raw)
+}
+
+// decryptFile will decrypt a config file if it is encrypted.
+func (c *Config) decryptFile(filename string, raw []byte) (_ []byte, err error) {
+	if ci := c.cipher(filename); ci != nil {
+		if raw, err = c.decrypt(ci, raw); err != nil {
+			return nil, fmt.Errorf("could not decrypt %s: %w", filename, err)
+		}
+	}
+	return c.decryptSOPS(filename, raw)
+}
+
+// transform will change the decrypted contents of a config file, or of a
+// document given to Apply, into the form that is decoded into the config
+// struct and check its values.
+func (c *Config) transform(filename string, raw []byte) (_ []byte, err error) {
+	if raw, err = c.evaluate(filename, raw); err != nil {
+		return nil, err
+	}
+	if raw, err = c.render(filename, raw); err != nil {
+		return nil, err
+	}
+	if c.jsonc {
+		raw = stripJSONC(raw)
+	}
+	if raw, err = c.migrate(filename, raw); err != nil {
+		return nil, err
+	}
+	if raw, err = c.expandFile(filename, c.rewriteAliases(filename, raw)); err != nil {
+		return nil, err
+	}
+	if raw, err = c.dropRestricted(filename, raw); err != nil {
+		return nil, err
+	}
+	if raw, err = c.decodeFile(filename, raw); err != nil {
+		return nil, err
+	}
+	if err = c.checkFileEnums(filename, raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// writeFile will write a config file and encrypt it if needed. Files
+// encrypted with sops are never overwritten.
+func (c *Config) writeFile(filename string, raw []byte) (err error) {
+	if c.IsReadOnly() {
+		return ErrReadOnly
+	}
+	if c.isSOPSFile(filename) {
+		return fmt.Errorf("%w %s", ErrSOPSFile, filename)
+	}
+	if c.evaluator(filename) != nil {
+		return fmt.Errorf("%w %s", ErrEvaluatedFile, filename)
+	}
+	if c.isTemplate(filename) {
+		return fmt.Errorf("%w %s", ErrTemplateFile, filename)
+	}
+	if ci := c.cipher(filename); ci != nil {
+		if raw, err = ci.Encrypt(raw); err != nil {
+			return fmt.Errorf("could not encrypt %s: %w", filename, err)
+		}
+	}
+	if ok, err := c.writeRevision(filename, raw); ok {
+		return err
+	}
+	unlock, err := c.lock(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err = c.backup(filename); err != nil {
+		return fmt.Errorf("could not back up %s: %w", filename, err)
+	}
+	return writeFile(filename, raw)
+}
+
+// plainName returns a filename without the
+// extension of its cipher.
+func (c *Config) plainName(filename string) string {
+	if c.cipher(filename) == nil {
+		return filename
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
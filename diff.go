@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// keyChange is a config value that was changed by editing a file.
+type keyChange struct {
+	Key      string
+	Old, New interface{}
+}
+
+// diffConfig will compare two copies of the config struct and
+// return every key that has a different value. Secret values are
+// redacted unless reveal is true.
+func (c *Config) diffConfig(before, after reflect.Value, reveal bool) []keyChange {
+	before, after = indirect(before, false), indirect(after, false)
+	values := make(map[string]interface{})
+	c.walk(before, "", func(key string, _ reflect.StructField, val reflect.Value) error {
+		values[key] = val.Interface()
+		return nil
+	})
+	var changes []keyChange
+	c.walk(after, "", func(key string, fld reflect.StructField, val reflect.Value) error {
+		prev, v := values[key], val.Interface()
+		if reflect.DeepEqual(prev, v) {
+			return nil
+		}
+		if !reveal {
+			prev, v = c.redactValue(fld, reflect.ValueOf(prev)), c.redactValue(fld, val)
+		}
+		changes = append(changes, keyChange{Key: key, Old: prev, New: v})
+		return nil
+	})
+	return changes
+}
+
+func printChanges(w io.Writer, changes []keyChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "no changes")
+		return
+	}
+	fmt.Fprintln(w, "changed:")
+	for _, ch := range changes {
+		fmt.Fprintf(w, "  %s: %s -> %s\n", ch.Key, formatValue(ch.Old), formatValue(ch.New))
+	}
+}
+
+func formatValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -4,32 +4,39 @@ package config
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"syscall"
+	"strings"
 )
 
-func runEditor(file string) (*exec.Cmd, error) {
-	editor, err := findEditor()
+// defaultEditors are tried in order when no editor has been set.
+var defaultEditors = []string{"sensible-editor", "vim", "vi", "nano"}
+
+func (c *Config) runEditor(file string, stderr io.Writer) (*exec.Cmd, error) {
+	args, err := c.findEditor()
 	if err != nil {
 		return nil, err
 	}
-	var cmd *exec.Cmd
-
-	stat, err := os.Stat(file)
+	writable, err := canWrite(file)
 	if err != nil {
 		return nil, err
 	}
-	fstat, ok := stat.Sys().(*syscall.Stat_t)
-
-	// if we are on linux and not part of the file's user
-	// or user group, then edit as root
-	if ok && (fstat.Uid != uint32(os.Getuid()) && fstat.Gid != uint32(os.Getgid())) {
-		fmt.Printf("running \"sudo %s %s\"\n", editor, file)
-		cmd = exec.Command("sudo", editor, file)
-	} else {
-		cmd = exec.Command(editor, file)
+	if writable {
+		return exec.Command(args[0], append(args[1:], file)...), nil
+	}
+	if !c.elevatedEdit {
+		return nil, fmt.Errorf("cannot edit %s (see AllowElevatedEdit): %w", file, os.ErrPermission)
 	}
 
-	return cmd, nil
+	// sudoedit copies the file so the editor does not run as root
+	if sudoedit, err := exec.LookPath("sudoedit"); err == nil {
+		cmd := exec.Command(sudoedit, file)
+		if os.Getenv("SUDO_EDITOR") == "" {
+			cmd.Env = append(os.Environ(), "SUDO_EDITOR="+strings.Join(args, " "))
+		}
+		return cmd, nil
+	}
+	fmt.Fprintf(stderr, "running \"sudo %s %s\"\n", strings.Join(args, " "), file)
+	return exec.Command("sudo", append(args, file)...), nil
 }
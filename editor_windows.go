@@ -1,11 +1,54 @@
 package config
 
-import "os/exec"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
 
-func runEditor(file string) (*exec.Cmd, error) {
-	editor, err := findEditor()
+// defaultEditors are tried in order when no editor has been set.
+var defaultEditors = []string{"notepad"}
+
+func (c *Config) runEditor(file string, stderr io.Writer) (*exec.Cmd, error) {
+	args, err := c.findEditor()
+	if errors.Is(err, errNoEditor) {
+		// open the file with whatever program is associated
+		// with its extension and wait for it to be closed
+		args = []string{"cmd", "/c", "start", "/wait", ""}
+	} else if err != nil {
+		return nil, err
+	}
+	writable, err := canWrite(file)
 	if err != nil {
 		return nil, err
 	}
-	return exec.Command(editor, file), nil
+	if writable {
+		return exec.Command(args[0], append(args[1:], file)...), nil
+	}
+	if !c.elevatedEdit {
+		return nil, fmt.Errorf("cannot edit %s (see AllowElevatedEdit): %w", file, os.ErrPermission)
+	}
+
+	// Start-Process will show the UAC prompt and wait for the editor
+	if args[0] == "cmd" {
+		args = []string{"notepad"}
+	}
+	list := make([]string, 0, len(args))
+	for _, arg := range append(args[1:], file) {
+		list = append(list, psQuote(`"`+arg+`"`))
+	}
+	script := fmt.Sprintf(
+		"Start-Process -FilePath %s -ArgumentList %s -Verb RunAs -Wait",
+		psQuote(args[0]), strings.Join(list, ","),
+	)
+	fmt.Fprintf(stderr, "running %q as administrator\n", strings.Join(append(args, file), " "))
+	return exec.Command("powershell", "-NoProfile", "-Command", script), nil
+}
+
+// psQuote will quote a string for powershell.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
 }
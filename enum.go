@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// EnumError is returned when a field with an "enum" tag is given a
+// value that is not one of the allowed values. The tag is checked for
+// values from config files, environment variables, defaults, flags, and
+// functions like SetFromString.
+//
+//	type Config struct {
+//		Format string `config:"format" enum:"json,yaml,toml"`
+//	}
+type EnumError struct {
+	// Key of the field, or the field name when
+	// the key is not known.
+	Key     string
+	Value   string
+	Allowed []string
+}
+
+func (e *EnumError) Error() string {
+	return fmt.Sprintf("invalid value %q for %s: must be one of %s",
+		e.Value, e.Key, strings.Join(e.Allowed, ", "))
+}
+
+// enumValues returns the allowed values from the "enum" tag.
+func enumValues(fld reflect.StructField) []string {
+	tag := fld.Tag.Get("enum")
+	if tag == "" {
+		return nil
+	}
+	values := strings.Split(tag, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+// checkEnum returns an EnumError if a struct field has an "enum"
+// tag that does not include the value.
+func checkEnum(key string, fld reflect.StructField, value string) error {
+	allowed := enumValues(fld)
+	if allowed == nil || contains(allowed, value) {
+		return nil
+	}
+	if key == "" {
+		key = fld.Name
+	}
+	return &EnumError{Key: key, Value: value, Allowed: allowed}
+}
+
+// enumUsage adds the allowed values of a field to its usage.
+func enumUsage(fld reflect.StructField, usage string) string {
+	allowed := enumValues(fld)
+	if allowed == nil {
+		return usage
+	}
+	list := "one of " + strings.Join(allowed, ", ")
+	if usage == "" {
+		return list
+	}
+	return usage + " (" + list + ")"
+}
+
+// hasEnums returns true if a struct type has any fields with an "enum" tag.
+func hasEnums(typ reflect.Type) bool {
+	return hasField(typ, func(fld reflect.StructField) bool { return fld.Tag.Get("enum") != "" })
+}
+
+// checkFileEnums returns an EnumError for the first value in a
+// config file that is not allowed by the "enum" tag of its field.
+func (c *Config) checkFileEnums(filename string, raw []byte) error {
+	if c.unmarshal == nil || !hasEnums(c.elem.Type()) {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := c.unmarshal(raw, &m); err != nil {
+		return nil // the error is reported when the file is decoded
+	}
+	m = normalizeMap(m).(map[string]interface{})
+	if err := c.checkEnums(m, c.elem.Type(), ""); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+func (c *Config) checkEnums(m map[string]interface{}, typ reflect.Type, prefix string) error {
+	for k, v := range m {
+		fld, ok := c.fieldByLabel(typ, k)
+		if !ok {
+			continue
+		}
+		key := joinFieldPath(prefix, c.keyName(fld))
+		if sub, ok := v.(map[string]interface{}); ok && isNestedStruct(fld.Type) {
+			if err := c.checkEnums(sub, indirectType(fld.Type), key); err != nil {
+				return err
+			}
+			continue
+		}
+		if v == nil {
+			continue
+		}
+		if err := checkEnum(key, fld, fmt.Sprint(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
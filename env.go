@@ -0,0 +1,122 @@
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ExportEnv returns every config value as a "KEY=value" pair that can
+// be given to a child process or used as an env file. Fields with an
+// "env" tag use the first variable name in the tag, otherwise the key is upper cased
+// with "." and "-" replaced by "_" and joined to the prefix. Slices are
+// joined with commas and maps are encoded as json.
+//
+//	config.ExportEnv("APP") // APP_DB_HOST=localhost
+func ExportEnv(prefix string) []string { return c.ExportEnv(prefix) }
+
+// ExportEnv returns every config value as a "KEY=value" pair that can
+// be given to a child process or used as an env file. Fields with an
+// "env" tag use the first variable name in the tag, otherwise the key is upper cased
+// with "." and "-" replaced by "_" and joined to the prefix. Slices are
+// joined with commas and maps are encoded as json.
+func (c *Config) ExportEnv(prefix string) []string { return c.exportEnv(prefix, true) }
+
+func (c *Config) exportEnv(prefix string, reveal bool) []string {
+	if c.elem.Kind() == reflect.Invalid {
+		panic(errElemNotSet)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	env := make([]string, 0)
+	c.walk(c.elem, "", func(key string, fld reflect.StructField, val reflect.Value) error {
+		value := redacted
+		if reveal || !isSecret(fld) {
+			val = c.effectiveValue(key, fld, val)
+			if !reveal && holdsSecrets(val.Type()) {
+				val = reflect.ValueOf(c.redactValue(fld, val))
+			}
+			value = envValue(val)
+		}
+		env = append(env, envName(prefix, key, fld)+"="+value)
+		return nil
+	})
+	return env
+}
+
+var envReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// envNames returns the environment variable names in the "env" tag of
+// a struct field. Fields may list more than one variable so that old
+// names keep working after a variable is renamed.
+func envNames(fld reflect.StructField) []string {
+	tag := fld.Tag.Get("env")
+	if tag == "" {
+		return nil
+	}
+	names := strings.Split(tag, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	return names
+}
+
+// lookupEnv will check each environment variable named in the "env" tag
+// of a struct field in order and return the first one that is not empty.
+// Fileonly fields are never read from the environment.
+func lookupEnv(fld reflect.StructField) (name, val string, ok bool) {
+	if isFileOnly(fld) {
+		return "", "", false
+	}
+	for _, name = range envNames(fld) {
+		if val = os.Getenv(name); val != "" {
+			return name, val, true
+		}
+	}
+	return "", "", false
+}
+
+// envName returns the environment variable name for a key.
+func envName(prefix, key string, fld reflect.StructField) string {
+	if names := envNames(fld); len(names) > 0 {
+		return names[0]
+	}
+	name := strings.ToUpper(envReplacer.Replace(key))
+	if prefix == "" {
+		return name
+	}
+	return strings.TrimSuffix(prefix, "_") + "_" + name
+}
+
+// envValue formats a value for an environment variable.
+func envValue(v reflect.Value) string {
+	v = indirect(v, false)
+	if !v.IsValid() || !v.CanInterface() {
+		return ""
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		if b, err := m.MarshalText(); err == nil {
+			return string(b)
+		}
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		// byte slices are read from a string, arrays are read as a list
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = envValue(v.Index(i))
+		}
+		return strings.Join(parts, ",")
+	case reflect.Map, reflect.Struct:
+		if b, err := json.Marshal(v.Interface()); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
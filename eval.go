@@ -0,0 +1,139 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrEvaluatedFile is returned when trying to overwrite a config
+// file that is evaluated (see SetEvaluator).
+var ErrEvaluatedFile = errors.New("cannot overwrite evaluated config file")
+
+// Evaluator is used to turn a config file written in a configuration
+// language such as CUE or Jsonnet into json.
+type Evaluator interface {
+	Evaluate(filename string, raw []byte) ([]byte, error)
+}
+
+// EvaluatorFunc is a function that implements the Evaluator interface.
+type EvaluatorFunc func(filename string, raw []byte) ([]byte, error)
+
+// Evaluate will call the evaluator function.
+func (fn EvaluatorFunc) Evaluate(filename string, raw []byte) ([]byte, error) {
+	return fn(filename, raw)
+}
+
+// SetEvaluator will use an evaluator to turn config files with the given
+// extension into json before they are decoded into the config struct. The
+// config struct is still the final schema for the evaluated values.
+// Evaluated files are never overwritten by Save.
+//
+//	config.AddFile("config.cue")
+//	config.SetEvaluator(".cue", config.CUE())
+func SetEvaluator(ext string, ev Evaluator) { c.SetEvaluator(ext, ev) }
+
+// SetEvaluator will use an evaluator to turn config files with the given
+// extension into json before they are decoded into the config struct. The
+// config struct is still the final schema for the evaluated values.
+// Evaluated files are never overwritten by Save.
+func (c *Config) SetEvaluator(ext string, ev Evaluator) {
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	c.mu.Lock()
+	if c.evaluators == nil {
+		c.evaluators = make(map[string]Evaluator)
+	}
+	c.evaluators[ext] = ev
+	c.mu.Unlock()
+}
+
+// CUE returns an Evaluator that runs "cue export" on a config file. The
+// file is passed to the command by name so that it can use imports.
+func CUE() Evaluator {
+	return &cmdEvaluator{args: []string{"cue", "export", "--out", "json"}}
+}
+
+// Jsonnet returns an Evaluator that runs the jsonnet command on a config
+// file. The file is passed to the command by name so that it can use
+// imports.
+func Jsonnet(args ...string) Evaluator {
+	return &cmdEvaluator{args: append([]string{"jsonnet"}, args...)}
+}
+
+type cmdEvaluator struct {
+	args []string
+}
+
+func (ce *cmdEvaluator) Evaluate(filename string, raw []byte) ([]byte, error) {
+	return ce.evaluateContext(context.Background(), filename, raw)
+}
+
+func (ce *cmdEvaluator) evaluateContext(ctx context.Context, filename string, _ []byte) ([]byte, error) {
+	args := make([]string, len(ce.args), len(ce.args)+1)
+	copy(args, ce.args)
+	return runFilter(ctx, append(args, filename), nil)
+}
+
+// evaluator returns the evaluator used for a file or
+// nil if the file is not evaluated.
+func (c *Config) evaluator(filename string) Evaluator {
+	return c.evaluators[filepath.Ext(c.plainName(filename))]
+}
+
+// evaluate will run the evaluator for a config file and convert
+// the resulting json to the current config type.
+func (c *Config) evaluate(filename string, raw []byte) ([]byte, error) {
+	ev := c.evaluator(filename)
+	if ev == nil {
+		return raw, nil
+	}
+	var err error
+	if ce, ok := ev.(*cmdEvaluator); ok {
+		raw, err = ce.evaluateContext(c.context(), filename, raw)
+	} else {
+		raw, err = ev.Evaluate(filename, raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not evaluate %s: %w", filename, err)
+	}
+	// Yaml is a superset of json so only
+	// other types need to be converted.
+	if c.tag == "json" || c.tag == "yaml" || c.marshal == nil {
+		return raw, nil
+	}
+	var m map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err = dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return c.marshal(jsonNumbers(m))
+}
+
+// jsonNumbers will replace each json.Number with an int64
+// or a float64 so that integers are not marshaled as floats.
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, val := range v {
+			v[k] = jsonNumbers(val)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonNumbers(v[i])
+		}
+	}
+	return v
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// SetAutoExpandEnv will turn on or off the expansion of environment
+// variables like "$HOME" or "${HOME}" in string values. When it is on,
+// the string getters and the values read from config files are expanded.
+func SetAutoExpandEnv(on bool) { c.SetAutoExpandEnv(on) }
+
+// SetAutoExpandEnv will turn on or off the expansion of environment
+// variables like "$HOME" or "${HOME}" in string values. When it is on,
+// the string getters and the values read from config files are expanded.
+func (c *Config) SetAutoExpandEnv(on bool) {
+	c.mu.Lock()
+	c.autoExpand = on
+	c.mu.Unlock()
+}
+
+// SetExpandFunc will set the function used to look up variables when
+// expanding strings, see os.Expand. The default is os.Getenv.
+func SetExpandFunc(mapping func(string) string) { c.SetExpandFunc(mapping) }
+
+// SetExpandFunc will set the function used to look up variables when
+// expanding strings, see os.Expand. The default is os.Getenv.
+func (c *Config) SetExpandFunc(mapping func(string) string) {
+	c.mu.Lock()
+	c.expandFunc = mapping
+	c.mu.Unlock()
+}
+
+// expand will expand the variables in a string
+// using the expand function.
+func (c *Config) expand(s string) string {
+	if c.expandFunc == nil {
+		return os.ExpandEnv(s)
+	}
+	return os.Expand(s, c.expandFunc)
+}
+
+// autoExpandString will expand a string only if
+// auto expansion has been turned on.
+func (c *Config) autoExpandString(s string) string {
+	if !c.autoExpand {
+		return s
+	}
+	return c.expand(s)
+}
+
+// expandFile will expand every string value in a config
+// file when auto expansion has been turned on.
+func (c *Config) expandFile(filename string, raw []byte) ([]byte, error) {
+	if !c.autoExpand || c.unmarshal == nil || c.marshal == nil {
+		return raw, nil
+	}
+	var m map[string]interface{}
+	if err := c.unmarshal(raw, &m); err != nil {
+		return raw, nil
+	}
+	v, changed := c.expandValue(normalizeMap(m))
+	if !changed {
+		return raw, nil
+	}
+	raw, err := c.marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return raw, nil
+}
+
+func (c *Config) expandValue(v interface{}) (interface{}, bool) {
+	changed := false
+	switch val := v.(type) {
+	case string:
+		s := c.expand(val)
+		return s, s != val
+	case map[string]interface{}:
+		for k, elem := range val {
+			if e, ch := c.expandValue(elem); ch {
+				val[k] = e
+				changed = true
+			}
+		}
+	case []interface{}:
+		for i, elem := range val {
+			if e, ch := c.expandValue(elem); ch {
+				val[i] = e
+				changed = true
+			}
+		}
+	}
+	return v, changed
+}
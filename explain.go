@@ -0,0 +1,96 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"reflect"
+)
+
+// FileStatus is the result of looking for one possible config file.
+type FileStatus string
+
+const (
+	// FileLoaded is used for files that can be read and parsed.
+	FileLoaded FileStatus = "loaded"
+	// FileMissing is used for files that do not exist.
+	FileMissing FileStatus = "missing"
+	// FileSkipped is used for paths that are not regular files.
+	FileSkipped FileStatus = "skipped"
+	// FileUnreadable is used for files that could not be read,
+	// decrypted or verified.
+	FileUnreadable FileStatus = "unreadable"
+	// FileInvalid is used for files that could not be parsed.
+	FileInvalid FileStatus = "invalid"
+)
+
+// FileCandidate is a possible config file and the reason
+// that it would or would not be used, see ExplainFiles.
+type FileCandidate struct {
+	Path   string
+	Status FileStatus
+	// Err is the reason that the file is not used.
+	Err error
+}
+
+func (fc FileCandidate) String() string {
+	if fc.Err == nil {
+		return fc.Path + ": " + string(fc.Status)
+	}
+	return fc.Path + ": " + string(fc.Status) + ": " + fc.Err.Error()
+}
+
+// ExplainFiles returns every path that is searched for a config file in
+// order of precedence along with whether it exists, could be read and
+// could be parsed. This is useful for finding out why a config file is
+// not being used. The config struct is not changed.
+func ExplainFiles() []FileCandidate { return c.ExplainFiles() }
+
+// ExplainFiles returns every path that is searched for a config file in
+// order of precedence along with whether it exists, could be read and
+// could be parsed. This is useful for finding out why a config file is
+// not being used. The config struct is not changed.
+func (c *Config) ExplainFiles() []FileCandidate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	files := c.allPossibleFiles()
+	res := make([]FileCandidate, len(files))
+	for i, file := range files {
+		res[i] = c.explainFile(file)
+	}
+	return res
+}
+
+func (c *Config) explainFile(file string) FileCandidate {
+	fc := FileCandidate{Path: file}
+	info, err := c.filesystem().Stat(file)
+	switch {
+	case os.IsNotExist(err):
+		fc.Status = FileMissing
+		return fc
+	case err != nil:
+		fc.Status, fc.Err = FileUnreadable, err
+		return fc
+	case info.IsDir():
+		fc.Status, fc.Err = FileSkipped, errors.New("is a directory")
+		return fc
+	}
+	raw, err := c.readCachedFile(file)
+	if err != nil {
+		fc.Status, fc.Err = FileUnreadable, err
+		return fc
+	}
+	if !c.elem.IsValid() {
+		fc.Status, fc.Err = FileInvalid, errElemNotSet
+		return fc
+	}
+	if c.unmarshal == nil {
+		fc.Status, fc.Err = FileInvalid, errNoType
+		return fc
+	}
+	if err = c.unmarshal(raw, reflect.New(c.elem.Type()).Interface()); err != nil {
+		fc.Status, fc.Err = FileInvalid, err
+		return fc
+	}
+	fc.Status = FileLoaded
+	return fc
+}
@@ -0,0 +1,36 @@
+package config
+
+import "expvar"
+
+// PublishExpvar will publish the config with the expvar package so that
+// it is served at /debug/vars. Each key is mapped to its value and the
+// source of the value (see Origin). Secret values are hidden. Like
+// expvar.Publish, this will panic if the name is already in use.
+func PublishExpvar(name string) { c.PublishExpvar(name) }
+
+// PublishExpvar will publish the config with the expvar package so that
+// it is served at /debug/vars. Each key is mapped to its value and the
+// source of the value (see Origin). Secret values are hidden. Like
+// expvar.Publish, this will panic if the name is already in use.
+func (c *Config) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(c.debugVars))
+}
+
+type debugVar struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// debugVars returns every config value along with its source.
+func (c *Config) debugVars() interface{} {
+	list := c.listKeys(false)
+	vars := make(map[string]debugVar, len(list))
+	for _, l := range list {
+		v := debugVar{Value: l.Value, Source: Source{}.String()}
+		if src, err := c.Origin(l.Key); err == nil {
+			v.Source = src.String()
+		}
+		vars[l.Key] = v
+	}
+	return vars
+}
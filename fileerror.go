@@ -0,0 +1,85 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FileError is an error from reading, parsing or merging one config file.
+type FileError struct {
+	File string
+	// Line and Column are the position of a parse error in the file.
+	// They are zero if the position is not known and Column is zero
+	// if only the line is known.
+	Line, Column int
+	Err          error
+}
+
+func (e *FileError) Error() string {
+	switch {
+	case e.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %v", e.File, e.Line, e.Column, e.Err)
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return e.File + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *FileError) Unwrap() error { return e.Err }
+
+// lineRegex finds the line number in yaml
+// and toml errors, e.g. "yaml: line 3: ...".
+var lineRegex = regexp.MustCompile(`\bline (\d+)\b`)
+
+// newFileError creates a FileError and finds the position of the error
+// if the file's contents are given.
+func newFileError(file string, raw []byte, err error) *FileError {
+	e := &FileError{File: file, Err: err}
+	if raw == nil {
+		return e
+	}
+	if line, col, ok := errorPosition(raw, err); ok {
+		e.Line, e.Column = line, col
+	} else if m := lineRegex.FindStringSubmatch(err.Error()); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+	}
+	return e
+}
+
+// FileErrors is returned by ReadConfig when more than one config file
+// has an error. The errors are in the order that the files were read.
+type FileErrors []*FileError
+
+func (errs FileErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is returns true if the error of any file matches the target.
+func (errs FileErrors) Is(target error) bool {
+	for _, e := range errs {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileErrors returns nil if there are no errors, the only error
+// if there is one, or all the errors as FileErrors.
+func fileErrors(errs []*FileError) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return FileErrors(errs)
+}
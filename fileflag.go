@@ -0,0 +1,89 @@
+package config
+
+import (
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+)
+
+// FileFlagMode sets how the file given to the flag
+// from BindConfigFileFlag is read.
+type FileFlagMode int
+
+const (
+	// FileFlagFirst reads the file given to the flag before the other
+	// config files so that it has the highest precedence. This is the
+	// default.
+	FileFlagFirst FileFlagMode = iota
+	// FileFlagOnly reads the file given to the flag
+	// instead of any of the other config files.
+	FileFlagOnly
+)
+
+// BindConfigFileFlag will add a flag to the flag set that takes the path
+// of a config file, usually "--config" or "-c". When the flag is given,
+// the file is required and has the highest precedence, or is the only
+// config file read when the mode is FileFlagOnly. The flag should be
+// parsed before calling ReadConfig.
+//
+//	config.BindConfigFileFlag(cmd.Flags(), "config", "c")
+func BindConfigFileFlag(set *pflag.FlagSet, name, shorthand string, mode ...FileFlagMode) {
+	c.BindConfigFileFlag(set, name, shorthand, mode...)
+}
+
+// BindConfigFileFlag will add a flag to the flag set that takes the path
+// of a config file, usually "--config" or "-c". When the flag is given,
+// the file is required and has the highest precedence, or is the only
+// config file read when the mode is FileFlagOnly. The flag should be
+// parsed before calling ReadConfig.
+//
+//	config.BindConfigFileFlag(cmd.Flags(), "config", "c")
+func (c *Config) BindConfigFileFlag(set *pflag.FlagSet, name, shorthand string, mode ...FileFlagMode) {
+	f := &fileFlag{c: c}
+	if len(mode) > 0 {
+		f.mode = mode[len(mode)-1]
+	}
+	set.VarP(f, name, shorthand, "config file")
+}
+
+// fileFlag is the flag from BindConfigFileFlag.
+type fileFlag struct {
+	c    *Config
+	mode FileFlagMode
+	path string
+}
+
+func (f *fileFlag) String() string { return f.path }
+func (f *fileFlag) Type() string   { return "string" }
+
+func (f *fileFlag) Set(s string) error {
+	p, err := expandTokens(s)
+	if err != nil {
+		return err
+	}
+	f.path = p
+	f.c.mu.Lock()
+	f.c.flagFile = p
+	f.c.flagFileMode = f.mode
+	f.c.mu.Unlock()
+	return nil
+}
+
+// withFlagFile adds the file from BindConfigFileFlag to
+// the front of a list of files in order of precedence.
+func (c *Config) withFlagFile(files []string) []string {
+	if c.flagFile == "" {
+		return files
+	}
+	if c.flagFileMode == FileFlagOnly {
+		return []string{c.flagFile}
+	}
+	res := make([]string, 1, len(files)+1)
+	res[0] = c.flagFile
+	for _, f := range files {
+		if filepath.Clean(f) != filepath.Clean(c.flagFile) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
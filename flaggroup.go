@@ -0,0 +1,71 @@
+package config
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// flagGroupAnnotation is the pflag annotation that
+// stores the group from the "group" struct tag.
+const flagGroupAnnotation = "config_group"
+
+// FlagUsages returns the usage of every flag in a flag set like
+// pflag.FlagSet.FlagUsages except that flags bound to fields with a
+// "group" struct tag are listed under a heading with the group's name.
+// The group of a nested struct is used for all of its fields.
+//
+//	type Config struct {
+//		DB struct {
+//			Host string
+//		} `config:"db" group:"Database"`
+//	}
+func FlagUsages(set *pflag.FlagSet) string {
+	var (
+		groups  = make(map[string]*pflag.FlagSet)
+		names   []string
+		ungroup = pflag.NewFlagSet("", pflag.ContinueOnError)
+	)
+	ungroup.SortFlags = set.SortFlags
+	set.VisitAll(func(f *pflag.Flag) {
+		g := f.Annotations[flagGroupAnnotation]
+		if len(g) == 0 {
+			ungroup.AddFlag(f)
+			return
+		}
+		fs, ok := groups[g[0]]
+		if !ok {
+			fs = pflag.NewFlagSet(g[0], pflag.ContinueOnError)
+			fs.SortFlags = set.SortFlags
+			groups[g[0]] = fs
+			names = append(names, g[0])
+		}
+		fs.AddFlag(f)
+	})
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(ungroup.FlagUsages())
+	for _, name := range names {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(name + ":\n")
+		b.WriteString(groups[name].FlagUsages())
+	}
+	return b.String()
+}
+
+// UseFlagGroups will change the usage template of a cobra command so that
+// its flags are listed by group (see FlagUsages).
+func UseFlagGroups(cmd *cobra.Command) {
+	cobra.AddTemplateFunc("configFlagUsages", FlagUsages)
+	cmd.SetUsageTemplate(strings.Replace(
+		cmd.UsageTemplate(),
+		"{{.LocalFlags.FlagUsages",
+		"{{configFlagUsages .LocalFlags",
+		1,
+	))
+}
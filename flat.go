@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Flatten returns every config value in a map using the same dotted
+// keys as AllKeys. Default values are used for fields that have not been
+// set. Unlike AllSettings, secret values are not hidden.
+func Flatten() map[string]interface{} { return c.Flatten() }
+
+// Flatten returns every config value in a map using the same dotted
+// keys as AllKeys. Default values are used for fields that have not been
+// set. Unlike AllSettings, secret values are not hidden.
+func (c *Config) Flatten() map[string]interface{} {
+	if c.elem.Kind() == reflect.Invalid {
+		panic(errElemNotSet)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	flat := make(map[string]interface{})
+	c.walk(c.elem, "", func(key string, fld reflect.StructField, val reflect.Value) error {
+		flat[key] = c.effectiveValue(key, fld, val).Interface()
+		return nil
+	})
+	return flat
+}
+
+// LoadFlatMap will set config values from a map of dotted keys to
+// strings. The strings are converted in the same way as environment
+// variables and default values. Keys are set in sorted order and the
+// first error is returned.
+func LoadFlatMap(m map[string]string) error { return c.LoadFlatMap(m) }
+
+// LoadFlatMap will set config values from a map of dotted keys to
+// strings. The strings are converted in the same way as environment
+// variables and default values. Keys are set in sorted order and the
+// first error is returned.
+func (c *Config) LoadFlatMap(m map[string]string) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	set := make([]string, 0, len(keys))
+	defer func() { c.recordMutation("set", Source{Kind: SourceSet}, set) }()
+	for _, k := range keys {
+		key := c.aliasKey(k)
+		if err := c.setString(c.elem, key, m[k]); err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		c.recordSet(key)
+		set = append(set, key)
+	}
+	return nil
+}
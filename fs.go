@@ -0,0 +1,50 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// FS is the file system used to find and read config files. Config
+// files are written to the operating system's file system unless the
+// FS is a RevisionFS.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	Stat(name string) (os.FileInfo, error)
+}
+
+// osFS is the FS used by default.
+type osFS struct{}
+
+func (osFS) ReadFile(name string) ([]byte, error)  { return ioutil.ReadFile(name) }
+func (osFS) Stat(name string) (os.FileInfo, error) { return os.Stat(name) }
+
+// SetFS will set the file system used to find and read config files.
+// This is useful for embedded config files or for testing.
+func SetFS(fs FS) { c.SetFS(fs) }
+
+// SetFS will set the file system used to find and read config files.
+// This is useful for embedded config files or for testing.
+func (c *Config) SetFS(fs FS) {
+	c.mu.Lock()
+	c.fs = fs
+	c.mu.Unlock()
+	c.clearFileCache()
+}
+
+func (c *Config) filesystem() FS {
+	if c.fs == nil {
+		return osFS{}
+	}
+	return c.fs
+}
+
+func (c *Config) exists(p string) bool {
+	_, err := c.filesystem().Stat(p)
+	return !os.IsNotExist(err)
+}
+
+func (c *Config) fileExists(p string) bool {
+	stat, err := c.filesystem().Stat(p)
+	return err == nil && !stat.IsDir()
+}
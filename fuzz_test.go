@@ -0,0 +1,25 @@
+//go:build go1.18
+// +build go1.18
+
+package config
+
+import "testing"
+
+func FuzzParse(f *testing.F) {
+	type C struct {
+		Host  string            `yaml:"host" json:"host" toml:"host" default:"localhost"`
+		Port  int               `yaml:"port" json:"port" toml:"port" default:"8080"`
+		Tags  []string          `yaml:"tags" json:"tags" toml:"tags" default:"a,b"`
+		Extra map[string]string `yaml:"extra" json:"extra" toml:"extra"`
+		DB    *struct {
+			User string `yaml:"user" json:"user" toml:"user"`
+		} `yaml:"db" json:"db" toml:"db"`
+	}
+	f.Add("yaml", []byte("host: example.com\nport: 80\ndb:\n  user: me\n"))
+	f.Add("json", []byte(`{"tags": ["x"], "extra": {"a": "b"}}`))
+	f.Add("toml", []byte("port = 1\n[db]\nuser = \"me\"\n"))
+	f.Fuzz(func(t *testing.T, format string, data []byte) {
+		var conf C
+		Parse(format, data, &conf)
+	})
+}
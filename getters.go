@@ -3,7 +3,6 @@ package config
 import (
 	"errors"
 	"fmt"
-	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -16,7 +15,89 @@ func HasKey(key string) bool { return c.HasKey(key) }
 
 // HasKey tests if the config struct has a key given
 func (c *Config) HasKey(key string) bool {
-	return hasKey(c.elem, strings.Split(key, "."))
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return hasKey(c.elem, splitKey(c.lookupKey(key)), c.tagSet())
+}
+
+// AllKeys returns the key of every value in the config
+// struct. Keys of nested values are separated by a ".".
+func AllKeys() []string { return c.AllKeys() }
+
+// AllKeys returns the key of every value in the config
+// struct. Keys of nested values are separated by a ".".
+func (c *Config) AllKeys() []string {
+	if c.elem.Kind() == reflect.Invalid {
+		panic(errElemNotSet)
+	}
+	keys := make([]string, 0)
+	c.walk(c.elem, "", func(key string, _ reflect.StructField, _ reflect.Value) error {
+		keys = append(keys, key)
+		return nil
+	})
+	return keys
+}
+
+// SkipStruct can be returned from a VisitFunc to skip
+// the fields of a nested struct.
+var SkipStruct = errors.New("skip this struct")
+
+// VisitFunc is called by Visit with the key path, struct field, and
+// value of a field in the config struct.
+type VisitFunc func(path string, field reflect.StructField, value reflect.Value) error
+
+// Visit will walk the config struct depth-first and call fn for every
+// exported field. Nested structs are given to fn before their fields
+// and if fn returns SkipStruct then those fields are skipped. Any other
+// error stops the walk and is returned. Pointers to a struct type that
+// is already being visited, as in recursive types, are not visited.
+func Visit(fn VisitFunc) error { return c.Visit(fn) }
+
+// Visit will walk the config struct depth-first and call fn for every
+// exported field. Nested structs are given to fn before their fields
+// and if fn returns SkipStruct then those fields are skipped. Any other
+// error stops the walk and is returned. Pointers to a struct type that
+// is already being visited, as in recursive types, are not visited.
+func (c *Config) Visit(fn VisitFunc) error {
+	if c.elem.Kind() == reflect.Invalid {
+		panic(errElemNotSet)
+	}
+	return c.visit(c.elem, "", fn, make(map[reflect.Type]bool))
+}
+
+// visit calls fn for the fields of a struct. Pointers to the struct
+// types on the current path are not visited so that recursive types
+// do not loop forever.
+func (c *Config) visit(val reflect.Value, prefix string, fn VisitFunc, stack map[reflect.Type]bool) error {
+	typ := val.Type()
+	stack[typ] = true
+	defer delete(stack, typ)
+	for i := 0; i < typ.NumField(); i++ {
+		fld := typ.Field(i)
+		if fld.PkgPath != "" || isIgnored(fld) {
+			continue // unexported or ignored
+		}
+		key := c.keyName(fld)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		fldval := val.Field(i)
+		if isRecursive(fld.Type, stack) {
+			continue
+		}
+		err := fn(key, fld, fldval)
+		if err == SkipStruct {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if isNestedStruct(fld.Type) {
+			if err = c.visit(indirect(fldval, false), key, fn, stack); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // IsEmpty returns true if the value stored at some
@@ -68,9 +149,17 @@ func (c *Config) get(key string) (reflect.Value, error) {
 	if c.elem.Kind() == reflect.Invalid {
 		panic(errElemNotSet)
 	}
-	keys := strings.Split(key, ".")
-	val, err := find(c.elem, keys)
-	return val, err
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	val, err := c.find(c.lookupKey(key))
+	if err != nil || !val.IsValid() || !val.CanInterface() {
+		return val, err
+	}
+	// Copy the value so that it can still be read after the
+	// lock is released while the config is being reloaded.
+	cp := reflect.New(val.Type()).Elem()
+	cp.Set(val)
+	return cp, nil
 }
 
 // GetString will get the config value by name and
@@ -78,8 +167,8 @@ func (c *Config) get(key string) (reflect.Value, error) {
 func GetString(key string) string { return c.GetString(key) }
 
 // GetString will get the config value by name and
-// return it as a string. This function will also expand
-// any environment variables in the value returned.
+// return it as a string. Environment variables in the
+// value are expanded if SetAutoExpandEnv is turned on.
 func (c *Config) GetString(key string) string {
 	s, _ := c.GetStringErr(key)
 	return s
@@ -98,17 +187,23 @@ func (c *Config) GetStringErr(key string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return val.String(), nil
+	return c.autoExpandString(val.String()), nil
 }
 
-// Getenv wraps GetString with os.ExpandEnv
+// Getenv wraps GetString with os.ExpandEnv or the
+// function given to SetExpandFunc.
 func Getenv(key string) string {
 	return c.Getenv(key)
 }
 
-// Getenv wraps GetString with os.ExpandEnv
+// Getenv wraps GetString with os.ExpandEnv or the
+// function given to SetExpandFunc.
 func (c *Config) Getenv(key string) string {
-	return os.ExpandEnv(c.GetString(key))
+	s, _ := c.GetStringErr(key)
+	if c.autoExpand {
+		return s // already expanded
+	}
+	return c.expand(s)
 }
 
 // GetInt will get the int value of a key
@@ -256,51 +351,68 @@ func (c *Config) GetBoolErr(key string) (bool, error) {
 	return val.Bool(), nil
 }
 
-// GetIntSlice will get a slice of ints from a key
+// GetIntSlice will get a slice of ints from a key. Slices of any integer
+// type are converted, nil is returned if the key does not reference a
+// slice of numbers that fit in an int.
 func GetIntSlice(key string) []int { return c.GetIntSlice(key) }
 
-// GetIntSlice will get a slice of ints from a key
-//
-// Warning: will panic if the key does not reference
-// a []int
+// GetIntSlice will get a slice of ints from a key. Slices of any integer
+// type are converted, nil is returned if the key does not reference a
+// slice of numbers that fit in an int.
 func (c *Config) GetIntSlice(key string) []int {
-	val, err := c.get(key)
-	if err != nil {
-		return nil
-	}
-	if val.Kind() != reflect.Slice {
-		return nil
-	}
-	ret, ok := val.Interface().([]int)
-	if !ok {
+	var ret []int
+	if c.GetSlice(key, &ret) != nil {
 		return nil
 	}
 	return ret
 }
 
-// GetInt64Slice will return a slice of int64.
-//
-// Warning: will panic if the key given does not
-// reference a []int64
+// GetInt64Slice will return a slice of int64. Slices of any integer type
+// are converted, nil is returned if the key does not reference a slice
+// of numbers that fit in an int64.
 func GetInt64Slice(key string) []int64 { return c.GetInt64Slice(key) }
 
-// GetInt64Slice will return a slice of int64.
-//
-// Warning: will panic if the key given does not
-// reference a []int64
+// GetInt64Slice will return a slice of int64. Slices of any integer type
+// are converted, nil is returned if the key does not reference a slice
+// of numbers that fit in an int64.
 func (c *Config) GetInt64Slice(key string) []int64 {
-	res, err := c.get(key)
-	if err != nil {
+	var ret []int64
+	if c.GetSlice(key, &ret) != nil {
 		return nil
 	}
-	if res.Kind() != reflect.Slice {
-		return nil
+	return ret
+}
+
+// GetSlice will get the slice or array stored at some key and store it in
+// the slice that out points to. Each element is converted to the element
+// type of out if they are the same kind or if they are both numbers that
+// can be converted without losing information, otherwise ErrWrongType is
+// returned.
+//
+//	var ports []uint16
+//	err := config.GetSlice("ports", &ports)
+func GetSlice(key string, out interface{}) error { return c.GetSlice(key, out) }
+
+// GetSlice will get the slice or array stored at some key and store it in
+// the slice that out points to. Each element is converted to the element
+// type of out if they are the same kind or if they are both numbers that
+// can be converted without losing information, otherwise ErrWrongType is
+// returned.
+func (c *Config) GetSlice(key string, out interface{}) error {
+	ptr := reflect.ValueOf(out)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("%w: GetSlice needs a pointer to a slice, got %T", ErrWrongType, out)
 	}
-	ret, ok := res.Interface().([]int64)
-	if !ok {
-		return nil
+	val, err := c.get(key)
+	if err != nil {
+		return err
 	}
-	return ret
+	res, err := convertSlice(val, ptr.Elem().Type())
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	ptr.Elem().Set(res)
+	return nil
 }
 
 // GetStringMap will get a map of string keys to string values
@@ -320,118 +432,411 @@ func (c *Config) GetStringMap(key string) map[string]string {
 	m := make(map[string]string)
 	iter := res.MapRange()
 	for iter.Next() {
-		m[iter.Key().String()] = iter.Value().String()
+		m[iter.Key().String()] = c.autoExpandString(iter.Value().String())
 	}
 	return m
 }
 
-func find(val reflect.Value, keyPath []string) (reflect.Value, error) {
-	var err error
-	typ := val.Type()
-	n := typ.NumField()
-	for i := 0; i < n; i++ {
-		typFld := typ.Field(i)
-		// if the first key is the same as the fieldname
-		if isCorrectLabel(keyPath[0], typFld) {
-			value := val.Field(i)
-			if len(keyPath) > 1 {
-				return find(value, keyPath[1:])
-			}
-			if !isZero(value) {
-				// if the field has been set then we return it
-				return value, nil
-			}
+// GetStringMapInterface will get a map of string keys to any value.
+// Nested structs are returned as maps with the same keys as AllKeys.
+func GetStringMapInterface(key string) map[string]interface{} {
+	return c.GetStringMapInterface(key)
+}
 
-			defvalue, err := getDefaultValue(&typFld, &value)
-			switch err {
-			case errNoDefaultValue:
-				return value, nil
-			case nil:
-				return defvalue, nil
-			default: // err != nil
-				return defvalue, err
+// GetStringMapInterface will get a map of string keys to any value.
+// Nested structs are returned as maps with the same keys as AllKeys.
+func (c *Config) GetStringMapInterface(key string) map[string]interface{} {
+	res, err := c.get(key)
+	if err != nil {
+		return nil
+	}
+	res = indirect(res, false)
+	switch res.Kind() {
+	case reflect.Struct:
+		return c.settings(res, false)
+	case reflect.Map:
+		m := make(map[string]interface{}, res.Len())
+		iter := res.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = iter.Value().Interface()
+		}
+		return m
+	}
+	return nil
+}
+
+// GetStringMapStringSlice will get a map of string keys to string
+// slices. Single values are returned as a slice with one element.
+func GetStringMapStringSlice(key string) map[string][]string {
+	return c.GetStringMapStringSlice(key)
+}
+
+// GetStringMapStringSlice will get a map of string keys to string
+// slices. Single values are returned as a slice with one element.
+func (c *Config) GetStringMapStringSlice(key string) map[string][]string {
+	res, err := c.get(key)
+	if err != nil {
+		return nil
+	}
+	if res.Kind() != reflect.Map {
+		return nil
+	}
+	m := make(map[string][]string, res.Len())
+	iter := res.MapRange()
+	for iter.Next() {
+		val := iter.Value()
+		if val.Kind() == reflect.Interface {
+			val = val.Elem()
+		}
+		var s []string
+		switch val.Kind() {
+		case reflect.Slice, reflect.Array:
+			s = make([]string, val.Len())
+			for i := range s {
+				s[i] = c.autoExpandString(fmt.Sprint(val.Index(i).Interface()))
 			}
+		case reflect.Invalid:
+		default:
+			s = []string{c.autoExpandString(fmt.Sprint(val.Interface()))}
 		}
+		m[fmt.Sprint(iter.Key().Interface())] = s
+	}
+	return m
+}
+
+func (c *Config) find(key string) (reflect.Value, error) {
+	value, typFld, err := c.findField(key)
+	if err != nil {
+		return nilval, err
+	}
+	if !isZero(value) || c.explicit(key) {
+		// if the field has been set then we return it
+		return value, nil
+	}
+
+	// Copy the field and value so that they are only moved to
+	// the heap when looking for a default value.
+	fld, fldval := typFld, value
+	defvalue, err := c.getDefaultValue(&fld, &fldval)
+	switch err {
+	case errNoDefaultValue:
+		return value, nil
+	case nil:
+		return defvalue, nil
+	default: // err != nil
+		return defvalue, err
+	}
+}
+
+// DefaultFor returns the default value declared in the "default" struct
+// tag of the field stored at some key. The config struct is not read
+// or changed and environment variables are not used. False is returned
+// if the key does not exist or its field has no valid default value.
+func DefaultFor(key string) (interface{}, bool) { return c.DefaultFor(key) }
+
+// DefaultFor returns the default value declared in the "default" struct
+// tag of the field stored at some key. The config struct is not read
+// or changed and environment variables are not used. False is returned
+// if the key does not exist or its field has no valid default value.
+func (c *Config) DefaultFor(key string) (interface{}, bool) {
+	if c.elem.Kind() == reflect.Invalid {
+		panic(errElemNotSet)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, fld, err := c.findField(c.lookupKey(key))
+	if err != nil || fld.Type == nil {
+		return nil, false
+	}
+	zero := reflect.New(fld.Type).Elem()
+	def, err := c.getTagDefault(&fld, &zero)
+	if err != nil || !def.IsValid() || !def.CanInterface() {
+		return nil, false
 	}
-	if err == nil {
-		err = ErrFieldNotFound
+	if def.Type() != fld.Type && def.Type().ConvertibleTo(fld.Type) {
+		def = def.Convert(fld.Type)
 	}
-	return nilval, err
+	return def.Interface(), true
 }
 
-func hasKey(val reflect.Value, keyPath []string) bool {
-	typ := val.Type()
-	n := typ.NumField()
-	for i := 0; i < n; i++ {
-		typFld := typ.Field(i)
-		if isCorrectLabel(keyPath[0], typFld) {
-			if len(keyPath) == 1 {
-				return true
+// findField will find the struct field at the end of the key path
+// without substituting any default values. Numeric keys are used as
+// indices for slices and arrays and other keys are used as map keys.
+func findField(val reflect.Value, keyPath []string, tags tagSet) (reflect.Value, reflect.StructField, error) {
+	return findFieldFrom(val, keyPath, nil, tags)
+}
+
+// findFieldFrom is the same as findField but it is given the keys
+// that have already been traversed so that errors can name the full
+// key path.
+func findFieldFrom(val reflect.Value, keyPath, seen []string, tags tagSet) (reflect.Value, reflect.StructField, error) {
+	seen = append(seen[:len(seen):len(seen)], keyPath[0])
+	value, fld, err := child(val, keyPath[0], seen, tags)
+	if err != nil {
+		return nilval, fld, err
+	}
+	if len(keyPath) > 1 {
+		// Nil pointers to nested structs are traversed as zero
+		// values so that reading never changes the struct.
+		return findFieldFrom(indirect(value, false), keyPath[1:], seen, tags)
+	}
+	return value, fld, nil
+}
+
+// child returns the value stored at one key of a struct, slice, array, or
+// map. Slice, array, and map elements are given a struct field with the
+// index or map key as its name and no struct tags.
+func child(val reflect.Value, key string, path []string, tags tagSet) (reflect.Value, reflect.StructField, error) {
+	if val.Kind() == reflect.Interface && !val.IsNil() {
+		val = val.Elem()
+	}
+	switch val.Kind() {
+	case reflect.Struct:
+		fields := fieldsOf(val.Type(), tags)
+		for i := range fields {
+			// if the first key is the same as the fieldname
+			if fields[i].label(key) {
+				return val.Field(fields[i].Index[0]), fields[i].StructField, nil
 			}
-			return hasKey(val.Field(i), keyPath[1:])
 		}
+	case reflect.Slice, reflect.Array:
+		i, err := strconv.Atoi(key)
+		if err != nil {
+			break
+		}
+		if i < 0 || i >= val.Len() {
+			return nilval, reflect.StructField{}, fmt.Errorf(
+				"%w: %s (length %d)", ErrIndexOutOfRange, strings.Join(path, "."), val.Len())
+		}
+		elem := val.Index(i)
+		return elem, reflect.StructField{Name: key, Type: elem.Type()}, nil
+	case reflect.Map:
+		k, ok := mapKey(val.Type().Key(), key)
+		if !ok {
+			break
+		}
+		elem := val.MapIndex(k)
+		if !elem.IsValid() {
+			return nilval, reflect.StructField{}, fmt.Errorf(
+				"%w: no map key %q at %s", ErrFieldNotFound, key, strings.Join(path, "."))
+		}
+		return elem, reflect.StructField{Name: key, Type: val.Type().Elem()}, nil
+	}
+	return nilval, reflect.StructField{}, ErrFieldNotFound
+}
+
+// mapKey converts a key from a key path to a map's key type.
+func mapKey(typ reflect.Type, key string) (reflect.Value, bool) {
+	switch typ.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(key).Convert(typ), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(key, 10, typ.Bits())
+		if err != nil {
+			return nilval, false
+		}
+		return reflect.ValueOf(i).Convert(typ), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(key, 10, typ.Bits())
+		if err != nil {
+			return nilval, false
+		}
+		return reflect.ValueOf(u).Convert(typ), true
+	}
+	return nilval, false
+}
+
+// updateField calls fn with the settable value stored at a key path. Map
+// elements cannot be set in place so they are copied, given to fn, and
+// then stored back in the map. Missing map keys are created.
+func updateField(val reflect.Value, keyPath []string, tags tagSet, fn func(reflect.Value, reflect.StructField) error) error {
+	return updateFieldFrom(val, keyPath, nil, tags, fn)
+}
+
+func updateFieldFrom(val reflect.Value, keyPath, seen []string, tags tagSet, fn func(reflect.Value, reflect.StructField) error) error {
+	seen = append(seen[:len(seen):len(seen)], keyPath[0])
+	if val.Kind() != reflect.Map {
+		value, fld, err := child(val, keyPath[0], seen, tags)
+		if err != nil {
+			return err
+		}
+		if len(keyPath) > 1 {
+			return updateFieldFrom(indirect(value, true), keyPath[1:], seen, tags, fn)
+		}
+		return fn(value, fld)
+	}
+
+	k, ok := mapKey(val.Type().Key(), keyPath[0])
+	if !ok {
+		return ErrFieldNotFound
+	}
+	if val.IsNil() {
+		if !val.CanSet() {
+			return errors.New("cannot set value")
+		}
+		val.Set(reflect.MakeMap(val.Type()))
+	}
+	elem := reflect.New(val.Type().Elem()).Elem()
+	if v := val.MapIndex(k); v.IsValid() {
+		elem.Set(v)
+	}
+	var err error
+	if len(keyPath) > 1 {
+		err = updateFieldFrom(indirect(elem, true), keyPath[1:], seen, tags, fn)
+	} else {
+		err = fn(elem, reflect.StructField{Name: keyPath[0], Type: elem.Type()})
+	}
+	if err != nil {
+		return err
 	}
-	return false
+	val.SetMapIndex(k, elem)
+	return nil
 }
 
-func setDefaults(val reflect.Value) (err error) {
-	var seterr error
+func hasKey(val reflect.Value, keyPath []string, tags tagSet) bool {
+	value, _, err := child(val, keyPath[0], nil, tags)
+	if err != nil {
+		return false
+	}
+	if len(keyPath) == 1 {
+		return true
+	}
+	return hasKey(indirect(value, false), keyPath[1:], tags)
+}
+
+func setDefaults(val reflect.Value) error {
+	return setDefaultsFrom(val, getDefaultValue)
+}
+
+// defaultFunc finds the default value of a struct field.
+type defaultFunc func(*reflect.StructField, *reflect.Value) (reflect.Value, error)
+
+// DefaultsError is returned when one or more default values could not be
+// set. Each error names the path of the struct field with the bad default.
+type DefaultsError []error
+
+func (e DefaultsError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// setDefaultsFrom will set the default value of every struct field that
+// is zero. All the fields are checked before returning a DefaultsError
+// for the ones that failed.
+func setDefaultsFrom(val reflect.Value, getDefault defaultFunc) error {
+	var errs DefaultsError
+	setDefaultsPath(val, "", getDefault, &errs, make(map[reflect.Type]bool))
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// setDefaultsPath sets the defaults of a struct at some path. The
+// struct types on the current path are kept in stack so that optional
+// sections of recursive types are not created forever.
+func setDefaultsPath(val reflect.Value, path string, getDefault defaultFunc, errs *DefaultsError, stack map[reflect.Type]bool) {
 	typ := val.Type()
+	stack[typ] = true
+	defer delete(stack, typ)
 	n := typ.NumField()
 	for i := 0; i < n; i++ {
 		fldVal := val.Field(i)  // field's value
 		fldType := typ.Field(i) // field's type
+		fldPath := joinFieldPath(path, fldType.Name)
+		if fldType.PkgPath != "" || isIgnored(fldType) {
+			continue // unexported or ignored
+		}
+
+		// Optional sections that are pointers to structs
+		// are only created if they have default values.
+		if fldVal.Kind() == reflect.Ptr && isNestedStruct(fldType.Type) {
+			if fldVal.IsNil() && (!fldVal.CanSet() || stack[indirectType(fldType.Type)] || !hasDefaults(fldType.Type)) {
+				continue
+			}
+			setDefaultsPath(indirect(fldVal, true), fldPath, getDefault, errs, stack)
+			continue
+		}
 
 		// make recursive calls
 		if fldVal.Kind() == reflect.Struct {
-			err := setDefaults(fldVal)
-			if seterr == nil {
-				seterr = err
-			}
+			setDefaultsPath(fldVal, fldPath, getDefault, errs, stack)
 			continue
 		}
 
 		// if the field has been set already, then
 		// it is a significant value to the user
 		// do not override with defaults
-		// Also, if the field is not exported then we
-		// cannot call isZero on it.
-		if (fldType.Name[0] >= 64 && fldType.Name[0] <= 90) && !isZero(fldVal) {
+		if !isZero(fldVal) {
 			continue
 		}
 
-		defval, err := getDefaultValue(&fldType, &fldVal)
+		defval, err := getDefault(&fldType, &fldVal)
 		switch err {
 		case nil: // break out of switch
 		case errNoDefaultValue:
 			continue
 		default:
-			return err
+			*errs = append(*errs, fmt.Errorf("field %s: %w", fldPath, err))
+			continue
+		}
+		if defval.IsValid() && defval.Type() != fldType.Type && defval.Type().ConvertibleTo(fldType.Type) {
+			defval = defval.Convert(fldType.Type)
 		}
 		if fldVal.CanSet() {
 			fldVal.Set(defval)
 		} else {
-			if seterr == nil {
-				seterr = fmt.Errorf("cannot set value for field '%s'", fldType.Name)
-			}
-			continue
+			*errs = append(*errs, fmt.Errorf("field %s: cannot set value", fldPath))
 		}
 	}
-	return seterr
+}
+
+// hasDefaults returns true if a struct type has any fields
+// with a default value.
+func hasDefaults(typ reflect.Type) bool {
+	return hasField(typ, func(fld reflect.StructField) bool {
+		return !isIgnored(fld) && (fld.Tag.Get("default") != "" || fld.Tag.Get("env") != "")
+	})
 }
 
 var errNoDefaultValue = errors.New("no default value found")
 
 func getDefaultValue(fld *reflect.StructField, fldval *reflect.Value) (def reflect.Value, err error) {
+	return lookupDefault(fld, fldval, true, valueFromString)
+}
+
+// getDefaultValue is the same as the getDefaultValue
+// function but it will use the decode hooks.
+func (c *Config) getDefaultValue(fld *reflect.StructField, fldval *reflect.Value) (reflect.Value, error) {
+	return lookupDefault(fld, fldval, true, c.decodeString)
+}
+
+// getTagDefault is the same as getDefaultValue
+// but it will ignore the "env" struct tag.
+func (c *Config) getTagDefault(fld *reflect.StructField, fldval *reflect.Value) (reflect.Value, error) {
+	return lookupDefault(fld, fldval, false, c.decodeString)
+}
+
+// decodeFunc converts a string to the type of a struct field.
+type decodeFunc func(string, *reflect.StructField, *reflect.Value) (reflect.Value, error)
+
+func lookupDefault(
+	fld *reflect.StructField,
+	fldval *reflect.Value,
+	useEnv bool,
+	decode decodeFunc,
+) (reflect.Value, error) {
 	val := fld.Tag.Get("default")
-	env := fld.Tag.Get("env")
-	if env != "" {
-		val = os.Getenv(env)
+	if useEnv && fld.Tag.Get("env") != "" {
+		_, val, _ = lookupEnv(*fld)
 	}
 	if val == "" {
 		return nilval, errNoDefaultValue
 	}
-	return valueFromString(val, fld, fldval)
+	return decode(val, fld, fldval)
 }
 
 func valueFromString(
@@ -444,6 +849,17 @@ func valueFromString(
 		uival uint64
 		fval  float64
 	)
+	if err = checkEnum("", *fld, val); err != nil {
+		return nilval, err
+	}
+	if v, fv, ok := newValue(fld.Type); ok {
+		// Types that are flag values parse
+		// strings the same way as flags.
+		if err = fv.Set(val); err != nil {
+			return nilval, err
+		}
+		return v, nil
+	}
 
 	switch fld.Type.Kind() {
 	case reflect.String:
@@ -489,16 +905,21 @@ func valueFromString(
 		bval, err = strconv.ParseBool(val)
 		result = reflect.ValueOf(bval)
 	case reflect.Slice:
-		switch fldval.Interface().(type) {
-		case []byte:
+		if fld.Type.Elem().Kind() == reflect.Uint8 {
 			result = reflect.ValueOf([]byte(val))
-		default:
-			panic(fmt.Sprintf("don't know how to parse %v yet", fld.Type.Kind()))
+			break
 		}
+		result, err = listFromString(val, fld)
+	case reflect.Array:
+		result, err = listFromString(val, fld)
 	case reflect.Complex64:
-		// TODO
+		var cval complex128
+		cval, err = strconv.ParseComplex(val, 64)
+		result = reflect.ValueOf(complex64(cval))
 	case reflect.Complex128:
-		// TODO
+		var cval complex128
+		cval, err = strconv.ParseComplex(val, 128)
+		result = reflect.ValueOf(cval)
 	case reflect.Func:
 	default:
 		return nilval, errors.New("unknown default config type")
@@ -509,19 +930,60 @@ func valueFromString(
 	return result, err
 }
 
-func isCorrectLabel(key string, field reflect.StructField) bool {
-	if len(key) == 0 {
-		return false
+// listFromString will parse a comma separated list into a slice or an
+// array. Arrays may be given fewer values than their length.
+func listFromString(val string, fld *reflect.StructField) (reflect.Value, error) {
+	var (
+		parts = strings.Split(val, ",")
+		typ   = fld.Type
+		res   reflect.Value
+	)
+	if typ.Kind() == reflect.Array {
+		if len(parts) > typ.Len() {
+			return nilval, fmt.Errorf("%d values given for an array of length %d", len(parts), typ.Len())
+		}
+		res = reflect.New(typ).Elem()
+	} else {
+		res = reflect.MakeSlice(typ, len(parts), len(parts))
+	}
+	elem := reflect.StructField{Name: fld.Name, Type: typ.Elem()}
+	for i, p := range parts {
+		v := reflect.New(elem.Type).Elem()
+		ev, err := valueFromString(strings.TrimSpace(p), &elem, &v)
+		if err != nil {
+			return nilval, err
+		}
+		if !ev.IsValid() {
+			return nilval, fmt.Errorf("cannot parse list of %s", elem.Type)
+		}
+		if ev.Type() != elem.Type {
+			ev = ev.Convert(elem.Type)
+		}
+		res.Index(i).Set(ev)
 	}
+	return res, nil
+}
+
+// tagName returns the name given in a struct tag. This is the same as
+// the first element of strings.Split(tag, ",") without the allocation.
+func tagName(field reflect.StructField, key string) string {
+	tag := field.Tag.Get(key)
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
 
-	var parts []string
-	// TODO don't look for the "json" tag if the filetype
-	// has been set as yaml and vice versa.
-	for _, tag := range []string{"config", "yaml", "json"} {
-		parts = strings.Split(field.Tag.Get(tag), ",")
-		if len(parts) > 0 && parts[0] == key {
-			return true
+// keyName returns the name used in key paths for a struct field. The
+// tags from SetTagNames are used first followed by the tag for the
+// current config type, the tags from AddTagNames, and then the field
+// name.
+func (c *Config) keyName(field reflect.StructField) string {
+	for _, tag := range c.nameTags() {
+		name := tagName(field, tag)
+		if name != "" {
+			return escapeKey(name)
 		}
 	}
-	return field.Name == key
+	return field.Name
 }
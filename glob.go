@@ -0,0 +1,69 @@
+package config
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// GlobFS is a file system that can find files matching a pattern. File
+// systems given to SetFS that do not implement GlobFS cannot be used
+// with AddFilePattern.
+type GlobFS interface {
+	FS
+	Glob(pattern string) ([]string, error)
+}
+
+// Glob calls filepath.Glob.
+func (osFS) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
+
+// AddFilePattern will add a glob pattern (see filepath.Match) for config
+// files. Relative patterns are joined with each path added with AddPath
+// so that every file matching "conf.d/*.yaml" in a search path is read.
+// The files matched in one directory are merged in lexical order where
+// files that sort later override files that sort earlier, the same as
+// drop-in directories like nginx's conf.d. Files matched by a pattern
+// have a lower precedence than the filenames in the same path (see
+// AddFile). filepath.ErrBadPattern is returned for invalid patterns.
+func AddFilePattern(pattern string) error { return c.AddFilePattern(pattern) }
+
+// AddFilePattern will add a glob pattern (see filepath.Match) for config
+// files. Relative patterns are joined with each path added with AddPath
+// so that every file matching "conf.d/*.yaml" in a search path is read.
+// The files matched in one directory are merged in lexical order where
+// files that sort later override files that sort earlier, the same as
+// drop-in directories like nginx's conf.d. Files matched by a pattern
+// have a lower precedence than the filenames in the same path (see
+// AddFile). filepath.ErrBadPattern is returned for invalid patterns.
+func (c *Config) AddFilePattern(pattern string) error {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.patterns = append(c.patterns, pattern)
+	c.mu.Unlock()
+	return nil
+}
+
+// matchPatterns returns the files matching the relative patterns in a
+// directory or the files matching the absolute patterns if dir is
+// empty. The files matched by each pattern are in reverse lexical order
+// so that the files that sort last have the highest precedence.
+func (c *Config) matchPatterns(dir string) []string {
+	fs, ok := c.filesystem().(GlobFS)
+	if !ok {
+		return nil
+	}
+	var files []string
+	for _, pattern := range c.patterns {
+		if filepath.IsAbs(pattern) != (dir == "") {
+			continue
+		}
+		matches, err := fs.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			continue
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+		files = append(files, matches...)
+	}
+	return files
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Mutation is a change to the config values, see History.
+type Mutation struct {
+	Time time.Time
+	// Op is what changed the config. It is one of "read" for ReadConfig,
+	// "reload" for Reload, "watch" for changes found by Watch, "push"
+	// for Apply and StreamUpdates, "set", "unset", or "flag".
+	Op string
+	// Source is where the new values came from. The name is empty when
+	// the values may have come from more than one file.
+	Source Source
+	// Keys that were changed.
+	Keys []string
+}
+
+func (m Mutation) String() string {
+	s := fmt.Sprintf("%s %s: %s", m.Time.Format(time.RFC3339), m.Op, strings.Join(m.Keys, ", "))
+	if m.Source.Name != "" {
+		s += " (" + m.Source.String() + ")"
+	}
+	return s
+}
+
+// SetHistory will keep a record of the last n changes to the config
+// values made by reading config files, watching files, flags, or
+// functions like Set. Each change is also written to w as a line of
+// text if w is not nil. Calling SetHistory clears the history and a
+// size of zero with a nil writer stops recording changes.
+func SetHistory(n int, w io.Writer) { c.SetHistory(n, w) }
+
+// SetHistory will keep a record of the last n changes to the config
+// values made by reading config files, watching files, flags, or
+// functions like Set. Each change is also written to w as a line of
+// text if w is not nil. Calling SetHistory clears the history and a
+// size of zero with a nil writer stops recording changes.
+func (c *Config) SetHistory(n int, w io.Writer) {
+	if n < 0 {
+		n = 0
+	}
+	c.histmu.Lock()
+	c.history = make([]Mutation, 0, n)
+	c.historyPos = 0
+	c.historyOut = w
+	c.histmu.Unlock()
+}
+
+// History returns the recorded changes to the config values
+// starting with the oldest, see SetHistory.
+func History() []Mutation { return c.History() }
+
+// History returns the recorded changes to the config values
+// starting with the oldest, see SetHistory.
+func (c *Config) History() []Mutation {
+	c.histmu.Lock()
+	defer c.histmu.Unlock()
+	res := make([]Mutation, 0, len(c.history))
+	res = append(res, c.history[c.historyPos:]...)
+	return append(res, c.history[:c.historyPos]...)
+}
+
+func (c *Config) recording() bool {
+	c.histmu.Lock()
+	defer c.histmu.Unlock()
+	return cap(c.history) > 0 || c.historyOut != nil
+}
+
+// recordMutation will add a change to the history.
+func (c *Config) recordMutation(op string, src Source, keys []string) {
+	c.histmu.Lock()
+	defer c.histmu.Unlock()
+	if len(keys) == 0 || (cap(c.history) == 0 && c.historyOut == nil) {
+		return
+	}
+	m := Mutation{Time: time.Now(), Op: op, Source: src, Keys: keys}
+	if len(c.history) < cap(c.history) {
+		c.history = append(c.history, m)
+	} else if len(c.history) > 0 {
+		c.history[c.historyPos] = m
+		c.historyPos = (c.historyPos + 1) % len(c.history)
+	}
+	if c.historyOut != nil {
+		fmt.Fprintln(c.historyOut, m)
+	}
+}
+
+// snapshot returns a copy of the config struct for recordChanges or an
+// invalid value if changes are not being recorded.
+func (c *Config) snapshot() reflect.Value {
+	if !c.recording() || !c.elem.IsValid() {
+		return nilval
+	}
+	return copyVal(c.elem)
+}
+
+// recordChanges will add the keys that are different from a
+// snapshot of the config struct to the history.
+func (c *Config) recordChanges(op string, src Source, before reflect.Value) {
+	if !before.IsValid() {
+		return
+	}
+	changes := c.diffConfig(before, c.elem, false)
+	keys := make([]string, len(changes))
+	for i, ch := range changes {
+		keys[i] = ch.Key
+	}
+	c.recordMutation(op, src, keys)
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// DecodeHook converts data into the type of a config field. Hooks that
+// cannot convert the data should return it unchanged.
+type DecodeHook func(from, to reflect.Type, data interface{}) (interface{}, error)
+
+// AddDecodeHook will add a hook that is used when converting values
+// from config files, environment variables, default values, and flags
+// into the config struct. Hooks are run in the order they were added.
+//
+//	config.AddDecodeHook(config.StringToDurationHook)
+func AddDecodeHook(hook DecodeHook) { c.AddDecodeHook(hook) }
+
+// AddDecodeHook will add a hook that is used when converting values
+// from config files, environment variables, default values, and flags
+// into the config struct. Hooks are run in the order they were added.
+func (c *Config) AddDecodeHook(hook DecodeHook) {
+	c.mu.Lock()
+	c.hooks = append(c.hooks, hook)
+	c.mu.Unlock()
+	c.clearFileCache()
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// StringToDurationHook converts strings like "1m30s" to a time.Duration.
+func StringToDurationHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from == nil || from.Kind() != reflect.String || to != durationType {
+		return data, nil
+	}
+	return time.ParseDuration(reflect.ValueOf(data).String())
+}
+
+// StringToSliceHook returns a hook that splits a
+// string into a slice of strings.
+func StringToSliceHook(sep string) DecodeHook {
+	return func(from, to reflect.Type, data interface{}) (interface{}, error) {
+		if from == nil || from.Kind() != reflect.String ||
+			to.Kind() != reflect.Slice || to.Elem().Kind() != reflect.String {
+			return data, nil
+		}
+		s := reflect.ValueOf(data).String()
+		if s == "" {
+			return reflect.MakeSlice(to, 0, 0).Interface(), nil
+		}
+		parts := strings.Split(s, sep)
+		slice := reflect.MakeSlice(to, len(parts), len(parts))
+		for i, p := range parts {
+			slice.Index(i).Set(reflect.ValueOf(p).Convert(to.Elem()))
+		}
+		return slice.Interface(), nil
+	}
+}
+
+// TextUnmarshalerHook converts strings using the UnmarshalText method of
+// the field's type. This is useful for enum types that validate their
+// values.
+func TextUnmarshalerHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from == nil || from.Kind() != reflect.String {
+		return data, nil
+	}
+	ptr := reflect.New(to)
+	u, ok := ptr.Interface().(encoding.TextUnmarshaler)
+	if !ok {
+		return data, nil
+	}
+	if err := u.UnmarshalText([]byte(reflect.ValueOf(data).String())); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+func (c *Config) runHooks(to reflect.Type, data interface{}) (interface{}, error) {
+	var err error
+	for _, hook := range c.hooks {
+		if data, err = hook(reflect.TypeOf(data), to, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// decodeString converts a string to the type of a struct field using
+// the decode hooks before falling back to the default parsing.
+func (c *Config) decodeString(s string, fld *reflect.StructField, fldval *reflect.Value) (reflect.Value, error) {
+	if len(c.hooks) > 0 {
+		data, err := c.runHooks(fld.Type, s)
+		if err != nil {
+			return nilval, err
+		}
+		val := reflect.ValueOf(data)
+		switch {
+		case !val.IsValid():
+		case val.Type() == fld.Type:
+			return val, nil
+		case val.Kind() == reflect.String:
+			s = val.String()
+		case val.Type().ConvertibleTo(fld.Type):
+			return val.Convert(fld.Type), nil
+		}
+	}
+	return valueFromString(s, fld, fldval)
+}
+
+// decodeFile will run the decode hooks on every value in a config file
+// that has a different type than its struct field. The file is returned
+// unchanged if no values were changed or it cannot be parsed.
+func (c *Config) decodeFile(filename string, raw []byte) ([]byte, error) {
+	if len(c.hooks) == 0 || c.unmarshal == nil || c.marshal == nil {
+		return raw, nil
+	}
+	var m map[string]interface{}
+	if err := c.unmarshal(raw, &m); err != nil {
+		return raw, nil
+	}
+	m = normalizeMap(m).(map[string]interface{})
+	changed, err := c.decodeMap(m, c.elem.Type(), "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if !changed {
+		return raw, nil
+	}
+	return c.marshal(m)
+}
+
+func (c *Config) decodeMap(m map[string]interface{}, typ reflect.Type, prefix string) (changed bool, err error) {
+	for k, v := range m {
+		fld, ok := c.fieldByLabel(typ, k)
+		if !ok || v == nil {
+			continue
+		}
+		key := joinFieldPath(prefix, k)
+		if sub, ok := v.(map[string]interface{}); ok && isNestedStruct(fld.Type) {
+			ch, err := c.decodeMap(sub, indirectType(fld.Type), key)
+			if err != nil {
+				return changed, err
+			}
+			changed = changed || ch
+			continue
+		}
+		if reflect.TypeOf(v) == fld.Type {
+			continue
+		}
+		data, err := c.runHooks(fld.Type, v)
+		if err != nil {
+			return changed, fmt.Errorf("%s: %w", key, err)
+		}
+		if !reflect.DeepEqual(data, v) {
+			m[k] = data
+			changed = true
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Handler returns an http.Handler that serves the effective config as
+// json with secret values hidden. The config is served at the root of
+// the handler and every key is listed at "/keys". Use AdminHandler to
+// allow changes.
+//
+//	http.Handle("/debug/config/", http.StripPrefix("/debug/config", config.Handler()))
+func Handler() http.Handler { return c.Handler() }
+
+// Handler returns an http.Handler that serves the effective config as
+// json with secret values hidden. The config is served at the root of
+// the handler and every key is listed at "/keys". Use AdminHandler to
+// allow changes.
+func (c *Config) Handler() http.Handler { return &configHandler{c: c} }
+
+// AdminHandler is the same as Handler but PATCH requests to the root of
+// the handler will set config values when auth returns true. The request
+// body should be a json object of keys and new values. If the config
+// struct implements Validator and the new values are not valid then
+// none of the values are changed.
+func AdminHandler(auth func(*http.Request) bool) http.Handler { return c.AdminHandler(auth) }
+
+// AdminHandler is the same as Handler but PATCH requests to the root of
+// the handler will set config values when auth returns true. The request
+// body should be a json object of keys and new values. If the config
+// struct implements Validator and the new values are not valid then
+// none of the values are changed.
+func (c *Config) AdminHandler(auth func(*http.Request) bool) http.Handler {
+	return &configHandler{c: c, auth: auth}
+}
+
+type configHandler struct {
+	c    *Config
+	auth func(*http.Request) bool
+}
+
+func (h *configHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case path == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		writeJSON(w, http.StatusOK, h.c.AllSettings())
+	case path == "keys" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		writeJSON(w, http.StatusOK, h.c.listKeys(false))
+	case path == "" && r.Method == http.MethodPatch && h.auth != nil:
+		if !h.auth(r) {
+			httpError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		var patch map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := h.c.patch(patch); errors.Is(err, ErrReadOnly) {
+			httpError(w, http.StatusForbidden, err.Error())
+			return
+		} else if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, h.c.AllSettings())
+	case path == "" || path == "keys":
+		allow := "GET, HEAD"
+		if h.auth != nil && path == "" {
+			allow += ", PATCH"
+		}
+		w.Header().Set("Allow", allow)
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// patch will set config values from json. The old values are put back
+// if any value cannot be set or the config is not valid.
+func (c *Config) patch(patch map[string]json.RawMessage) error {
+	keys := make([]string, 0, len(patch))
+	for k := range patch {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	old := make(map[string]patchedValue, len(keys))
+	restore := func() {
+		for k, v := range old {
+			if v.created > 0 {
+				c.deleteMapKey(splitKey(k)[:v.created])
+				continue
+			}
+			c.setValue(c.elem, k, v.prev.Interface())
+		}
+	}
+	for _, k := range keys {
+		var (
+			key     = c.lookupKey(k)
+			keyPath = splitKey(key)
+			prev    reflect.Value
+			created = missingKeys(c.elem, keyPath, c.tagSet())
+		)
+		// Keys are set the same way as Set so that missing map keys are
+		// created. The value is decoded once the field's type is known.
+		err := updateField(c.elem, keyPath, c.tagSet(), func(field reflect.Value, fld reflect.StructField) error {
+			if !field.CanSet() {
+				return errors.New("cannot set value")
+			}
+			val := reflect.New(field.Type())
+			if err := json.Unmarshal(patch[k], val.Interface()); err != nil {
+				return err
+			}
+			if err := checkEnum(key, fld, fmt.Sprint(val.Elem().Interface())); err != nil {
+				return err
+			}
+			prev = reflect.New(field.Type()).Elem()
+			prev.Set(field)
+			field.Set(val.Elem())
+			return nil
+		})
+		if err != nil {
+			restore()
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		if _, _, name, err := c.resolveKey(key); err == nil {
+			key = name
+		}
+		// The same key can be given more than once using aliases and
+		// only the value from before the patch should be restored.
+		if _, ok := old[key]; !ok {
+			old[key] = patchedValue{prev: prev, created: created}
+		}
+	}
+	if v, ok := c.config.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			restore()
+			return err
+		}
+	}
+	set := make([]string, 0, len(old))
+	for key := range old {
+		c.recordSet(key)
+		set = append(set, key)
+	}
+	sort.Strings(set)
+	c.recordMutation("set", Source{Kind: SourceSet}, set)
+	return nil
+}
+
+// patchedValue is the value of a key from before a PATCH request. If
+// created is not zero then the first created keys of the key path did
+// not exist and the map key at that path is deleted instead.
+type patchedValue struct {
+	prev    reflect.Value
+	created int
+}
+
+// missingKeys returns the length of the shortest part of a key path that
+// does not exist or zero if the whole key path exists.
+func missingKeys(val reflect.Value, keyPath []string, tags tagSet) int {
+	for i := 1; i <= len(keyPath); i++ {
+		if !hasKey(val, keyPath[:i], tags) {
+			return i
+		}
+	}
+	return 0
+}
+
+// deleteMapKey removes the map key at the end of a key path.
+func (c *Config) deleteMapKey(keyPath []string) {
+	if len(keyPath) < 2 {
+		return
+	}
+	m, _, err := findField(c.elem, keyPath[:len(keyPath)-1], c.tagSet())
+	if err != nil {
+		return
+	}
+	m = indirect(m, false)
+	if m.Kind() != reflect.Map || m.IsNil() {
+		return
+	}
+	if k, ok := mapKey(m.Type().Key(), keyPath[len(keyPath)-1]); ok {
+		m.SetMapIndex(k, reflect.Value{})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func httpError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// fieldPath is the location of a struct field in the
+// config struct, see reflect.Value.FieldByIndex.
+type fieldPath struct {
+	index []int
+	field reflect.StructField
+}
+
+// fieldIndex maps every key path that leads only through structs to
+// the field stored at that key. It is built once for the type of the
+// config struct so that the getters do not have to search through the
+// struct fields for every lookup.
+type fieldIndex map[string]fieldPath
+
+// buildIndex will create the field index for a struct type. Each
+// index is only built once and is shared so it must not be changed.
+func buildIndex(typ reflect.Type, tags tagSet) fieldIndex {
+	key := metaKey{typ: typ, tags: tags}
+	if idx, ok := indexCache.Load(key); ok {
+		return idx.(fieldIndex)
+	}
+	idx := make(fieldIndex)
+	if typ.Kind() != reflect.Struct {
+		return idx
+	}
+	idx.add(typ, tags, "", nil, map[reflect.Type]bool{})
+	actual, _ := indexCache.LoadOrStore(key, idx)
+	return actual.(fieldIndex)
+}
+
+func (idx fieldIndex) add(typ reflect.Type, tags tagSet, prefix string, index []int, seen map[reflect.Type]bool) {
+	if seen[typ] {
+		return // recursive types are left to findField
+	}
+	seen[typ] = true
+	defer delete(seen, typ)
+
+	fields := fieldsOf(typ, tags)
+	for i := range fields {
+		fld := &fields[i]
+		fldIndex := append(index[:len(index):len(index)], fld.Index[0])
+		for _, name := range fld.labels {
+			key := escapeKey(name)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			// findField uses the first field with a matching
+			// label so later fields cannot replace it.
+			if _, ok := idx[key]; ok {
+				continue
+			}
+			idx[key] = fieldPath{index: fldIndex, field: fld.StructField}
+			if fld.nested {
+				idx.add(indirectType(fld.Type), tags, key, fldIndex, seen)
+			}
+		}
+	}
+}
+
+// labels returns every name that can be used in a key
+// to find a struct field using the given struct tags.
+func labels(field reflect.StructField, tags []string) []string {
+	names := make([]string, 0, len(tags)+1)
+	for _, tag := range tags {
+		name := tagName(field, tag)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return append(names, field.Name)
+}
+
+// lookup will get the value of a field using the index. Nil pointers to
+// nested structs are treated as zero values in the same way as findField.
+func (idx fieldIndex) lookup(val reflect.Value, key string) (reflect.Value, reflect.StructField, bool) {
+	fp, ok := idx[key]
+	if !ok {
+		return nilval, reflect.StructField{}, false
+	}
+	for i, n := range fp.index {
+		if i > 0 {
+			val = indirect(val, false)
+		}
+		val = val.Field(n)
+	}
+	return val, fp.field, true
+}
+
+// buildIndex will rebuild the field index used by the getters. It
+// should be called whenever the config struct is changed.
+func (c *Config) buildIndex() {
+	if !c.elem.IsValid() {
+		c.index = nil
+		return
+	}
+	c.index = buildIndex(c.elem.Type(), c.tagSet())
+}
+
+// tagSet returns the struct tags used to find fields by their key.
+// These are the tags from SetTagNames, the tag for the config type,
+// and any tags added with AddTagNames. Both the "yaml" and "json" tags
+// are used if the config type has not been set.
+func (c *Config) tagSet() tagSet {
+	if c.tags == "" {
+		return defaultTags
+	}
+	return c.tags
+}
+
+// nameTags returns the struct tags used by keyName. These are the
+// same as tagSet without the "yaml" and "json" tags that are only
+// used to find fields when there is no config type.
+func (c *Config) nameTags() []string {
+	if c.keyTags == nil {
+		return []string{"config"}
+	}
+	return c.keyTags
+}
+
+// setTags will update the struct tags used to find fields after
+// the config type or the list of tag names has changed.
+func (c *Config) setTags() {
+	var names []string
+	add := func(tags ...string) {
+		for _, tag := range tags {
+			if tag != "" && !contains(names, tag) {
+				names = append(names, tag)
+			}
+		}
+	}
+	if c.tagNames == nil {
+		add("config")
+	} else {
+		add(c.tagNames...)
+	}
+	add(c.tag)
+	add(c.extraTags...)
+	c.keyTags = names
+	if c.tag == "" {
+		names = nil
+		add(c.keyTags...)
+		add("yaml", "json")
+	}
+	c.tags = tagSet(strings.Join(names, ","))
+	c.buildIndex()
+}
+
+// SetTagNames will set the struct tags that name config fields in
+// keys, flags, and generated docs, such as "mapstructure" or "koanf"
+// for structs that already use them. Earlier tags are preferred and
+// the tag for the config type is always used after them. The default
+// is the "config" tag. Options like "usage" and "secret" are always
+// read from the "config" tag.
+func SetTagNames(names []string) { c.SetTagNames(names) }
+
+// SetTagNames will set the struct tags that name config fields in
+// keys, flags, and generated docs, such as "mapstructure" or "koanf"
+// for structs that already use them. Earlier tags are preferred and
+// the tag for the config type is always used after them. The default
+// is the "config" tag. Options like "usage" and "secret" are always
+// read from the "config" tag.
+func (c *Config) SetTagNames(names []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tagNames = append([]string{}, names...)
+	c.setTags()
+	c.clearFileCache()
+}
+
+// AddTagNames will register more struct tags, such as "toml" or
+// "mapstructure", that are used to find fields by their key. The tags
+// are checked after the tags from SetTagNames and the tag for the
+// config type.
+func AddTagNames(names ...string) { c.AddTagNames(names...) }
+
+// AddTagNames will register more struct tags, such as "toml" or
+// "mapstructure", that are used to find fields by their key. The tags
+// are checked after the tags from SetTagNames and the tag for the
+// config type.
+func (c *Config) AddTagNames(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range names {
+		if name != "" && !contains(c.extraTags, name) {
+			c.extraTags = append(c.extraTags, name)
+		}
+	}
+	c.setTags()
+	c.clearFileCache()
+}
+
+// findField is the same as the findField function but it will use
+// the field index when the key is only made up of struct fields.
+func (c *Config) findField(key string) (reflect.Value, reflect.StructField, error) {
+	if val, fld, ok := c.index.lookup(c.elem, key); ok {
+		return val, fld, nil
+	}
+	// Use the index for the longest part of the
+	// key that only leads through structs.
+	for i := strings.LastIndexByte(key, '.'); i > 0; i = strings.LastIndexByte(key[:i], '.') {
+		if val, _, ok := c.index.lookup(c.elem, key[:i]); ok {
+			return findFieldFrom(indirect(val, false), splitKey(key[i+1:]), splitKey(key[:i]), c.tagSet())
+		}
+	}
+	return findField(c.elem, splitKey(key), c.tagSet())
+}
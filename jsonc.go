@@ -0,0 +1,79 @@
+package config
+
+import "encoding/json"
+
+// jsoncUnmarshal will decode json that may have comments and
+// trailing commas.
+func jsoncUnmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(stripJSONC(b), v)
+}
+
+func jsoncUnmarshalStrict(b []byte, v interface{}) error {
+	return jsonUnmarshalStrict(stripJSONC(b), v)
+}
+
+// stripJSONC will remove the comments and trailing commas from json so
+// that it can be decoded by the standard library. Everything that is
+// removed is replaced with spaces so that the line and column numbers
+// in error messages stay the same.
+func stripJSONC(b []byte) []byte {
+	out := make([]byte, len(b))
+	copy(out, b)
+	var (
+		instr bool
+		comma = -1 // index of the last comma outside of a string
+	)
+	for i := 0; i < len(out); i++ {
+		ch := out[i]
+		if instr {
+			switch ch {
+			case '\\':
+				i++
+			case '"':
+				instr = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			instr = true
+			comma = -1
+		case ',':
+			comma = i
+		case '}', ']':
+			if comma >= 0 {
+				out[comma] = ' '
+			}
+			comma = -1
+		case '/':
+			if i+1 >= len(out) {
+				comma = -1
+				break
+			}
+			switch out[i+1] {
+			case '/':
+				for ; i < len(out) && out[i] != '\n'; i++ {
+					out[i] = ' '
+				}
+			case '*':
+				out[i], out[i+1] = ' ', ' '
+				for i += 2; i < len(out); i++ {
+					if out[i] == '*' && i+1 < len(out) && out[i+1] == '/' {
+						out[i], out[i+1] = ' ', ' '
+						i++
+						break
+					}
+					if out[i] != '\n' {
+						out[i] = ' '
+					}
+				}
+			default:
+				comma = -1
+			}
+		case ' ', '\t', '\n', '\r':
+		default:
+			comma = -1
+		}
+	}
+	return out
+}
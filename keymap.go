@@ -0,0 +1,154 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// SetKeyMapper will set a function used to normalize keys. When a key
+// does not match a struct field exactly, each part of the key and each
+// of the field's names are passed through the mapper and compared. This
+// allows a struct tagged with snake_case names to be queried using
+// kebab-case keys without adding more tags. See NormalizeKey.
+//
+//	config.SetKeyMapper(config.NormalizeKey)
+//	config.GetInt("db.max-conns") // finds `config:"max_conns"`
+func SetKeyMapper(mapper func(string) string) { c.SetKeyMapper(mapper) }
+
+// SetKeyMapper will set a function used to normalize keys. When a key
+// does not match a struct field exactly, each part of the key and each
+// of the field's names are passed through the mapper and compared. This
+// allows a struct tagged with snake_case names to be queried using
+// kebab-case keys without adding more tags. See NormalizeKey.
+func (c *Config) SetKeyMapper(mapper func(string) string) {
+	c.mu.Lock()
+	c.keyMapper = mapper
+	c.mu.Unlock()
+}
+
+// NormalizeKey is a key mapper that removes dashes and underscores and
+// converts a key to lower case so that kebab-case, snake_case, and
+// camelCase names are all the same.
+func NormalizeKey(key string) string {
+	var b strings.Builder
+	b.Grow(len(key))
+	for _, r := range key {
+		if r == '-' || r == '_' {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// lookupKey returns the key that an alias points to rewritten by the
+// key mapper. This is how keys given to the getters, Set, and the http
+// handler are resolved.
+func (c *Config) lookupKey(key string) string {
+	return c.mapKey(c.aliasKey(key))
+}
+
+// mapKey will rewrite a key using the key mapper so that every part of
+// the key that names a struct field uses one of the field's names. Keys
+// are returned unchanged if there is no key mapper or if the key already
+// matches a field.
+func (c *Config) mapKey(key string) string {
+	if c.keyMapper == nil || !c.elem.IsValid() {
+		return key
+	}
+	if _, ok := c.index[key]; ok {
+		return key
+	}
+	var (
+		parts = splitKey(key)
+		typ   = c.elem.Type()
+	)
+loop:
+	for i, part := range parts {
+		typ = indirectType(typ)
+		switch typ.Kind() {
+		case reflect.Struct:
+			fld := c.mappedField(typ, part)
+			if fld == nil {
+				break loop
+			}
+			if !fld.label(part) {
+				parts[i] = fld.labels[0]
+			}
+			typ = fld.Type
+		case reflect.Map, reflect.Slice, reflect.Array:
+			// map keys and indices are not mapped
+			typ = typ.Elem()
+		default:
+			break loop
+		}
+	}
+	return joinKey(parts)
+}
+
+// mappedField will find the field with a name that
+// is the same as the key once they are both mapped.
+func (c *Config) mappedField(typ reflect.Type, key string) *fieldInfo {
+	fields := fieldsOf(typ, c.tagSet())
+	for i := range fields {
+		if fields[i].label(key) {
+			return &fields[i]
+		}
+	}
+	mapped := c.keyMapper(key)
+	for i := range fields {
+		for _, l := range fields[i].labels {
+			if c.keyMapper(l) == mapped {
+				return &fields[i]
+			}
+		}
+	}
+	return nil
+}
+
+// splitKey will split a key path on every dot. Dots that are part of a
+// name are escaped with a backslash as in "hosts.example\.com" and a
+// literal backslash is written as "\\".
+func splitKey(key string) []string {
+	if strings.IndexByte(key, '\\') < 0 {
+		return strings.Split(key, ".")
+	}
+	var (
+		parts []string
+		b     strings.Builder
+	)
+	for i := 0; i < len(key); i++ {
+		switch ch := key[i]; {
+		case ch == '\\' && i+1 < len(key) && (key[i+1] == '.' || key[i+1] == '\\'):
+			i++
+			b.WriteByte(key[i])
+		case ch == '.':
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return append(parts, b.String())
+}
+
+// joinKey is the opposite of splitKey.
+func joinKey(parts []string) string {
+	escaped := make([]string, len(parts))
+	for i, p := range parts {
+		escaped[i] = escapeKey(p)
+	}
+	return strings.Join(escaped, ".")
+}
+
+// escapeKey will escape the dots and backslashes in
+// a name so that it can be used as part of a key.
+func escapeKey(name string) string {
+	if !strings.ContainsAny(name, `.\`) {
+		return name
+	}
+	return keyEscaper.Replace(name)
+}
+
+var keyEscaper = strings.NewReplacer(`\`, `\\`, `.`, `\.`)
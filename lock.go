@@ -0,0 +1,99 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrFileLocked is returned when a config file is still locked by another
+// writer after the lock timeout has passed (see SetLockTimeout).
+var ErrFileLocked = errors.New("config file is locked")
+
+// lockPollInterval is how often a locked file is retried.
+const lockPollInterval = 50 * time.Millisecond
+
+// SetLockTimeout will set how long to wait for a config file that is locked
+// by another writer. Config files are locked while they are written by Save
+// and WriteFile and while the edit command is running so that concurrent
+// programs do not corrupt the file. The locks are advisory and use a
+// lock file in the user's cache directory so that no files are left next
+// to the config file. If the timeout passes then ErrFileLocked is
+// returned. By default there is no timeout.
+func SetLockTimeout(timeout time.Duration) { c.SetLockTimeout(timeout) }
+
+// SetLockTimeout will set how long to wait for a config file that is locked
+// by another writer. Config files are locked while they are written by Save
+// and WriteFile and while the edit command is running so that concurrent
+// programs do not corrupt the file. The locks are advisory and use a
+// lock file in the user's cache directory so that no files are left next
+// to the config file. If the timeout passes then ErrFileLocked is
+// returned. By default there is no timeout.
+func (c *Config) SetLockTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	c.lockTimeout = timeout
+	c.mu.Unlock()
+}
+
+// lock will take an exclusive lock on a config file and return
+// a function that releases it.
+func (c *Config) lock(filename string) (func(), error) {
+	c.mu.RLock()
+	timeout := c.lockTimeout
+	c.mu.RUnlock()
+	name, err := lockPath(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		ok, err := lockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not lock %s: %w", filename, err)
+		}
+		if ok {
+			break
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%w: %s", ErrFileLocked, filename)
+		}
+		time.Sleep(lockPollInterval)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// lockPath returns the lock file used for a config file. Lock files are
+// kept in the user's cache directory and named after a hash of the
+// absolute path of the config file. They are never removed because
+// another process may be waiting on the same lock file.
+func lockPath(filename string) (string, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, "config-locks", hex.EncodeToString(sum[:16])+".lock"), nil
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package config
+
+import "os"
+
+// lockFile is a no-op on systems without file locking.
+func lockFile(f *os.File) (bool, error) { return true, nil }
+
+func unlockFile(f *os.File) error { return nil }
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// lockFile will try to take an exclusive lock on a file without
+// blocking. It returns false if the file is locked by someone else.
+func lockFile(f *os.File) (bool, error) {
+	var ol syscall.Overlapped
+	r, _, err := procLockFileEx.Call(
+		f.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately,
+		0, 1, 0,
+		uintptr(unsafe.Pointer(&ol)),
+	)
+	if r != 0 {
+		return true, nil
+	}
+	if err == errorLockViolation || err == syscall.ERROR_IO_PENDING {
+		return false, nil
+	}
+	return false, err
+}
+
+func unlockFile(f *os.File) error {
+	var ol syscall.Overlapped
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
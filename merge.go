@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// MergeStrategy is how slices are combined when
+// reading more than one config file.
+type MergeStrategy int
+
+const (
+	// MergeReplace uses the slice from the config file with the highest
+	// precedence and ignores the rest. This is the default.
+	MergeReplace MergeStrategy = iota
+	// MergeAppend joins the slices from every config file
+	// starting with the file with the highest precedence.
+	MergeAppend
+	// MergeUnion is the same as MergeAppend but
+	// duplicate values are removed.
+	MergeUnion
+)
+
+func (s MergeStrategy) String() string {
+	switch s {
+	case MergeReplace:
+		return "replace"
+	case MergeAppend:
+		return "append"
+	case MergeUnion:
+		return "union"
+	}
+	return fmt.Sprintf("MergeStrategy(%d)", int(s))
+}
+
+// FilePrecedence is the order in which config files take precedence
+// over each other when more than one is read.
+type FilePrecedence int
+
+const (
+	// Descending gives the files that were added first the highest
+	// precedence. Full file paths (see AddFilepath) come before the
+	// files found by joining each path with each filename, so with the
+	// paths "/etc/app" and "." and the filename "config.yml" the order
+	// is "/etc/app/config.yml" then "config.yml". This is the default.
+	Descending FilePrecedence = iota
+	// Ascending is the reverse of Descending so that files
+	// that were added last have the highest precedence.
+	Ascending
+)
+
+// SetFilePrecedence will set which config files have the highest
+// precedence when more than one is read. FilesUsed returns the files
+// in this order.
+func SetFilePrecedence(p FilePrecedence) { c.SetFilePrecedence(p) }
+
+// SetFilePrecedence will set which config files have the highest
+// precedence when more than one is read. FilesUsed returns the files
+// in this order.
+func (c *Config) SetFilePrecedence(p FilePrecedence) {
+	c.mu.Lock()
+	c.precedence = p
+	c.mu.Unlock()
+}
+
+// SetMergeStrategy will set how slices are combined when more than one
+// config file is read. The strategy for one field can be changed with
+// the "merge" struct tag, e.g. `merge:"append"`.
+func SetMergeStrategy(s MergeStrategy) { c.SetMergeStrategy(s) }
+
+// SetMergeStrategy will set how slices are combined when more than one
+// config file is read. The strategy for one field can be changed with
+// the "merge" struct tag, e.g. `merge:"append"`.
+func (c *Config) SetMergeStrategy(s MergeStrategy) {
+	c.mu.Lock()
+	c.mergeStrategy = s
+	c.mu.Unlock()
+}
+
+// MergeFunc merges the value from a config file with a lower
+// precedence (src) into the value that has already been read (dst).
+// The dst value can always be set.
+type MergeFunc func(dst, src reflect.Value) error
+
+// RegisterMerger will set the function used to merge values of a type
+// when more than one config file is read. This replaces the default
+// behavior of only setting values that are zero.
+//
+//	config.RegisterMerger(reflect.TypeOf(Range{}), mergeRanges)
+func RegisterMerger(typ reflect.Type, fn MergeFunc) { c.RegisterMerger(typ, fn) }
+
+// RegisterMerger will set the function used to merge values of a type
+// when more than one config file is read. This replaces the default
+// behavior of only setting values that are zero.
+func (c *Config) RegisterMerger(typ reflect.Type, fn MergeFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mergers == nil {
+		c.mergers = make(map[reflect.Type]MergeFunc)
+	}
+	c.mergers[typ] = fn
+}
+
+// merge will merge src into dst using the merge options that have been
+// set on the config. The keys in keep have been set in dst and will not
+// be replaced even if they are zero values.
+func (c *Config) merge(dst, src reflect.Value, keep map[string]bool) error {
+	m := merger{
+		slices:  c.mergeStrategy,
+		funcs:   c.mergers,
+		keep:    keep,
+		keyName: c.keyName,
+	}
+	return m.merge(dst, src, c.mergeStrategy, "")
+}
+
+// fileKeySet returns the set of keys found in a config file.
+func (c *Config) fileKeySet(raw []byte) map[string]bool {
+	keys := c.fileKeys(raw)
+	set := make(map[string]bool, len(keys))
+	for k := range keys {
+		set[k] = true
+	}
+	return set
+}
+
+// fieldMergeStrategy returns the strategy given in the "merge" tag of
+// a struct field or the default if there is no tag.
+func fieldMergeStrategy(fld reflect.StructField, def MergeStrategy) (MergeStrategy, error) {
+	switch tag := fld.Tag.Get("merge"); tag {
+	case "":
+		return def, nil
+	case "replace":
+		return MergeReplace, nil
+	case "append":
+		return MergeAppend, nil
+	case "union":
+		return MergeUnion, nil
+	default:
+		return def, fmt.Errorf("unknown merge strategy %q for field %s", tag, fld.Name)
+	}
+}
+
+// appendValues returns a new slice with the values of b appended to
+// the values of a. If unique is true then duplicates are left out.
+func appendValues(a, b reflect.Value, unique bool) reflect.Value {
+	res := reflect.MakeSlice(a.Type(), 0, a.Len()+b.Len())
+	for _, s := range []reflect.Value{a, b} {
+		for i := 0; i < s.Len(); i++ {
+			v := s.Index(i)
+			if unique && containsValue(res, v) {
+				continue
+			}
+			res = reflect.Append(res, v)
+		}
+	}
+	return res
+}
+
+func containsValue(s, v reflect.Value) bool {
+	for i := 0; i < s.Len(); i++ {
+		if reflect.DeepEqual(s.Index(i).Interface(), v.Interface()) {
+			return true
+		}
+	}
+	return false
+}
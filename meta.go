@@ -0,0 +1,84 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fieldInfo is a struct field along with the information
+// parsed from its struct tags and type.
+type fieldInfo struct {
+	reflect.StructField
+	// Names used in keys, see labels.
+	labels []string
+	// See isNestedStruct.
+	nested bool
+}
+
+// label returns true if the field can be found using a key.
+func (fi *fieldInfo) label(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, l := range fi.labels {
+		if l == key {
+			return true
+		}
+	}
+	return false
+}
+
+// tagSet is a comma separated list of the struct tags that are used
+// to find the names of fields in keys. It is a string so that it can
+// be used as part of a cache key.
+type tagSet string
+
+// defaultTags are used when the config type has not been set.
+const defaultTags tagSet = "config,yaml,json"
+
+func (ts tagSet) names() []string {
+	if ts == "" {
+		return nil
+	}
+	return strings.Split(string(ts), ",")
+}
+
+// metaKey is a struct type along with the
+// struct tags used to parse its fields.
+type metaKey struct {
+	typ  reflect.Type
+	tags tagSet
+}
+
+var (
+	// Metadata for struct types shared by every Config.
+	fieldCache sync.Map // map[metaKey][]fieldInfo
+	indexCache sync.Map // map[metaKey]fieldIndex
+)
+
+// fieldsOf returns the exported fields of a struct type with labels
+// taken from the given tags. The fields are only parsed once for each
+// type and are shared so they must not be changed.
+func fieldsOf(typ reflect.Type, tags tagSet) []fieldInfo {
+	key := metaKey{typ: typ, tags: tags}
+	if fields, ok := fieldCache.Load(key); ok {
+		return fields.([]fieldInfo)
+	}
+	names := tags.names()
+	n := typ.NumField()
+	fields := make([]fieldInfo, 0, n)
+	for i := 0; i < n; i++ {
+		fld := typ.Field(i)
+		if fld.PkgPath != "" || isIgnored(fld) {
+			continue // unexported or ignored
+		}
+		fields = append(fields, fieldInfo{
+			StructField: fld,
+			labels:      labels(fld, names),
+			nested:      isNestedStruct(fld.Type),
+		})
+	}
+	actual, _ := fieldCache.LoadOrStore(key, fields)
+	return actual.([]fieldInfo)
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrInvalidMigration is returned by RegisterMigration when a
+// migration does not move a config file to a newer version.
+var ErrInvalidMigration = errors.New("migration must move to a newer version")
+
+// versionKey is the top-level key used to store
+// the version of a config file.
+const versionKey = "version"
+
+type migration struct {
+	to int
+	fn func(map[string]interface{}) error
+}
+
+// RegisterMigration will add a function that changes the contents of a
+// config file from one version to another. The version of a config file
+// is stored in the top-level "version" key and files without a version
+// are version zero. When a config file is read, the migrations are run in
+// a chain until the file has the newest version.
+//
+// The config struct should have a top-level version field so that the
+// newest version is written when the config is saved. If the new version
+// is not greater than the old one, ErrInvalidMigration is returned.
+//
+//	config.RegisterMigration(0, 1, func(m map[string]interface{}) error {
+//		m["host"] = m["hostname"]
+//		delete(m, "hostname")
+//		return nil
+//	})
+func RegisterMigration(from, to int, fn func(map[string]interface{}) error) error {
+	return c.RegisterMigration(from, to, fn)
+}
+
+// RegisterMigration will add a function that changes the contents of a
+// config file from one version to another, see the RegisterMigration
+// function for details.
+func (c *Config) RegisterMigration(from, to int, fn func(map[string]interface{}) error) error {
+	if to <= from {
+		return fmt.Errorf("%w: %d to %d", ErrInvalidMigration, from, to)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.migrations == nil {
+		c.migrations = make(map[int]migration)
+	}
+	c.migrations[from] = migration{to: to, fn: fn}
+	if to > c.version {
+		c.version = to
+	}
+	c.clearFileCache()
+	return nil
+}
+
+// migrate will run the migrations for a config file
+// that has an older version.
+func (c *Config) migrate(filename string, raw []byte) ([]byte, error) {
+	if len(c.migrations) == 0 || c.unmarshal == nil || c.marshal == nil {
+		return raw, nil
+	}
+	var m map[string]interface{}
+	if err := c.unmarshal(raw, &m); err != nil {
+		return raw, nil
+	}
+	m = normalizeMap(m).(map[string]interface{})
+	version, ok := toInt(m[versionKey])
+	if !ok {
+		version = 0
+	}
+	if version >= c.version {
+		return raw, nil
+	}
+	for version < c.version {
+		mig, ok := c.migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("%s: no migration from version %d", filename, version)
+		}
+		if mig.to <= version {
+			// Should not happen since RegisterMigration checks
+			// this, but a loop here would never end.
+			return nil, fmt.Errorf("%s: %w: %d to %d", filename, ErrInvalidMigration, version, mig.to)
+		}
+		if err := mig.fn(m); err != nil {
+			return nil, fmt.Errorf("%s: migrating from version %d: %w", filename, version, err)
+		}
+		version = mig.to
+	}
+	m[versionKey] = version
+	return c.marshal(m)
+}
+
+// setVersion will set the top-level version field of a config
+// struct to the newest version from the migrations.
+func (c *Config) setVersion(val reflect.Value) {
+	if len(c.migrations) == 0 {
+		return
+	}
+	fld, ok := c.fieldByLabel(val.Type(), versionKey)
+	if !ok {
+		return
+	}
+	v := val.FieldByIndex(fld.Index)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(c.version))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(c.version))
+	}
+}
+
+func toInt(v interface{}) (int, bool) {
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(val.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(val.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return int(val.Float()), true
+	}
+	return 0, false
+}
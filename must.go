@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrMissingValue is used by the Must getters when a key has not been
+// given a value by a config file, environment variable, or default.
+var ErrMissingValue = errors.New("missing value")
+
+// mustError is the error used when a Must getter panics. It names the
+// key and every config file that was searched.
+func (c *Config) mustError(key string, err error) error {
+	files := c.allPossibleFiles()
+	searched := "no config files"
+	if len(files) > 0 {
+		searched = strings.Join(files, ", ")
+	}
+	return fmt.Errorf("config: could not get %q (searched %s): %w", key, searched, err)
+}
+
+// MustGet is the same as Get but it panics if the key does not exist or
+// has an empty value. This is meant for values that are required when a
+// program starts.
+func MustGet(key string) interface{} { return c.MustGet(key) }
+
+// MustGet is the same as Get but it panics if the key does not exist or
+// has an empty value. This is meant for values that are required when a
+// program starts.
+func (c *Config) MustGet(key string) interface{} {
+	val, err := c.GetErr(key)
+	c.must(key, val == nil || reflect.ValueOf(val).IsZero(), err)
+	return val
+}
+
+// MustGetString is the same as GetString but it panics if the key
+// does not exist or has an empty value.
+func MustGetString(key string) string { return c.MustGetString(key) }
+
+// MustGetString is the same as GetString but it panics if the key
+// does not exist or has an empty value.
+func (c *Config) MustGetString(key string) string {
+	s, err := c.GetStringErr(key)
+	c.must(key, s == "", err)
+	return s
+}
+
+// MustGetInt is the same as GetInt but it panics if the key
+// does not exist or has an empty value.
+func MustGetInt(key string) int { return c.MustGetInt(key) }
+
+// MustGetInt is the same as GetInt but it panics if the key
+// does not exist or has an empty value.
+func (c *Config) MustGetInt(key string) int {
+	i, err := c.GetIntErr(key)
+	c.must(key, i == 0, err)
+	return i
+}
+
+// MustGetInt64 is the same as GetInt64 but it panics if the key
+// does not exist or has an empty value.
+func MustGetInt64(key string) int64 { return c.MustGetInt64(key) }
+
+// MustGetInt64 is the same as GetInt64 but it panics if the key
+// does not exist or has an empty value.
+func (c *Config) MustGetInt64(key string) int64 {
+	i, err := c.GetInt64Err(key)
+	c.must(key, i == 0, err)
+	return i
+}
+
+// MustGetUint is the same as GetUint but it panics if the key
+// does not exist or has an empty value.
+func MustGetUint(key string) uint { return c.MustGetUint(key) }
+
+// MustGetUint is the same as GetUint but it panics if the key
+// does not exist or has an empty value.
+func (c *Config) MustGetUint(key string) uint {
+	u, err := c.GetUintErr(key)
+	c.must(key, u == 0, err)
+	return u
+}
+
+// MustGetFloat is the same as GetFloat but it panics if the key
+// does not exist or has an empty value.
+func MustGetFloat(key string) float64 { return c.MustGetFloat(key) }
+
+// MustGetFloat is the same as GetFloat but it panics if the key
+// does not exist or has an empty value.
+func (c *Config) MustGetFloat(key string) float64 {
+	f, err := c.GetFloatErr(key)
+	c.must(key, f == 0, err)
+	return f
+}
+
+// must panics if err is not nil or the value is empty.
+func (c *Config) must(key string, empty bool, err error) {
+	if err == nil && empty {
+		err = ErrMissingValue
+	}
+	if err != nil {
+		panic(c.mustError(key, err))
+	}
+}
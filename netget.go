@@ -0,0 +1,169 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+)
+
+var (
+	ipType  = reflect.TypeOf(net.IP(nil))
+	urlType = reflect.TypeOf(url.URL{})
+)
+
+// GetIP will get an IP address from a key. Fields that are strings are
+// parsed and fields that are already a net.IP are returned as is. A nil
+// IP is returned if the value is not a valid IP address.
+func GetIP(key string) net.IP { return c.GetIP(key) }
+
+// GetIP will get an IP address from a key. Fields that are strings are
+// parsed and fields that are already a net.IP are returned as is. A nil
+// IP is returned if the value is not a valid IP address.
+func (c *Config) GetIP(key string) net.IP {
+	ip, _ := c.GetIPErr(key)
+	return ip
+}
+
+// GetIPErr is the same as GetIP but it returns an
+// error when the value is not a valid IP address.
+func GetIPErr(key string) (net.IP, error) { return c.GetIPErr(key) }
+
+// GetIPErr is the same as GetIP but it returns an
+// error when the value is not a valid IP address.
+func (c *Config) GetIPErr(key string) (net.IP, error) {
+	val, err := c.getIndirect(key)
+	if err != nil {
+		return nil, err
+	}
+	if val.Type() == ipType {
+		return val.Interface().(net.IP), nil
+	}
+	s, err := c.textValue(key, val)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%s: %w", key, &net.ParseError{Type: "IP address", Text: s})
+	}
+	return ip, nil
+}
+
+// GetURL will get an absolute URL from a key. Fields that are strings
+// are parsed and fields that are already a url.URL are copied. A nil URL
+// is returned if the value is not a valid URL or does not have a scheme.
+func GetURL(key string) *url.URL { return c.GetURL(key) }
+
+// GetURL will get an absolute URL from a key. Fields that are strings
+// are parsed and fields that are already a url.URL are copied. A nil URL
+// is returned if the value is not a valid URL or does not have a scheme.
+func (c *Config) GetURL(key string) *url.URL {
+	u, _ := c.GetURLErr(key)
+	return u
+}
+
+// GetURLErr is the same as GetURL but it returns an error
+// when the value is not a valid URL or does not have a scheme.
+func GetURLErr(key string) (*url.URL, error) { return c.GetURLErr(key) }
+
+// GetURLErr is the same as GetURL but it returns an error
+// when the value is not a valid URL or does not have a scheme.
+func (c *Config) GetURLErr(key string) (*url.URL, error) {
+	val, err := c.getIndirect(key)
+	if err != nil {
+		return nil, err
+	}
+	if val.Type() == urlType {
+		u := val.Interface().(url.URL)
+		return &u, nil
+	}
+	s, err := c.textValue(key, val)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	if !u.IsAbs() {
+		return nil, fmt.Errorf("%s: %q is not an absolute URL", key, s)
+	}
+	return u, nil
+}
+
+// GetHostPort will split a "host:port" address from a key into its host
+// and port. Empty strings and a port of zero are returned if the value
+// is not an address with a port number.
+//
+//	host, port := config.GetHostPort("db.addr") // "localhost", 5432
+func GetHostPort(key string) (host string, port int) { return c.GetHostPort(key) }
+
+// GetHostPort will split a "host:port" address from a key into its host
+// and port. Empty strings and a port of zero are returned if the value
+// is not an address with a port number.
+func (c *Config) GetHostPort(key string) (host string, port int) {
+	host, port, _ = c.GetHostPortErr(key)
+	return host, port
+}
+
+// GetHostPortErr is the same as GetHostPort but it returns an
+// error when the value is not an address with a port number.
+func GetHostPortErr(key string) (host string, port int, err error) {
+	return c.GetHostPortErr(key)
+}
+
+// GetHostPortErr is the same as GetHostPort but it returns an
+// error when the value is not an address with a port number.
+func (c *Config) GetHostPortErr(key string) (host string, port int, err error) {
+	val, err := c.getIndirect(key)
+	if err != nil {
+		return "", 0, err
+	}
+	s, err := c.textValue(key, val)
+	if err != nil {
+		return "", 0, err
+	}
+	host, p, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", 0, fmt.Errorf("%s: %w", key, err)
+	}
+	port, err = strconv.Atoi(p)
+	if err != nil || port < 0 || port > 65535 {
+		return "", 0, fmt.Errorf("%s: invalid port %q in address %q", key, p, s)
+	}
+	return host, port, nil
+}
+
+// getIndirect is the same as get but pointers are followed.
+func (c *Config) getIndirect(key string) (reflect.Value, error) {
+	val, err := c.get(key)
+	if err != nil {
+		return val, err
+	}
+	val = indirect(val, false)
+	if !val.IsValid() {
+		return val, fmt.Errorf("%s: %w", key, ErrFieldNotFound)
+	}
+	return val, nil
+}
+
+// textValue returns the string form of a value that is
+// either a string or an encoding.TextMarshaler.
+func (c *Config) textValue(key string, val reflect.Value) (string, error) {
+	if val.Kind() == reflect.String {
+		return c.autoExpandString(val.String()), nil
+	}
+	if val.CanInterface() {
+		if m, ok := val.Interface().(encoding.TextMarshaler); ok {
+			b, err := m.MarshalText()
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", key, err)
+			}
+			return string(b), nil
+		}
+	}
+	return "", fmt.Errorf("%s: %w: cannot use %s as a string", key, ErrWrongType, val.Type())
+}
@@ -0,0 +1,82 @@
+package config
+
+// Option is used to configure a Config created with New. Each option
+// does the same thing as one of the Config methods so that a Config can
+// be set up without using the package level functions.
+type Option func(*Config) error
+
+// WithType sets the config file type, see SetType.
+func WithType(t string) Option {
+	return func(c *Config) error { return c.SetType(t) }
+}
+
+// WithPaths adds directories to search for config files, see AddPath.
+func WithPaths(paths ...string) Option {
+	return func(c *Config) error {
+		for _, p := range paths {
+			c.AddPath(p)
+		}
+		return nil
+	}
+}
+
+// WithFiles adds the names of config files, see AddFile.
+func WithFiles(names ...string) Option {
+	return func(c *Config) error {
+		for _, name := range names {
+			c.AddFile(name)
+		}
+		return nil
+	}
+}
+
+// WithFilepaths adds full config file paths, see AddFilepath.
+func WithFilepaths(files ...string) Option {
+	return func(c *Config) error {
+		for _, f := range files {
+			c.AddFilepath(f)
+		}
+		return nil
+	}
+}
+
+// WithDefaultDirs adds the default config
+// directories, see UseDefaultDirs.
+func WithDefaultDirs(dirname string) Option {
+	return func(c *Config) error {
+		c.UseDefaultDirs(dirname)
+		return nil
+	}
+}
+
+// WithFS sets the file system used to read config files, see SetFS.
+func WithFS(fs FS) Option {
+	return func(c *Config) error {
+		c.SetFS(fs)
+		return nil
+	}
+}
+
+// WithLogger sets the logger used for warnings, see SetLogger.
+func WithLogger(l Logger) Option {
+	return func(c *Config) error {
+		c.SetLogger(l)
+		return nil
+	}
+}
+
+// WithDecodeHooks adds decode hooks, see AddDecodeHook.
+func WithDecodeHooks(hooks ...DecodeHook) Option {
+	return func(c *Config) error {
+		for _, h := range hooks {
+			c.AddDecodeHook(h)
+		}
+		return nil
+	}
+}
+
+// WithDefaults sets every field to its default
+// value, see InitDefaults.
+func WithDefaults() Option {
+	return func(c *Config) error { return c.InitDefaults() }
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// BindOverrideFlag will add a flag to the flag set that sets config
+// values from "key=value" pairs, usually "--set". The flag can be given
+// more than once and the values are parsed the same way as
+// SetFromString. Values from the flag are set again after config files
+// are read, reloaded or changed so that they override the files,
+// environment variables and defaults.
+//
+//	config.BindOverrideFlag(cmd.Flags(), "set") // --set db.port=5433
+func BindOverrideFlag(set *pflag.FlagSet, name string) { c.BindOverrideFlag(set, name) }
+
+// BindOverrideFlag will add a flag to the flag set that sets config
+// values from "key=value" pairs, usually "--set". The flag can be given
+// more than once and the values are parsed the same way as
+// SetFromString. Values from the flag are set again after config files
+// are read, reloaded or changed so that they override the files,
+// environment variables and defaults.
+//
+//	config.BindOverrideFlag(cmd.Flags(), "set") // --set db.port=5433
+func (c *Config) BindOverrideFlag(set *pflag.FlagSet, name string) {
+	set.Var(&overrideFlag{c: c, name: name}, name, "override a config value (can be given more than once)")
+}
+
+// override is a value given to the flag from BindOverrideFlag.
+type override struct {
+	key, value string
+	// flag is the name of the flag used as the source of the value.
+	flag string
+}
+
+// overrideFlag is the flag from BindOverrideFlag.
+type overrideFlag struct {
+	c      *Config
+	name   string
+	values []string
+}
+
+func (f *overrideFlag) String() string { return strings.Join(f.values, ",") }
+func (f *overrideFlag) Type() string   { return "key=value" }
+
+func (f *overrideFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid override %q: expected key=value", s)
+	}
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+	o := override{key: f.c.mapKey(s[:i]), value: s[i+1:], flag: f.name}
+	if err := f.c.setOverride(o); err != nil {
+		return err
+	}
+	f.c.overrides = append(f.c.overrides, o)
+	f.values = append(f.values, s)
+	f.c.recordMutation("flag", Source{Kind: SourceFlag, Name: o.flag}, []string{o.key})
+	return nil
+}
+
+// setOverride sets the config value of an override and
+// records the flag as its source.
+func (c *Config) setOverride(o override) error {
+	if err := c.setString(c.elem, o.key, o.value); err != nil {
+		return err
+	}
+	if _, _, key, err := c.resolveKey(o.key); err == nil {
+		c.setSource(key, Source{Kind: SourceFlag, Name: o.flag})
+	}
+	return nil
+}
+
+// applyOverrides will set the values from the flag given to
+// BindOverrideFlag again, see readConfigFiles and SetConfig.
+func (c *Config) applyOverrides() error {
+	var errs []string
+	for _, o := range c.overrides {
+		if err := c.setOverride(o); err != nil {
+			errs = append(errs, fmt.Sprintf("flag %s: %s: %v", o.flag, o.key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// reapplyOverrides is the same as applyOverrides
+// but the error is logged.
+func (c *Config) reapplyOverrides() {
+	if err := c.applyOverrides(); err != nil {
+		c.logf("config: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Parse will decode config data of some format ("yaml", "json", "jsonc"
+// or "toml") into a pointer to a struct the same way as ReadConfig. The
+// values in the data replace the values in the struct, other values in
+// the struct are kept, and default values from the "default" tag are
+// used for any fields that are still empty. Environment variables, files,
+// and the package's global config are never used, and the struct is not
+// changed if an error is returned. This makes Parse suitable for testing
+// and fuzzing.
+func Parse(format string, data []byte, into interface{}) error {
+	v := reflect.ValueOf(into)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("config: Parse needs a non-nil pointer to a struct")
+	}
+	c := &Config{logger: nopLogger{}}
+	if err := c.SetType(format); err != nil {
+		return err
+	}
+	c.SetConfig(into)
+
+	if c.jsonc {
+		data = stripJSONC(data)
+	}
+	data, err := c.dropRestricted("", data)
+	if err != nil {
+		return err
+	}
+	cp := reflect.New(c.elem.Type())
+	if err = c.unmarshal(data, cp.Interface()); err != nil {
+		return err
+	}
+	// Keep the old values that are not in the data
+	// without replacing the zero values that are.
+	m := merger{keep: c.fileKeySet(data), keyName: c.keyName}
+	if err = m.merge(cp.Elem(), copyVal(c.elem), MergeReplace, ""); err != nil {
+		return err
+	}
+	if err = setDefaultsFrom(cp.Elem(), c.getTagDefault); err != nil {
+		return err
+	}
+	c.elem.Set(cp.Elem())
+	return nil
+}
+
+// nopLogger is a Logger that does nothing.
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...interface{}) {}
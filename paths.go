@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pathTokens are the tokens that can be used in paths given to AddPath
+// and AddFilepath mapped to the functions that find them.
+var pathTokens = []struct {
+	token string
+	dir   func() (string, error)
+}{
+	{"{userconfig}", os.UserConfigDir},
+	{"{home}", homeDir},
+	{"{cache}", os.UserCacheDir},
+	{"{tmp}", func() (string, error) { return os.TempDir(), nil }},
+}
+
+// expandTokens will replace the directory tokens in
+// a path. Unknown tokens are not changed.
+func expandTokens(p string) (string, error) {
+	replaced := false
+	for _, t := range pathTokens {
+		if !strings.Contains(p, t.token) {
+			continue
+		}
+		dir, err := t.dir()
+		if err != nil {
+			return "", err
+		}
+		p = strings.Replace(p, t.token, dir, -1)
+		replaced = true
+	}
+	if replaced {
+		p = filepath.Clean(filepath.FromSlash(p))
+	}
+	return p, nil
+}
+
+// AddPaths is the same as AddPath but it will add many paths at once.
+// Each path is made absolute and the paths that were added are returned.
+// Empty paths and paths that have already been added are not added and
+// an error listing them is returned.
+func AddPaths(paths ...string) ([]string, error) { return c.AddPaths(paths...) }
+
+// AddPaths is the same as AddPath but it will add many paths at once.
+// Each path is made absolute and the paths that were added are returned.
+// Empty paths and paths that have already been added are not added and
+// an error listing them is returned.
+func (c *Config) AddPaths(paths ...string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var (
+		added = make([]string, 0, len(paths))
+		errs  []string
+	)
+	for _, path := range paths {
+		p, err := expandTokens(os.ExpandEnv(path))
+		if strings.TrimSpace(p) == "" && err == nil {
+			errs = append(errs, fmt.Sprintf("%q: empty path", path))
+			continue
+		}
+		if err == nil {
+			p, err = filepath.Abs(p)
+		}
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Sprintf("%q: %v", path, err))
+		case contains(c.paths, p):
+			errs = append(errs, fmt.Sprintf("%q: duplicate path %s", path, p))
+		default:
+			c.paths = append(c.paths, p)
+			added = append(added, p)
+		}
+	}
+	if len(errs) > 0 {
+		return added, fmt.Errorf("could not add paths: %s", strings.Join(errs, "; "))
+	}
+	return added, nil
+}
+
+// AddFiles is the same as AddFile but it will add many filenames at
+// once and return the names that were added. Empty names and names that
+// have already been added are not added and an error listing them is
+// returned.
+func AddFiles(names ...string) ([]string, error) { return c.AddFiles(names...) }
+
+// AddFiles is the same as AddFile but it will add many filenames at
+// once and return the names that were added. Empty names and names that
+// have already been added are not added and an error listing them is
+// returned.
+func (c *Config) AddFiles(names ...string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var (
+		added = make([]string, 0, len(names))
+		errs  []string
+	)
+	for _, name := range names {
+		n := strings.TrimSpace(name)
+		if n != "" {
+			n = filepath.Clean(n)
+		}
+		switch {
+		case n == "":
+			errs = append(errs, fmt.Sprintf("%q: empty filename", name))
+		case contains(c.filenames, n):
+			errs = append(errs, fmt.Sprintf("%q: duplicate filename", name))
+		default:
+			c.filenames = append(c.filenames, n)
+			added = append(added, n)
+		}
+	}
+	if len(errs) > 0 {
+		return added, fmt.Errorf("could not add files: %s", strings.Join(errs, "; "))
+	}
+	return added, nil
+}
+
+func contains(s []string, value string) bool {
+	for _, v := range s {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime"
+)
+
+// ErrInsecurePermissions is returned when a config file with secret values
+// can be read by other users and secure permissions are required.
+var ErrInsecurePermissions = errors.New("config file can be read by other users")
+
+// Logger is used to report problems that do not stop
+// the config from being read.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) { log.Printf(format, v...) }
+
+// SetLogger will set the logger used for warnings. The standard
+// library's logger is used by default.
+func SetLogger(l Logger) { c.SetLogger(l) }
+
+// SetLogger will set the logger used for warnings. The standard
+// library's logger is used by default.
+func (c *Config) SetLogger(l Logger) {
+	c.mu.Lock()
+	c.logger = l
+	c.mu.Unlock()
+}
+
+func (c *Config) logf(format string, v ...interface{}) {
+	if c.logger == nil {
+		stdLogger{}.Printf(format, v...)
+		return
+	}
+	c.logger.Printf(format, v...)
+}
+
+// RequireSecurePermissions will stop config files from being read if they
+// can be read by other users and the config struct has secret values.
+// This has no effect on windows.
+func RequireSecurePermissions(require bool) { c.RequireSecurePermissions(require) }
+
+// RequireSecurePermissions will stop config files from being read if they
+// can be read by other users and the config struct has secret values.
+// This has no effect on windows.
+func (c *Config) RequireSecurePermissions(require bool) {
+	c.mu.Lock()
+	c.securePerms = require
+	c.mu.Unlock()
+}
+
+// checkPermissions will return an error if secure permissions are
+// required and a config file with secrets can be read by other users.
+func (c *Config) checkPermissions(filename string) error {
+	if !c.securePerms || runtime.GOOS == "windows" || !hasSecrets(c.elem.Type()) {
+		return nil
+	}
+	stat, err := c.filesystem().Stat(filename)
+	if err != nil {
+		return err
+	}
+	if perm := stat.Mode().Perm(); perm&0077 != 0 {
+		c.logf("config: %s has secrets but has permissions %#o, it should be 0600", filename, perm)
+		return fmt.Errorf("%s: %w", filename, ErrInsecurePermissions)
+	}
+	return nil
+}
+
+// hasSecrets returns true if a struct type has secret fields.
+func hasSecrets(typ reflect.Type) bool { return hasField(typ, isSecret) }
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Apply will decode a config document that was not read from a file,
+// such as one pushed by a config service, and update the values that it
+// sets. The document must use the current config type (see SetType) and
+// the name is used as the source of the values (see Origin). Aliases,
+// migrations, envonly fields and enums are handled the same way as they
+// are for config files. If the config struct implements Validator, the
+// new values are validated before any are changed and nothing is
+// changed if they are not valid.
+func Apply(name string, raw []byte) error { return c.Apply(name, raw) }
+
+// Apply will decode a config document that was not read from a file,
+// such as one pushed by a config service, and update the values that it
+// sets. The document must use the current config type (see SetType) and
+// the name is used as the source of the values (see Origin). Aliases,
+// migrations, envonly fields and enums are handled the same way as they
+// are for config files. If the config struct implements Validator, the
+// new values are validated before any are changed and nothing is
+// changed if they are not valid.
+func (c *Config) Apply(name string, raw []byte) error {
+	defer c.flushTraces()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if c.unmarshal == nil {
+		return errNoType
+	}
+	// Documents are changed and checked the same way as config files.
+	doc := raw
+	raw, err := c.transform(name, doc)
+	if err != nil {
+		return err
+	}
+	next := reflect.New(c.elem.Type())
+	next.Elem().Set(copyVal(c.elem))
+	done := c.trace(TraceUnmarshal, name)
+	err = c.unmarshal(raw, next.Interface())
+	done(err)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	keys := c.fileKeys(raw)
+	c.setLines(keys, doc)
+	m := merger{keep: c.fileKeySet(raw), keyName: c.keyName}
+	done = c.trace(TraceMerge, name)
+	err = m.merge(next.Elem(), copyVal(c.elem), MergeReplace, "")
+	done(err)
+	if err != nil {
+		return err
+	}
+	if v, ok := next.Interface().(Validator); ok {
+		done = c.trace(TraceValidate, name)
+		err = v.Validate()
+		done(err)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	before := c.snapshot()
+	c.elem.Set(next.Elem())
+	for key, line := range keys {
+		c.setSource(key, Source{Kind: SourceRemote, Name: name, Line: line})
+	}
+	c.reapplyOverrides()
+	c.recordChanges("push", Source{Kind: SourceRemote, Name: name}, before)
+	return nil
+}
+
+// StreamUpdates will connect to a server-sent events stream and apply
+// the data of each event as a config document (see Apply) so that
+// changes from a config service are used as soon as they are pushed.
+// Only events without a type or with the type "config" are used.
+// Updates that cannot be applied are logged (see SetLogger). The stream
+// is opened again after it closes, waiting for the retry time sent by
+// the server or one second. StreamUpdates blocks until the context is
+// done and then returns its error. A nil client uses
+// http.DefaultClient.
+func StreamUpdates(ctx context.Context, client *http.Client, url string) error {
+	return c.StreamUpdates(ctx, client, url)
+}
+
+// StreamUpdates will connect to a server-sent events stream and apply
+// the data of each event as a config document (see Apply) so that
+// changes from a config service are used as soon as they are pushed.
+// Only events without a type or with the type "config" are used.
+// Updates that cannot be applied are logged (see SetLogger). The stream
+// is opened again after it closes, waiting for the retry time sent by
+// the server or one second. StreamUpdates blocks until the context is
+// done and then returns its error. A nil client uses
+// http.DefaultClient.
+func (c *Config) StreamUpdates(ctx context.Context, client *http.Client, url string) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	retry := time.Second
+	for {
+		err := c.streamUpdates(ctx, client, url, &retry)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			c.logf("config.StreamUpdates: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+}
+
+func (c *Config) streamUpdates(ctx context.Context, client *http.Client, url string, retry *time.Duration) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	var (
+		event string
+		data  []string
+		sc    = bufio.NewScanner(resp.Body)
+	)
+	sc.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			if len(data) > 0 && (event == "" || event == "config") {
+				if err = c.Apply(url, []byte(strings.Join(data, "\n"))); err != nil {
+					c.logf("config.StreamUpdates: %v", err)
+				}
+			}
+			event, data = "", nil
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				*retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,35 @@
+package config
+
+import "errors"
+
+// ErrReadOnly is returned when changing config values or
+// writing config files after SetReadOnly has been called.
+var ErrReadOnly = errors.New("config is read-only")
+
+// SetReadOnly will freeze the config values. While the config is
+// read-only, Set, SetFromString, Unset, LoadFlatMap, Save, WriteFile
+// and the http handler return ErrReadOnly and changes found by Watch
+// are ignored. ReadConfig and Reload can still be called to reload
+// the config explicitly.
+func SetReadOnly(readOnly bool) { c.SetReadOnly(readOnly) }
+
+// SetReadOnly will freeze the config values. While the config is
+// read-only, Set, SetFromString, Unset, LoadFlatMap, Save, WriteFile
+// and the http handler return ErrReadOnly and changes found by Watch
+// are ignored. ReadConfig and Reload can still be called to reload
+// the config explicitly.
+func (c *Config) SetReadOnly(readOnly bool) {
+	c.mu.Lock()
+	c.readOnly = readOnly
+	c.mu.Unlock()
+}
+
+// IsReadOnly returns true if the config is read-only, see SetReadOnly.
+func IsReadOnly() bool { return c.IsReadOnly() }
+
+// IsReadOnly returns true if the config is read-only, see SetReadOnly.
+func (c *Config) IsReadOnly() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.readOnly
+}
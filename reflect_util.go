@@ -1,61 +1,239 @@
 package config
 
 import (
+	"encoding"
 	"errors"
+	"fmt"
+	"math"
 	"reflect"
-	"strings"
 )
 
 func isZero(val reflect.Value) bool {
-	return reflect.DeepEqual(
-		val.Interface(),
-		reflect.Zero(val.Type()).Interface(),
-	)
+	return val.IsZero()
 }
 
+// hasField returns true if match returns true for any exported field
+// of a struct type or of the structs nested in it. The fields of ignored
+// nested structs are not checked. Each type is only visited once so that
+// recursive types do not loop forever.
+func hasField(typ reflect.Type, match func(reflect.StructField) bool) bool {
+	return hasFieldSeen(typ, match, make(map[reflect.Type]bool))
+}
+
+func hasFieldSeen(typ reflect.Type, match func(reflect.StructField) bool, seen map[reflect.Type]bool) bool {
+	typ = indirectType(typ)
+	if typ.Kind() != reflect.Struct || seen[typ] {
+		return false
+	}
+	seen[typ] = true
+	for i := 0; i < typ.NumField(); i++ {
+		fld := typ.Field(i)
+		if fld.PkgPath != "" {
+			continue
+		}
+		if match(fld) {
+			return true
+		}
+		if !isIgnored(fld) && isNestedStruct(fld.Type) && hasFieldSeen(fld.Type, match, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkFunc is called with the key, struct field, and value
+// of every field in a config struct.
+type walkFunc func(key string, field reflect.StructField, value reflect.Value) error
+
+// walk will call fn for every exported field of a struct. Nested
+// structs are walked recursively instead of being passed to fn.
+func (c *Config) walk(val reflect.Value, prefix string, fn walkFunc) error {
+	return c.walkPath(val, prefix, fn, make(map[reflect.Type]bool))
+}
+
+// walkPath walks the fields of a struct. The struct types on the current
+// path are kept in stack and pointers to those types are skipped so that
+// recursive types are not walked forever.
+func (c *Config) walkPath(val reflect.Value, prefix string, fn walkFunc, stack map[reflect.Type]bool) error {
+	typ := val.Type()
+	stack[typ] = true
+	defer delete(stack, typ)
+	fields := fieldsOf(typ, c.tagSet())
+	for i := range fields {
+		fld := fields[i].StructField
+		key := c.keyName(fld)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		fldval := val.Field(fld.Index[0])
+		if fields[i].nested {
+			if isRecursive(fld.Type, stack) {
+				continue
+			}
+			if err := c.walkPath(indirect(fldval, false), key, fn, stack); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(key, fld, fldval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRecursive returns true if typ is a pointer to one of the struct
+// types in stack.
+func isRecursive(typ reflect.Type, stack map[reflect.Type]bool) bool {
+	return typ.Kind() == reflect.Ptr && stack[indirectType(typ)]
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// isNestedStruct returns true for struct types, or pointers to struct
+// types, that should be treated as a collection of config values and not
+// as a single value.
+func isNestedStruct(t reflect.Type) bool {
+	t = indirectType(t)
+	return t.Kind() == reflect.Struct &&
+		!t.Implements(textMarshalerType) &&
+		!reflect.PtrTo(t).Implements(textMarshalerType) &&
+		!isValueType(t)
+}
+
+// indirectType returns the type that a pointer type points to.
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// indirect returns the value that a pointer points to. If alloc is true
+// then nil pointers that can be set are given a new value, otherwise a
+// zero value is returned for nil pointers.
+func indirect(v reflect.Value, alloc bool) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if !alloc || !v.CanSet() {
+				return reflect.New(indirectType(v.Type())).Elem()
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// effectiveValue returns the value of a field or its default
+// value if the field has not been set.
+func (c *Config) effectiveValue(key string, fld reflect.StructField, val reflect.Value) reflect.Value {
+	if !isZero(val) {
+		return val
+	}
+	if _, ok := c.getSource(key); ok {
+		return val // explicitly set to zero
+	}
+	def, err := c.getDefaultValue(&fld, &val)
+	if err != nil {
+		return val
+	}
+	return def
+}
+
+// copyVal returns a deep copy of a value. Pointers, slices, maps, and
+// interfaces are copied recursively and a pointer that is found more
+// than once, as in a cycle, is only copied once. Funcs, channels, and
+// unexported struct fields cannot be copied so they are shared with the
+// original value.
 func copyVal(v reflect.Value) reflect.Value {
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
-	var cp reflect.Value
+	if !v.IsValid() {
+		return v
+	}
+	cp := copier{seen: make(map[seenPtr]reflect.Value)}
+	return cp.copy(v)
+}
 
-	switch v.Kind() {
-	case reflect.Array:
-		t := reflect.ArrayOf(v.Len(), v.Type().Elem())
-		cp = reflect.New(t).Elem()
-		reflect.Copy(cp, v)
-	case reflect.Slice:
-		cp = reflect.MakeSlice(v.Type(), v.Len(), v.Cap())
-		reflect.Copy(cp, v)
+type seenPtr struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// copier keeps track of the pointers that have been copied by copyVal.
+type copier struct {
+	seen map[seenPtr]reflect.Value
+}
+
+func (cp *copier) copy(v reflect.Value) reflect.Value {
+	res := reflect.New(v.Type()).Elem()
+	cp.copyInto(res, v)
+	return res
+}
+
+func (cp *copier) copyInto(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		key := seenPtr{ptr: src.Pointer(), typ: src.Type()}
+		if p, ok := cp.seen[key]; ok {
+			dst.Set(p)
+			return
+		}
+		p := reflect.New(src.Type().Elem())
+		cp.seen[key] = p
+		cp.copyInto(p.Elem(), src.Elem())
+		dst.Set(p)
+	case reflect.Interface:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		dst.Set(cp.copy(src.Elem()))
 	case reflect.Struct:
-		cp = reflect.New(v.Type()).Elem()
-
-		for i := 0; i < v.NumField(); i++ {
-			vf := v.Field(i)
-			cf := cp.Field(i)
-			switch vf.Kind() {
-			case reflect.Ptr:
-				if vf.IsNil() {
-					continue
-				}
-				fieldcopy := copyVal(vf.Elem())
-				cf = reflect.New(vf.Elem().Type())
-				cf.Elem().Set(fieldcopy)
-				cp.Field(i).Set(cf)
-			default:
-				cp.Field(i).Set(copyVal(vf))
+		// Unexported fields are copied along with the struct.
+		dst.Set(src)
+		typ := src.Type()
+		for i := 0; i < src.NumField(); i++ {
+			if typ.Field(i).PkgPath != "" {
+				continue
 			}
+			cp.copyInto(dst.Field(i), src.Field(i))
+		}
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		s := reflect.MakeSlice(src.Type(), src.Len(), src.Cap())
+		for i := 0; i < src.Len(); i++ {
+			cp.copyInto(s.Index(i), src.Index(i))
+		}
+		dst.Set(s)
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			cp.copyInto(dst.Index(i), src.Index(i))
 		}
 	case reflect.Map:
-		cp = reflect.MakeMap(v.Type())
-		for _, key := range v.MapKeys() {
-			cp.SetMapIndex(key, copyVal(v.MapIndex(key)))
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		m := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			m.SetMapIndex(iter.Key(), cp.copy(iter.Value()))
 		}
+		dst.Set(m)
 	default:
-		cp = reflect.New(v.Type()).Elem()
-		cp.Set(v)
+		// Funcs and channels are copied by reference.
+		dst.Set(src)
 	}
-	return cp
 }
 
 var errMismatchedTypes = errors.New("mismatched types")
@@ -63,11 +241,36 @@ var errMismatchedTypes = errors.New("mismatched types")
 // merge the fields of src into dst if they have not
 // already been set.
 func merge(dst, src reflect.Value) error {
+	return (&merger{}).merge(dst, src, MergeReplace, "")
+}
+
+// merger merges the values from one config struct into another. Values
+// are only copied into zero values unless a merge strategy says
+// otherwise. Values that were explicitly set, even to a zero value, are
+// never replaced. These are non-nil pointers and the keys in keep.
+type merger struct {
+	// default strategy for slices
+	slices MergeStrategy
+	// custom merge functions, see RegisterMerger
+	funcs map[reflect.Type]MergeFunc
+	// keys that have been set in dst and the
+	// function used to find the key of a field
+	keep    map[string]bool
+	keyName func(reflect.StructField) string
+}
+
+func (m *merger) merge(dst, src reflect.Value, strategy MergeStrategy, path string) error {
+	if fn, ok := m.funcs[dst.Type()]; ok && dst.Type() == src.Type() {
+		return fn(dst, src)
+	}
 	if src.Kind() == reflect.Ptr {
 		src = src.Elem()
 	}
 	if dst.Kind() == reflect.Ptr {
 		dst = dst.Elem()
+		if fn, ok := m.funcs[dst.Type()]; ok && dst.Type() == src.Type() {
+			return fn(dst, src)
+		}
 	}
 	if dst.Kind() != src.Kind() {
 		return errMismatchedTypes
@@ -76,7 +279,12 @@ func merge(dst, src reflect.Value) error {
 	var err error
 	switch dst.Kind() {
 	case reflect.Struct:
+		typ := dst.Type()
 		for i := 0; i < src.NumField(); i++ {
+			fld := typ.Field(i)
+			if fld.PkgPath != "" {
+				continue // unexported
+			}
 			sf := src.Field(i)
 			df := dst.Field(i)
 
@@ -84,6 +292,17 @@ func merge(dst, src reflect.Value) error {
 			if sf.IsZero() {
 				continue
 			}
+			st, err := fieldMergeStrategy(fld, m.slices)
+			if err != nil {
+				return err
+			}
+			fkey := fld.Name
+			if m.keyName != nil {
+				fkey = joinFieldPath(path, m.keyName(fld))
+			}
+			if st == MergeReplace && m.isSet(fld, df, fkey) {
+				continue
+			}
 			if sf.Kind() == reflect.Ptr {
 				// Copy of nil is useless
 				if sf.IsNil() {
@@ -93,7 +312,7 @@ func merge(dst, src reflect.Value) error {
 					df = reflect.New(sf.Elem().Type())
 				}
 			}
-			err = merge(df, sf)
+			err = m.merge(df, sf, st, fkey)
 			if err != nil {
 				return err
 			}
@@ -117,13 +336,29 @@ func merge(dst, src reflect.Value) error {
 					dstval = dstval.Addr()
 				}
 			} else {
-				err = merge(dstval, srcval)
+				// map values cannot be set so
+				// merge into a copy
+				tmp := reflect.New(dstval.Type()).Elem()
+				tmp.Set(dstval)
+				err = m.merge(tmp, srcval, m.slices, path)
 				if err != nil {
 					return err
 				}
+				dstval = tmp
 			}
 			dst.SetMapIndex(key, dstval)
 		}
+	case reflect.Slice:
+		switch strategy {
+		case MergeAppend:
+			dst.Set(appendValues(dst, src, false))
+		case MergeUnion:
+			dst.Set(appendValues(dst, src, true))
+		default:
+			if dst.IsZero() {
+				dst.Set(src)
+			}
+		}
 	default:
 		if dst.IsZero() {
 			dst.Set(src)
@@ -132,69 +367,170 @@ func merge(dst, src reflect.Value) error {
 	return nil
 }
 
+// isSet returns true if a field should be treated as set even if it
+// is a zero value. Nested structs and maps are always merged.
+func (m *merger) isSet(fld reflect.StructField, val reflect.Value, key string) bool {
+	if isNestedStruct(fld.Type) || indirectType(fld.Type).Kind() == reflect.Map {
+		return false
+	}
+	if val.Kind() == reflect.Ptr && !val.IsNil() {
+		return true
+	}
+	return m.keep[key]
+}
+
 func set(obj interface{}, key string, val interface{}) error {
 	objval := reflect.ValueOf(obj).Elem() // BUG: don't use Elem for everything
-	field, err := find(objval, strings.Split(key, "."))
-	if err != nil {
-		return err
-	}
-	if !field.CanSet() {
-		return errors.New("cannot set value")
-	}
-
-	var exptype reflect.Kind
-	switch v := val.(type) {
-	case string:
-		exptype = reflect.String
-		field.SetString(v)
-	case []byte:
-		exptype = reflect.Slice
-		field.SetBytes(v)
-	case bool:
-		exptype = reflect.Bool
-		field.SetBool(v)
-	case complex64:
-		exptype = reflect.Complex64
-		field.SetComplex(complex128(v))
-	case complex128:
-		exptype = reflect.Complex128
-		field.SetComplex(v)
-	case int:
-		exptype = reflect.Int
-		field.SetInt(int64(v))
-	case int8:
-		exptype = reflect.Int8
-		field.SetInt(int64(v))
-	case int32:
-		exptype = reflect.Int32
-		field.SetInt(int64(v))
-	case int64:
-		exptype = reflect.Int64
-		field.SetInt(int64(v))
-	case uint:
-		exptype = reflect.Uint
-		field.SetUint(uint64(v))
-	case uint16:
-		exptype = reflect.Uint16
-		field.SetUint(uint64(v))
-	case uint32:
-		exptype = reflect.Uint32
-		field.SetUint(uint64(v))
-	case uint64:
-		exptype = reflect.Uint64
-		field.SetUint(uint64(v))
-	case float32:
-		exptype = reflect.Float32
-		field.SetFloat(float64(v))
-	case float64:
-		exptype = reflect.Float64
-		field.SetFloat(v)
-	default:
-		field.Set(reflect.ValueOf(val))
+	return setValue(objval, key, val)
+}
+
+func setValue(objval reflect.Value, key string, val interface{}) error {
+	return setValueTags(objval, key, val, defaultTags)
+}
+
+// setValue is the same as the setValue function but it
+// will use the struct tags for the config type.
+func (c *Config) setValue(objval reflect.Value, key string, val interface{}) error {
+	return setValueTags(objval, key, val, c.tagSet())
+}
+
+func setValueTags(objval reflect.Value, key string, val interface{}, tags tagSet) error {
+	return updateField(objval, splitKey(key), tags, func(field reflect.Value, fld reflect.StructField) error {
+		if !field.CanSet() {
+			return errors.New("cannot set value")
+		}
+		if err := checkEnum(key, fld, fmt.Sprint(val)); err != nil {
+			return err
+		}
+
+		// Allow for named types with the same underlying kind
+		// e.g. "type Port int" should accept an int, and for
+		// numbers that fit in the field's type.
+		v, err := convertValue(reflect.ValueOf(val), field.Type())
+		if err != nil {
+			return err
+		}
+		field.Set(v)
 		return nil
+	})
+}
+
+func setString(objval reflect.Value, key, s string) error {
+	return setStringWith(objval, key, s, valueFromString, defaultTags)
+}
+
+// setString is the same as the setString function but it will
+// use the decode hooks and the struct tags for the config type.
+func (c *Config) setString(objval reflect.Value, key, s string) error {
+	return setStringWith(objval, key, s, c.decodeString, c.tagSet())
+}
+
+func setStringWith(objval reflect.Value, key, s string, decode decodeFunc, tags tagSet) error {
+	return updateField(objval, splitKey(key), tags, func(field reflect.Value, fld reflect.StructField) error {
+		if !field.CanSet() {
+			return errors.New("cannot set value")
+		}
+		if err := checkEnum(key, fld, s); err != nil {
+			return err
+		}
+		val, err := decode(s, &fld, &field)
+		if err != nil {
+			return err
+		}
+		if !val.IsValid() {
+			return fmt.Errorf("cannot set %q from a string: %w", key, ErrWrongType)
+		}
+		if val.Type() != field.Type() {
+			val = val.Convert(field.Type())
+		}
+		field.Set(val)
+		return nil
+	})
+}
+
+// convertValue will convert a value to another type if they have the same
+// kind or if they are both numbers that fit in the new type without losing
+// any information. ErrWrongType is returned otherwise.
+func convertValue(v reflect.Value, typ reflect.Type) (reflect.Value, error) {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
 	}
-	if field.Kind() != exptype {
-		return ErrWrongType
+	if !v.IsValid() {
+		return nilval, ErrWrongType
 	}
-	return nil
+	if v.Type().AssignableTo(typ) {
+		return v, nil
+	}
+	if v.Kind() == typ.Kind() && v.Type().ConvertibleTo(typ) {
+		return v.Convert(typ), nil
+	}
+	if !isNumber(v.Kind()) || !isNumber(typ.Kind()) {
+		return nilval, fmt.Errorf("%w: cannot convert %s to %s", ErrWrongType, v.Type(), typ)
+	}
+	res := reflect.New(typ).Elem()
+	var ok bool
+	switch {
+	case isInt(v.Kind()):
+		i := v.Int()
+		switch {
+		case isInt(typ.Kind()):
+			ok = !res.OverflowInt(i)
+		case isUint(typ.Kind()):
+			ok = i >= 0 && !res.OverflowUint(uint64(i))
+		default:
+			ok = !res.OverflowFloat(float64(i))
+		}
+	case isUint(v.Kind()):
+		u := v.Uint()
+		switch {
+		case isInt(typ.Kind()):
+			ok = u <= math.MaxInt64 && !res.OverflowInt(int64(u))
+		case isUint(typ.Kind()):
+			ok = !res.OverflowUint(u)
+		default:
+			ok = !res.OverflowFloat(float64(u))
+		}
+	default:
+		f := v.Float()
+		whole := f == math.Trunc(f)
+		switch {
+		case isInt(typ.Kind()):
+			ok = whole && f >= math.MinInt64 && f < math.MaxInt64 && !res.OverflowInt(int64(f))
+		case isUint(typ.Kind()):
+			ok = whole && f >= 0 && f < math.MaxUint64 && !res.OverflowUint(uint64(f))
+		default:
+			ok = !res.OverflowFloat(f)
+		}
+	}
+	if !ok {
+		return nilval, fmt.Errorf("%w: %v does not fit in %s", ErrWrongType, v.Interface(), typ)
+	}
+	return v.Convert(typ), nil
+}
+
+// convertSlice will copy a slice or an array into a new slice
+// of another type by converting each element with convertValue.
+func convertSlice(v reflect.Value, typ reflect.Type) (reflect.Value, error) {
+	v = indirect(v, false)
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nilval, fmt.Errorf("%w: %s is not a slice", ErrWrongType, v.Type())
+	}
+	res := reflect.MakeSlice(typ, v.Len(), v.Len())
+	for i := 0; i < v.Len(); i++ {
+		e, err := convertValue(v.Index(i), typ.Elem())
+		if err != nil {
+			return nilval, fmt.Errorf("index %d: %w", i, err)
+		}
+		res.Index(i).Set(e)
+	}
+	return res, nil
+}
+
+func isInt(k reflect.Kind) bool  { return k >= reflect.Int && k <= reflect.Int64 }
+func isUint(k reflect.Kind) bool { return k >= reflect.Uint && k <= reflect.Uintptr }
+func isNumber(k reflect.Kind) bool {
+	return isInt(k) || isUint(k) || k == reflect.Float32 || k == reflect.Float64
 }
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"errors"
 	"flag"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/spf13/pflag"
@@ -85,6 +87,48 @@ func TestBindToFlagSet(t *testing.T) {
 	}
 }
 
+func TestBindFlags_Err(t *testing.T) {
+	defer cleanup()
+	type C struct {
+		A     string `config:"a"`
+		Inner struct {
+			M map[string]string `config:"m"`
+		} `config:"inner"`
+		Ch chan int `config:"ch"`
+		B  int      `config:"b"`
+	}
+	SetConfig(&C{})
+	s := flag.NewFlagSet("testing", flag.ContinueOnError)
+	err := BindToFlagSet(s)
+	if !errors.Is(err, ErrUnsupportedFlagType) {
+		t.Fatalf("expected ErrUnsupportedFlagType, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "Inner.M") {
+		t.Errorf("error should name the field path: %v", err)
+	}
+	if s.Lookup("a") == nil || s.Lookup("b") == nil {
+		t.Error("supported fields should still be bound")
+	}
+	ps := pflag.NewFlagSet("testing", pflag.ContinueOnError)
+	if err = BindToPFlagSet(ps); !errors.Is(err, ErrUnsupportedFlagType) {
+		t.Fatalf("expected ErrUnsupportedFlagType, got %v", err)
+	}
+	if ps.Lookup("inner-m") != nil || ps.Lookup("ch") != nil {
+		t.Error("unsupported fields should not be bound")
+	}
+
+	ps = pflag.NewFlagSet("testing", pflag.ContinueOnError)
+	err = BindToPFlagSet(ps, DisableFlag("inner-m"), DisableFlag("ch"))
+	if err != nil {
+		t.Errorf("disabled fields should not return an error: %v", err)
+	}
+
+	c = &Config{}
+	if err = BindToFlagSet(flag.NewFlagSet("", flag.ContinueOnError)); err != errElemNotSet {
+		t.Errorf("expected errElemNotSet, got %v", err)
+	}
+}
+
 func TestCopyVal(t *testing.T) {
 	type Inner struct{ Val string }
 	type T struct {
@@ -183,6 +227,54 @@ func TestCopyVal_MapArr(t *testing.T) {
 	}
 }
 
+func TestCopyVal_Kinds(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+	type T struct {
+		Fn      func() int
+		Ch      chan int
+		Any     interface{}
+		List    []*node
+		Nil     []string
+		private map[string]int
+		Node    *node
+	}
+	loop := &node{Name: "a"}
+	loop.Next = loop
+	ch := make(chan int)
+	a := T{
+		Fn:      func() int { return 1 },
+		Ch:      ch,
+		Any:     map[string]int{"x": 1},
+		List:    []*node{{Name: "b"}},
+		private: map[string]int{"y": 2},
+		Node:    loop,
+	}
+	b := copyVal(reflect.ValueOf(&a)).Interface().(T)
+	if b.Fn == nil || b.Fn() != 1 || b.Ch != ch {
+		t.Error("funcs and channels should be copied by reference")
+	}
+	b.Any.(map[string]int)["x"] = 2
+	if a.Any.(map[string]int)["x"] != 1 {
+		t.Error("values in interfaces should be copied")
+	}
+	b.List[0].Name = "c"
+	if a.List[0].Name != "b" {
+		t.Error("pointers in slices should be copied")
+	}
+	if b.Nil != nil {
+		t.Error("nil slices should stay nil")
+	}
+	if b.private["y"] != 2 {
+		t.Error("unexported fields should be kept")
+	}
+	if b.Node == loop || b.Node.Next != b.Node {
+		t.Error("cycles should be copied")
+	}
+}
+
 func TestMerge(t *testing.T) {
 	type T struct {
 		A    string
@@ -266,3 +358,84 @@ func TestMerge_Err(t *testing.T) {
 		t.Error("expected an error for different types")
 	}
 }
+
+func TestSetValue(t *testing.T) {
+	type Port int
+	type T struct {
+		S    string
+		P    Port
+		I    int
+		Nest struct {
+			F float64 `default:"1.5"`
+		}
+	}
+	v := &T{}
+	val := reflect.ValueOf(v).Elem()
+	if err := setValue(val, "P", 80); err != nil {
+		t.Error(err)
+	}
+	if v.P != 80 {
+		t.Error("named type should be set from its underlying type")
+	}
+	if err := setValue(val, "S", 10); !errors.Is(err, ErrWrongType) {
+		t.Errorf("expected ErrWrongType, got %v", err)
+	}
+	if err := setValue(val, "Nest.F", 2.5); err != nil {
+		t.Error(err)
+	}
+	if v.Nest.F != 2.5 {
+		t.Error("fields with a default value should still be settable")
+	}
+	if err := setString(val, "P", "8080"); err != nil {
+		t.Error(err)
+	}
+	if v.P != 8080 {
+		t.Error("named type should be set from a string")
+	}
+	if err := setString(val, "I", "x"); err == nil {
+		t.Error("expected a parsing error")
+	}
+}
+
+func TestFieldIndex(t *testing.T) {
+	type Inner struct {
+		Host string `config:"host" yaml:"hostname"`
+		Port int
+	}
+	type C struct {
+		Name  string `json:"name"`
+		First string `config:"dup"`
+		Dup   string
+		DB    Inner  `config:"db"`
+		Cache *Inner `config:"cache"`
+		Tags  []Inner
+	}
+	conf := &C{Name: "n", First: "a", Dup: "b", DB: Inner{Host: "h", Port: 1}}
+	val := reflect.ValueOf(conf).Elem()
+	idx := buildIndex(val.Type(), defaultTags)
+	for _, key := range []string{
+		"name", "Name", "dup", "Dup", "db.host", "db.hostname", "DB.Port",
+		"cache.host", "Tags",
+	} {
+		v, fld, ok := idx.lookup(val, key)
+		if !ok {
+			t.Errorf("%q should be in the index", key)
+			continue
+		}
+		exp, expFld, err := findField(val, strings.Split(key, "."), defaultTags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(fld, expFld) || !reflect.DeepEqual(v.Interface(), exp.Interface()) {
+			t.Errorf("index and findField disagree for %q", key)
+		}
+	}
+	if conf.Cache != nil {
+		t.Error("lookup should not allocate nil nested structs")
+	}
+	for _, key := range []string{"Tags.0.host", "missing", "db.missing"} {
+		if _, _, ok := idx.lookup(val, key); ok {
+			t.Errorf("%q should not be in the index", key)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+)
+
+// Reload will read one config file again and update the values that it
+// sets. Values set by config files with a higher precedence are not
+// changed, and keys that were removed from the file fall back to the
+// next config file that sets them. The values are merged with the other
+// config files the same way as ReadConfig, using the merge strategies
+// and mergers (see SetMergeStrategy and RegisterMerger). The other
+// config files are not read again unless they have never been read or
+// could not be cached (see SetTemplates and SetEvaluator), so this is
+// useful when only one of many large config files has changed. The file
+// must be one of the files returned by FilesUsed.
+func Reload(path string) error { return c.Reload(path) }
+
+// Reload will read one config file again and update the values that it
+// sets. Values set by config files with a higher precedence are not
+// changed, and keys that were removed from the file fall back to the
+// next config file that sets them. The values are merged with the other
+// config files the same way as ReadConfig, using the merge strategies
+// and mergers (see SetMergeStrategy and RegisterMerger). The other
+// config files are not read again unless they have never been read or
+// could not be cached (see SetTemplates and SetEvaluator), so this is
+// useful when only one of many large config files has changed. The file
+// must be one of the files returned by FilesUsed.
+func (c *Config) Reload(path string) error {
+	defer c.flushTraces()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.unmarshal == nil {
+		return errNoType
+	}
+	var (
+		ctx   = context.Background()
+		files = existingFiles(c)
+		slot  = -1
+	)
+	for i, f := range files {
+		if filepath.Clean(f) == filepath.Clean(path) {
+			slot = i
+			break
+		}
+	}
+	if slot < 0 {
+		return fmt.Errorf("%w: %s is not in use", ErrNoConfigFile, path)
+	}
+	file := files[slot]
+	defer c.recordChanges("reload", Source{Kind: SourceFile, Name: file}, c.snapshot())
+	defer c.reapplyOverrides()
+
+	var oldKeys map[string]int
+	if cf := c.cacheEntry(file); cf != nil {
+		oldKeys = c.cachedKeys(file, cf.raw)
+	}
+	c.cachemu.Lock()
+	delete(c.files, file)
+	c.cachemu.Unlock()
+
+	done := c.trace(TraceRead, file)
+	raw, err := c.readFileContext(ctx, file)
+	done(err)
+	if err != nil {
+		return err
+	}
+	newKeys := c.cachedKeys(file, raw)
+
+	// The keys of the file are merged again from every config file in
+	// the same order as ReadConfig so that values from files with a
+	// higher precedence, merge strategies and mergers are all used.
+	var (
+		merged  = reflect.New(c.elem.Type()).Elem()
+		seen    = make(map[string]bool)
+		sources = make(map[string]Source)
+	)
+	for i, f := range files {
+		fraw := raw
+		if i != slot {
+			if fraw, err = c.fileContents(ctx, f); err != nil {
+				return err
+			}
+		}
+		cp := reflect.New(c.elem.Type())
+		if i == slot {
+			done = c.trace(TraceUnmarshal, file)
+			err = c.unmarshal(fraw, cp.Interface())
+			done(err)
+		} else {
+			err = c.unmarshal(fraw, cp.Interface())
+		}
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			merged.Set(cp.Elem())
+		} else if err = c.merge(merged, cp, seen); err != nil {
+			return err
+		}
+		for key, line := range c.cachedKeys(f, fraw) {
+			if !seen[key] {
+				seen[key] = true
+				sources[key] = Source{Kind: SourceFile, Name: f, Line: line}
+			}
+		}
+	}
+
+	// Keys removed from the file use the value
+	// from the next files that have them.
+	keys := make(map[string]bool, len(oldKeys)+len(newKeys))
+	for key := range oldKeys {
+		keys[key] = true
+	}
+	for key := range newKeys {
+		keys[key] = true
+	}
+	for key := range keys {
+		if src, ok := sources[key]; ok {
+			c.setSource(key, src)
+		} else {
+			c.deleteSource(key)
+		}
+		if err = c.copyKey(c.elem, merged, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileContents returns the cached contents of a config
+// file or reads the file if it has not been cached.
+func (c *Config) fileContents(ctx context.Context, filename string) ([]byte, error) {
+	if cf := c.cacheEntry(filename); cf != nil {
+		return cf.raw, nil
+	}
+	return c.readFileContext(ctx, filename)
+}
+
+// copyKey will set the value stored at a key in dst
+// to the value stored at the same key in src.
+func (c *Config) copyKey(dst, src reflect.Value, key string) error {
+	keyPath := splitKey(key)
+	val, _, err := findField(src, keyPath, c.tagSet())
+	if err != nil {
+		return err
+	}
+	return updateField(dst, keyPath, c.tagSet(), func(field reflect.Value, _ reflect.StructField) error {
+		field.Set(val)
+		return nil
+	})
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// ErrRequiredFile is returned by ReadConfig in a FileError
+// when a config file marked as Required does not exist.
+var ErrRequiredFile = errors.New("required config file not found")
+
+// FileRequirement marks a file given to AddFile or AddFilepath as
+// required or optional. By default ReadConfig returns ErrNoConfigFile
+// only when none of the config files exist.
+type FileRequirement int
+
+const (
+	// Required files must exist. ReadConfig returns a FileError with
+	// ErrRequiredFile for every required file that does not exist. A
+	// filename given to AddFile is required to be in at least one of
+	// the search paths.
+	Required FileRequirement = iota + 1
+	// Optional files are skipped when they do not exist. ReadConfig
+	// does not return ErrNoConfigFile when every config file is
+	// optional or required.
+	Optional
+)
+
+func (r FileRequirement) String() string {
+	switch r {
+	case Required:
+		return "required"
+	case Optional:
+		return "optional"
+	}
+	return fmt.Sprintf("FileRequirement(%d)", int(r))
+}
+
+func (c *Config) setRequirement(reqs *map[string]FileRequirement, name string, req []FileRequirement) {
+	if len(req) == 0 {
+		return
+	}
+	if *reqs == nil {
+		*reqs = make(map[string]FileRequirement)
+	}
+	(*reqs)[name] = req[len(req)-1]
+}
+
+// missingRequired returns an error for each
+// required file that does not exist.
+func (c *Config) missingRequired() []*FileError {
+	var errs []*FileError
+	if c.flagFile != "" {
+		if !c.fileExists(c.flagFile) {
+			errs = append(errs, &FileError{File: c.flagFile, Err: ErrRequiredFile})
+		}
+		if c.flagFileMode == FileFlagOnly {
+			return errs
+		}
+	}
+	for _, p := range c.filepaths {
+		if c.pathReqs[p] == Required && !c.fileExists(p) {
+			errs = append(errs, &FileError{File: p, Err: ErrRequiredFile})
+		}
+	}
+	for _, name := range c.filenames {
+		if c.nameReqs[name] != Required {
+			continue
+		}
+		found := false
+		for _, p := range c.paths {
+			if c.fileExists(filepath.Join(p, name)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, &FileError{
+				File: name,
+				Err:  fmt.Errorf("%w in %v", ErrRequiredFile, c.paths),
+			})
+		}
+	}
+	return errs
+}
+
+// allOptional returns true if every config file
+// has been marked as required or optional.
+func (c *Config) allOptional() bool {
+	if len(c.filepaths) == 0 && len(c.filenames) == 0 {
+		return false
+	}
+	for _, p := range c.filepaths {
+		if c.pathReqs[p] == 0 {
+			return false
+		}
+	}
+	for _, name := range c.filenames {
+		if c.nameReqs[name] == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrUnexportedField is returned when struct tags are used on an
+// unexported field and strict tags are turned on (see SetStrictTags).
+var ErrUnexportedField = errors.New("struct tags on unexported field")
+
+// SetStrictTags will make InitDefaults, DefaultsCheck, ReadConfig, and
+// the flag binding functions return ErrUnexportedField if any unexported
+// fields of the config struct have struct tags. Unexported fields are
+// always skipped so their tags are most likely a mistake.
+func SetStrictTags(strict bool) { c.SetStrictTags(strict) }
+
+// SetStrictTags will make InitDefaults, DefaultsCheck, ReadConfig, and
+// the flag binding functions return ErrUnexportedField if any unexported
+// fields of the config struct have struct tags. Unexported fields are
+// always skipped so their tags are most likely a mistake.
+func (c *Config) SetStrictTags(strict bool) {
+	c.mu.Lock()
+	c.strictTags = strict
+	c.mu.Unlock()
+}
+
+// checkTags returns ErrUnexportedField listing every unexported
+// field with struct tags if strict tags are turned on.
+func (c *Config) checkTags() error {
+	if !c.strictTags || c.elem.Kind() == reflect.Invalid {
+		return nil
+	}
+	paths := taggedUnexported(c.elem.Type(), "", nil)
+	if len(paths) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnexportedField, strings.Join(paths, ", "))
+}
+
+func taggedUnexported(typ reflect.Type, prefix string, paths []string) []string {
+	return taggedUnexportedSeen(typ, prefix, paths, make(map[reflect.Type]bool))
+}
+
+func taggedUnexportedSeen(typ reflect.Type, prefix string, paths []string, seen map[reflect.Type]bool) []string {
+	typ = indirectType(typ)
+	if seen[typ] {
+		return paths // recursive types are only checked once
+	}
+	seen[typ] = true
+	for i := 0; i < typ.NumField(); i++ {
+		fld := typ.Field(i)
+		path := joinFieldPath(prefix, fld.Name)
+		if fld.PkgPath != "" {
+			if fld.Tag != "" {
+				paths = append(paths, path)
+			}
+			continue
+		}
+		if isNestedStruct(fld.Type) {
+			paths = taggedUnexportedSeen(fld.Type, path, paths, seen)
+		}
+	}
+	return paths
+}
+
+// isEnvOnly returns true for fields with the "envonly" option in the
+// config tag. These fields are never read from config files.
+func isEnvOnly(fld reflect.StructField) bool { return hasOption(fld, "envonly") }
+
+// isFileOnly returns true for fields with the "fileonly" option in the
+// config tag. These fields are never read from environment variables
+// or command line flags.
+func isFileOnly(fld reflect.StructField) bool { return hasOption(fld, "fileonly") }
+
+// isIgnored returns true for fields with the tag `config:"-"`. These
+// fields are never read from config files, defaults, environment
+// variables, or flags and are left out of keys, docs, and output.
+func isIgnored(fld reflect.StructField) bool { return tagName(fld, "config") == "-" }
+
+// hasIgnored returns true if a struct type has any ignored fields.
+func hasIgnored(typ reflect.Type) bool { return hasField(typ, isIgnored) }
+
+// ignoredLabel returns true if a key from a config file or marshaled
+// config struct is the name of an ignored field in one of the other
+// struct tags.
+func (c *Config) ignoredLabel(typ reflect.Type, key string) bool {
+	for i := 0; i < typ.NumField(); i++ {
+		fld := typ.Field(i)
+		if fld.PkgPath != "" || !isIgnored(fld) {
+			continue
+		}
+		if strings.EqualFold(key, fld.Name) {
+			return true
+		}
+		for _, l := range labels(fld, c.tagSet().names()) {
+			if l == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasEnvOnly returns true if a struct type has any envonly fields.
+func hasEnvOnly(typ reflect.Type) bool { return hasField(typ, isEnvOnly) }
+
+// dropRestricted will remove the values of envonly and
+// ignored fields from a config file before it is decoded.
+func (c *Config) dropRestricted(filename string, raw []byte) ([]byte, error) {
+	if c.unmarshal == nil || c.marshal == nil || !(hasEnvOnly(c.elem.Type()) || hasIgnored(c.elem.Type())) {
+		return raw, nil
+	}
+	var m map[string]interface{}
+	if err := c.unmarshal(raw, &m); err != nil {
+		return raw, nil
+	}
+	m = normalizeMap(m).(map[string]interface{})
+	if !c.dropKeys(filename, m, c.elem.Type(), "") {
+		return raw, nil
+	}
+	return c.marshal(m)
+}
+
+func (c *Config) dropKeys(filename string, m map[string]interface{}, typ reflect.Type, prefix string) (changed bool) {
+	for k, v := range m {
+		fld, ok := c.fieldByLabel(typ, k)
+		if !ok {
+			if c.ignoredLabel(typ, k) {
+				delete(m, k)
+				changed = true
+			}
+			continue
+		}
+		key := joinFieldPath(prefix, k)
+		if isEnvOnly(fld) {
+			c.logf("config: %s: ignoring %q, it can only be set from the environment", filename, key)
+			delete(m, k)
+			changed = true
+			continue
+		}
+		if sub, ok := v.(map[string]interface{}); ok && isNestedStruct(fld.Type) {
+			if c.dropKeys(filename, sub, indirectType(fld.Type), key) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// stripIgnored will remove the values of ignored fields
+// from a config struct that has been marshaled.
+func (c *Config) stripIgnored(raw []byte) []byte {
+	if c.unmarshal == nil || c.marshalIndent == nil || !c.elem.IsValid() || !hasIgnored(c.elem.Type()) {
+		return raw
+	}
+	var m map[string]interface{}
+	if err := c.unmarshal(raw, &m); err != nil {
+		return raw
+	}
+	m = normalizeMap(m).(map[string]interface{})
+	if !c.dropIgnored(m, indirectType(c.elem.Type())) {
+		return raw
+	}
+	res, err := c.marshalIndent(m, "", "  ")
+	if err != nil {
+		return raw
+	}
+	return res
+}
+
+func (c *Config) dropIgnored(m map[string]interface{}, typ reflect.Type) (changed bool) {
+	for k, v := range m {
+		fld, ok := c.fieldByLabel(typ, k)
+		if !ok {
+			if c.ignoredLabel(typ, k) {
+				delete(m, k)
+				changed = true
+			}
+			continue
+		}
+		if sub, ok := v.(map[string]interface{}); ok && isNestedStruct(fld.Type) {
+			if c.dropIgnored(sub, indirectType(fld.Type)) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrConflict is returned when a config file in a RevisionFS was
+// changed since it was last read.
+var ErrConflict = errors.New("config file was changed since it was read")
+
+// RevisionFS is a file system for remote config stores that keep a
+// revision of each file, like the mod revision in etcd, the modify index
+// in Consul or the ETag of an http resource. When the file system given
+// to SetFS is a RevisionFS, Save and WriteFile write config files back to
+// it instead of the operating system's file system and only replace a
+// file if it has not been changed since it was read.
+type RevisionFS interface {
+	FS
+	// ReadFileRevision returns the contents of a file along with its
+	// current revision.
+	ReadFileRevision(name string) ([]byte, string, error)
+	// WriteFileRevision replaces a file only if its current revision is
+	// rev and returns the new revision. ErrConflict should be returned
+	// if the revision does not match. An empty rev is used for files
+	// that have not been read and should only be written if they do
+	// not exist.
+	WriteFileRevision(name string, data []byte, rev string) (string, error)
+}
+
+// readRaw will read a file from the file system and
+// save its revision if the file system has revisions.
+func (c *Config) readRaw(filename string) ([]byte, error) {
+	fs := c.filesystem()
+	rfs, ok := fs.(RevisionFS)
+	if !ok {
+		return fs.ReadFile(filename)
+	}
+	raw, rev, err := rfs.ReadFileRevision(filename)
+	if err != nil {
+		return nil, err
+	}
+	c.setRevision(filename, rev)
+	return raw, nil
+}
+
+// writeRevision will write a file to a RevisionFS using the revision
+// from when it was last read. False is returned if the file system
+// does not have revisions.
+func (c *Config) writeRevision(filename string, raw []byte) (bool, error) {
+	rfs, ok := c.filesystem().(RevisionFS)
+	if !ok {
+		return false, nil
+	}
+	c.cachemu.Lock()
+	rev := c.revisions[filename]
+	c.cachemu.Unlock()
+	rev, err := rfs.WriteFileRevision(filename, raw, rev)
+	if err != nil {
+		return true, fmt.Errorf("could not write %s: %w", filename, err)
+	}
+	c.setRevision(filename, rev)
+	return true, nil
+}
+
+func (c *Config) setRevision(filename, rev string) {
+	c.cachemu.Lock()
+	if c.revisions == nil {
+		c.revisions = make(map[string]string)
+	}
+	c.revisions[filename] = rev
+	c.cachemu.Unlock()
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// sampleConfig will marshal a config struct that only has default values.
+// When using yaml, the usage of each field is added as a comment.
+func (c *Config) sampleConfig() ([]byte, error) {
+	if c.tag != "yaml" {
+		return c.marshalDefaults()
+	}
+	v := reflect.New(c.elem.Type())
+	if err := setDefaultsFrom(v.Elem(), c.getTagDefault); err != nil {
+		return nil, err
+	}
+	c.setVersion(v.Elem())
+	var doc yaml3.Node
+	if err := doc.Encode(v.Interface()); err != nil {
+		return nil, err
+	}
+	c.addUsageComments(&doc, c.elem.Type())
+
+	var buf bytes.Buffer
+	enc := yaml3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Config) addUsageComments(n *yaml3.Node, typ reflect.Type) {
+	if n.Kind != yaml3.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		fld, ok := c.fieldByLabel(typ, k.Value)
+		if !ok {
+			if c.ignoredLabel(typ, k.Value) {
+				n.Content = append(n.Content[:i], n.Content[i+2:]...)
+				i -= 2
+			}
+			continue
+		}
+		if _, _, usage, _ := getFlagInfo(fld); usage != "" {
+			k.HeadComment = usage
+		}
+		if isNestedStruct(fld.Type) {
+			c.addUsageComments(v, indirectType(fld.Type))
+		}
+	}
+}
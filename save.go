@@ -0,0 +1,392 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+var errNoType = errors.New("no config type set, use SetType")
+
+// Set will set the value stored at some key. The value given must be
+// assignable to the field stored at that key, have the same underlying
+// type, or be a number that can be converted to the field's type without
+// losing information, otherwise ErrWrongType is returned.
+func Set(key string, val interface{}) error { return c.Set(key, val) }
+
+// Set will set the value stored at some key. The value given must be
+// assignable to the field stored at that key, have the same underlying
+// type, or be a number that can be converted to the field's type without
+// losing information, otherwise ErrWrongType is returned.
+func (c *Config) Set(key string, val interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	key = c.lookupKey(key)
+	if err := c.setValue(c.elem, key, val); err != nil {
+		return err
+	}
+	c.recordSet(key)
+	c.recordMutation("set", Source{Kind: SourceSet}, []string{key})
+	return nil
+}
+
+// Unset will reset the value stored at some key to its zero value so
+// that the getters will fall back to the field's default value. The key
+// is removed from the config file the next time it is saved.
+func Unset(key string) error { return c.Unset(key) }
+
+// Unset will reset the value stored at some key to its zero value so
+// that the getters will fall back to the field's default value. The key
+// is removed from the config file the next time it is saved.
+func (c *Config) Unset(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	key = c.lookupKey(key)
+	err := updateField(c.elem, splitKey(key), c.tagSet(), func(field reflect.Value, _ reflect.StructField) error {
+		if !field.CanSet() {
+			return errors.New("cannot set value")
+		}
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, _, key, err = c.resolveKey(key); err == nil {
+		c.deleteSource(key)
+	}
+	c.recordMutation("unset", Source{Kind: SourceSet}, []string{key})
+	return nil
+}
+
+// SetFromString will parse a string into the type of the field stored at
+// some key and set it. The string is parsed the same way as default
+// values and flags, including any decode hooks (see AddDecodeHook).
+//
+//	config.SetFromString("server.port", "8080")
+func SetFromString(key, val string) error { return c.SetFromString(key, val) }
+
+// SetFromString will parse a string into the type of the field stored at
+// some key and set it. The string is parsed the same way as default
+// values and flags, including any decode hooks (see AddDecodeHook).
+func (c *Config) SetFromString(key, val string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	key = c.lookupKey(key)
+	if err := c.setString(c.elem, key, val); err != nil {
+		return err
+	}
+	c.recordSet(key)
+	c.recordMutation("set", Source{Kind: SourceSet}, []string{key})
+	return nil
+}
+
+func (c *Config) recordSet(key string) {
+	if _, _, key, err := c.resolveKey(key); err == nil {
+		c.setSource(key, Source{Kind: SourceSet})
+	}
+}
+
+// Save will write the config struct to the first config file that
+// exists (see FilesUsed). Only the values read from a config file,
+// changed with functions like Set, or assigned to the config struct
+// directly are written so that the defaults of every other field still
+// apply when the file is read again. If no config file exists, then
+// ErrNoConfigFile is returned.
+func Save() error { return c.Save() }
+
+// Save will write the config struct to the first config file that
+// exists (see FilesUsed). Only the values read from a config file,
+// changed with functions like Set, or assigned to the config struct
+// directly are written so that the defaults of every other field still
+// apply when the file is read again. If no config file exists, then
+// ErrNoConfigFile is returned.
+func (c *Config) Save() error {
+	files := c.FilesUsed()
+	if len(files) == 0 {
+		return ErrNoConfigFile
+	}
+	raw, err := c.marshalConfig()
+	if err != nil {
+		return err
+	}
+	raw = c.keepPersisted(raw)
+	if c.tag == "yaml" {
+		raw = c.keepLayout(files[0], raw)
+	}
+	return c.writeFile(files[0], raw)
+}
+
+// WriteFile will marshal the config struct using the current config
+// type (see SetType) and write it to a file. The file's permissions are
+// kept if it already exists. When overwriting a yaml file, the comments
+// and key order of the existing file are kept.
+func WriteFile(filename string) error { return c.WriteFile(filename) }
+
+// WriteFile will marshal the config struct using the current config
+// type (see SetType) and write it to a file. The file's permissions are
+// kept if it already exists. When overwriting a yaml file, the comments
+// and key order of the existing file are kept.
+func (c *Config) WriteFile(filename string) error {
+	raw, err := c.marshalConfig()
+	if err != nil {
+		return err
+	}
+	if c.tag == "yaml" {
+		raw = c.keepLayout(filename, raw)
+	}
+	return c.writeFile(filename, raw)
+}
+
+func (c *Config) marshalConfig() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setVersion(c.elem)
+	return c.marshalValue(c.config)
+}
+
+// marshalValue will marshal a value using the current
+// config type for writing to a file.
+func (c *Config) marshalValue(v interface{}) ([]byte, error) {
+	if c.marshalIndent == nil {
+		return nil, errNoType
+	}
+	raw, err := c.marshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	raw = c.stripIgnored(raw)
+	if len(raw) > 0 && raw[len(raw)-1] != '\n' {
+		raw = append(raw, '\n')
+	}
+	return raw, nil
+}
+
+// keepPersisted will remove the values from a marshaled config struct
+// that were not read from a config file or changed by the program.
+// Otherwise the zero values of unset fields would be read back as
+// explicit values and hide their defaults.
+func (c *Config) keepPersisted(raw []byte) []byte {
+	if !c.elem.IsValid() {
+		return raw
+	}
+	typ := indirectType(c.elem.Type())
+	if c.tag == "yaml" {
+		// Yaml nodes keep the field order of the struct.
+		var doc yaml3.Node
+		if yaml3.Unmarshal(raw, &doc) != nil || len(doc.Content) == 0 {
+			return raw
+		}
+		c.dropUnpersistedNode(doc.Content[0], typ, "")
+		var buf bytes.Buffer
+		enc := yaml3.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if enc.Encode(&doc) != nil || enc.Close() != nil {
+			return raw
+		}
+		return buf.Bytes()
+	}
+	if c.unmarshal == nil || c.marshalIndent == nil {
+		return raw
+	}
+	var m map[string]interface{}
+	if err := c.unmarshal(raw, &m); err != nil {
+		return raw
+	}
+	m = normalizeMap(m).(map[string]interface{})
+	c.dropUnpersisted(m, typ, "")
+	res, err := c.marshalIndent(m, "", "  ")
+	if err != nil {
+		return raw
+	}
+	if len(res) > 0 && res[len(res)-1] != '\n' {
+		res = append(res, '\n')
+	}
+	return res
+}
+
+func (c *Config) dropUnpersisted(m map[string]interface{}, typ reflect.Type, prefix string) {
+	for k, v := range m {
+		fld, ok := c.fieldByLabel(typ, k)
+		if !ok || c.alwaysPersisted(prefix, k) {
+			continue
+		}
+		key := joinFieldPath(prefix, c.keyName(fld))
+		if sub, ok := v.(map[string]interface{}); ok && isNestedStruct(fld.Type) {
+			c.dropUnpersisted(sub, indirectType(fld.Type), key)
+			if len(sub) == 0 && !c.persisted(key) {
+				delete(m, k)
+			}
+			continue
+		}
+		if !c.persisted(key) && !c.unknownSource(key) {
+			delete(m, k)
+		}
+	}
+}
+
+func (c *Config) dropUnpersistedNode(n *yaml3.Node, typ reflect.Type, prefix string) {
+	if n.Kind != yaml3.MappingNode {
+		return
+	}
+	content := n.Content[:0]
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		fld, ok := c.fieldByLabel(typ, k.Value)
+		if !ok || c.alwaysPersisted(prefix, k.Value) {
+			content = append(content, k, v)
+			continue
+		}
+		key := joinFieldPath(prefix, c.keyName(fld))
+		if v.Kind == yaml3.MappingNode && isNestedStruct(fld.Type) {
+			c.dropUnpersistedNode(v, indirectType(fld.Type), key)
+			if len(v.Content) > 0 || c.persisted(key) {
+				content = append(content, k, v)
+			}
+			continue
+		}
+		if c.persisted(key) || c.unknownSource(key) {
+			content = append(content, k, v)
+		}
+	}
+	n.Content = content
+}
+
+// alwaysPersisted returns true for the top-level version key
+// which always holds the newest version (see setVersion).
+func (c *Config) alwaysPersisted(prefix, label string) bool {
+	return prefix == "" && label == versionKey && len(c.migrations) > 0
+}
+
+// persisted returns true if the value stored at a key, or any key
+// nested under it, was read from a config file or set by the program.
+func (c *Config) persisted(key string) bool {
+	c.srcmu.Lock()
+	defer c.srcmu.Unlock()
+	for k, src := range c.sources {
+		if src.Kind != SourceFile && src.Kind != SourceSet {
+			continue
+		}
+		if k == key || strings.HasPrefix(k, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownSource returns true if no source was recorded for a key that
+// holds a value other than its zero or default value, such as a value
+// assigned to the config struct directly. These values are kept since
+// they cannot be replaced by a default.
+func (c *Config) unknownSource(key string) bool {
+	if _, ok := c.getSource(key); ok {
+		return false
+	}
+	src, err := c.Origin(key)
+	return err != nil || src.Kind == SourceSet
+}
+
+func writeFile(filename string, raw []byte) error {
+	var mode os.FileMode = 0644
+	if stat, err := os.Stat(filename); err == nil {
+		mode = stat.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, raw, mode)
+}
+
+// EnsureConfigFile will make sure that a config file exists and return
+// its path. If no config file is found then a new one is created in the
+// first config path with the default value of every config variable
+// (see WriteDefaultIfMissing).
+func EnsureConfigFile() (string, error) { return c.EnsureConfigFile() }
+
+// EnsureConfigFile will make sure that a config file exists and return
+// its path. If no config file is found then a new one is created in the
+// first config path with the default value of every config variable
+// (see WriteDefaultIfMissing).
+func (c *Config) EnsureConfigFile() (string, error) {
+	if files := c.FilesUsed(); len(files) > 0 {
+		return files[0], nil
+	}
+	file, err := c.preferredFile()
+	if err != nil {
+		return "", err
+	}
+	if _, err = c.WriteDefaultIfMissing(file); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// WriteDefaultIfMissing will write a config file with the default value
+// of every config variable if the file does not exist. Yaml files will
+// include the usage of each field as a comment. Returns true if the file
+// was written.
+func WriteDefaultIfMissing(filename string) (bool, error) {
+	return c.WriteDefaultIfMissing(filename)
+}
+
+// WriteDefaultIfMissing will write a config file with the default value
+// of every config variable if the file does not exist. Yaml files will
+// include the usage of each field as a comment. Returns true if the file
+// was written.
+func (c *Config) WriteDefaultIfMissing(filename string) (bool, error) {
+	if _, err := os.Stat(filename); err == nil {
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	raw, err := c.sampleConfig()
+	if err != nil {
+		return false, err
+	}
+	if err = c.writeFile(filename, raw); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// preferredFile returns the file that should be used when
+// creating a new config file.
+func (c *Config) preferredFile() (string, error) {
+	if c.flagFile != "" {
+		return c.flagFile, nil
+	}
+	if len(c.filepaths) > 0 {
+		return c.filepaths[0], nil
+	}
+	dir := c.DirUsed()
+	if dir == "" || len(c.filenames) == 0 {
+		return "", ErrNoConfigFile
+	}
+	return filepath.Join(dir, c.filenames[0]), nil
+}
+
+// marshalDefaults will marshal a new config struct that only
+// has the values from the "default" struct tags.
+func (c *Config) marshalDefaults() ([]byte, error) {
+	v := reflect.New(c.elem.Type())
+	if err := setDefaultsFrom(v.Elem(), c.getTagDefault); err != nil {
+		return nil, err
+	}
+	c.setVersion(v.Elem())
+	return c.marshalValue(v.Interface())
+}
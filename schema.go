@@ -0,0 +1,166 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// JSONSchema returns a JSON Schema that describes the config file for the
+// config struct. The schema includes the type of every value along with
+// its default, the usage from the "config" tag as a description, values
+// from the "enum" tag, and fields with the "required" option. Recursive
+// struct types are added to the schema's definitions and referenced
+// with "$ref".
+//
+//	type Config struct {
+//		Level string `config:"level,required,usage=log level" enum:"debug,info,error"`
+//	}
+func JSONSchema() ([]byte, error) { return c.JSONSchema() }
+
+// JSONSchema returns a JSON Schema that describes the config file for the
+// config struct.
+func (c *Config) JSONSchema() ([]byte, error) {
+	if c.elem.Kind() == reflect.Invalid {
+		return nil, errElemNotSet
+	}
+	gen := schemaGen{
+		c:     c,
+		stack: make(map[reflect.Type]bool),
+		defs:  make(map[string]interface{}),
+	}
+	// copy the top level schema because it
+	// may also be one of the definitions
+	schema := make(map[string]interface{})
+	for k, v := range gen.typeSchema(c.elem.Type()) {
+		schema[k] = v
+	}
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	if len(gen.defs) > 0 {
+		schema["definitions"] = gen.defs
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// schemaGen builds a JSON Schema for a struct type. A struct type that
+// is found again while its own schema is being built, as in recursive
+// types, is put in the definitions and referenced with "$ref".
+type schemaGen struct {
+	c     *Config
+	stack map[reflect.Type]bool
+	defs  map[string]interface{}
+}
+
+func (g *schemaGen) typeSchema(typ reflect.Type) map[string]interface{} {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Implements(textMarshalerType) || reflect.PtrTo(typ).Implements(textMarshalerType) {
+		return map[string]interface{}{"type": "string"}
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.typeSchema(typ.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": g.typeSchema(typ.Elem()),
+		}
+	case reflect.Struct:
+		return g.structSchema(typ)
+	}
+	return map[string]interface{}{}
+}
+
+func (g *schemaGen) structSchema(typ reflect.Type) map[string]interface{} {
+	def := typ.String()
+	if g.stack[typ] {
+		g.defs[def] = nil // filled in once the schema is built
+		return map[string]interface{}{"$ref": "#/definitions/" + def}
+	}
+	g.stack[typ] = true
+	defer delete(g.stack, typ)
+
+	var (
+		c        = g.c
+		props    = make(map[string]interface{})
+		required = make([]string, 0)
+	)
+	for i := 0; i < typ.NumField(); i++ {
+		fld := typ.Field(i)
+		if fld.PkgPath != "" || isIgnored(fld) {
+			continue
+		}
+		name := c.fileKey(fld)
+		if name == "-" {
+			continue
+		}
+		schema := g.typeSchema(fld.Type)
+		if _, _, usage, _ := getFlagInfo(fld); usage != "" {
+			schema["description"] = usage
+		}
+		zero := reflect.New(fld.Type).Elem()
+		if def, err := c.getTagDefault(&fld, &zero); err == nil {
+			schema["default"] = def.Interface()
+		}
+		if enum := enumValues(fld); enum != nil {
+			schema["enum"] = enum
+		}
+		if hasOption(fld, "required") {
+			required = append(required, name)
+		}
+		props[name] = schema
+	}
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if _, ok := g.defs[def]; ok {
+		g.defs[def] = schema
+	}
+	return schema
+}
+
+// fileKey returns the name of a struct field
+// as it is found in a config file.
+func (c *Config) fileKey(fld reflect.StructField) string {
+	if c.tag != "" {
+		if name := tagName(fld, c.tag); name != "" {
+			return name
+		}
+	}
+	if c.tag == "yaml" {
+		// yaml uses lowercase names for untagged fields
+		return strings.ToLower(fld.Name)
+	}
+	return fld.Name
+}
+
+// hasOption returns true if the "config" struct
+// tag has some option after the name.
+func hasOption(fld reflect.StructField, option string) bool {
+	parts := strings.Split(fld.Tag.Get("config"), ",")
+	for _, p := range parts[1:] {
+		if strings.TrimSpace(p) == option {
+			return true
+		}
+	}
+	return false
+}
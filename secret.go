@@ -0,0 +1,181 @@
+package config
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// redacted replaces the value of secret config variables.
+const redacted = "*****"
+
+// AllSettings returns every config value in a nested map using
+// the same names as AllKeys. The values of secret config variables
+// are replaced with "*****".
+func AllSettings() map[string]interface{} { return c.AllSettings() }
+
+// AllSettings returns every config value in a nested map using
+// the same names as AllKeys. The values of secret config variables
+// are replaced with "*****".
+func (c *Config) AllSettings() map[string]interface{} {
+	if c.elem.Kind() == reflect.Invalid {
+		panic(errElemNotSet)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.settings(c.elem, true)
+}
+
+// settings returns the values of a struct in a nested map. If
+// redact is true then secret values are replaced with "*****".
+func (c *Config) settings(v reflect.Value, redact bool) map[string]interface{} {
+	settings := make(map[string]interface{})
+	c.walk(v, "", func(key string, fld reflect.StructField, val reflect.Value) error {
+		var (
+			m     = settings
+			parts = splitKey(key)
+		)
+		for _, p := range parts[:len(parts)-1] {
+			sub, ok := m[p].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				m[p] = sub
+			}
+			m = sub
+		}
+		val = c.effectiveValue(key, fld, val)
+		if redact {
+			m[parts[len(parts)-1]] = c.redactValue(fld, val)
+		} else {
+			m[parts[len(parts)-1]] = val.Interface()
+		}
+		return nil
+	})
+	return settings
+}
+
+// isSecret returns true for fields that have the "secret" option
+// in the config tag or that have the tag `secret:"true"`.
+func isSecret(fld reflect.StructField) bool {
+	if ok, _ := strconv.ParseBool(fld.Tag.Get("secret")); ok {
+		return true
+	}
+	return hasOption(fld, "secret")
+}
+
+// isSecretKey returns true if the value stored at
+// some key is a secret.
+func (c *Config) isSecretKey(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, fld, _, err := c.resolveKey(key)
+	return err == nil && isSecret(fld)
+}
+
+// redact will replace the value of every secret field in a struct,
+// including the structs held by maps, slices, and arrays. Strings are
+// set to "*****" and all other values are set to zero.
+func (c *Config) redact(val reflect.Value) {
+	c.walk(val, "", func(_ string, fld reflect.StructField, v reflect.Value) error {
+		if !isSecret(fld) {
+			if holdsSecrets(v.Type()) {
+				c.redactElems(v)
+			}
+			return nil
+		}
+		if !v.CanSet() {
+			return nil
+		}
+		if v.Kind() == reflect.String {
+			v.SetString(redacted)
+		} else {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	})
+}
+
+// redactElems will redact the structs held by a value. Map values
+// are copied since they cannot be changed in place.
+func (c *Config) redactElems(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			c.redactElems(v.Elem())
+		}
+	case reflect.Struct:
+		c.redact(v)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			c.redactElems(v.Index(i))
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(iter.Value())
+			c.redactElems(e)
+			v.SetMapIndex(iter.Key(), e)
+		}
+	}
+}
+
+// holdsSecrets returns true for maps, slices, arrays, and
+// pointers that hold structs with secret fields.
+func holdsSecrets(typ reflect.Type) bool {
+	for {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			typ = typ.Elem()
+			continue
+		case reflect.Struct:
+			return hasSecrets(typ)
+		}
+		return false
+	}
+}
+
+// redactValue returns the value of a field for output. Secret fields
+// are replaced with "*****" and the secrets held by other values are
+// redacted from a copy of the value.
+func (c *Config) redactValue(fld reflect.StructField, val reflect.Value) interface{} {
+	if isSecret(fld) {
+		return redacted
+	}
+	if !val.IsValid() {
+		return nil
+	}
+	if !holdsSecrets(val.Type()) {
+		return val.Interface()
+	}
+	cp := copier{seen: make(map[seenPtr]reflect.Value)}
+	res := cp.copy(val)
+	c.redactElems(res)
+	return res.Interface()
+}
+
+// redactKey returns the value stored at some key with its secrets
+// redacted. Nested structs are copied so that the secrets they hold
+// are redacted without changing the config struct.
+func (c *Config) redactKey(key string, val interface{}) interface{} {
+	if c.isSecretKey(key) {
+		return redacted
+	}
+	v := reflect.ValueOf(val)
+	if !v.IsValid() || !holdsSecrets(v.Type()) {
+		return val
+	}
+	cp := copier{seen: make(map[seenPtr]reflect.Value)}
+	res := cp.copy(v)
+	c.redactElems(res)
+	return res.Interface()
+}
+
+// redactedConfig returns a copy of the config
+// struct with all the secrets removed.
+func (c *Config) redactedConfig() interface{} {
+	c.mu.RLock()
+	cp := copyVal(c.elem)
+	c.mu.RUnlock()
+	c.redact(cp)
+	return cp.Addr().Interface()
+}
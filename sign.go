@@ -0,0 +1,62 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ErrBadSignature is returned when the signature of
+// a config file could not be verified.
+var ErrBadSignature = errors.New("bad config file signature")
+
+// RequireSignature will require that every config file is signed with the
+// private key of an ed25519 public key. Signatures are read from a file
+// next to the config file with ".sig" added to the name, see SignFile.
+func RequireSignature(pubkey ed25519.PublicKey) { c.RequireSignature(pubkey) }
+
+// RequireSignature will require that every config file is signed with the
+// private key of an ed25519 public key. Signatures are read from a file
+// next to the config file with ".sig" added to the name, see SignFile.
+func (c *Config) RequireSignature(pubkey ed25519.PublicKey) {
+	c.mu.Lock()
+	c.pubkey = pubkey
+	c.mu.Unlock()
+}
+
+// SignFile will sign a config file and write the base64 encoded signature
+// to a file with the same name and ".sig" added to the end.
+func SignFile(filename string, key ed25519.PrivateKey) error {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	sig := ed25519.Sign(key, raw)
+	return writeFile(filename+".sig", []byte(base64.StdEncoding.EncodeToString(sig)+"\n"))
+}
+
+// verify will check the signature of a config file's contents if
+// signatures are required.
+func (c *Config) verify(filename string, raw []byte) error {
+	if c.pubkey == nil {
+		return nil
+	}
+	sig, err := c.filesystem().ReadFile(filename + ".sig")
+	if err != nil {
+		return fmt.Errorf("%w for %s: %v", ErrBadSignature, filename, err)
+	}
+	// Signatures may be raw bytes or base64 encoded
+	if len(sig) != ed25519.SignatureSize {
+		sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil {
+			return fmt.Errorf("%w for %s: %v", ErrBadSignature, filename, err)
+		}
+	}
+	if !ed25519.Verify(c.pubkey, raw, sig) {
+		return fmt.Errorf("%w for %s", ErrBadSignature, filename)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// ErrSOPSFile is returned when trying to overwrite
+// a sops encrypted config file.
+var ErrSOPSFile = errors.New("cannot overwrite sops encrypted file")
+
+// SetSOPS will set the function used to decrypt sops encrypted config
+// files. Files are detected by their sops metadata and will be decrypted
+// using the sops command by default. The function is given the contents
+// of the file as read from the config's file system and the config type
+// of the file, "yaml" or "json". The decrypt package from sops can be
+// used directly.
+//
+//	config.SetSOPS(decrypt.Data)
+func SetSOPS(decrypt func(data []byte, format string) ([]byte, error)) { c.SetSOPS(decrypt) }
+
+// SetSOPS will set the function used to decrypt sops encrypted config
+// files. Files are detected by their sops metadata and will be decrypted
+// using the sops command by default.
+func (c *Config) SetSOPS(decrypt func(data []byte, format string) ([]byte, error)) {
+	c.mu.Lock()
+	c.sopsDecrypt = decrypt
+	c.mu.Unlock()
+	c.clearFileCache()
+}
+
+// sopsCommand will decrypt a file with the sops command. The contents
+// are given on stdin so that files that are not on the os file system
+// can be decrypted.
+func sopsCommand(ctx context.Context, data []byte, format string) ([]byte, error) {
+	return runFilter(ctx, []string{
+		"sops", "--decrypt",
+		"--input-type", format,
+		"--output-type", format,
+		"/dev/stdin",
+	}, data)
+}
+
+// decryptSOPS will decrypt a config file if it was encrypted with sops.
+func (c *Config) decryptSOPS(filename string, raw []byte) ([]byte, error) {
+	if !isSOPS(raw) {
+		return raw, nil
+	}
+	format := fileType(c.plainName(filename), c.tag)
+	if format == "yml" {
+		format = "yaml"
+	}
+	decrypt := c.sopsDecrypt
+	if decrypt == nil {
+		ctx := c.context()
+		decrypt = func(data []byte, format string) ([]byte, error) {
+			return sopsCommand(ctx, data, format)
+		}
+	}
+	plain, err := decrypt(raw, format)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt %s: %w", filename, err)
+	}
+	return plain, nil
+}
+
+// isSOPS returns true if a file has the metadata that
+// sops adds to encrypted yaml and json files.
+func isSOPS(raw []byte) bool {
+	if !bytes.Contains(raw, []byte("sops")) {
+		return false
+	}
+	// Yaml is a superset of json so
+	// this will parse both.
+	var doc struct {
+		SOPS map[string]interface{} `yaml:"sops"`
+	}
+	if err := yaml3.Unmarshal(raw, &doc); err != nil {
+		return false
+	}
+	_, ok := doc.SOPS["mac"]
+	return ok
+}
+
+// isSOPSFile returns true if an existing file was encrypted with sops.
+func (c *Config) isSOPSFile(filename string) bool {
+	read := ioutil.ReadFile
+	if fs, ok := c.filesystem().(RevisionFS); ok {
+		read = fs.ReadFile
+	}
+	raw, err := read(filename)
+	return err == nil && isSOPS(raw)
+}
@@ -0,0 +1,277 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// SourceKind is the kind of source that a config value came from.
+type SourceKind string
+
+const (
+	// SourceNone is used for values that have not been set.
+	SourceNone SourceKind = ""
+	// SourceDefault is used for values from the "default" struct tag.
+	SourceDefault SourceKind = "default"
+	// SourceEnv is used for values from the environment variable
+	// named in the "env" struct tag.
+	SourceEnv SourceKind = "env"
+	// SourceFile is used for values read from a config file.
+	SourceFile SourceKind = "file"
+	// SourceFlag is used for values set by a command line flag.
+	SourceFlag SourceKind = "flag"
+	// SourceSet is used for values that were changed by the program
+	// with functions like Set or Unset.
+	SourceSet SourceKind = "set"
+	// SourceRemote is used for values from a config
+	// document given to Apply or StreamUpdates.
+	SourceRemote SourceKind = "remote"
+)
+
+// Source describes where a config value came from.
+type Source struct {
+	Kind SourceKind
+	// Name is the file path, environment variable, or flag name that
+	// the value came from.
+	Name string
+	// Line is the line of the config file that the value was
+	// found on or zero if it is not known.
+	Line int
+}
+
+func (s Source) String() string {
+	switch {
+	case s.Kind == SourceNone:
+		return "unset"
+	case s.Line > 0:
+		return fmt.Sprintf("%s %s:%d", s.Kind, s.Name, s.Line)
+	case s.Name != "":
+		return fmt.Sprintf("%s %s", s.Kind, s.Name)
+	}
+	return string(s.Kind)
+}
+
+// Origin returns the source of the value stored at some key.
+func Origin(key string) (Source, error) { return c.Origin(key) }
+
+// Origin returns the source of the value stored at some key.
+func (c *Config) Origin(key string) (Source, error) {
+	c.mu.RLock()
+	field, fld, key, err := c.resolveKey(key)
+	if err != nil {
+		c.mu.RUnlock()
+		return Source{}, err
+	}
+	zero := isZero(field)
+	c.mu.RUnlock()
+
+	src, ok := c.getSource(key)
+	if zero && !ok {
+		// Zero values are replaced by their defaults when
+		// using the getters unless they were set explicitly.
+		if def, ok := defaultSource(fld); ok {
+			return def, nil
+		}
+		return src, nil
+	}
+	if ok {
+		return src, nil
+	}
+	// The value may have been set by InitDefaults.
+	def, err := c.getDefaultValue(&fld, &field)
+	if err == nil && reflect.DeepEqual(def.Interface(), field.Interface()) {
+		src, _ = defaultSource(fld)
+		return src, nil
+	}
+	return Source{Kind: SourceSet}, nil
+}
+
+func defaultSource(fld reflect.StructField) (Source, bool) {
+	if fld.Tag.Get("env") != "" {
+		name, _, ok := lookupEnv(fld)
+		if !ok {
+			return Source{}, false
+		}
+		return Source{Kind: SourceEnv, Name: name}, true
+	}
+	if fld.Tag.Get("default") == "" {
+		return Source{}, false
+	}
+	return Source{Kind: SourceDefault}, true
+}
+
+// resolveKey will find the field stored at a key and return the key
+// using the names that are used by AllKeys.
+func (c *Config) resolveKey(key string) (reflect.Value, reflect.StructField, string, error) {
+	var (
+		keyPath = splitKey(c.mapKey(key))
+		names   = make([]string, len(keyPath))
+		val     = c.elem
+	)
+	for i := range keyPath {
+		field, fld, err := findFieldFrom(val, keyPath[i:i+1], keyPath[:i], c.tagSet())
+		if err != nil {
+			return nilval, fld, "", err
+		}
+		names[i] = c.keyName(fld)
+		if i == len(keyPath)-1 {
+			return field, fld, strings.Join(names, "."), nil
+		}
+		val = indirect(field, false)
+	}
+	return nilval, reflect.StructField{}, "", ErrFieldNotFound
+}
+
+// explicit returns true if the value stored at a key was set by a config
+// file, flag, or function like Set. These values are used by the getters
+// even when they are zero instead of being replaced by a default.
+func (c *Config) explicit(key string) bool {
+	c.srcmu.Lock()
+	n := len(c.sources)
+	c.srcmu.Unlock()
+	if n == 0 {
+		return false
+	}
+	_, _, key, err := c.resolveKey(key)
+	if err != nil {
+		return false
+	}
+	_, ok := c.getSource(key)
+	return ok
+}
+
+func (c *Config) setSource(key string, src Source) {
+	c.srcmu.Lock()
+	if c.sources == nil {
+		c.sources = make(map[string]Source)
+	}
+	c.sources[key] = src
+	c.srcmu.Unlock()
+}
+
+func (c *Config) deleteSource(key string) {
+	c.srcmu.Lock()
+	delete(c.sources, key)
+	c.srcmu.Unlock()
+}
+
+func (c *Config) getSource(key string) (Source, bool) {
+	c.srcmu.Lock()
+	defer c.srcmu.Unlock()
+	src, ok := c.sources[key]
+	return src, ok
+}
+
+// recordFile will set the config file as the source of every key
+// found in that file. Keys that are in the skip set are ignored and
+// every key found is added to it.
+func (c *Config) recordFile(filename string, raw []byte, skip map[string]bool) {
+	for key, line := range c.cachedKeys(filename, raw) {
+		if skip != nil {
+			if skip[key] {
+				continue
+			}
+			skip[key] = true
+		}
+		c.setSource(key, Source{Kind: SourceFile, Name: filename, Line: line})
+	}
+}
+
+// fileKeys returns the keys found in a raw config file mapped
+// to their line number or zero if the line is not known.
+func (c *Config) fileKeys(raw []byte) map[string]int {
+	keys := make(map[string]int)
+	typ := c.elem.Type()
+
+	// Yaml is a superset of json so we can find line numbers for
+	// both. Other formats, like toml, may also be valid yaml so
+	// they are only parsed with their own unmarshal function.
+	if c.tag == "yaml" || c.tag == "json" {
+		var doc yaml3.Node
+		if err := yaml3.Unmarshal(raw, &doc); err == nil {
+			if len(doc.Content) > 0 {
+				c.nodeKeys(doc.Content[0], typ, "", keys)
+			}
+			return keys
+		}
+	}
+	var m interface{}
+	if c.unmarshal == nil || c.unmarshal(raw, &m) != nil {
+		return keys
+	}
+	c.mapKeys(m, typ, "", keys)
+	return keys
+}
+
+// setLines will change the line numbers of keys found in a processed
+// config file to the lines they are on in the decrypted file. Aliases,
+// migrations, envonly fields and decode hooks can change the order of
+// the keys and remove comments when the file is processed.
+func (c *Config) setLines(keys map[string]int, plain []byte) {
+	if c.jsonc {
+		plain = stripJSONC(plain)
+	}
+	lines := c.fileKeys(plain)
+	for k := range keys {
+		keys[k] = lines[k]
+	}
+}
+
+func (c *Config) nodeKeys(n *yaml3.Node, typ reflect.Type, prefix string, keys map[string]int) {
+	if n.Kind != yaml3.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		fld, ok := c.fieldByLabel(typ, k.Value)
+		if !ok {
+			continue
+		}
+		key := joinFieldPath(prefix, c.keyName(fld))
+		if isNestedStruct(fld.Type) {
+			c.nodeKeys(v, indirectType(fld.Type), key, keys)
+			continue
+		}
+		keys[key] = k.Line
+	}
+}
+
+func (c *Config) mapKeys(m interface{}, typ reflect.Type, prefix string, keys map[string]int) {
+	val := reflect.ValueOf(m)
+	if val.Kind() != reflect.Map {
+		return
+	}
+	iter := val.MapRange()
+	for iter.Next() {
+		fld, ok := c.fieldByLabel(typ, fmt.Sprint(iter.Key().Interface()))
+		if !ok {
+			continue
+		}
+		key := joinFieldPath(prefix, c.keyName(fld))
+		if isNestedStruct(fld.Type) {
+			c.mapKeys(iter.Value().Interface(), indirectType(fld.Type), key, keys)
+			continue
+		}
+		keys[key] = 0
+	}
+}
+
+func (c *Config) fieldByLabel(typ reflect.Type, label string) (reflect.StructField, bool) {
+	fields := fieldsOf(typ, c.tagSet())
+	for i := range fields {
+		if fields[i].label(label) {
+			return fields[i].StructField, true
+		}
+	}
+	// Both yaml and json will match untagged
+	// fields regardless of case.
+	for i := range fields {
+		if strings.EqualFold(label, fields[i].Name) {
+			return fields[i].StructField, true
+		}
+	}
+	return reflect.StructField{}, false
+}
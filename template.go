@@ -0,0 +1,111 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// ErrTemplateFile is returned when trying to overwrite a config
+// file while templates are turned on (see SetTemplates).
+var ErrTemplateFile = errors.New("cannot overwrite templated config file")
+
+// SetTemplates will turn on or off the rendering of config files as Go
+// templates before they are decoded. Templates only have access to the
+// data given to SetTemplateData and the following functions:
+//
+//	env "NAME"           the value of an environment variable
+//	hostname             the name of the current host
+//	file "path"          the contents of a file relative to the config file
+//	default "x" .Value   the first argument when the second is empty
+//
+// Existing config files are never overwritten by Save while templates
+// are turned on.
+func SetTemplates(on bool) { c.SetTemplates(on) }
+
+// SetTemplates will turn on or off the rendering of config files as Go
+// templates before they are decoded. Templates only have access to the
+// data given to SetTemplateData and the following functions:
+//
+//	env "NAME"           the value of an environment variable
+//	hostname             the name of the current host
+//	file "path"          the contents of a file relative to the config file
+//	default "x" .Value   the first argument when the second is empty
+//
+// Existing config files are never overwritten by Save while templates
+// are turned on.
+func (c *Config) SetTemplates(on bool) {
+	c.mu.Lock()
+	c.templates = on
+	c.mu.Unlock()
+}
+
+// SetTemplateData will set the data used as dot when rendering config
+// file templates, see SetTemplates.
+func SetTemplateData(data interface{}) { c.SetTemplateData(data) }
+
+// SetTemplateData will set the data used as dot when rendering config
+// file templates, see SetTemplates.
+func (c *Config) SetTemplateData(data interface{}) {
+	c.mu.Lock()
+	c.templateData = data
+	c.mu.Unlock()
+}
+
+// render will execute a config file as a template if templates are on.
+func (c *Config) render(filename string, raw []byte) ([]byte, error) {
+	if !c.templates {
+		return raw, nil
+	}
+	dir := filepath.Dir(filename)
+	tmpl, err := template.New(filepath.Base(filename)).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{
+			"env":      os.Getenv,
+			"hostname": os.Hostname,
+			"file": func(name string) (string, error) {
+				if !filepath.IsAbs(name) {
+					name = filepath.Join(dir, name)
+				}
+				b, err := c.filesystem().ReadFile(name)
+				return strings.TrimSuffix(string(b), "\n"), err
+			},
+			"default": templateDefault,
+		}).
+		Parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template %s: %w", filename, err)
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, c.templateData); err != nil {
+		return nil, fmt.Errorf("could not render template %s: %w", filename, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// templateDefault returns def if val is
+// empty so it can be used in a pipeline.
+func templateDefault(def, val interface{}) interface{} {
+	if val == nil {
+		return def
+	}
+	if v := reflect.ValueOf(val); v.IsZero() {
+		return def
+	}
+	return val
+}
+
+// isTemplate returns true if a file exists and
+// would be rendered as a template.
+func (c *Config) isTemplate(filename string) bool {
+	if !c.templates {
+		return false
+	}
+	_, err := os.Stat(filename)
+	return err == nil
+}
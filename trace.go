@@ -0,0 +1,96 @@
+package config
+
+import "time"
+
+// TraceOp is a step of loading the config that can be traced.
+type TraceOp string
+
+const (
+	// TraceRead is used when reading, decrypting, and
+	// migrating a config file.
+	TraceRead TraceOp = "read"
+	// TraceUnmarshal is used when parsing a config file.
+	TraceUnmarshal TraceOp = "unmarshal"
+	// TraceMerge is used when merging the values from a config
+	// file with the values from earlier files.
+	TraceMerge TraceOp = "merge"
+	// TraceValidate is used when a config file is validated.
+	TraceValidate TraceOp = "validate"
+	// TraceSkipReload is used when a signal or message that triggers
+	// a reload is skipped because of the reload interval. The name is
+	// the signal or the message channel.
+	TraceSkipReload TraceOp = "skip-reload"
+	// TraceWatchPanic is used when handling a changed config file
+	// panics while watching files. The name is the file and the
+	// error wraps ErrWatchPanic.
+	TraceWatchPanic TraceOp = "watch-panic"
+)
+
+// Tracer is used to measure how long it takes to load the config. Start
+// is called for each step with the name of the file being loaded and the
+// function it returns is called with how long the step took and the
+// error if there was one. This can be used to start and end spans for a
+// tracing library without depending on it.
+//
+// Steps are sent to the tracer once the config is unlocked so that the
+// tracer can call methods on the config, which means Start is called
+// after the step is done and the duration should be used for its start
+// time.
+type Tracer interface {
+	Start(op TraceOp, name string) func(d time.Duration, err error)
+}
+
+// TracerFunc is a function that implements Tracer.
+type TracerFunc func(op TraceOp, name string) func(d time.Duration, err error)
+
+// Start calls the tracer function.
+func (f TracerFunc) Start(op TraceOp, name string) func(time.Duration, error) { return f(op, name) }
+
+// SetTracer will set the tracer used when loading config files.
+func SetTracer(t Tracer) { c.SetTracer(t) }
+
+// SetTracer will set the tracer used when loading config files.
+func (c *Config) SetTracer(t Tracer) {
+	c.mu.Lock()
+	c.tracer = t
+	c.mu.Unlock()
+}
+
+// traceStep is a step that is done but has not been sent to the tracer.
+type traceStep struct {
+	tracer Tracer
+	op     TraceOp
+	name   string
+	d      time.Duration
+	err    error
+}
+
+// trace starts tracing a step and returns the function that should be
+// called when it is done. The step is sent to the tracer by flushTraces.
+func (c *Config) trace(op TraceOp, name string) func(error) {
+	tracer := c.tracer
+	if tracer == nil {
+		return func(error) {}
+	}
+	start := time.Now()
+	return func(err error) {
+		step := traceStep{tracer: tracer, op: op, name: name, d: time.Since(start), err: err}
+		c.tracemu.Lock()
+		c.traces = append(c.traces, step)
+		c.tracemu.Unlock()
+	}
+}
+
+// flushTraces sends every traced step to its tracer. This must be called
+// after c.mu is unlocked so that the tracer can use the config.
+func (c *Config) flushTraces() {
+	c.tracemu.Lock()
+	steps := c.traces
+	c.traces = nil
+	c.tracemu.Unlock()
+	for _, s := range steps {
+		if end := s.tracer.Start(s.op, s.name); end != nil {
+			end(s.d, s.err)
+		}
+	}
+}
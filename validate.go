@@ -0,0 +1,102 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Validator can be implemented by a config struct to check
+// that the values read from a config file are valid.
+type Validator interface {
+	Validate() error
+}
+
+// checkFile will strictly parse a config file and validate the result
+// without changing the config struct. The file is checked on its own so
+// values missing from the file are only filled in by their defaults and
+// never by the current config.
+func (c *Config) checkFile(filename string) error {
+	_, err := c.parseFile(filename)
+	return err
+}
+
+// parseFile is the same as checkFile but returns a pointer
+// to a copy of the config struct holding the file's values.
+func (c *Config) parseFile(filename string) (reflect.Value, error) {
+	defer c.flushTraces()
+	if c.unmarshalStrict == nil {
+		return reflect.Value{}, errNoType
+	}
+	raw, err := c.readFile(filename)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	cp := reflect.New(c.elem.Type())
+	if err = c.unmarshalStrict(raw, cp.Interface()); err != nil {
+		if line, col, ok := errorPosition(raw, err); ok {
+			return reflect.Value{}, fmt.Errorf("%s:%d:%d: %w", filename, line, col, err)
+		}
+		return reflect.Value{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err = setDefaultsFrom(cp.Elem(), c.getTagDefault); err != nil {
+		return reflect.Value{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	if v, ok := cp.Interface().(Validator); ok {
+		done := c.trace(TraceValidate, filename)
+		err = v.Validate()
+		done(err)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	return cp, nil
+}
+
+// errorPosition will find the line and column of a json error. Yaml
+// and toml errors already include the line number.
+func errorPosition(raw []byte, err error) (line, col int, ok bool) {
+	var (
+		offset  int64
+		syntax  *json.SyntaxError
+		typeErr *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &syntax):
+		offset = syntax.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return 0, 0, false
+	}
+	// the offset is just past the bad byte
+	if offset > int64(len(raw)) {
+		offset = int64(len(raw))
+	}
+	if offset > 0 {
+		offset--
+	}
+	line, col = 1, 1
+	for _, b := range raw[:offset] {
+		if b == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col, true
+}
+
+// checkFiles will check every file and return all
+// the errors found.
+func (c *Config) checkFiles(files []string) []error {
+	var errs []error
+	for _, file := range files {
+		if err := c.checkFile(file); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
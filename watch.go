@@ -1,26 +1,165 @@
 package config
 
 import (
+	"context"
 	"errors"
-	"io/ioutil"
-	"log"
+	"fmt"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"reflect"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
+// ErrWatchPanic is logged and traced (see TraceWatchPanic) when
+// handling a changed config file panics. The panic is recovered and
+// the files are still watched.
+var ErrWatchPanic = errors.New("panic while handling a config file change")
+
 // ReloadOn takes a list of signals and will reload
-// the config whenever any of them are received.
+// the config whenever any of them are received. Signals that
+// arrive within the reload interval of the last reload are
+// combined into one reload, see SetReloadInterval.
 func (c *Config) ReloadOn(sig ...os.Signal) {
-	var sigs = make(chan os.Signal)
+	var (
+		sigs     = make(chan os.Signal, 1)
+		triggers = make(chan string)
+	)
 	signal.Notify(sigs, sig...)
 	go func() {
-		for range sigs {
-			c.ReadConfig()
+		for s := range sigs {
+			triggers <- s.String()
 		}
 	}()
+	go c.reloadOn(triggers)
+}
+
+// Subscriber is a subscription to a message channel or topic, such as a
+// Redis or NATS subscription, that is used to trigger reloads.
+type Subscriber interface {
+	// Receive blocks until a message is received and returns the name
+	// of its channel or topic. An error is returned when the
+	// subscription is closed or the context is done.
+	Receive(ctx context.Context) (string, error)
+}
+
+// SubscriberFunc is a function that implements Subscriber.
+type SubscriberFunc func(ctx context.Context) (string, error)
+
+// Receive calls the subscriber function.
+func (f SubscriberFunc) Receive(ctx context.Context) (string, error) { return f(ctx) }
+
+// ReloadOnMessage will reload the config whenever a message is received
+// by the subscriber so that many programs can be told to read their
+// config again with a single broadcast. Messages are rate limited the
+// same way as signals, see SetReloadInterval. Reloading stops when the
+// context is done or Receive returns an error, which is logged.
+func ReloadOnMessage(ctx context.Context, sub Subscriber) { c.ReloadOnMessage(ctx, sub) }
+
+// ReloadOnMessage will reload the config whenever a message is received
+// by the subscriber so that many programs can be told to read their
+// config again with a single broadcast. Messages are rate limited the
+// same way as signals, see SetReloadInterval. Reloading stops when the
+// context is done or Receive returns an error, which is logged.
+func (c *Config) ReloadOnMessage(ctx context.Context, sub Subscriber) {
+	triggers := make(chan string)
+	go func() {
+		defer close(triggers)
+		for {
+			name, err := sub.Receive(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				c.logf("config.ReloadOnMessage: %v", err)
+				return
+			}
+			select {
+			case triggers <- name:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go c.reloadOn(triggers)
+}
+
+// SetReloadInterval will set the minimum time between reloads started by
+// the signals given to ReloadOn or the messages from ReloadOnMessage. A
+// signal received sooner will delay the reload until the interval has
+// passed and any more signals received while waiting are skipped (see
+// SkippedReloads). There is no minimum interval by default.
+func SetReloadInterval(d time.Duration) { c.SetReloadInterval(d) }
+
+// SetReloadInterval will set the minimum time between reloads started by
+// the signals given to ReloadOn or the messages from ReloadOnMessage. A
+// signal received sooner will delay the reload until the interval has
+// passed and any more signals received while waiting are skipped (see
+// SkippedReloads). There is no minimum interval by default.
+func (c *Config) SetReloadInterval(d time.Duration) {
+	c.mu.Lock()
+	c.reloadInterval = d
+	c.mu.Unlock()
+}
+
+// SkippedReloads returns the number of signals or messages that were
+// skipped because a reload was already waiting for the reload interval
+// to pass. Each skipped reload is also traced with TraceSkipReload, see
+// SetTracer.
+func SkippedReloads() uint64 { return c.SkippedReloads() }
+
+// SkippedReloads returns the number of signals or messages that were
+// skipped because a reload was already waiting for the reload interval
+// to pass. Each skipped reload is also traced with TraceSkipReload, see
+// SetTracer.
+func (c *Config) SkippedReloads() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.skippedReloads
+}
+
+// reloadOn will reload the config for each name received until the
+// channel is closed. The names are the signals or message channels
+// that triggered the reload.
+func (c *Config) reloadOn(triggers <-chan string) {
+	var (
+		last    time.Time
+		pending <-chan time.Time
+	)
+	reload := func() {
+		if err := c.ReadConfig(); err != nil {
+			c.logf("config.ReloadOn: %v", err)
+		}
+		last = time.Now()
+	}
+	for {
+		select {
+		case name, ok := <-triggers:
+			if !ok {
+				return
+			}
+			if pending != nil {
+				c.mu.Lock()
+				c.skippedReloads++
+				c.trace(TraceSkipReload, name)(nil)
+				c.mu.Unlock()
+				c.flushTraces()
+				continue
+			}
+			c.mu.RLock()
+			wait := c.reloadInterval - time.Since(last)
+			c.mu.RUnlock()
+			if wait > 0 {
+				pending = time.After(wait)
+				continue
+			}
+			reload()
+		case <-pending:
+			pending = nil
+			reload()
+		}
+	}
 }
 
 // Watch will watch the config files and reload the
@@ -33,47 +172,144 @@ func Watch() error { return c.Watch() }
 // or changes.
 func (c *Config) Watch() error {
 	return c.updated(func(e fsnotify.Event) {
+		defer c.flushTraces()
 		c.mu.Lock()
 		defer c.mu.Unlock()
+		if c.readOnly {
+			c.logf("config.Watch: %s changed while read-only, ignoring", e.Name)
+			return
+		}
+		before := c.snapshot()
 
-		raw, err := ioutil.ReadFile(e.Name)
+		done := c.trace(TraceRead, e.Name)
+		raw, err := c.readCachedFile(e.Name)
+		done(err)
 		if err != nil {
-			log.Println("config.Watch:", err)
+			c.logf("config.Watch: %v", err)
 			return
 		}
 		tmp := copyVal(c.elem)
+		defer func() {
+			if r := recover(); r != nil {
+				// Keep the old values if the file
+				// was only partly unmarshaled.
+				c.elem.Set(tmp)
+				panic(r)
+			}
+		}()
 
+		done = c.trace(TraceUnmarshal, e.Name)
 		err = c.unmarshal(raw, c.config)
+		done(err)
 		if err != nil {
-			log.Println("config.Watch:", err)
+			c.logf("config.Watch: %v", err)
 			return
 		}
 
-		err = merge(c.elem, tmp)
+		// Keep the old values that are not in the file
+		// without replacing the zero values that are.
+		m := merger{keep: c.fileKeySet(raw), keyName: c.keyName}
+		done = c.trace(TraceMerge, e.Name)
+		err = m.merge(c.elem, tmp, MergeReplace, "")
+		done(err)
 		if err != nil {
-			log.Println("config.Watch:", err)
+			c.logf("config.Watch: %v", err)
 			return
 		}
+		c.recordFile(e.Name, raw, nil)
+		c.reapplyOverrides()
+		c.recordChanges("watch", Source{Kind: SourceFile, Name: e.Name}, before)
 	})
 }
 
+// Event is sent by Updated when a config file is created or written to.
+type Event struct {
+	// Name is the path of the config file.
+	Name string
+	// Op is the file system operation that changed the file.
+	Op fsnotify.Op
+	// Keys that have a different value in the file than in the last
+	// event for the file. For the first event, Keys is nil if the file
+	// had not been read when Updated was called or is not cached (see
+	// SetTemplates and SetEvaluator).
+	Keys []string
+}
+
 // Updated will return a channel which will never close and will
-// recieve an empty struct every time a config file is created,
+// recieve an Event every time a config file is created,
 // or written to.
-func Updated() (<-chan struct{}, error) {
+func Updated() (<-chan Event, error) {
 	return c.Updated()
 }
 
 // Updated will return a channel which will never close and will
-// recieve an empty struct every time a config file is created,
+// recieve an Event every time a config file is created,
 // or written to.
-func (c *Config) Updated() (<-chan struct{}, error) {
-	ch := make(chan struct{})
+func (c *Config) Updated() (<-chan Event, error) {
+	ch := make(chan Event)
+	// The contents of each file from the last event are kept here
+	// because the file cache is also changed by Watch and ReadConfig.
+	last := make(map[string][]byte)
+	c.mu.RLock()
+	for _, f := range existingFiles(c) {
+		if cf := c.cacheEntry(f); cf != nil {
+			last[f] = cf.raw
+		}
+	}
+	c.mu.RUnlock()
 	return ch, c.updated(func(e fsnotify.Event) {
-		ch <- struct{}{}
+		keys, raw := c.changedKeys(e.Name, last[e.Name])
+		if raw != nil {
+			last[e.Name] = raw
+		}
+		ch <- Event{Name: e.Name, Op: e.Op, Keys: keys}
 	})
 }
 
+// changedKeys reads a config file without changing the file cache and
+// returns the keys that have a different value than in old along with
+// the contents of the file. The keys are nil if old is nil.
+func (c *Config) changedKeys(filename string, old []byte) ([]string, []byte) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	raw, err := c.readCopy(context.Background(), filename).readCachedFile(filename)
+	if err != nil {
+		return nil, nil
+	}
+	if old == nil || c.unmarshal == nil {
+		return nil, raw
+	}
+	before, after := reflect.New(c.elem.Type()), reflect.New(c.elem.Type())
+	if c.unmarshal(old, before.Interface()) != nil || c.unmarshal(raw, after.Interface()) != nil {
+		return nil, raw
+	}
+	changes := c.diffConfig(before, after, false)
+	keys := make([]string, len(changes))
+	for i, ch := range changes {
+		keys[i] = ch.Key
+	}
+	return keys, raw
+}
+
+// handleEvent calls f with a file event and recovers from any panic so
+// that one bad change to a file does not stop the files being watched.
+func (c *Config) handleEvent(f func(fsnotify.Event), e fsnotify.Event) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		err := fmt.Errorf("%w: %s: %v", ErrWatchPanic, e.Name, r)
+		c.logf("config.Watch: %v", err)
+		c.mu.RLock()
+		done := c.trace(TraceWatchPanic, e.Name)
+		c.mu.RUnlock()
+		done(err)
+		c.flushTraces()
+	}()
+	f(e)
+}
+
 func (c *Config) updated(f func(fsnotify.Event)) error {
 	var (
 		err error
@@ -93,7 +329,7 @@ func (c *Config) updated(f func(fsnotify.Event)) error {
 				}
 				switch event.Op {
 				case fsnotify.Write, fsnotify.Create:
-					f(event)
+					c.handleEvent(f, event)
 				default:
 					continue
 				}
@@ -102,24 +338,21 @@ func (c *Config) updated(f func(fsnotify.Event)) error {
 					continue
 				}
 				if err != nil {
-					log.Println("config watcher error:", err)
+					c.logf("config watcher error: %v", err)
 				}
 			}
 		}
 	}()
 
-	n := 0
-	for _, path := range c.paths {
-		for _, file := range c.filenames {
-			f := filepath.Join(path, file)
-			err = watcher.Add(f)
-			if err != nil {
-				return err
-			}
-			n++
+	c.mu.RLock()
+	files := existingFiles(c)
+	c.mu.RUnlock()
+	for _, f := range files {
+		if err = watcher.Add(f); err != nil {
+			return err
 		}
 	}
-	if n == 0 {
+	if len(files) == 0 {
 		return errors.New("not watching any config files")
 	}
 	return nil
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// keepLayout will update the contents of an existing yaml config file
+// with a newly marshaled config so that the comments and key order
+// written by hand are kept. Only the values that changed are replaced,
+// although blank lines between keys are not kept by the yaml encoder.
+// The new contents are returned unchanged if the existing file cannot be
+// read or parsed.
+func (c *Config) keepLayout(filename string, raw []byte) []byte {
+	read := ioutil.ReadFile
+	if fs, ok := c.filesystem().(RevisionFS); ok {
+		read = fs.ReadFile
+	}
+	old, err := read(filename)
+	if err != nil {
+		return raw
+	}
+	if ci := c.cipher(filename); ci != nil {
+		if old, err = ci.Decrypt(old); err != nil {
+			return raw
+		}
+	}
+	var dst, src yaml3.Node
+	if yaml3.Unmarshal(old, &dst) != nil || yaml3.Unmarshal(raw, &src) != nil {
+		return raw
+	}
+	if len(dst.Content) == 0 || len(src.Content) == 0 {
+		return raw
+	}
+	mergeNode(dst.Content[0], src.Content[0])
+
+	var buf bytes.Buffer
+	enc := yaml3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if enc.Encode(&dst) != nil || enc.Close() != nil {
+		return raw
+	}
+	return buf.Bytes()
+}
+
+// mergeNode will update dst so that it holds the same values as src
+// while keeping the comments and ordering of dst.
+func mergeNode(dst, src *yaml3.Node) {
+	if dst.Kind != src.Kind || dst.Kind == yaml3.AliasNode {
+		replaceNode(dst, src)
+		return
+	}
+	switch dst.Kind {
+	case yaml3.MappingNode:
+		// New keys are placed before the next key
+		// that is already in the document.
+		var (
+			pending []*yaml3.Node
+			before  = make(map[int][]*yaml3.Node)
+		)
+		for j := 0; j+1 < len(src.Content); j += 2 {
+			i := findMapKey(dst, src.Content[j].Value)
+			if i < 0 {
+				pending = append(pending, src.Content[j], src.Content[j+1])
+				continue
+			}
+			mergeNode(dst.Content[i+1], src.Content[j+1])
+			before[i] = pending
+			pending = nil
+		}
+		content := make([]*yaml3.Node, 0, len(src.Content))
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			if nodes, ok := before[i]; ok {
+				content = append(content, nodes...)
+				content = append(content, dst.Content[i], dst.Content[i+1])
+			}
+		}
+		dst.Content = append(content, pending...)
+	case yaml3.SequenceNode:
+		n := len(src.Content)
+		if len(dst.Content) < n {
+			n = len(dst.Content)
+		}
+		for i := 0; i < n; i++ {
+			mergeNode(dst.Content[i], src.Content[i])
+		}
+		dst.Content = append(dst.Content[:n], src.Content[n:]...)
+	case yaml3.ScalarNode:
+		if dst.Value != src.Value || dst.ShortTag() != src.ShortTag() {
+			dst.Value = src.Value
+			dst.Tag = src.Tag
+			dst.Style = src.Style
+		}
+	}
+}
+
+// replaceNode will replace a node but keep its comments.
+func replaceNode(dst, src *yaml3.Node) {
+	head, line, foot := dst.HeadComment, dst.LineComment, dst.FootComment
+	*dst = *src
+	dst.HeadComment, dst.LineComment, dst.FootComment = head, line, foot
+}
+
+func findMapKey(n *yaml3.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}